@@ -16,6 +16,7 @@ package events
 
 import (
 	"context"
+	"time"
 
 	eh "github.com/looplab/eventhorizon"
 )
@@ -44,3 +45,21 @@ type Aggregate interface {
 	// IncrementVersion.
 	ApplyEvent(context.Context, eh.Event) error
 }
+
+// VersionSetter is an aggregate that can have its version set, which is used
+// when restoring it from a snapshot. AggregateBase implements it.
+type VersionSetter interface {
+	// SetAggregateVersion sets the version of the aggregate.
+	SetAggregateVersion(int)
+}
+
+// SnapshotTracker is an aggregate that keeps the version and timestamp of its
+// last snapshot, which is used to decide when to take a new snapshot without
+// loading the last one from the snapshot store. AggregateBase implements it.
+type SnapshotTracker interface {
+	// LastSnapshot returns the version and timestamp of the last snapshot, or
+	// zero values if there is none.
+	LastSnapshot() (int, time.Time)
+	// SetLastSnapshot sets the version and timestamp of the last snapshot.
+	SetLastSnapshot(int, time.Time)
+}
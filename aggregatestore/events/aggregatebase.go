@@ -61,6 +61,9 @@ type AggregateBase struct {
 	t      eh.AggregateType
 	v      int
 	events []eh.Event
+
+	snapshotVersion   int
+	snapshotTimestamp time.Time
 }
 
 // NewAggregateBase creates an aggregate.
@@ -91,6 +94,23 @@ func (a *AggregateBase) IncrementVersion() {
 	a.v++
 }
 
+// SetAggregateVersion implements the SetAggregateVersion method of the
+// VersionSetter interface.
+func (a *AggregateBase) SetAggregateVersion(v int) {
+	a.v = v
+}
+
+// LastSnapshot implements the LastSnapshot method of the SnapshotTracker interface.
+func (a *AggregateBase) LastSnapshot() (int, time.Time) {
+	return a.snapshotVersion, a.snapshotTimestamp
+}
+
+// SetLastSnapshot implements the SetLastSnapshot method of the SnapshotTracker interface.
+func (a *AggregateBase) SetLastSnapshot(v int, timestamp time.Time) {
+	a.snapshotVersion = v
+	a.snapshotTimestamp = timestamp
+}
+
 // Events implements the Events method of the eh.EventSource interface.
 func (a *AggregateBase) Events() []eh.Event {
 	events := a.events
@@ -17,6 +17,9 @@ package events
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
+	"time"
 
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
@@ -26,8 +29,12 @@ import (
 // uses an event store for loading and saving events used to build the aggregate
 // and an event handler to handle resulting events.
 type AggregateStore struct {
-	store        eh.EventStore
-	eventHandler eh.EventHandler
+	store            eh.EventStore
+	eventHandler     eh.EventHandler
+	snapshotStore    eh.SnapshotStore
+	snapshotStrategy SnapshotStrategy
+	outbox           eh.EventStoreOutbox
+	errCh            chan eh.EventBusError
 }
 
 var (
@@ -39,6 +46,16 @@ var (
 	ErrInvalidAggregateType = errors.New("invalid aggregate type")
 	// ErrMismatchedEventType occurs when loaded events from ID does not match aggregate type.
 	ErrMismatchedEventType = errors.New("mismatched event type and aggregate type")
+	// ErrInvalidSnapshotStore is when a snapshot option is used with a nil store.
+	ErrInvalidSnapshotStore = errors.New("invalid snapshot store")
+	// ErrMismatchedSnapshotType is when a loaded snapshot does not match the aggregate type.
+	ErrMismatchedSnapshotType = errors.New("mismatched snapshot type and aggregate type")
+	// ErrIncorrectSnapshotVersion is when an aggregate does not have the version
+	// of the snapshot after applying it.
+	ErrIncorrectSnapshotVersion = errors.New("incorrect snapshot version")
+	// ErrInvalidOutboxStore is when the outbox option is used with an event
+	// store that does not implement eh.EventStoreOutbox.
+	ErrInvalidOutboxStore = errors.New("invalid outbox store")
 )
 
 // ApplyEventError is when an event could not be applied. It contains the error
@@ -68,7 +85,7 @@ func (e ApplyEventError) Cause() error {
 // NewAggregateStore creates a aggregate store with an event store and an event
 // handler that will handle resulting events (for example by publishing them
 // on an event bus).
-func NewAggregateStore(store eh.EventStore, eventHandler eh.EventHandler) (*AggregateStore, error) {
+func NewAggregateStore(store eh.EventStore, eventHandler eh.EventHandler, options ...Option) (*AggregateStore, error) {
 	if store == nil {
 		return nil, ErrInvalidEventStore
 	}
@@ -80,14 +97,64 @@ func NewAggregateStore(store eh.EventStore, eventHandler eh.EventHandler) (*Aggr
 	d := &AggregateStore{
 		store:        store,
 		eventHandler: eventHandler,
+		errCh:        make(chan eh.EventBusError, 100),
 	}
+
+	for _, option := range options {
+		if err := option(d); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
 	return d, nil
 }
 
+// Option is an option setter used to configure creation.
+type Option func(*AggregateStore) error
+
+// WithSnapshots enables snapshots of aggregates implementing eh.Snapshotable.
+// Snapshots are saved to the snapshot store when the strategy says so, and
+// used as the starting point when loading aggregates. Snapshots that could not
+// be saved do not fail saving the aggregate, the errors are sent on the error
+// channel instead. Aggregates that implement SnapshotTracker keep the last
+// snapshot from loading, other aggregates load it again when saving.
+func WithSnapshots(store eh.SnapshotStore, strategy SnapshotStrategy) Option {
+	return func(s *AggregateStore) error {
+		if store == nil || strategy == nil {
+			return ErrInvalidSnapshotStore
+		}
+		s.snapshotStore = store
+		s.snapshotStrategy = strategy
+		return nil
+	}
+}
+
+// WithOutbox marks events as published in the event store once they have been
+// handled, for use with an event store that has its outbox enabled. Events
+// that could not be handled do not fail the save, they are instead left in
+// the outbox for a relay to publish later, see the outbox package.
+func WithOutbox() Option {
+	return func(s *AggregateStore) error {
+		outbox, ok := s.store.(eh.EventStoreOutbox)
+		if !ok {
+			return ErrInvalidOutboxStore
+		}
+		s.outbox = outbox
+		return nil
+	}
+}
+
+// Errors returns an error channel where errors are sent for snapshots that
+// could not be taken, with the last saved event.
+func (r *AggregateStore) Errors() <-chan eh.EventBusError {
+	return r.errCh
+}
+
 // Load implements the Load method of the eventhorizon.AggregateStore interface.
 // It loads an aggregate from the event store by creating a new aggregate of the
 // type with the ID and then applies all events to it, thus making it the most
-// current version of the aggregate.
+// current version of the aggregate. If snapshots are enabled the aggregate is
+// first restored from the latest snapshot and only the newer events are applied.
 func (r *AggregateStore) Load(ctx context.Context, aggregateType eh.AggregateType, id uuid.UUID) (eh.Aggregate, error) {
 	agg, err := eh.CreateAggregate(aggregateType, id)
 	if err != nil {
@@ -98,11 +165,25 @@ func (r *AggregateStore) Load(ctx context.Context, aggregateType eh.AggregateTyp
 		return nil, ErrInvalidAggregateType
 	}
 
-	events, err := r.store.Load(ctx, a.EntityID())
+	if err := r.applySnapshot(ctx, a); err != nil {
+		return nil, err
+	}
+
+	var events []eh.Event
+	if store, ok := r.store.(eh.EventStoreVersionLoader); ok {
+		events, err = store.LoadFrom(ctx, a.EntityID(), a.Version())
+	} else {
+		events, err = r.store.Load(ctx, a.EntityID())
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	// Skip the events already included in the snapshot, if any.
+	for len(events) > 0 && events[0].Version() <= a.Version() {
+		events = events[1:]
+	}
+
 	if err := r.applyEvents(ctx, a, events); err != nil {
 		return nil, err
 	}
@@ -135,10 +216,107 @@ func (r *AggregateStore) Save(ctx context.Context, agg eh.Aggregate) error {
 
 	for _, e := range events {
 		if err := r.eventHandler.HandleEvent(ctx, e); err != nil {
+			if r.outbox != nil {
+				// Leave this and the following events for the relay, to
+				// keep the order.
+				break
+			}
+			return err
+		}
+		if r.outbox != nil {
+			if err := r.outbox.MarkPublished(ctx, e); err != nil {
+				// The event will be published again by the relay.
+				break
+			}
+		}
+	}
+
+	if err := r.takeSnapshot(ctx, a, events[len(events)-1]); err != nil {
+		// The events are saved, the snapshot is taken on a later save.
+		err = fmt.Errorf("could not take snapshot: %w", err)
+		select {
+		case r.errCh <- eh.EventBusError{Err: err, Ctx: ctx, Event: events[len(events)-1]}:
+		default:
+			log.Printf("eventhorizon: missed error in aggregate store: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *AggregateStore) applySnapshot(ctx context.Context, a Aggregate) error {
+	sa, ok := a.(eh.Snapshotable)
+	if !ok || r.snapshotStore == nil {
+		return nil
+	}
+
+	snapshot, err := r.snapshotStore.LoadSnapshot(ctx, a.EntityID())
+	if err != nil {
+		return err
+	} else if snapshot == nil {
+		return nil
+	}
+
+	if snapshot.AggregateType != a.AggregateType() {
+		return ErrMismatchedSnapshotType
+	}
+
+	if err := sa.ApplySnapshot(snapshot); err != nil {
+		return err
+	}
+	if v, ok := a.(VersionSetter); ok {
+		v.SetAggregateVersion(snapshot.Version)
+	}
+	if a.Version() != snapshot.Version {
+		return ErrIncorrectSnapshotVersion
+	}
+	if t, ok := a.(SnapshotTracker); ok {
+		t.SetLastSnapshot(snapshot.Version, snapshot.Timestamp)
+	}
+
+	return nil
+}
+
+func (r *AggregateStore) takeSnapshot(ctx context.Context, a Aggregate, lastEvent eh.Event) error {
+	sa, ok := a.(eh.Snapshotable)
+	if !ok || r.snapshotStore == nil {
+		return nil
+	}
+
+	var lastVersion int
+	var lastTimestamp time.Time
+	tracker, tracked := a.(SnapshotTracker)
+	if tracked {
+		lastVersion, lastTimestamp = tracker.LastSnapshot()
+	} else {
+		last, err := r.snapshotStore.LoadSnapshot(ctx, a.EntityID())
+		if err != nil {
 			return err
+		} else if last != nil {
+			lastVersion = last.Version
+			lastTimestamp = last.Timestamp
 		}
 	}
 
+	if !r.snapshotStrategy.ShouldTakeSnapshot(lastVersion, lastTimestamp, lastEvent) {
+		return nil
+	}
+
+	snapshot := sa.CreateSnapshot()
+	if snapshot == nil {
+		return nil
+	}
+	snapshot.Version = a.Version()
+	snapshot.AggregateType = a.AggregateType()
+	snapshot.Timestamp = lastEvent.Timestamp()
+
+	if err := r.snapshotStore.SaveSnapshot(ctx, a.EntityID(), *snapshot); err != nil {
+		return err
+	}
+	if tracked {
+		tracker.SetLastSnapshot(snapshot.Version, snapshot.Timestamp)
+	}
+
 	return nil
 }
 
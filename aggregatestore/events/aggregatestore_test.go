@@ -23,6 +23,7 @@ import (
 
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventstore/memory"
 	"github.com/looplab/eventhorizon/mocks"
 )
 
@@ -236,6 +237,183 @@ func TestAggregateStore_AggregateNotRegistered(t *testing.T) {
 	}
 }
 
+func TestAggregateStore_Snapshots(t *testing.T) {
+	eventStore := memory.NewEventStore()
+	bus := &mocks.EventBus{
+		Events: make([]eh.Event, 0),
+	}
+
+	if _, err := NewAggregateStore(eventStore, bus, WithSnapshots(nil, EveryNumberOfEvents(2))); !errors.Is(err, ErrInvalidSnapshotStore) {
+		t.Error("there should be a ErrInvalidSnapshotStore error:", err)
+	}
+
+	store, err := NewAggregateStore(eventStore, bus, WithSnapshots(eventStore, EveryNumberOfEvents(2)))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	ctx := context.Background()
+
+	id := uuid.New()
+	agg := NewTestAggregateSnapshot(id)
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	// No snapshot should be taken after the first event.
+	agg.AppendEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp)
+	if err := store.Save(ctx, agg); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	snapshot, err := eventStore.LoadSnapshot(ctx, id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if snapshot != nil {
+		t.Error("there should be no snapshot:", snapshot)
+	}
+
+	// A snapshot should be taken after the second event.
+	agg.AppendEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp)
+	if err := store.Save(ctx, agg); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	snapshot, err = eventStore.LoadSnapshot(ctx, id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if snapshot == nil {
+		t.Fatal("there should be a snapshot")
+	}
+	if snapshot.Version != 2 || snapshot.AggregateType != TestAggregateSnapshotType {
+		t.Error("the snapshot should be correct:", snapshot)
+	}
+	if state, ok := snapshot.State.(*TestSnapshotState); !ok || state.Content != "event2" {
+		t.Error("the snapshot state should be correct:", snapshot.State)
+	}
+
+	// Load should restore the snapshot and apply the newer events only.
+	agg.AppendEvent(mocks.EventType, &mocks.EventData{Content: "event3"}, timestamp)
+	if err := store.Save(ctx, agg); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	loaded, err := store.Load(ctx, TestAggregateSnapshotType, id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	a, ok := loaded.(*TestAggregateSnapshot)
+	if !ok {
+		t.Fatal("the aggregate shoud be of correct type")
+	}
+	if a.Version() != 3 {
+		t.Error("the version should be 3:", a.Version())
+	}
+	if a.content != "event3" {
+		t.Error("the content should be correct:", a.content)
+	}
+	if a.applied != 1 {
+		t.Error("only the event after the snapshot should be applied:", a.applied)
+	}
+	if a.restored != 1 {
+		t.Error("the snapshot should be applied once:", a.restored)
+	}
+	if v, ts := a.LastSnapshot(); v != 2 || !ts.Equal(timestamp) {
+		t.Error("the last snapshot should be tracked:", v, ts)
+	}
+
+	// Snapshots that could not be saved should not fail saving, and the last
+	// snapshot should not be loaded again.
+	snapshotStore := &failingSnapshotStore{EventStore: eventStore, err: errors.New("snapshot error")}
+	store, err = NewAggregateStore(eventStore, bus, WithSnapshots(snapshotStore, EveryNumberOfEvents(1)))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	a.AppendEvent(mocks.EventType, &mocks.EventData{Content: "event4"}, timestamp)
+	if err := store.Save(ctx, a); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	select {
+	case err := <-store.Errors():
+		if !errors.Is(err.Err, snapshotStore.err) {
+			t.Error("there should be a snapshot error:", err)
+		}
+	default:
+		t.Error("there should be a snapshot error")
+	}
+	if snapshotStore.loaded != 0 {
+		t.Error("the last snapshot should not be loaded:", snapshotStore.loaded)
+	}
+}
+
+// failingSnapshotStore fails saving snapshots and counts loaded snapshots.
+type failingSnapshotStore struct {
+	*memory.EventStore
+	err    error
+	loaded int
+}
+
+func (s *failingSnapshotStore) LoadSnapshot(ctx context.Context, id uuid.UUID) (*eh.Snapshot, error) {
+	s.loaded++
+	return s.EventStore.LoadSnapshot(ctx, id)
+}
+
+func (s *failingSnapshotStore) SaveSnapshot(ctx context.Context, id uuid.UUID, snapshot eh.Snapshot) error {
+	return s.err
+}
+
+func TestAggregateStore_Outbox(t *testing.T) {
+	bus := &mocks.EventBus{
+		Events: make([]eh.Event, 0),
+	}
+
+	if _, err := NewAggregateStore(&mocks.EventStore{}, bus, WithOutbox()); !errors.Is(err, ErrInvalidOutboxStore) {
+		t.Error("there should be a ErrInvalidOutboxStore error:", err)
+	}
+
+	eventStore := memory.NewEventStore(memory.WithOutbox())
+	store, err := NewAggregateStore(eventStore, bus, WithOutbox())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	ctx := context.Background()
+
+	id := uuid.New()
+	agg := NewTestAggregateOther(id)
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	// Handled events should be marked as published.
+	event1 := agg.AppendEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp)
+	if err := store.Save(ctx, agg); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(bus.Events, []eh.Event{event1}) {
+		t.Error("there should be an event on the bus:", bus.Events)
+	}
+	unpublished, err := eventStore.LoadUnpublished(ctx, time.Now().Add(time.Second), 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(unpublished) != 0 {
+		t.Error("there should be no unpublished events:", unpublished)
+	}
+
+	// Events that could not be handled should be left in the outbox.
+	event2 := agg.AppendEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp)
+	bus.Err = errors.New("bus error")
+	if err := store.Save(ctx, agg); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	unpublished, err = eventStore.LoadUnpublished(ctx, time.Now().Add(time.Second), 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(unpublished) != 1 {
+		t.Fatal("there should be one unpublished event:", unpublished)
+	}
+	if err := eh.CompareEvents(unpublished[0].Event, event2); err != nil {
+		t.Error("the unpublished event should be correct:", err)
+	}
+}
+
 func createStore(t *testing.T) (*AggregateStore, *mocks.EventStore, *mocks.EventBus) {
 	eventStore := &mocks.EventStore{
 		Events: make([]eh.Event, 0),
@@ -259,6 +437,65 @@ func init() {
 	})
 }
 
+func init() {
+	eh.RegisterAggregate(func(id uuid.UUID) eh.Aggregate {
+		return NewTestAggregateSnapshot(id)
+	})
+	eh.RegisterSnapshotData(TestAggregateSnapshotType, func() eh.SnapshotData {
+		return &TestSnapshotState{}
+	})
+}
+
+const TestAggregateSnapshotType eh.AggregateType = "TestAggregateSnapshot"
+
+type TestSnapshotState struct {
+	Content string
+}
+
+type TestAggregateSnapshot struct {
+	*AggregateBase
+	content  string
+	applied  int
+	restored int
+}
+
+var _ = Aggregate(&TestAggregateSnapshot{})
+var _ = eh.Snapshotable(&TestAggregateSnapshot{})
+
+func NewTestAggregateSnapshot(id uuid.UUID) *TestAggregateSnapshot {
+	return &TestAggregateSnapshot{
+		AggregateBase: NewAggregateBase(TestAggregateSnapshotType, id),
+	}
+}
+
+func (a *TestAggregateSnapshot) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	return nil
+}
+
+func (a *TestAggregateSnapshot) ApplyEvent(ctx context.Context, event eh.Event) error {
+	if data, ok := event.Data().(*mocks.EventData); ok {
+		a.content = data.Content
+	}
+	a.applied++
+	return nil
+}
+
+func (a *TestAggregateSnapshot) CreateSnapshot() *eh.Snapshot {
+	return &eh.Snapshot{
+		State: &TestSnapshotState{Content: a.content},
+	}
+}
+
+func (a *TestAggregateSnapshot) ApplySnapshot(snapshot *eh.Snapshot) error {
+	state, ok := snapshot.State.(*TestSnapshotState)
+	if !ok {
+		return errors.New("invalid snapshot state")
+	}
+	a.content = state.Content
+	a.restored++
+	return nil
+}
+
 const TestAggregateOtherType eh.AggregateType = "TestAggregateOther"
 
 type TestAggregateOther struct {
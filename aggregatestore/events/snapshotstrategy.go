@@ -0,0 +1,48 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// SnapshotStrategy decides when a new snapshot should be taken of an aggregate.
+type SnapshotStrategy interface {
+	// ShouldTakeSnapshot is called with the version and timestamp of the last
+	// snapshot (zero values if there is none) and the last saved event.
+	ShouldTakeSnapshot(lastSnapshotVersion int, lastSnapshotTimestamp time.Time, event eh.Event) bool
+}
+
+// EveryNumberOfEvents is a snapshot strategy that takes a snapshot when at
+// least a number of events has been saved since the last snapshot.
+type EveryNumberOfEvents int
+
+// ShouldTakeSnapshot implements the ShouldTakeSnapshot method of the
+// SnapshotStrategy interface.
+func (n EveryNumberOfEvents) ShouldTakeSnapshot(lastSnapshotVersion int, lastSnapshotTimestamp time.Time, event eh.Event) bool {
+	return n > 0 && event.Version()-lastSnapshotVersion >= int(n)
+}
+
+// Periodic is a snapshot strategy that takes a snapshot when at least a
+// duration has passed between the last snapshot and the last event.
+type Periodic time.Duration
+
+// ShouldTakeSnapshot implements the ShouldTakeSnapshot method of the
+// SnapshotStrategy interface.
+func (d Periodic) ShouldTakeSnapshot(lastSnapshotVersion int, lastSnapshotTimestamp time.Time, event eh.Event) bool {
+	return event.Timestamp().Sub(lastSnapshotTimestamp) >= time.Duration(d)
+}
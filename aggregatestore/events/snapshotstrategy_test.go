@@ -0,0 +1,56 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestEveryNumberOfEvents(t *testing.T) {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(mocks.EventType, nil, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 5))
+
+	if EveryNumberOfEvents(5).ShouldTakeSnapshot(1, time.Time{}, event) {
+		t.Error("there should be no snapshot after 4 events")
+	}
+	if !EveryNumberOfEvents(5).ShouldTakeSnapshot(0, time.Time{}, event) {
+		t.Error("there should be a snapshot after 5 events")
+	}
+	if EveryNumberOfEvents(0).ShouldTakeSnapshot(0, time.Time{}, event) {
+		t.Error("there should never be a snapshot for 0")
+	}
+}
+
+func TestPeriodic(t *testing.T) {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(mocks.EventType, nil, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	if Periodic(time.Hour).ShouldTakeSnapshot(0, timestamp.Add(-time.Minute), event) {
+		t.Error("there should be no snapshot within the period")
+	}
+	if !Periodic(time.Hour).ShouldTakeSnapshot(0, timestamp.Add(-time.Hour), event) {
+		t.Error("there should be a snapshot after the period")
+	}
+	if !Periodic(time.Hour).ShouldTakeSnapshot(0, time.Time{}, event) {
+		t.Error("there should be a snapshot if there is no previous one")
+	}
+}
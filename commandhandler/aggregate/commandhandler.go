@@ -17,6 +17,10 @@ package aggregate
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jpillora/backoff"
 
 	eh "github.com/looplab/eventhorizon"
 )
@@ -24,6 +28,9 @@ import (
 // ErrNilAggregateStore is when a dispatcher is created with a nil aggregate store.
 var ErrNilAggregateStore = errors.New("aggregate store is nil")
 
+// ErrInvalidRetryPolicy is when a retry policy has no attempts.
+var ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+
 // CommandHandler dispatches commands to an aggregate.
 //
 // The dispatch process is as follows:
@@ -33,13 +40,17 @@ var ErrNilAggregateStore = errors.New("aggregate store is nil")
 // 4. The aggregate stores events in response to the command.
 // 5. The new events are stored in the event store.
 // 6. The events are published on the event bus after a successful store.
+//
+// With a retry policy, steps 2-5 are repeated if the aggregate was changed by
+// an other command between loading and storing it.
 type CommandHandler struct {
 	t     eh.AggregateType
 	store eh.AggregateStore
+	retry *RetryPolicy
 }
 
 // NewCommandHandler creates a new CommandHandler for an aggregate type.
-func NewCommandHandler(t eh.AggregateType, store eh.AggregateStore) (*CommandHandler, error) {
+func NewCommandHandler(t eh.AggregateType, store eh.AggregateStore, options ...Option) (*CommandHandler, error) {
 	if store == nil {
 		return nil, ErrNilAggregateStore
 	}
@@ -48,9 +59,54 @@ func NewCommandHandler(t eh.AggregateType, store eh.AggregateStore) (*CommandHan
 		t:     t,
 		store: store,
 	}
+
+	for _, option := range options {
+		if err := option(h); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
 	return h, nil
 }
 
+// Option is an option setter used to configure creation.
+type Option func(*CommandHandler) error
+
+// RetryPolicy is the policy for retrying commands after optimistic
+// concurrency conflicts.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of times to handle a command, including
+	// the first attempt.
+	MaxAttempts int
+	// Backoff is used to get the delay before each retry, using the attempt
+	// number. No delay is used if nil.
+	Backoff *backoff.Backoff
+	// IsConflict returns true for errors that should be retried, uses
+	// IsConflict if nil.
+	IsConflict func(error) bool
+}
+
+// IsConflict returns true if the error is an optimistic concurrency conflict
+// from the event store, see eventhorizon.ErrEventConflictFromOtherSave.
+func IsConflict(err error) bool {
+	return errors.Is(err, eh.ErrEventConflictFromOtherSave)
+}
+
+// WithRetry retries commands that fail because of a conflict when saving the
+// aggregate, by loading the aggregate again and re-running the command.
+func WithRetry(policy RetryPolicy) Option {
+	return func(h *CommandHandler) error {
+		if policy.MaxAttempts < 1 {
+			return ErrInvalidRetryPolicy
+		}
+		if policy.IsConflict == nil {
+			policy.IsConflict = IsConflict
+		}
+		h.retry = &policy
+		return nil
+	}
+}
+
 // HandleCommand handles a command with the registered aggregate.
 // Returns ErrAggregateNotFound if no aggregate could be found.
 func (h *CommandHandler) HandleCommand(ctx context.Context, cmd eh.Command) error {
@@ -59,6 +115,27 @@ func (h *CommandHandler) HandleCommand(ctx context.Context, cmd eh.Command) erro
 		return err
 	}
 
+	if h.retry == nil {
+		return h.handleCommand(ctx, cmd)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := h.handleCommand(ctx, cmd)
+		if err == nil || attempt >= h.retry.MaxAttempts || !h.retry.IsConflict(err) {
+			return err
+		}
+
+		if h.retry.Backoff != nil {
+			select {
+			case <-time.After(h.retry.Backoff.ForAttempt(float64(attempt - 1))):
+			case <-ctx.Done():
+				return err
+			}
+		}
+	}
+}
+
+func (h *CommandHandler) handleCommand(ctx context.Context, cmd eh.Command) error {
 	a, err := h.store.Load(ctx, h.t, cmd.AggregateID())
 	if err != nil {
 		return err
@@ -19,8 +19,10 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jpillora/backoff"
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/mocks"
 )
@@ -136,6 +138,120 @@ func TestCommandHandler_NoHandlers(t *testing.T) {
 	}
 }
 
+func TestCommandHandler_RetryOnConflict(t *testing.T) {
+	a := mocks.NewAggregate(uuid.New())
+	store := &conflictStore{
+		AggregateStore: mocks.AggregateStore{
+			Aggregates: map[uuid.UUID]eh.Aggregate{
+				a.EntityID(): a,
+			},
+		},
+		conflicts: 2,
+	}
+
+	if _, err := NewCommandHandler(mocks.AggregateType, store,
+		WithRetry(RetryPolicy{}),
+	); !errors.Is(err, ErrInvalidRetryPolicy) {
+		t.Error("there should be a ErrInvalidRetryPolicy error:", err)
+	}
+
+	h, err := NewCommandHandler(mocks.AggregateType, store, WithRetry(RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     &backoff.Backoff{Min: time.Millisecond, Max: 10 * time.Millisecond},
+	}))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// The command should succeed on the last attempt.
+	cmd := &mocks.Command{
+		ID:      a.EntityID(),
+		Content: "command1",
+	}
+	if err := h.HandleCommand(context.Background(), cmd); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if store.saves != 3 {
+		t.Error("the aggregate should be saved three times:", store.saves)
+	}
+	if len(a.Commands) != 3 {
+		t.Error("the command should be handled three times:", a.Commands)
+	}
+
+	// The last conflict should be returned when running out of attempts.
+	store.conflicts = 3
+	store.saves = 0
+	err = h.HandleCommand(context.Background(), cmd)
+	if !errors.Is(err, eh.ErrEventConflictFromOtherSave) {
+		t.Error("there should be a ErrEventConflictFromOtherSave error:", err)
+	}
+	if store.saves != 3 {
+		t.Error("the aggregate should be saved three times:", store.saves)
+	}
+
+	// Other errors should not be retried.
+	saveErr := errors.New("save error")
+	store.Err = saveErr
+	store.saves = 0
+	err = h.HandleCommand(context.Background(), cmd)
+	if !errors.Is(err, saveErr) {
+		t.Error("there should be a save error:", err)
+	}
+}
+
+func TestCommandHandler_RetryCustomConflict(t *testing.T) {
+	a := mocks.NewAggregate(uuid.New())
+	store := &conflictStore{
+		AggregateStore: mocks.AggregateStore{
+			Aggregates: map[uuid.UUID]eh.Aggregate{
+				a.EntityID(): a,
+			},
+		},
+	}
+	saveErr := errors.New("save error")
+	h, err := NewCommandHandler(mocks.AggregateType, store, WithRetry(RetryPolicy{
+		MaxAttempts: 2,
+		IsConflict: func(err error) bool {
+			return errors.Is(err, saveErr)
+		},
+	}))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	store.saveErr = saveErr
+	cmd := &mocks.Command{
+		ID:      a.EntityID(),
+		Content: "command1",
+	}
+	if err := h.HandleCommand(context.Background(), cmd); !errors.Is(err, saveErr) {
+		t.Error("there should be a save error:", err)
+	}
+	if store.saves != 2 {
+		t.Error("the aggregate should be saved twice:", store.saves)
+	}
+}
+
+// conflictStore is an aggregate store that fails saves with a conflict.
+type conflictStore struct {
+	mocks.AggregateStore
+	conflicts int
+	saveErr   error
+	saves     int
+}
+
+func (s *conflictStore) Save(ctx context.Context, a eh.Aggregate) error {
+	s.saves++
+	if s.saveErr != nil {
+		return s.saveErr
+	}
+	if s.conflicts > 0 {
+		s.conflicts--
+		return eh.EventStoreError{Err: eh.ErrEventConflictFromOtherSave}
+	}
+	return s.AggregateStore.Save(ctx, a)
+}
+
 func BenchmarkCommandHandler(b *testing.B) {
 	a := mocks.NewAggregate(uuid.New())
 	store := &mocks.AggregateStore{
@@ -54,6 +54,16 @@ type Event interface {
 	String() string
 }
 
+// PositionedEvent is an event loaded from an event store that keeps a global
+// position for all events in a namespace.
+type PositionedEvent interface {
+	Event
+
+	// Position is the global position of the event in its namespace, starting
+	// at 1. Events that has not been saved has position 0.
+	Position() int64
+}
+
 // EventType is the type of an event, used as its unique identifier.
 type EventType string
 
@@ -95,6 +105,16 @@ func WithMetadata(metadata map[string]interface{}) EventOption {
 	}
 }
 
+// WithPosition adds the global position of an event, used by event stores
+// when loading events.
+func WithPosition(position int64) EventOption {
+	return func(e Event) {
+		if evt, ok := e.(*event); ok {
+			evt.position = position
+		}
+	}
+}
+
 // FromCommand adds metadat for the originating command when crating an event.
 // Currently it adds the command type and optionally a command ID (if the
 // CommandIDer interface is implemented).
@@ -144,6 +164,7 @@ type event struct {
 	aggregateID   uuid.UUID
 	version       int
 	metadata      map[string]interface{}
+	position      int64
 }
 
 // EventType implements the EventType method of the Event interface.
@@ -181,6 +202,11 @@ func (e event) Metadata() map[string]interface{} {
 	return e.metadata
 }
 
+// Position implements the Position method of the PositionedEvent interface.
+func (e event) Position() int64 {
+	return e.position
+}
+
 // String implements the String method of the Event interface.
 func (e event) String() string {
 	return fmt.Sprintf("%s@%d", e.eventType, e.version)
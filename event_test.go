@@ -82,6 +82,14 @@ func TestNewEvent(t *testing.T) {
 	if event.String() != "TestEvent@3" {
 		t.Error("the string representation should be correct:", event.String())
 	}
+
+	event = NewEvent(TestEventType, nil, timestamp,
+		ForAggregate(TestAggregateType, id, 3),
+		WithPosition(42),
+	)
+	if e, ok := event.(PositionedEvent); !ok || e.Position() != 42 {
+		t.Error("the position should be correct:", event)
+	}
 }
 
 func TestCreateEventData(t *testing.T) {
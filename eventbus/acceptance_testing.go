@@ -26,6 +26,7 @@ import (
 	"github.com/kr/pretty"
 
 	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventbus/dlq"
 	"github.com/looplab/eventhorizon/middleware/eventhandler/observer"
 	"github.com/looplab/eventhorizon/mocks"
 )
@@ -243,6 +244,79 @@ func AcceptanceTest(t *testing.T, bus1, bus2 eh.EventBus, timeout time.Duration)
 	bus2.Wait()
 }
 
+// DeadLetterAcceptanceTest is the acceptance test for event buses with a dead
+// letter policy. The bus should be created with the queue in its policy:
+//
+//   func TestEventBusDeadLetters(t *testing.T) {
+//       q := dlq.NewMemoryQueue()
+//       bus := NewEventBus(WithDeadLetterPolicy(&dlq.Policy{MaxAttempts: 3, Queue: q}))
+//       eventbus.DeadLetterAcceptanceTest(t, bus, q, time.Second)
+//   }
+//
+func DeadLetterAcceptanceTest(t *testing.T, bus eh.EventBus, q dlq.Queue, timeout time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handlerErr := errors.New("handler error")
+	handler := mocks.NewEventHandler("dead-letter-handler")
+	handler.Err = handlerErr
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Events that can not be handled should be added to the queue.
+	ctx = mocks.WithContextOne(ctx, "testval")
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	select {
+	case err := <-bus.Errors():
+		if !dlq.IsDeadLettered(err) || !errors.Is(err, handlerErr) {
+			t.Error("there should be a dead letter error:", err)
+		}
+	case <-time.After(timeout):
+		t.Fatal("there should be an error")
+	}
+
+	entries, err := q.List(context.Background())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(entries) != 1 {
+		t.Fatal("there should be one entry:", entries)
+	}
+	e := entries[0]
+	if e.HandlerType != handler.HandlerType() || e.Err == "" || e.Attempts < 1 {
+		t.Error("the entry should be correct:", e)
+	}
+	if err := eh.CompareEvents(e.Event, event); err != nil {
+		t.Error("the event should be correct:", err)
+	}
+
+	// Redriven events should be handled with their context.
+	handler.Lock()
+	handler.Err = nil
+	handler.Unlock()
+	if err := dlq.Redrive(context.Background(), q, handler, dlq.MatchHandlerType(handler.HandlerType())); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	handler.RLock()
+	if len(handler.Events) != 1 {
+		t.Error("the event should be handled:", handler.Events)
+	}
+	if val, ok := mocks.ContextOne(handler.Context); !ok || val != "testval" {
+		t.Error("the context should be correct:", handler.Context)
+	}
+	handler.RUnlock()
+	if entries, _ := q.List(context.Background()); len(entries) != 0 {
+		t.Error("there should be no entries:", entries)
+	}
+}
+
 // LoadTest is a load test for an event bus implementation.
 func LoadTest(t *testing.T, bus eh.EventBus) {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -0,0 +1,124 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dlq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// QueueAcceptanceTest is the acceptance test that all implementations of
+// Queue should pass. It should manually be called from a test case in each
+// implementation:
+//
+//	func TestQueue(t *testing.T) {
+//	    q := NewQueue()
+//	    dlq.QueueAcceptanceTest(t, q)
+//	}
+func QueueAcceptanceTest(t *testing.T, q Queue) {
+	ctx := context.Background()
+
+	entries, err := q.List(ctx)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(entries) != 0 {
+		t.Error("there should be no entries:", entries)
+	}
+
+	// Add entries for events in different namespaces.
+	timestamp := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	entry1 := &Entry{
+		ID:          uuid.New(),
+		HandlerType: "handler",
+		Event: eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"},
+			timestamp, eh.ForAggregate(mocks.AggregateType, uuid.New(), 1)),
+		Context:  eh.MarshalContext(eh.NewContextWithNamespace(ctx, "ns")),
+		Err:      "handler error",
+		Attempts: 3,
+		FailedAt: timestamp,
+	}
+	if err := q.Add(ctx, entry1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	entry2 := &Entry{
+		ID:          uuid.New(),
+		HandlerType: "other",
+		Event: eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"},
+			timestamp, eh.ForAggregate(mocks.AggregateType, uuid.New(), 2)),
+		Context:  eh.MarshalContext(ctx),
+		Err:      "other error",
+		Attempts: 1,
+		FailedAt: timestamp,
+	}
+	if err := q.Add(ctx, entry2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	entries, err = q.List(ctx)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("there should be two entries:", entries)
+	}
+	checkEntry(t, entries[0], entry1)
+	checkEntry(t, entries[1], entry2)
+	if ns := eh.NamespaceFromContext(eh.UnmarshalContext(ctx, entries[0].Context)); ns != "ns" {
+		t.Error("the namespace should be kept:", ns)
+	}
+
+	// Remove an entry.
+	if err := q.Remove(ctx, entry1.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := q.Remove(ctx, entry1.ID); !errors.Is(err, ErrEntryNotFound) {
+		t.Error("there should be a entry not found error:", err)
+	}
+	if err := q.Remove(ctx, uuid.New()); !errors.Is(err, ErrEntryNotFound) {
+		t.Error("there should be a entry not found error:", err)
+	}
+
+	entries, err = q.List(ctx)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(entries) != 1 {
+		t.Fatal("there should be one entry:", entries)
+	}
+	checkEntry(t, entries[0], entry2)
+}
+
+func checkEntry(t *testing.T, e, expected *Entry) {
+	t.Helper()
+
+	if e.ID != expected.ID ||
+		e.HandlerType != expected.HandlerType ||
+		e.Err != expected.Err ||
+		e.Attempts != expected.Attempts ||
+		!e.FailedAt.Equal(expected.FailedAt) {
+		t.Error("the entry should be correct:", e)
+	}
+	if err := eh.CompareEvents(e.Event, expected.Event); err != nil {
+		t.Error("the event should be correct:", err)
+	}
+}
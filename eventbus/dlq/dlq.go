@@ -0,0 +1,222 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dlq implements a dead letter queue for event buses. When an event
+// bus is configured with a Policy, events that could not be handled after a
+// number of attempts are added to a Queue, where they can be listed and
+// re-driven into a handler once the problem has been fixed.
+package dlq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+var (
+	// ErrMissingQueue is when a policy has no queue.
+	ErrMissingQueue = errors.New("missing dead letter queue")
+	// ErrEntryNotFound is when an entry is not in the queue.
+	ErrEntryNotFound = errors.New("dead letter not found")
+	// ErrInvalidEntry is when an entry in the queue could not be decoded.
+	ErrInvalidEntry = errors.New("invalid dead letter")
+)
+
+// Entry is an event that could not be handled, with the context it was
+// handled with and the last error.
+type Entry struct {
+	// ID is the unique ID of the entry.
+	ID uuid.UUID
+	// HandlerType is the type of the handler that failed.
+	HandlerType eh.EventHandlerType
+	// Event is the event that could not be handled.
+	Event eh.Event
+	// Context is the marshaled context that the event was handled with, see
+	// eventhorizon.MarshalContext.
+	Context map[string]interface{}
+	// Err is the error from the last attempt.
+	Err string
+	// Attempts is the number of attempts made to handle the event.
+	Attempts int
+	// FailedAt is the time of the last attempt.
+	FailedAt time.Time
+}
+
+// Queue is a dead letter queue that stores entries in the order they are added.
+type Queue interface {
+	// Add adds an entry to the queue.
+	Add(ctx context.Context, entry *Entry) error
+
+	// List lists all entries in the queue, in the order they were added.
+	// Entries that could not be decoded are skipped and reported with an
+	// error wrapping ErrInvalidEntry, which is returned with the other entries.
+	List(ctx context.Context) ([]*Entry, error)
+
+	// Remove removes an entry from the queue. Returns ErrEntryNotFound if the
+	// entry is not in the queue.
+	Remove(ctx context.Context, id uuid.UUID) error
+}
+
+// Policy decides when an event is added to a dead letter queue. It is used by
+// the event buses with their WithDeadLetterPolicy options.
+type Policy struct {
+	// MaxAttempts is the number of attempts to handle an event before it is
+	// added to the queue. Values below 1 are treated as 1.
+	MaxAttempts int
+	// Delay is the time to wait between attempts.
+	Delay time.Duration
+	// Queue is the queue to add events to.
+	Queue Queue
+}
+
+// Validate returns ErrMissingQueue if the policy has no queue. A nil policy is
+// valid. It is used by the WithDeadLetterPolicy options of the event buses.
+func (p *Policy) Validate() error {
+	if p != nil && p.Queue == nil {
+		return ErrMissingQueue
+	}
+
+	return nil
+}
+
+// HandleEvent handles an event with a handler according to the policy. If all
+// attempts fail the event is added to the queue and an Error is returned, which
+// means that the event should not be delivered again. If the event could not be
+// added to the queue, including when the policy has no queue, the handler error
+// is returned with the reason appended. A nil policy handles the event once, making it
+// possible for event buses to call it unconditionally.
+func (p *Policy) HandleEvent(ctx context.Context, h eh.EventHandler, event eh.Event) error {
+	if p == nil {
+		return h.HandleEvent(ctx, event)
+	}
+
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var err error
+	attempt := 0
+	for attempt < maxAttempts {
+		attempt++
+		if err = h.HandleEvent(ctx, event); err == nil {
+			return nil
+		}
+
+		if attempt < maxAttempts && p.Delay > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(p.Delay):
+			}
+		}
+	}
+
+	if p.Queue == nil {
+		return fmt.Errorf("%w (could not add to dead letter queue: %s)", err, ErrMissingQueue)
+	}
+
+	entry := &Entry{
+		ID:          uuid.New(),
+		HandlerType: h.HandlerType(),
+		Event:       event,
+		Context:     eh.MarshalContext(ctx),
+		Err:         err.Error(),
+		Attempts:    attempt,
+		FailedAt:    time.Now(),
+	}
+	if qErr := p.Queue.Add(ctx, entry); qErr != nil {
+		return fmt.Errorf("%w (could not add to dead letter queue: %s)", err, qErr)
+	}
+
+	return Error{
+		Err:      err,
+		EntryID:  entry.ID,
+		Attempts: attempt,
+	}
+}
+
+// Error is an error for an event that has been added to the dead letter queue.
+type Error struct {
+	// Err is the error from the last attempt.
+	Err error
+	// EntryID is the ID of the entry in the queue.
+	EntryID uuid.UUID
+	// Attempts is the number of attempts made to handle the event.
+	Attempts int
+}
+
+// Error implements the Error method of the error interface.
+func (e Error) Error() string {
+	return fmt.Sprintf("dead lettered after %d attempts: %s", e.Attempts, e.Err)
+}
+
+// Unwrap implements the errors.Unwrap method.
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// Cause implements the github.com/pkg/errors Unwrap method.
+func (e Error) Cause() error {
+	return e.Unwrap()
+}
+
+// IsDeadLettered returns true if the error is, or wraps, an Error.
+func IsDeadLettered(err error) bool {
+	var e Error
+	return errors.As(err, &e)
+}
+
+// Redrive handles the entries in the queue that matches with a handler, in the
+// order they were added, and removes them when handled. A nil match function
+// matches all entries. Stops at the first error. Entries that could not be
+// decoded are skipped, and reported after the other entries are handled.
+func Redrive(ctx context.Context, q Queue, h eh.EventHandler, match func(*Entry) bool) error {
+	entries, listErr := q.List(ctx)
+	if listErr != nil && !errors.Is(listErr, ErrInvalidEntry) {
+		return fmt.Errorf("could not list dead letters: %w", listErr)
+	}
+
+	for _, e := range entries {
+		if match != nil && !match(e) {
+			continue
+		}
+
+		if err := h.HandleEvent(eh.UnmarshalContext(ctx, e.Context), e.Event); err != nil {
+			return fmt.Errorf("could not redrive dead letter %s: %w", e.ID, err)
+		}
+		if err := q.Remove(ctx, e.ID); err != nil {
+			return fmt.Errorf("could not remove dead letter %s: %w", e.ID, err)
+		}
+	}
+
+	if listErr != nil {
+		return fmt.Errorf("could not list all dead letters: %w", listErr)
+	}
+
+	return nil
+}
+
+// MatchHandlerType returns a match function for Redrive that matches the
+// entries of a handler type.
+func MatchHandlerType(t eh.EventHandlerType) func(*Entry) bool {
+	return func(e *Entry) bool {
+		return e.HandlerType == t
+	}
+}
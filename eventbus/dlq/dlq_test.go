@@ -0,0 +1,153 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dlq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestPolicy(t *testing.T) {
+	q := NewMemoryQueue()
+	p := &Policy{
+		MaxAttempts: 3,
+		Delay:       time.Millisecond,
+		Queue:       q,
+	}
+	h := &failingHandler{EventHandler: mocks.NewEventHandler("handler"), failures: 2}
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	// The event should be handled on the last attempt.
+	if err := p.HandleEvent(ctx, h, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if h.attempts != 3 {
+		t.Error("the event should be handled three times:", h.attempts)
+	}
+
+	// The event should be added to the queue after the last attempt.
+	h.attempts = 0
+	h.failures = 3
+	err := p.HandleEvent(ctx, h, event)
+	if !IsDeadLettered(err) || !errors.Is(err, errHandler) {
+		t.Error("there should be a dead letter error:", err)
+	}
+	entries, err := q.List(ctx)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(entries) != 1 {
+		t.Fatal("there should be one entry:", entries)
+	}
+	if e := entries[0]; e.HandlerType != "handler" || e.Event != event ||
+		e.Attempts != 3 || e.Err != errHandler.Error() {
+		t.Error("the entry should be correct:", e)
+	}
+
+	// Policies without a queue should be invalid, but still handle events and
+	// only fail when dead lettering.
+	if err := (&Policy{}).Validate(); !errors.Is(err, ErrMissingQueue) {
+		t.Error("there should be a missing queue error:", err)
+	}
+	if err := (*Policy)(nil).Validate(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	h.attempts = 0
+	h.failures = 0
+	if err := (&Policy{}).HandleEvent(ctx, h, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	h.failures = 1
+	err = (&Policy{}).HandleEvent(ctx, h, event)
+	if !errors.Is(err, errHandler) || IsDeadLettered(err) ||
+		!strings.Contains(err.Error(), ErrMissingQueue.Error()) {
+		t.Error("there should be a missing queue error:", err)
+	}
+}
+
+func TestRedrive(t *testing.T) {
+	q := NewMemoryQueue()
+	ctx := context.Background()
+	for i, ht := range []eh.EventHandlerType{"handler", "other", "handler"} {
+		if err := q.Add(ctx, &Entry{
+			ID:          uuid.New(),
+			HandlerType: ht,
+			Event: eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, time.Now(),
+				eh.ForAggregate(mocks.AggregateType, uuid.New(), i+1)),
+			Context: eh.MarshalContext(eh.NewContextWithNamespace(ctx, "ns")),
+		}); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+
+	// Failed entries should be kept.
+	h := &failingHandler{EventHandler: mocks.NewEventHandler("handler"), failures: 1}
+	if err := Redrive(ctx, q, h, MatchHandlerType("handler")); !errors.Is(err, errHandler) {
+		t.Error("there should be a handler error:", err)
+	}
+	if entries, _ := q.List(ctx); len(entries) != 3 {
+		t.Error("there should be three entries:", entries)
+	}
+
+	// Handled entries should be removed.
+	if err := Redrive(ctx, q, h, MatchHandlerType("handler")); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	entries, _ := q.List(ctx)
+	if len(entries) != 1 || entries[0].HandlerType != "other" {
+		t.Error("there should be one other entry:", entries)
+	}
+	if len(h.Events) != 2 || h.Events[0].Version() != 1 || h.Events[1].Version() != 3 {
+		t.Error("the events should be handled in order:", h.Events)
+	}
+	if ns := eh.NamespaceFromContext(h.Context); ns != "ns" {
+		t.Error("the context should be restored:", ns)
+	}
+
+	if err := Redrive(ctx, q, h, nil); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if entries, _ := q.List(ctx); len(entries) != 0 {
+		t.Error("there should be no entries:", entries)
+	}
+}
+
+var errHandler = errors.New("handler error")
+
+// failingHandler fails a number of times before handling events.
+type failingHandler struct {
+	*mocks.EventHandler
+	failures int
+	attempts int
+}
+
+func (h *failingHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	h.attempts++
+	if h.failures > 0 {
+		h.failures--
+		return errHandler
+	}
+	return h.EventHandler.HandleEvent(ctx, event)
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dlq
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/codec/json"
+)
+
+// DefaultNamespace is the default namespace of an EventStoreQueue.
+const DefaultNamespace = "dead_letters"
+
+// listBatchSize is the number of events loaded at a time when listing.
+const listBatchSize = 1000
+
+const (
+	// DeadLetterAggregateType is the aggregate type of the entries in an
+	// EventStoreQueue, each entry is a separate aggregate.
+	DeadLetterAggregateType eh.AggregateType = "dead_letter"
+
+	// DeadLetterAddedEvent is when an entry is added to an EventStoreQueue.
+	DeadLetterAddedEvent eh.EventType = "dead_letter_added"
+	// DeadLetterRemovedEvent is when an entry is removed from an EventStoreQueue.
+	DeadLetterRemovedEvent eh.EventType = "dead_letter_removed"
+)
+
+func init() {
+	eh.RegisterEventData(DeadLetterAddedEvent, func() eh.EventData {
+		return &DeadLetterAddedData{}
+	})
+}
+
+// DeadLetterAddedData is the event data for the DeadLetterAddedEvent.
+type DeadLetterAddedData struct {
+	HandlerType eh.EventHandlerType `json:"handler_type" bson:"handler_type"`
+	// Event is the event and its context, encoded with the JSON codec.
+	Event    []byte    `json:"event"     bson:"event"`
+	Err      string    `json:"err"       bson:"err"`
+	Attempts int       `json:"attempts"  bson:"attempts"`
+	FailedAt time.Time `json:"failed_at" bson:"failed_at"`
+}
+
+// EventStoreQueue is a Queue that stores its entries as events in a namespace
+// of an event store, which makes the queue as durable as the store.
+type EventStoreQueue struct {
+	store     eh.EventStorePositionLoader
+	namespace string
+	codec     eh.EventCodec
+}
+
+// NewEventStoreQueue creates a new EventStoreQueue using a namespace of the
+// store, or DefaultNamespace if empty.
+func NewEventStoreQueue(store eh.EventStorePositionLoader, namespace string) *EventStoreQueue {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	return &EventStoreQueue{
+		store:     store,
+		namespace: namespace,
+		codec:     &json.EventCodec{},
+	}
+}
+
+// Add implements the Add method of the Queue interface.
+func (q *EventStoreQueue) Add(ctx context.Context, entry *Entry) error {
+	data, err := q.codec.MarshalEvent(eh.UnmarshalContext(context.Background(), entry.Context), entry.Event)
+	if err != nil {
+		return fmt.Errorf("could not marshal event: %w", err)
+	}
+
+	event := eh.NewEvent(DeadLetterAddedEvent, &DeadLetterAddedData{
+		HandlerType: entry.HandlerType,
+		Event:       data,
+		Err:         entry.Err,
+		Attempts:    entry.Attempts,
+		FailedAt:    entry.FailedAt,
+	}, time.Now(), eh.ForAggregate(DeadLetterAggregateType, entry.ID, 1))
+	if err := q.store.Save(q.ctx(ctx), []eh.Event{event}, 0); err != nil {
+		return fmt.Errorf("could not save dead letter: %w", err)
+	}
+
+	return nil
+}
+
+// List implements the List method of the Queue interface. The events of the
+// namespace are loaded in batches of listBatchSize.
+func (q *EventStoreQueue) List(ctx context.Context) ([]*Entry, error) {
+	var (
+		entries  []*Entry
+		index    = map[uuid.UUID]int{}
+		invalid  = map[uuid.UUID]bool{}
+		position int64
+	)
+	for {
+		events, err := q.store.LoadAll(q.ctx(ctx), position, listBatchSize)
+		if err != nil {
+			return nil, fmt.Errorf("could not load dead letters: %w", err)
+		}
+
+		for _, event := range events {
+			if e, ok := event.(eh.PositionedEvent); ok {
+				position = e.Position()
+			}
+
+			switch event.EventType() {
+			case DeadLetterAddedEvent:
+				entry, err := q.entry(event)
+				if err != nil {
+					invalid[event.AggregateID()] = true
+					continue
+				}
+				index[entry.ID] = len(entries)
+				entries = append(entries, entry)
+			case DeadLetterRemovedEvent:
+				// Entries are always added before they are removed.
+				if i, ok := index[event.AggregateID()]; ok {
+					entries[i] = nil
+				}
+				delete(invalid, event.AggregateID())
+			}
+		}
+
+		if len(events) < listBatchSize {
+			break
+		}
+	}
+
+	// Compact the removed entries.
+	result := entries[:0]
+	for _, entry := range entries {
+		if entry != nil {
+			result = append(result, entry)
+		}
+	}
+
+	if len(invalid) > 0 {
+		ids := make([]string, 0, len(invalid))
+		for id := range invalid {
+			ids = append(ids, id.String())
+		}
+		sort.Strings(ids)
+		return result, fmt.Errorf("%w: %s", ErrInvalidEntry, strings.Join(ids, ", "))
+	}
+
+	return result, nil
+}
+
+// Remove implements the Remove method of the Queue interface.
+func (q *EventStoreQueue) Remove(ctx context.Context, id uuid.UUID) error {
+	events, err := q.store.Load(q.ctx(ctx), id)
+	if err != nil {
+		return fmt.Errorf("could not load dead letter: %w", err)
+	}
+	if len(events) != 1 {
+		return ErrEntryNotFound
+	}
+
+	event := eh.NewEvent(DeadLetterRemovedEvent, nil, time.Now(),
+		eh.ForAggregate(DeadLetterAggregateType, id, 2))
+	if err := q.store.Save(q.ctx(ctx), []eh.Event{event}, 1); err != nil {
+		return fmt.Errorf("could not remove dead letter: %w", err)
+	}
+
+	return nil
+}
+
+func (q *EventStoreQueue) ctx(ctx context.Context) context.Context {
+	return eh.NewContextWithNamespace(ctx, q.namespace)
+}
+
+func (q *EventStoreQueue) entry(event eh.Event) (*Entry, error) {
+	data, ok := event.Data().(*DeadLetterAddedData)
+	if !ok {
+		return nil, fmt.Errorf("invalid dead letter data: %T", event.Data())
+	}
+
+	e, eventCtx, err := q.codec.UnmarshalEvent(context.Background(), data.Event)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal event: %w", err)
+	}
+
+	return &Entry{
+		ID:          event.AggregateID(),
+		HandlerType: data.HandlerType,
+		Event:       e,
+		Context:     eh.MarshalContext(eventCtx),
+		Err:         data.Err,
+		Attempts:    data.Attempts,
+		FailedAt:    data.FailedAt,
+	}, nil
+}
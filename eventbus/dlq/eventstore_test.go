@@ -0,0 +1,94 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dlq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestEventStoreQueue(t *testing.T) {
+	store := memory.NewEventStore()
+	q := NewEventStoreQueue(store, "")
+	QueueAcceptanceTest(t, q)
+
+	// The entries should be stored in the namespace of the queue.
+	ctx := eh.NewContextWithNamespace(context.Background(), DefaultNamespace)
+	events, err := store.LoadAll(ctx, 0, 0)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(events) != 3 {
+		t.Error("there should be three events:", events)
+	}
+	events, err = store.LoadAll(context.Background(), 0, 0)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(events) != 0 {
+		t.Error("there should be no events in the default namespace:", events)
+	}
+}
+
+func TestEventStoreQueueInvalidEntry(t *testing.T) {
+	store := memory.NewEventStore()
+	q := NewEventStoreQueue(store, "")
+	ctx := context.Background()
+	entry := &Entry{
+		ID:          uuid.New(),
+		HandlerType: "handler",
+		Event: eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, time.Now(),
+			eh.ForAggregate(mocks.AggregateType, uuid.New(), 1)),
+	}
+	if err := q.Add(ctx, entry); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Add an entry with an event that can not be decoded.
+	invalidID := uuid.New()
+	event := eh.NewEvent(DeadLetterAddedEvent, &DeadLetterAddedData{
+		HandlerType: "handler",
+		Event:       []byte("invalid"),
+	}, time.Now(), eh.ForAggregate(DeadLetterAggregateType, invalidID, 1))
+	if err := store.Save(q.ctx(ctx), []eh.Event{event}, 0); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// The invalid entry should be skipped and reported.
+	entries, err := q.List(ctx)
+	if !errors.Is(err, ErrInvalidEntry) || !strings.Contains(err.Error(), invalidID.String()) {
+		t.Error("there should be an invalid entry error:", err)
+	}
+	if len(entries) != 1 || entries[0].ID != entry.ID {
+		t.Error("the valid entries should be listed:", entries)
+	}
+
+	// Removed invalid entries should not be reported.
+	if err := q.Remove(ctx, invalidID); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if entries, err := q.List(ctx); err != nil || len(entries) != 1 {
+		t.Error("there should be no error:", entries, err)
+	}
+}
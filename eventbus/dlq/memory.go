@@ -0,0 +1,74 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dlq
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// MemoryQueue is a Queue in memory, useful for testing and for processes that
+// inspect their dead letters themselves.
+type MemoryQueue struct {
+	entries   []*Entry
+	entriesMu sync.RWMutex
+}
+
+// NewMemoryQueue creates a new MemoryQueue.
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{}
+}
+
+// Add implements the Add method of the Queue interface.
+func (q *MemoryQueue) Add(ctx context.Context, entry *Entry) error {
+	q.entriesMu.Lock()
+	defer q.entriesMu.Unlock()
+
+	e := *entry
+	q.entries = append(q.entries, &e)
+
+	return nil
+}
+
+// List implements the List method of the Queue interface.
+func (q *MemoryQueue) List(ctx context.Context) ([]*Entry, error) {
+	q.entriesMu.RLock()
+	defer q.entriesMu.RUnlock()
+
+	entries := make([]*Entry, len(q.entries))
+	for i, e := range q.entries {
+		entry := *e
+		entries[i] = &entry
+	}
+
+	return entries, nil
+}
+
+// Remove implements the Remove method of the Queue interface.
+func (q *MemoryQueue) Remove(ctx context.Context, id uuid.UUID) error {
+	q.entriesMu.Lock()
+	defer q.entriesMu.Unlock()
+
+	for i, e := range q.entries {
+		if e.ID == id {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrEntryNotFound
+}
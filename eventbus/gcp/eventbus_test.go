@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/looplab/eventhorizon/eventbus"
+	"github.com/looplab/eventhorizon/eventbus/dlq"
 )
 
 func TestEventBusIntegration(t *testing.T) {
@@ -54,6 +55,37 @@ func TestEventBusIntegration(t *testing.T) {
 	eventbus.AcceptanceTest(t, bus1, bus2, time.Second)
 }
 
+func TestEventBusDeadLettersIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Connect to localhost if not running inside docker
+	if os.Getenv("PUBSUB_EMULATOR_HOST") == "" {
+		os.Setenv("PUBSUB_EMULATOR_HOST", "localhost:8793")
+	}
+
+	// Get a random app ID.
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	appID := "app-" + hex.EncodeToString(b)
+
+	q := dlq.NewMemoryQueue()
+	policy := &dlq.Policy{
+		MaxAttempts: 3,
+		Delay:       10 * time.Millisecond,
+		Queue:       q,
+	}
+	bus, err := NewEventBus("project_id", appID, WithDeadLetterPolicy(policy))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.DeadLetterAcceptanceTest(t, bus, q, time.Second)
+}
+
 func TestEventBusLoadtest(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
@@ -25,6 +25,7 @@ import (
 
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/codec/json"
+	"github.com/looplab/eventhorizon/eventbus/dlq"
 )
 
 // EventBus is a local event bus that delegates handling of published events
@@ -41,6 +42,7 @@ type EventBus struct {
 	errCh        chan eh.EventBusError
 	wg           sync.WaitGroup
 	codec        eh.EventCodec
+	deadLetters  *dlq.Policy
 }
 
 // NewEventBus creates an EventBus, with optional GCP connection settings.
@@ -99,6 +101,19 @@ func WithCodec(codec eh.EventCodec) Option {
 	}
 }
 
+// WithDeadLetterPolicy adds events that could not be handled to a dead letter
+// queue, according to the policy, and acks them. Without a policy the events
+// are nacked and redelivered.
+func WithDeadLetterPolicy(p *dlq.Policy) Option {
+	return func(b *EventBus) error {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		b.deadLetters = p
+		return nil
+	}
+}
+
 // WithNATSOptions adds the NATS options to the underlying client.
 func WithNATSOptions(opts ...nats.Option) Option {
 	return func(b *EventBus) error {
@@ -215,13 +230,18 @@ func (b *EventBus) handler(ctx context.Context, m eh.EventMatcher, h eh.EventHan
 		}
 
 		// Handle the event if it did match.
-		if err := h.HandleEvent(ctx, event); err != nil {
+		if err := b.deadLetters.HandleEvent(ctx, h, event); err != nil {
 			err = fmt.Errorf("could not handle event (%s): %w", h.HandlerType(), err)
 			select {
 			case b.errCh <- eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
 			default:
 				log.Printf("eventhorizon: missed error in Jetstream event bus: %s", err)
 			}
+			// Ack events that have been added to the dead letter queue.
+			if dlq.IsDeadLettered(err) {
+				msg.AckSync()
+				return
+			}
 			msg.Nak()
 			return
 		}
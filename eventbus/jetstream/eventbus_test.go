@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/looplab/eventhorizon/eventbus"
+	"github.com/looplab/eventhorizon/eventbus/dlq"
 )
 
 func TestEventBusIntegration(t *testing.T) {
@@ -56,6 +57,39 @@ func TestEventBusIntegration(t *testing.T) {
 	eventbus.AcceptanceTest(t, bus1, bus2, time.Second)
 }
 
+func TestEventBusDeadLettersIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Enable testing with Docker, default to local testing.
+	addr := os.Getenv("NATS_ADDR")
+	if addr == "" {
+		addr = "localhost:4222"
+	}
+	url := "nats://" + addr
+
+	// Get a random app ID.
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	appID := "app-" + hex.EncodeToString(b)
+
+	q := dlq.NewMemoryQueue()
+	policy := &dlq.Policy{
+		MaxAttempts: 3,
+		Delay:       10 * time.Millisecond,
+		Queue:       q,
+	}
+	bus, err := NewEventBus(url, appID, WithDeadLetterPolicy(policy))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.DeadLetterAcceptanceTest(t, bus, q, time.Second)
+}
+
 func TestEventBusLoadtest(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
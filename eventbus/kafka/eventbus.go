@@ -26,6 +26,7 @@ import (
 
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/codec/json"
+	"github.com/looplab/eventhorizon/eventbus/dlq"
 )
 
 // EventBus is a local event bus that delegates handling of published events
@@ -42,6 +43,7 @@ type EventBus struct {
 	errCh        chan eh.EventBusError
 	wg           sync.WaitGroup
 	codec        eh.EventCodec
+	deadLetters  *dlq.Policy
 }
 
 // NewEventBus creates an EventBus, with optional GCP connection settings.
@@ -101,6 +103,19 @@ func WithCodec(codec eh.EventCodec) Option {
 	}
 }
 
+// WithDeadLetterPolicy adds events that could not be handled to a dead letter
+// queue, according to the policy, and commits them. Without a policy the events
+// are not committed, but also not delivered again to the same reader.
+func WithDeadLetterPolicy(p *dlq.Policy) Option {
+	return func(b *EventBus) error {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		b.deadLetters = p
+		return nil
+	}
+}
+
 // HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
 func (b *EventBus) HandlerType() eh.EventHandlerType {
 	return "eventbus"
@@ -239,13 +254,17 @@ func (b *EventBus) handler(m eh.EventMatcher, h eh.EventHandler, r *kafka.Reader
 		}
 
 		// Handle the event if it did match.
-		if err := h.HandleEvent(ctx, event); err != nil {
+		if err := b.deadLetters.HandleEvent(ctx, h, event); err != nil {
 			err = fmt.Errorf("could not handle event (%s): %w", h.HandlerType(), err)
 			select {
 			case b.errCh <- eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
 			default:
 				log.Printf("eventhorizon: missed error in Kafka event bus: %s", err)
 			}
+			// Commit events that have been added to the dead letter queue.
+			if dlq.IsDeadLettered(err) {
+				r.CommitMessages(ctx, msg)
+			}
 			return
 		}
 
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/looplab/eventhorizon/eventbus"
+	"github.com/looplab/eventhorizon/eventbus/dlq"
 )
 
 func TestEventBusIntegration(t *testing.T) {
@@ -59,6 +60,41 @@ func TestEventBusIntegration(t *testing.T) {
 	eventbus.AcceptanceTest(t, bus1, bus2, 10*time.Second)
 }
 
+func TestEventBusDeadLettersIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Connect to localhost if not running inside docker
+	addr := os.Getenv("KAFKA_ADDR")
+	if addr == "" {
+		addr = "localhost:9093"
+	}
+
+	// Get a random app ID.
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	appID := "app-" + hex.EncodeToString(b)
+
+	q := dlq.NewMemoryQueue()
+	policy := &dlq.Policy{
+		MaxAttempts: 3,
+		Delay:       10 * time.Millisecond,
+		Queue:       q,
+	}
+	bus, err := NewEventBus(addr, appID, WithDeadLetterPolicy(policy))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Need to wait here for the topic to be created.
+	time.Sleep(3 * time.Second)
+
+	eventbus.DeadLetterAcceptanceTest(t, bus, q, 10*time.Second)
+}
+
 func TestEventBusLoadtest(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
@@ -16,17 +16,52 @@ package local
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/codec/json"
+	"github.com/looplab/eventhorizon/eventbus/dlq"
 )
 
 // DefaultQueueSize is the default queue size per handler for publishing events.
 var DefaultQueueSize = 10
 
+// ErrQueueFull is when an event could not be published because the queue of a
+// handler is full, when using the ErrorOnFull overflow mode.
+var ErrQueueFull = errors.New("publish queue full")
+
+// OverflowMode decides what happens when publishing to a full handler queue.
+type OverflowMode int
+
+const (
+	// DropOnFull drops the event for the handler and logs it, the default.
+	DropOnFull OverflowMode = iota
+	// BlockOnFull blocks publishing until there is space in the queue or the
+	// publishing context is done. A handler that publishes to the same bus can
+	// deadlock with this mode when its own queue is full.
+	BlockOnFull
+	// ErrorOnFull returns ErrQueueFull from publishing, after publishing to the
+	// handlers that have space in their queues. The publishing is not undone,
+	// when used with an aggregate store the events are already saved and some
+	// handlers may have them even though saving returns the error.
+	ErrorOnFull
+)
+
+// QueueStats is the state of the publishing queue of a handler.
+type QueueStats struct {
+	// Depth is the number of events waiting in the queue.
+	Depth int
+	// Size is the capacity of the queue.
+	Size int
+	// Dropped is the number of events that have not been published to the
+	// handler because the queue was full.
+	Dropped uint64
+}
+
 // EventBus is a local event bus that delegates handling of published events
 // to all matching registered handlers, in order of registration.
 type EventBus struct {
@@ -36,6 +71,9 @@ type EventBus struct {
 	errCh        chan eh.EventBusError
 	wg           sync.WaitGroup
 	codec        eh.EventCodec
+	deadLetters  *dlq.Policy
+	queueSize    int
+	overflow     OverflowMode
 }
 
 // NewEventBus creates a EventBus.
@@ -45,6 +83,7 @@ func NewEventBus(options ...Option) *EventBus {
 		registered: map[eh.EventHandlerType]struct{}{},
 		errCh:      make(chan eh.EventBusError, 100),
 		codec:      &json.EventCodec{},
+		queueSize:  DefaultQueueSize,
 	}
 
 	// Apply configuration options.
@@ -68,6 +107,28 @@ func WithCodec(codec eh.EventCodec) Option {
 	}
 }
 
+// WithDeadLetterPolicy adds events that could not be handled to a dead letter
+// queue, according to the policy. Without a policy errors are only sent on
+// the error channel and the events are lost. A policy without a queue retries
+// events, but fails to dead letter them, see dlq.Policy.Validate.
+func WithDeadLetterPolicy(p *dlq.Policy) Option {
+	return func(b *EventBus) {
+		b.deadLetters = p
+	}
+}
+
+// WithPublishQueue sets the size of the queue of each handler and what happens
+// when publishing to a full queue. The size applies to handlers added to the
+// bus after this, queues shared in a group keep the size they were created with.
+func WithPublishQueue(size int, mode OverflowMode) Option {
+	return func(b *EventBus) {
+		if size > 0 {
+			b.queueSize = size
+		}
+		b.overflow = mode
+	}
+}
+
 // WithGroup uses a specified group for transmitting events.
 func WithGroup(g *Group) Option {
 	return func(b *EventBus) {
@@ -87,7 +148,7 @@ func (b *EventBus) HandleEvent(ctx context.Context, event eh.Event) error {
 		return fmt.Errorf("could not marshal event: %w", err)
 	}
 
-	return b.group.publish(ctx, data)
+	return b.group.publish(ctx, data, b.overflow)
 }
 
 // AddHandler implements the AddHandler method of the eventhorizon.EventBus interface.
@@ -108,14 +169,23 @@ func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.Event
 
 	// Get or create the channel.
 	id := h.HandlerType().String()
-	ch := b.group.channel(id)
+	ch := b.group.channel(id, b.queueSize)
 
 	// Register handler.
 	b.registered[h.HandlerType()] = struct{}{}
 
 	// Handle until context is cancelled.
 	b.wg.Add(1)
-	go b.handle(ctx, m, h, ch)
+	go func() {
+		defer b.wg.Done()
+		b.handle(ctx, m, h, ch)
+
+		// Unregister the handler when it stops handling.
+		b.group.release(id)
+		b.registeredMu.Lock()
+		delete(b.registered, h.HandlerType())
+		b.registeredMu.Unlock()
+	}()
 
 	return nil
 }
@@ -125,6 +195,12 @@ func (b *EventBus) Errors() <-chan eh.EventBusError {
 	return b.errCh
 }
 
+// QueueStats returns the state of the publishing queues of all handlers in the
+// group of the bus, by handler type.
+func (b *EventBus) QueueStats() map[eh.EventHandlerType]QueueStats {
+	return b.group.stats()
+}
+
 // Wait for all channels to close in the event bus group
 func (b *EventBus) Wait() {
 	b.wg.Wait()
@@ -138,8 +214,6 @@ type evt struct {
 
 // Handles all events coming in on the channel.
 func (b *EventBus) handle(ctx context.Context, m eh.EventMatcher, h eh.EventHandler, ch <-chan []byte) {
-	defer b.wg.Done()
-
 	for {
 		select {
 		case data := <-ch:
@@ -160,8 +234,8 @@ func (b *EventBus) handle(ctx context.Context, m eh.EventMatcher, h eh.EventHand
 			}
 
 			// Handle the event if it did match.
-			if err := h.HandleEvent(ctx, event); err != nil {
-				err = fmt.Errorf("could not handle event (%s): %s", h.HandlerType(), err.Error())
+			if err := b.deadLetters.HandleEvent(ctx, h, event); err != nil {
+				err = fmt.Errorf("could not handle event (%s): %w", h.HandlerType(), err)
 				select {
 				case b.errCh <- eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
 				default:
@@ -176,52 +250,132 @@ func (b *EventBus) handle(ctx context.Context, m eh.EventMatcher, h eh.EventHand
 
 // Group is a publishing group shared by multiple event busses locally, if needed.
 type Group struct {
-	bus   map[string]chan []byte
+	bus   map[string]*queue
 	busMu sync.RWMutex
 }
 
+// queue is the publishing queue of a handler, shared by the handlers of the
+// same type in the busses of a group.
+type queue struct {
+	dropped uint64 // First for 64-bit alignment of atomic operations.
+	id      string
+	ch      chan []byte
+	refs    int
+	// done is closed when the queue is unregistered, to stop blocked publishing.
+	done chan struct{}
+}
+
 // NewGroup creates a Group.
 func NewGroup() *Group {
 	return &Group{
-		bus: map[string]chan []byte{},
+		bus: map[string]*queue{},
+	}
+}
+
+func (g *Group) channel(id string, size int) <-chan []byte {
+	g.busMu.Lock()
+	defer g.busMu.Unlock()
+
+	if q, ok := g.bus[id]; ok {
+		q.refs++
+		return q.ch
+	}
+
+	q := &queue{
+		id:   id,
+		ch:   make(chan []byte, size),
+		refs: 1,
+		done: make(chan struct{}),
 	}
+	g.bus[id] = q
+	return q.ch
 }
 
-func (g *Group) channel(id string) <-chan []byte {
+// release unregisters a handler from its queue, removing the queue when it has
+// no more handlers. Events left in a removed queue are lost.
+func (g *Group) release(id string) {
 	g.busMu.Lock()
 	defer g.busMu.Unlock()
 
-	if ch, ok := g.bus[id]; ok {
-		return ch
+	q, ok := g.bus[id]
+	if !ok {
+		return
 	}
 
-	ch := make(chan []byte, DefaultQueueSize)
-	g.bus[id] = ch
-	return ch
+	q.refs--
+	if q.refs <= 0 {
+		delete(g.bus, id)
+		close(q.done)
+	}
 }
 
-func (g *Group) publish(ctx context.Context, b []byte) error {
+func (g *Group) publish(ctx context.Context, b []byte, mode OverflowMode) error {
+	// Publish to the queues without holding the lock, which would block adding
+	// and removing handlers while waiting for a full queue.
 	g.busMu.RLock()
-	defer g.busMu.RUnlock()
+	queues := make([]*queue, 0, len(g.bus))
+	for _, q := range g.bus {
+		queues = append(queues, q)
+	}
+	g.busMu.RUnlock()
 
-	for _, ch := range g.bus {
-		// Marshal and unmarshal the context to both simulate only sending data
-		// that would be sent over a network bus and also break any relationship
-		// with the old context.
+	full := 0
+	for _, q := range queues {
 		select {
-		case ch <- b:
+		case q.ch <- b:
+			continue
+		default:
+		}
+
+		switch mode {
+		case BlockOnFull:
+			select {
+			case q.ch <- b:
+			case <-q.done:
+			case <-ctx.Done():
+				return fmt.Errorf("could not publish to %s: %w", q.id, ctx.Err())
+			}
+		case ErrorOnFull:
+			atomic.AddUint64(&q.dropped, 1)
+			full++
 		default:
+			atomic.AddUint64(&q.dropped, 1)
 			log.Printf("eventhorizon: publish queue full in local event bus")
 		}
 	}
 
+	if full > 0 {
+		return fmt.Errorf("could not publish to %d handlers: %w", full, ErrQueueFull)
+	}
+
 	return nil
 }
 
-// Closes all the open channels after handling is done.
+func (g *Group) stats() map[eh.EventHandlerType]QueueStats {
+	g.busMu.RLock()
+	defer g.busMu.RUnlock()
+
+	stats := make(map[eh.EventHandlerType]QueueStats, len(g.bus))
+	for id, q := range g.bus {
+		stats[eh.EventHandlerType(id)] = QueueStats{
+			Depth:   len(q.ch),
+			Size:    cap(q.ch),
+			Dropped: atomic.LoadUint64(&q.dropped),
+		}
+	}
+
+	return stats
+}
+
+// Closes all the open queues after handling is done. The channels of the
+// queues are left open, as they can still be published to by a concurrent
+// publish, but publishing stops waiting for them.
 func (g *Group) close() {
-	for _, ch := range g.bus {
-		close(ch)
+	g.busMu.Lock()
+	defer g.busMu.Unlock()
+
+	for id, q := range g.bus {
+		delete(g.bus, id)
+		close(q.done)
 	}
-	g.bus = nil
 }
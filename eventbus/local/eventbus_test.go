@@ -15,10 +15,19 @@
 package local
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/eventbus"
+	"github.com/looplab/eventhorizon/eventbus/dlq"
+	"github.com/looplab/eventhorizon/mocks"
 )
 
 // NOTE: Not named "Integration" to enable running with the unit tests.
@@ -41,6 +50,219 @@ func TestEventBus(t *testing.T) {
 	eventbus.AcceptanceTest(t, bus1, bus2, time.Second)
 }
 
+func TestEventBusDeadLetters(t *testing.T) {
+	q := dlq.NewMemoryQueue()
+	bus := NewEventBus(WithDeadLetterPolicy(&dlq.Policy{
+		MaxAttempts: 3,
+		Delay:       time.Millisecond,
+		Queue:       q,
+	}))
+	if bus == nil {
+		t.Fatal("there should be a bus")
+	}
+
+	eventbus.DeadLetterAcceptanceTest(t, bus, q, time.Second)
+}
+
+func TestEventBusOverflow(t *testing.T) {
+	for _, mode := range []OverflowMode{DropOnFull, BlockOnFull, ErrorOnFull} {
+		bus := NewEventBus(WithPublishQueue(1, mode))
+		ctx, cancel := context.WithCancel(context.Background())
+		h := &blockingHandler{
+			handling: make(chan struct{}, 10),
+			release:  make(chan struct{}),
+		}
+		if err := bus.AddHandler(ctx, eh.MatchAll{}, h); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+
+		// Block the handler with the first event and fill its queue.
+		event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, time.Now(),
+			eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+		if err := bus.HandleEvent(ctx, event); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		<-h.handling
+		if err := bus.HandleEvent(ctx, event); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+
+		timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		err := bus.HandleEvent(timeoutCtx, event)
+		timeoutCancel()
+		expected := QueueStats{Depth: 1, Size: 1, Dropped: 1}
+		switch mode {
+		case DropOnFull:
+			if err != nil {
+				t.Error("there should be no error:", err)
+			}
+		case BlockOnFull:
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Error("there should be a deadline exceeded error:", err)
+			}
+			expected.Dropped = 0
+		case ErrorOnFull:
+			if !errors.Is(err, ErrQueueFull) {
+				t.Error("there should be a queue full error:", err)
+			}
+		}
+		if stats := bus.QueueStats()[h.HandlerType()]; stats != expected {
+			t.Error("the queue stats should be correct:", mode, stats)
+		}
+
+		// Blocked publishing should continue when there is space.
+		if mode == BlockOnFull {
+			published := make(chan error, 1)
+			go func() {
+				published <- bus.HandleEvent(ctx, event)
+			}()
+			close(h.release)
+			select {
+			case err := <-published:
+				if err != nil {
+					t.Error("there should be no error:", err)
+				}
+			case <-time.After(time.Second):
+				t.Error("the event should be published")
+			}
+		} else {
+			close(h.release)
+		}
+
+		cancel()
+		bus.Wait()
+	}
+}
+
+func TestEventBusUnregister(t *testing.T) {
+	bus := NewEventBus(WithPublishQueue(1, BlockOnFull))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handlerCtx, handlerCancel := context.WithCancel(ctx)
+	h := &blockingHandler{
+		handling: make(chan struct{}, 10),
+		release:  make(chan struct{}),
+	}
+	if err := bus.AddHandler(handlerCtx, eh.MatchAll{}, h); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Block the handler and its queue, and a publishing after that.
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	<-h.handling
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	published := make(chan error, 1)
+	go func() {
+		published <- bus.HandleEvent(ctx, event)
+	}()
+
+	// Adding handlers should not wait for the blocked publishing.
+	added := make(chan error, 1)
+	go func() {
+		added <- bus.AddHandler(ctx, eh.MatchAll{}, mocks.NewEventHandler("other"))
+	}()
+	select {
+	case err := <-added:
+		if err != nil {
+			t.Error("there should be no error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the handler should be added")
+	}
+
+	// Stopping the handler should unregister it and its queue.
+	handlerCancel()
+	close(h.release)
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Error("there should be no error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the blocked publishing should be done")
+	}
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		if _, ok := bus.QueueStats()[h.HandlerType()]; !ok {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("the queue should be removed")
+		}
+	}
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, h); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	cancel()
+	bus.Wait()
+}
+
+func TestEventBusPublishDuringClose(t *testing.T) {
+	// Close a bus while publishing to the handlers of another bus in the group.
+	group := NewGroup()
+	closed := NewEventBus(WithGroup(group))
+	bus := NewEventBus(WithGroup(group), WithPublishQueue(1, ErrorOnFull))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		h := mocks.NewEventHandler(fmt.Sprintf("handler-%d", i))
+		if err := bus.AddHandler(ctx, eh.MatchAll{}, h); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+
+	// Publishing while the bus is closed should not panic.
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				bus.HandleEvent(ctx, event)
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	closed.Wait()
+	time.Sleep(10 * time.Millisecond)
+	close(done)
+	wg.Wait()
+
+	cancel()
+	bus.Wait()
+}
+
+// blockingHandler blocks when handling events until released.
+type blockingHandler struct {
+	handling chan struct{}
+	release  chan struct{}
+}
+
+func (h *blockingHandler) HandlerType() eh.EventHandlerType {
+	return "blocking"
+}
+
+func (h *blockingHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	h.handling <- struct{}{}
+	<-h.release
+	return nil
+}
+
 func TestEventBusLoadtest(t *testing.T) {
 	bus := NewEventBus()
 	if bus == nil {
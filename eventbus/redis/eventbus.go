@@ -26,6 +26,7 @@ import (
 
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/codec/json"
+	"github.com/looplab/eventhorizon/eventbus/dlq"
 )
 
 // EventBus is a local event bus that delegates handling of published events
@@ -41,6 +42,7 @@ type EventBus struct {
 	errCh        chan eh.EventBusError
 	wg           sync.WaitGroup
 	codec        eh.EventCodec
+	deadLetters  *dlq.Policy
 }
 
 // NewEventBus creates an EventBus, with optional settings.
@@ -92,6 +94,19 @@ func WithCodec(codec eh.EventCodec) Option {
 	}
 }
 
+// WithDeadLetterPolicy adds events that could not be handled to a dead letter
+// queue, according to the policy, and acks them. Without a policy the events
+// are left pending in the consumer group.
+func WithDeadLetterPolicy(p *dlq.Policy) Option {
+	return func(b *EventBus) error {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		b.deadLetters = p
+		return nil
+	}
+}
+
 // WithRedisOptions uses the Redis options for the underlying client, instead of the defaults.
 func WithRedisOptions(opts *redis.Options) Option {
 	return func(b *EventBus) error {
@@ -252,15 +267,18 @@ func (b *EventBus) handler(m eh.EventMatcher, h eh.EventHandler, groupName strin
 		}
 
 		// Handle the event if it did match.
-		if err := h.HandleEvent(ctx, event); err != nil {
+		if err := b.deadLetters.HandleEvent(ctx, h, event); err != nil {
 			err = fmt.Errorf("could not handle event (%s): %w", h.HandlerType(), err)
 			select {
 			case b.errCh <- eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
 			default:
 				log.Printf("eventhorizon: missed error in Redis event bus: %s", err)
 			}
-			// TODO: Nack if possible.
-			return
+			// Ack events that have been added to the dead letter queue.
+			if !dlq.IsDeadLettered(err) {
+				// TODO: Nack if possible.
+				return
+			}
 		}
 
 		_, err = b.client.XAck(ctx, b.streamName, groupName, msg.ID).Result()
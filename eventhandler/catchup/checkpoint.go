@@ -0,0 +1,106 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catchup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// CheckpointStore persists the position in the event store up to which a
+// handler has handled events.
+type CheckpointStore interface {
+	// LoadCheckpoint returns the position of a handler, or 0 if it has none.
+	LoadCheckpoint(context.Context, eh.EventHandlerType) (int64, error)
+
+	// SaveCheckpoint saves the position of a handler.
+	SaveCheckpoint(context.Context, eh.EventHandlerType, int64) error
+}
+
+// Checkpoint is the entity used by RepoCheckpointStore to store a position.
+type Checkpoint struct {
+	ID          uuid.UUID           `json:"id"           bson:"_id"`
+	HandlerType eh.EventHandlerType `json:"handler_type" bson:"handler_type"`
+	Position    int64               `json:"position"     bson:"position"`
+}
+
+var _ = eh.Entity(&Checkpoint{})
+
+// EntityID implements the EntityID method of the eventhorizon.Entity interface.
+func (c *Checkpoint) EntityID() uuid.UUID {
+	return c.ID
+}
+
+// checkpointIDSpace is the UUID namespace used to derive checkpoint IDs.
+var checkpointIDSpace = uuid.MustParse("4c1e4e2e-3c86-4bb7-a9b5-4d6c1f0e9a52")
+
+// CheckpointID returns the ID of the checkpoint entity of a handler.
+func CheckpointID(handlerType eh.EventHandlerType) uuid.UUID {
+	return uuid.NewSHA1(checkpointIDSpace, []byte(handlerType))
+}
+
+// RepoCheckpointStore is a CheckpointStore that stores checkpoints as entities
+// in a repo, for example a memory or MongoDB repo. Checkpoints are stored in
+// the namespace of the context.
+type RepoCheckpointStore struct {
+	repo eh.ReadWriteRepo
+}
+
+var _ = CheckpointStore(&RepoCheckpointStore{})
+
+// NewRepoCheckpointStore creates a new RepoCheckpointStore. The entity factory
+// of the repo is set to create checkpoints, if supported by the repo.
+func NewRepoCheckpointStore(repo eh.ReadWriteRepo) *RepoCheckpointStore {
+	if r, ok := repo.(interface {
+		SetEntityFactory(func() eh.Entity)
+	}); ok {
+		r.SetEntityFactory(func() eh.Entity { return &Checkpoint{} })
+	}
+
+	return &RepoCheckpointStore{
+		repo: repo,
+	}
+}
+
+// LoadCheckpoint implements the LoadCheckpoint method of the CheckpointStore interface.
+func (s *RepoCheckpointStore) LoadCheckpoint(ctx context.Context, handlerType eh.EventHandlerType) (int64, error) {
+	entity, err := s.repo.Find(ctx, CheckpointID(handlerType))
+	if errors.Is(err, eh.ErrEntityNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	c, ok := entity.(*Checkpoint)
+	if !ok {
+		return 0, fmt.Errorf("incorrect checkpoint entity type: %T", entity)
+	}
+
+	return c.Position, nil
+}
+
+// SaveCheckpoint implements the SaveCheckpoint method of the CheckpointStore interface.
+func (s *RepoCheckpointStore) SaveCheckpoint(ctx context.Context, handlerType eh.EventHandlerType, position int64) error {
+	return s.repo.Save(ctx, &Checkpoint{
+		ID:          CheckpointID(handlerType),
+		HandlerType: handlerType,
+		Position:    position,
+	})
+}
@@ -0,0 +1,425 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package catchup implements subscriptions that first replay the historical
+// events of an event store to a handler and then continue with new events as
+// they are published on an event bus.
+package catchup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+var (
+	// ErrMissingStore is when no event store is provided.
+	ErrMissingStore = errors.New("missing event store")
+	// ErrMissingBus is when no event bus is provided.
+	ErrMissingBus = errors.New("missing event bus")
+	// ErrMissingCheckpointStore is when no checkpoint store is provided.
+	ErrMissingCheckpointStore = errors.New("missing checkpoint store")
+	// ErrMissingPosition is when the event store returns an event without position.
+	ErrMissingPosition = errors.New("missing event position")
+)
+
+// DefaultBatchSize is the default number of events loaded at a time.
+var DefaultBatchSize = 100
+
+// DefaultPollInterval is the default interval for checking the event store
+// for new events, in case a published event was missed.
+var DefaultPollInterval = time.Second
+
+// DefaultSettleWindow is the default time to wait for a missing position to
+// become visible before moving past it.
+var DefaultSettleWindow = 5 * time.Second
+
+// maxContexts is the max number of contexts of published events kept until
+// the events are read from the store.
+const maxContexts = 10000
+
+// Subscriber creates catch-up subscriptions for handlers.
+//
+// All events are read from the event store in the order of their global
+// position, which is also what the checkpoints keep track of. The event bus is
+// only used to get notified of new events, which makes the switch from
+// replaying to live events free of duplicates. Events that are published but
+// not yet readable from the store are picked up by polling.
+//
+// Positions are not always visible in order: a concurrent save can commit a
+// lower position after a higher one, and a failed save leaves a permanent gap.
+// When a position is missing the subscription therefore stops before it and
+// waits up to the settle window for it to appear, before moving past it, see
+// eventhorizon.PositionGaps. An event whose save takes longer than the settle
+// window to become visible can still be missed, the window should be well above
+// the save latency.
+//
+// Checkpoints are saved after each batch of events and when the subscription
+// stops at an event, which can handle a batch again after a restart.
+type Subscriber struct {
+	store        eh.EventStorePositionLoader
+	bus          eh.EventBus
+	checkpoints  CheckpointStore
+	batchSize    int
+	pollInterval time.Duration
+	settleWindow time.Duration
+}
+
+// NewSubscriber creates a Subscriber.
+func NewSubscriber(store eh.EventStorePositionLoader, bus eh.EventBus, checkpoints CheckpointStore, options ...Option) (*Subscriber, error) {
+	if store == nil {
+		return nil, ErrMissingStore
+	}
+	if bus == nil {
+		return nil, ErrMissingBus
+	}
+	if checkpoints == nil {
+		return nil, ErrMissingCheckpointStore
+	}
+
+	s := &Subscriber{
+		store:        store,
+		bus:          bus,
+		checkpoints:  checkpoints,
+		batchSize:    DefaultBatchSize,
+		pollInterval: DefaultPollInterval,
+		settleWindow: DefaultSettleWindow,
+	}
+
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Subscriber) error
+
+// WithBatchSize sets the number of events loaded from the store at a time.
+func WithBatchSize(size int) Option {
+	return func(s *Subscriber) error {
+		if size <= 0 {
+			return fmt.Errorf("invalid batch size: %d", size)
+		}
+		s.batchSize = size
+		return nil
+	}
+}
+
+// WithPollInterval sets the interval for checking the store for new events,
+// in addition to being notified by the event bus. Use 0 to disable polling.
+func WithPollInterval(interval time.Duration) Option {
+	return func(s *Subscriber) error {
+		if interval < 0 {
+			return fmt.Errorf("invalid poll interval: %s", interval)
+		}
+		s.pollInterval = interval
+		return nil
+	}
+}
+
+// WithSettleWindow sets the time to wait for a missing position to become
+// visible in the store before moving past it. Use 0 to never wait, which can
+// skip events from concurrent saves.
+func WithSettleWindow(window time.Duration) Option {
+	return func(s *Subscriber) error {
+		if window < 0 {
+			return fmt.Errorf("invalid settle window: %s", window)
+		}
+		s.settleWindow = window
+		return nil
+	}
+}
+
+// Subscribe starts a subscription for a handler, which is run until the
+// context is cancelled. Matching events after the checkpoint of the handler
+// are replayed from the store, after which new events are handled as they
+// are published. The handler is added to the bus using its own handler type,
+// which must be unique.
+//
+// Events are delivered at least once: an event is handled again after a
+// restart if its checkpoint could not be saved. If the handler returns an
+// error the subscription stops at that event and retries it later.
+//
+// Events are handled with the context they were published with on the bus,
+// with the cancellation of the subscription context. Replayed events that
+// were not published during the subscription are handled with the
+// subscription context.
+func (s *Subscriber) Subscribe(ctx context.Context, m eh.EventMatcher, h eh.EventHandler) (*Subscription, error) {
+	if m == nil {
+		return nil, eh.ErrMissingMatcher
+	}
+	if h == nil {
+		return nil, eh.ErrMissingHandler
+	}
+
+	position, err := s.checkpoints.LoadCheckpoint(ctx, h.HandlerType())
+	if err != nil {
+		return nil, fmt.Errorf("could not load checkpoint: %w", err)
+	}
+
+	sub := &Subscription{
+		subscriber: s,
+		matcher:    m,
+		handler:    h,
+		position:   position,
+		gaps:       eh.NewPositionGaps(s.settleWindow),
+		contexts:   map[eventKey]map[string]interface{}{},
+		trigger:    make(chan struct{}, 1),
+		caughtUp:   make(chan struct{}),
+		errCh:      make(chan eh.EventBusError, 100),
+		done:       make(chan struct{}),
+	}
+
+	// Listen for new events before replaying, to not miss any.
+	if err := s.bus.AddHandler(ctx, m, &liveHandler{sub}); err != nil {
+		return nil, err
+	}
+
+	go sub.run(ctx)
+
+	return sub, nil
+}
+
+// Subscription is a running catch-up subscription for a handler.
+type Subscription struct {
+	subscriber   *Subscriber
+	matcher      eh.EventMatcher
+	handler      eh.EventHandler
+	position     int64
+	contexts     map[eventKey]map[string]interface{}
+	contextsMu   sync.Mutex
+	gaps         *eh.PositionGaps
+	trigger      chan struct{}
+	caughtUp     chan struct{}
+	caughtUpOnce sync.Once
+	errCh        chan eh.EventBusError
+	done         chan struct{}
+}
+
+// Position returns the position of the last handled event.
+func (s *Subscription) Position() int64 {
+	return atomic.LoadInt64(&s.position)
+}
+
+// CaughtUp returns a channel that is closed when all events in the store at
+// the time of subscribing have been handled.
+func (s *Subscription) CaughtUp() <-chan struct{} {
+	return s.caughtUp
+}
+
+// Errors returns an error channel where handling errors are sent.
+func (s *Subscription) Errors() <-chan eh.EventBusError {
+	return s.errCh
+}
+
+// Wait waits for the subscription to stop after its context is cancelled.
+func (s *Subscription) Wait() {
+	<-s.done
+}
+
+func (s *Subscription) run(ctx context.Context) {
+	defer close(s.done)
+
+	var poll <-chan time.Time
+	if s.subscriber.pollInterval > 0 {
+		ticker := time.NewTicker(s.subscriber.pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		caughtUp, retryAfter := s.catchUp(ctx)
+		if caughtUp {
+			s.caughtUpOnce.Do(func() { close(s.caughtUp) })
+		}
+
+		// Retry when a missing position has settled.
+		var retry <-chan time.Time
+		var timer *time.Timer
+		if retryAfter > 0 {
+			timer = time.NewTimer(retryAfter)
+			retry = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-s.trigger:
+		case <-poll:
+		case <-retry:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// catchUp handles all events after the current position, returning true if
+// the end of the store was reached. If stopped at a missing position, the time
+// left until it has settled is returned.
+func (s *Subscription) catchUp(ctx context.Context) (bool, time.Duration) {
+	position := s.Position()
+	saved := position
+	defer func() {
+		// Save the position of the events handled since the last batch.
+		if position != saved {
+			s.saveCheckpoint(ctx, position)
+		}
+	}()
+
+	for {
+		events, err := s.subscriber.store.LoadAll(ctx, position, s.subscriber.batchSize)
+		if err != nil {
+			s.error(ctx, fmt.Errorf("could not load events: %w", err), nil)
+			return false, 0
+		}
+
+		// Wait for missing positions to settle before moving past them.
+		settled, wait := s.gaps.Settled(position, events)
+
+		for _, event := range events[:settled] {
+			if ctx.Err() != nil {
+				return false, 0
+			}
+
+			e, ok := event.(eh.PositionedEvent)
+			if !ok || e.Position() == 0 {
+				s.error(ctx, ErrMissingPosition, event)
+				return false, 0
+			}
+
+			// Ignore non-matching events, only passing their position.
+			if !s.matcher.Match(event) {
+				position = e.Position()
+				atomic.StoreInt64(&s.position, position)
+				continue
+			}
+
+			if err := s.handler.HandleEvent(s.eventContext(ctx, event), event); err != nil {
+				s.error(ctx, fmt.Errorf("could not handle event (%s): %w",
+					s.handler.HandlerType(), err), event)
+				return false, 0
+			}
+
+			position = e.Position()
+			atomic.StoreInt64(&s.position, position)
+		}
+		if settled < len(events) {
+			return false, wait
+		}
+
+		if position != saved && s.saveCheckpoint(ctx, position) {
+			saved = position
+		}
+
+		if len(events) < s.subscriber.batchSize {
+			return true, 0
+		}
+	}
+}
+
+// eventKey identifies an event both when published and when loaded.
+type eventKey struct {
+	id      uuid.UUID
+	version int
+}
+
+// eventContext returns the context to handle an event with, using the values
+// of the context it was published with, if any.
+func (s *Subscription) eventContext(ctx context.Context, event eh.Event) context.Context {
+	s.contextsMu.Lock()
+	key := eventKey{event.AggregateID(), event.Version()}
+	vals, ok := s.contexts[key]
+	delete(s.contexts, key)
+	s.contextsMu.Unlock()
+
+	if !ok {
+		return ctx
+	}
+	return eh.UnmarshalContext(ctx, vals)
+}
+
+// keepContext keeps the values of the context of a published event until the
+// event is loaded from the store.
+func (s *Subscription) keepContext(ctx context.Context, event eh.Event) {
+	s.contextsMu.Lock()
+	defer s.contextsMu.Unlock()
+
+	// Drop contexts of events that were handled before being published.
+	if len(s.contexts) >= maxContexts {
+		s.contexts = map[eventKey]map[string]interface{}{}
+	}
+	s.contexts[eventKey{event.AggregateID(), event.Version()}] = eh.MarshalContext(ctx)
+}
+
+func (s *Subscription) saveCheckpoint(ctx context.Context, position int64) bool {
+	if err := s.subscriber.checkpoints.SaveCheckpoint(ctx, s.handler.HandlerType(), position); err != nil {
+		s.error(ctx, fmt.Errorf("could not save checkpoint: %w", err), nil)
+		return false
+	}
+
+	return true
+}
+
+func (s *Subscription) error(ctx context.Context, err error, event eh.Event) {
+	select {
+	case s.errCh <- eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
+	default:
+		log.Printf("eventhorizon: missed error in catch-up subscription: %s", err)
+	}
+}
+
+// liveHandler is added to the event bus to notify the subscription of new
+// events, which are then read from the store.
+type liveHandler struct {
+	s *Subscription
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
+func (h *liveHandler) HandlerType() eh.EventHandlerType {
+	return h.s.handler.HandlerType()
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
+func (h *liveHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	// Skip events that are known to be handled already.
+	if e, ok := event.(eh.PositionedEvent); ok && e.Position() > 0 &&
+		e.Position() <= h.s.Position() {
+		return nil
+	}
+
+	h.s.keepContext(ctx, event)
+
+	select {
+	case h.s.trigger <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
@@ -0,0 +1,454 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catchup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventbus/local"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	"github.com/looplab/eventhorizon/mocks"
+	repo "github.com/looplab/eventhorizon/repo/memory"
+)
+
+func TestSubscription(t *testing.T) {
+	store := memory.NewEventStore()
+	bus := local.NewEventBus()
+	checkpoints := NewRepoCheckpointStore(repo.NewRepo())
+	s, err := NewSubscriber(store, bus, checkpoints,
+		WithBatchSize(2),
+		WithPollInterval(0),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Store some history before subscribing, with an event that should not match.
+	id := uuid.New()
+	otherID := uuid.New()
+	save(t, store, nil, id, 0, 2)
+	save(t, store, nil, otherID, 0, 1)
+	save(t, store, nil, id, 2, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := mocks.NewEventHandler("handler")
+	sub, err := s.Subscribe(ctx, matchAggregate(id), h)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	select {
+	case <-sub.CaughtUp():
+	case <-time.After(time.Second):
+		t.Fatal("the subscription should catch up")
+	}
+	checkVersions(t, h, 1, 2, 3)
+	for i := 0; i < 3; i++ {
+		h.Wait(time.Second)
+	}
+	if sub.Position() != 4 {
+		t.Error("the position should be correct:", sub.Position())
+	}
+
+	// New events should be handled as they are published, without duplicates.
+	events := save(t, store, bus, id, 3, 2)
+	if !h.Wait(time.Second) || !h.Wait(time.Second) {
+		t.Fatal("the live events should be handled")
+	}
+	if err := bus.HandleEvent(context.Background(), events[0]); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if h.Wait(50 * time.Millisecond) {
+		t.Error("a published event should not be handled twice")
+	}
+	checkVersions(t, h, 1, 2, 3, 4, 5)
+
+	cancel()
+	sub.Wait()
+
+	// A new subscription should resume from the checkpoint.
+	save(t, store, nil, id, 5, 1)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	h = mocks.NewEventHandler("handler")
+	s.bus = local.NewEventBus()
+	sub, err = s.Subscribe(ctx, matchAggregate(id), h)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	select {
+	case <-sub.CaughtUp():
+	case <-time.After(time.Second):
+		t.Fatal("the subscription should catch up")
+	}
+	checkVersions(t, h, 6)
+}
+
+func TestSubscriptionHandlerError(t *testing.T) {
+	store := memory.NewEventStore()
+	bus := local.NewEventBus()
+	checkpoints := NewRepoCheckpointStore(repo.NewRepo())
+	s, err := NewSubscriber(store, bus, checkpoints,
+		WithPollInterval(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	id := uuid.New()
+	save(t, store, nil, id, 0, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := mocks.NewEventHandler("handler")
+	handlerErr := errors.New("handler error")
+	h.Err = handlerErr
+	sub, err := s.Subscribe(ctx, eh.MatchAll{}, h)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	select {
+	case err := <-sub.Errors():
+		if !errors.Is(err, handlerErr) {
+			t.Error("the error should be correct:", err)
+		}
+		if err.Event == nil || err.Event.Version() != 1 {
+			t.Error("the event should be correct:", err.Event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("there should be an error")
+	}
+
+	// The failed event should be retried.
+	h.Lock()
+	h.Err = nil
+	h.Unlock()
+	select {
+	case <-sub.CaughtUp():
+	case <-time.After(time.Second):
+		t.Fatal("the subscription should catch up")
+	}
+	checkVersions(t, h, 1, 2)
+
+	position, err := checkpoints.LoadCheckpoint(context.Background(), h.HandlerType())
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if position != 2 {
+		t.Error("the checkpoint should be saved:", position)
+	}
+}
+
+func TestSubscriptionGap(t *testing.T) {
+	store := &gapStore{EventStore: memory.NewEventStore()}
+	bus := local.NewEventBus()
+	checkpoints := NewRepoCheckpointStore(repo.NewRepo())
+	s, err := NewSubscriber(store, bus, checkpoints,
+		WithPollInterval(10*time.Millisecond),
+		WithSettleWindow(200*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// The second event is not yet visible.
+	id := uuid.New()
+	otherID := uuid.New()
+	store.hide(2)
+	save(t, store, nil, id, 0, 1)
+	save(t, store, nil, otherID, 0, 1)
+	save(t, store, nil, id, 1, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := mocks.NewEventHandler("handler")
+	sub, err := s.Subscribe(ctx, eh.MatchAll{}, h)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if !h.Wait(time.Second) {
+		t.Fatal("the first event should be handled")
+	}
+	if h.Wait(50 * time.Millisecond) {
+		t.Error("the subscription should wait for the missing event")
+	}
+
+	// The missing event should be handled in order once visible.
+	store.hide(0)
+	if !h.Wait(time.Second) || !h.Wait(time.Second) {
+		t.Fatal("the events should be handled")
+	}
+	h.RLock()
+	if h.Events[1].AggregateID() != otherID {
+		t.Error("the events should be handled in order:", h.Events)
+	}
+	h.RUnlock()
+
+	// A permanent gap should be passed after the settle window.
+	store.hide(4)
+	save(t, store, nil, otherID, 1, 1)
+	save(t, store, nil, id, 2, 1)
+	start := time.Now()
+	if !h.Wait(time.Second) {
+		t.Fatal("the event after the gap should be handled")
+	}
+	if time.Since(start) < 150*time.Millisecond {
+		t.Error("the subscription should wait for the settle window")
+	}
+	if sub.Position() != 5 {
+		t.Error("the position should be correct:", sub.Position())
+	}
+}
+
+func TestSubscriptionBackdatedGap(t *testing.T) {
+	store := &gapStore{EventStore: memory.NewEventStore()}
+	bus := local.NewEventBus()
+	checkpoints := NewRepoCheckpointStore(repo.NewRepo())
+	s, err := NewSubscriber(store, bus, checkpoints,
+		WithPollInterval(10*time.Millisecond),
+		WithSettleWindow(time.Minute),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Gaps before events with old timestamps should still be waited for, as
+	// the timestamps are set before saving.
+	id := uuid.New()
+	store.hide(1)
+	events := []eh.Event{
+		eh.NewEvent(mocks.EventType, nil, time.Now().Add(-time.Hour),
+			eh.ForAggregate(mocks.AggregateType, id, 1)),
+		eh.NewEvent(mocks.EventType, nil, time.Now().Add(-time.Hour),
+			eh.ForAggregate(mocks.AggregateType, id, 2)),
+	}
+	if err := store.Save(context.Background(), events, 0); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := mocks.NewEventHandler("handler")
+	if _, err := s.Subscribe(ctx, eh.MatchAll{}, h); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if h.Wait(50 * time.Millisecond) {
+		t.Error("the subscription should wait for the missing event")
+	}
+
+	store.hide(0)
+	if !h.Wait(time.Second) || !h.Wait(time.Second) {
+		t.Fatal("the events should be handled")
+	}
+	checkVersions(t, h, 1, 2)
+}
+
+func TestSubscriptionCheckpointPerBatch(t *testing.T) {
+	store := memory.NewEventStore()
+	bus := local.NewEventBus()
+	checkpoints := &countingCheckpointStore{
+		CheckpointStore: NewRepoCheckpointStore(repo.NewRepo()),
+	}
+	s, err := NewSubscriber(store, bus, checkpoints,
+		WithPollInterval(0),
+		WithBatchSize(2),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	save(t, store, nil, uuid.New(), 0, 5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := mocks.NewEventHandler("handler")
+	sub, err := s.Subscribe(ctx, eh.MatchAll{}, h)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	select {
+	case <-sub.CaughtUp():
+	case <-time.After(time.Second):
+		t.Fatal("the subscription should catch up")
+	}
+
+	// The checkpoint should be saved once per batch, not per event.
+	if n := atomic.LoadInt32(&checkpoints.saves); n != 3 {
+		t.Error("the checkpoint should be saved for each batch:", n)
+	}
+	position, err := checkpoints.LoadCheckpoint(context.Background(), h.HandlerType())
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if position != 5 {
+		t.Error("the checkpoint should be saved:", position)
+	}
+}
+
+// countingCheckpointStore counts the saved checkpoints.
+type countingCheckpointStore struct {
+	CheckpointStore
+	saves int32
+}
+
+func (s *countingCheckpointStore) SaveCheckpoint(ctx context.Context, handlerType eh.EventHandlerType, position int64) error {
+	atomic.AddInt32(&s.saves, 1)
+	return s.CheckpointStore.SaveCheckpoint(ctx, handlerType, position)
+}
+
+func TestSubscriptionEventContext(t *testing.T) {
+	store := memory.NewEventStore()
+	bus := local.NewEventBus()
+	checkpoints := NewRepoCheckpointStore(repo.NewRepo())
+	s, err := NewSubscriber(store, bus, checkpoints, WithPollInterval(0))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := mocks.NewEventHandler("handler")
+	sub, err := s.Subscribe(ctx, eh.MatchAll{}, h)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	<-sub.CaughtUp()
+
+	events := save(t, store, nil, uuid.New(), 0, 1)
+	publishCtx := eh.NewContextWithCommandType(context.Background(), "command")
+	if err := bus.HandleEvent(publishCtx, events[0]); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if !h.Wait(time.Second) {
+		t.Fatal("the event should be handled")
+	}
+
+	h.RLock()
+	defer h.RUnlock()
+	if commandType, ok := eh.CommandTypeFromContext(h.Context); !ok || commandType != "command" {
+		t.Error("the event should be handled with its context:", commandType)
+	}
+}
+
+func TestNewSubscriber(t *testing.T) {
+	store := memory.NewEventStore()
+	bus := local.NewEventBus()
+	checkpoints := NewRepoCheckpointStore(repo.NewRepo())
+	if _, err := NewSubscriber(nil, bus, checkpoints); err != ErrMissingStore {
+		t.Error("there should be a missing store error:", err)
+	}
+	if _, err := NewSubscriber(store, nil, checkpoints); err != ErrMissingBus {
+		t.Error("there should be a missing bus error:", err)
+	}
+	if _, err := NewSubscriber(store, bus, nil); err != ErrMissingCheckpointStore {
+		t.Error("there should be a missing checkpoint store error:", err)
+	}
+
+	s, err := NewSubscriber(store, bus, checkpoints)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if _, err := s.Subscribe(context.Background(), nil, mocks.NewEventHandler("h")); err != eh.ErrMissingMatcher {
+		t.Error("there should be a missing matcher error:", err)
+	}
+	if _, err := s.Subscribe(context.Background(), eh.MatchAll{}, nil); err != eh.ErrMissingHandler {
+		t.Error("there should be a missing handler error:", err)
+	}
+}
+
+type matchAggregate uuid.UUID
+
+func (m matchAggregate) Match(e eh.Event) bool {
+	return e.AggregateID() == uuid.UUID(m)
+}
+
+// save saves events to the store and publishes them on the bus, if any.
+func save(t *testing.T, store eh.EventStore, bus eh.EventBus, id uuid.UUID, version, count int) []eh.Event {
+	t.Helper()
+
+	events := make([]eh.Event, count)
+	for i := range events {
+		events[i] = eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"},
+			time.Now(), eh.ForAggregate(mocks.AggregateType, id, version+i+1))
+	}
+	if err := store.Save(context.Background(), events, version); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if bus != nil {
+		for _, e := range events {
+			if err := bus.HandleEvent(context.Background(), e); err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+		}
+	}
+
+	return events
+}
+
+func checkVersions(t *testing.T, h *mocks.EventHandler, versions ...int) {
+	t.Helper()
+
+	h.RLock()
+	defer h.RUnlock()
+	if len(h.Events) != len(versions) {
+		t.Fatal("there should be the correct number of events:", h.Events)
+	}
+	for i, v := range versions {
+		if h.Events[i].Version() != v {
+			t.Error("the event version should be correct:", h.Events[i])
+		}
+	}
+}
+
+// gapStore hides an event position from LoadAll, as if its save has not yet
+// been committed.
+type gapStore struct {
+	*memory.EventStore
+
+	mu     sync.Mutex
+	hidden int64
+}
+
+func (s *gapStore) hide(position int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hidden = position
+}
+
+func (s *gapStore) LoadAll(ctx context.Context, fromPosition int64, limit int) ([]eh.Event, error) {
+	events, err := s.EventStore.LoadAll(ctx, fromPosition, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var visible []eh.Event
+	for _, e := range events {
+		if e.(eh.PositionedEvent).Position() != s.hidden {
+			visible = append(visible, e)
+		}
+	}
+	return visible, nil
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package projector
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrIncorrectEntityID is when a projected model has another ID than the one
+// it was projected for.
+var ErrIncorrectEntityID = errors.New("incorrect entity ID")
+
+// MultiProjector is a projector of events onto models other than the aggregate
+// of the event, for example aggregated views or many-to-one lookups.
+type MultiProjector interface {
+	// ProjectorType returns the type of the projector.
+	ProjectorType() Type
+
+	// EntityIDs returns the IDs of the models that an event should be
+	// projected onto, which can be none.
+	EntityIDs(context.Context, eh.Event) ([]uuid.UUID, error)
+
+	// Project projects an event onto the model with an ID and returns the
+	// updated model, or nil to remove the model. New models are created with
+	// the entity factory and must get the ID set by the projector.
+	Project(context.Context, eh.Event, uuid.UUID, eh.Entity) (eh.Entity, error)
+}
+
+// SourceVersionable is a model that keeps the last projected version of each
+// aggregate that it is projected from, and a version of its own that is
+// incremented for each projected event. It is used by MultiEventHandler to
+// ignore old and duplicate events, and to save the model conditionally on its
+// own version.
+type SourceVersionable interface {
+	eh.Versionable
+
+	// SourceVersion returns the last projected version of an aggregate, or 0.
+	SourceVersion(uuid.UUID) int
+	// SetSourceVersion sets the last projected version of an aggregate and
+	// increments the version of the model.
+	SetSourceVersion(uuid.UUID, int)
+}
+
+// SourceVersions implements SourceVersionable and can be embedded in models,
+// for MongoDB with `bson:",inline"`.
+type SourceVersions struct {
+	Version int            `json:"version" bson:"version"`
+	Sources map[string]int `json:"sources" bson:"sources"`
+}
+
+// AggregateVersion implements the AggregateVersion method of the
+// eventhorizon.Versionable interface.
+func (v *SourceVersions) AggregateVersion() int {
+	return v.Version
+}
+
+// SourceVersion implements the SourceVersion method of the SourceVersionable interface.
+func (v *SourceVersions) SourceVersion(id uuid.UUID) int {
+	return v.Sources[id.String()]
+}
+
+// SetSourceVersion implements the SetSourceVersion method of the SourceVersionable interface.
+func (v *SourceVersions) SetSourceVersion(id uuid.UUID, version int) {
+	if v.Sources == nil {
+		v.Sources = map[string]int{}
+	}
+	v.Sources[id.String()] = version
+	v.Version++
+}
+
+// MultiEventHandler is a CQRS projection handler to run a MultiProjector
+// implementation.
+//
+// Models that implement SourceVersionable get the version of the event's
+// aggregate recorded, and events that are not newer are ignored. An event that
+// skips versions of an aggregate that has been projected onto the model is an
+// eventhorizon.ErrIncorrectEntityVersion error, like with EventHandler, unless
+// gaps are allowed with WithSourceVersionGaps.
+//
+// The version of the model is incremented for each projected event. The repo
+// must be created with a strict version check, such as
+// memory.WithStrictVersionCheck or mongodb.WithStrictVersionCheck, for saving
+// the model to fail with eventhorizon.ErrIncorrectEntityVersion if it was
+// updated concurrently by an event from another aggregate. The event should
+// then be handled again. Updated models are saved in one operation if the repo
+// implements eventhorizon.BatchWriteRepo. Models are removed after the updated
+// models have been saved, without a version check.
+type MultiEventHandler struct {
+	projector MultiProjector
+	repo      eh.ReadWriteRepo
+	factoryFn func() eh.Entity
+	allowGaps bool
+}
+
+var _ = eh.EventHandler(&MultiEventHandler{})
+
+// NewMultiEventHandler creates a new MultiEventHandler.
+func NewMultiEventHandler(projector MultiProjector, repo eh.ReadWriteRepo, options ...MultiOption) *MultiEventHandler {
+	h := &MultiEventHandler{
+		projector: projector,
+		repo:      repo,
+	}
+	for _, option := range options {
+		option(h)
+	}
+	return h
+}
+
+// MultiOption is an option setter used to configure creation.
+type MultiOption func(*MultiEventHandler)
+
+// WithSourceVersionGaps allows gaps in the versions of an aggregate projected
+// onto a model, for projectors that do not project all events of an aggregate
+// onto the same models. Events that are delivered out of order are then
+// ignored as old events.
+func WithSourceVersionGaps() MultiOption {
+	return func(h *MultiEventHandler) {
+		h.allowGaps = true
+	}
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
+func (h *MultiEventHandler) HandlerType() eh.EventHandlerType {
+	return eh.EventHandlerType("projector_" + h.projector.ProjectorType())
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
+func (h *MultiEventHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	ids, err := h.projector.EntityIDs(ctx, event)
+	if err != nil {
+		return h.error(ctx, event, err, 0)
+	}
+
+	var (
+		entities []eh.Entity
+		removed  []uuid.UUID
+	)
+	for _, id := range ids {
+		entity, err := h.repo.Find(ctx, id)
+		if rrErr, ok := err.(eh.RepoError); ok && rrErr.Err == eh.ErrEntityNotFound {
+			if h.factoryFn == nil {
+				return h.error(ctx, event, ErrModelNotSet, 0)
+			}
+			entity = h.factoryFn()
+		} else if err != nil {
+			return h.error(ctx, event, err, 0)
+		}
+
+		// Ignore old/duplicate events.
+		sourceVersion, modelVersion := 0, 0
+		if entity, ok := entity.(SourceVersionable); ok {
+			sourceVersion = entity.SourceVersion(event.AggregateID())
+			if sourceVersion >= event.Version() {
+				continue
+			}
+			if sourceVersion > 0 && sourceVersion+1 != event.Version() && !h.allowGaps {
+				return h.error(ctx, event, eh.ErrIncorrectEntityVersion, sourceVersion)
+			}
+			modelVersion = entity.AggregateVersion()
+		}
+
+		newEntity, err := h.projector.Project(ctx, event, id, entity)
+		if err != nil {
+			return h.error(ctx, event, err, sourceVersion)
+		}
+
+		if newEntity == nil {
+			removed = append(removed, id)
+			continue
+		}
+
+		if newEntity.EntityID() != id {
+			return h.error(ctx, event, ErrIncorrectEntityID, sourceVersion)
+		}
+		if newEntity, ok := newEntity.(SourceVersionable); ok {
+			newEntity.SetSourceVersion(event.AggregateID(), event.Version())
+
+			// The model should be saved with the version after the loaded one.
+			if newEntity.AggregateVersion() != modelVersion+1 {
+				return h.error(ctx, event, eh.ErrIncorrectEntityVersion, sourceVersion)
+			}
+		}
+		entities = append(entities, newEntity)
+	}
+
+	if len(entities) == 1 {
+		if err := h.repo.Save(ctx, entities[0]); err != nil {
+			return h.error(ctx, event, err, 0)
+		}
+	} else if len(entities) > 1 {
+		if err := eh.SaveAll(ctx, h.repo, entities); err != nil {
+			return h.error(ctx, event, err, 0)
+		}
+	}
+
+	// Remove models after the saves, so that they are projected again if the
+	// event is retried. Models that are already removed are not an error.
+	for _, id := range removed {
+		err := h.repo.Remove(ctx, id)
+		if rrErr, ok := err.(eh.RepoError); ok && rrErr.Err == eh.ErrEntityNotFound {
+			continue
+		} else if err != nil {
+			return h.error(ctx, event, err, 0)
+		}
+	}
+
+	return nil
+}
+
+// SetEntityFactory sets a factory function that creates concrete entity types.
+func (h *MultiEventHandler) SetEntityFactory(f func() eh.Entity) {
+	h.factoryFn = f
+}
+
+func (h *MultiEventHandler) error(ctx context.Context, event eh.Event, err error, sourceVersion int) error {
+	return Error{
+		Err:           err,
+		Projector:     h.projector.ProjectorType().String(),
+		Namespace:     eh.NamespaceFromContext(ctx),
+		EventVersion:  event.Version(),
+		EntityVersion: sourceVersion,
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package projector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/repo/memory"
+)
+
+func TestMultiEventHandler(t *testing.T) {
+	ctx := context.Background()
+	repo := &batchRepo{Repo: memory.NewRepo(memory.WithStrictVersionCheck())}
+	repo.SetEntityFactory(func() eh.Entity { return &totalModel{} })
+	projector := &testMultiProjector{ids: map[uuid.UUID][]uuid.UUID{}}
+	h := NewMultiEventHandler(projector, repo)
+
+	// A missing factory should be an error.
+	order1, customer1, customer2 := uuid.New(), uuid.New(), uuid.New()
+	projector.ids[order1] = []uuid.UUID{customer1}
+	if err := h.HandleEvent(ctx, newOrderEvent(order1, 1)); !errors.Is(err, ErrModelNotSet) {
+		t.Error("there should be a model not set error:", err)
+	}
+	h.SetEntityFactory(func() eh.Entity { return &totalModel{} })
+
+	// Events from several aggregates should be projected to one model.
+	order2 := uuid.New()
+	projector.ids[order2] = []uuid.UUID{customer1}
+	for _, event := range []eh.Event{
+		newOrderEvent(order1, 1),
+		newOrderEvent(order2, 1),
+		newOrderEvent(order1, 2),
+	} {
+		if err := h.HandleEvent(ctx, event); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+	if m := findTotal(t, repo, customer1); m.Total != 3 || m.Version != 3 ||
+		m.SourceVersion(order1) != 2 || m.SourceVersion(order2) != 1 {
+		t.Error("the model should be correct:", m)
+	}
+
+	// Old and duplicate events should be ignored per source aggregate.
+	if err := h.HandleEvent(ctx, newOrderEvent(order1, 2)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if m := findTotal(t, repo, customer1); m.Total != 3 {
+		t.Error("the duplicate event should be ignored:", m)
+	}
+
+	// Gaps in the versions of a projected aggregate should be an error.
+	if err := h.HandleEvent(ctx, newOrderEvent(order1, 4)); !errors.Is(err, eh.ErrIncorrectEntityVersion) {
+		t.Error("there should be an incorrect version error:", err)
+	}
+	if m := findTotal(t, repo, customer1); m.Total != 3 {
+		t.Error("the model should not be updated:", m)
+	}
+
+	// Concurrently updated models should not be overwritten.
+	projector.before = func() {
+		m := findTotal(t, repo, customer1)
+		m.SetSourceVersion(uuid.New(), 1)
+		if err := repo.Save(ctx, m); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+	if err := h.HandleEvent(ctx, newOrderEvent(order1, 3)); !errors.Is(err, eh.ErrIncorrectEntityVersion) {
+		t.Error("there should be an incorrect version error:", err)
+	}
+	projector.before = nil
+	if err := h.HandleEvent(ctx, newOrderEvent(order1, 3)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if m := findTotal(t, repo, customer1); m.Total != 4 || m.Version != 5 {
+		t.Error("the model should be correct:", m)
+	}
+
+	// Events projected to several models should be saved in a batch.
+	projector.ids[order2] = []uuid.UUID{customer1, customer2}
+	if err := h.HandleEvent(ctx, newOrderEvent(order2, 2)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if repo.batches != 1 {
+		t.Error("the models should be saved in a batch:", repo.batches)
+	}
+	if m := findTotal(t, repo, customer2); m.Total != 1 || m.SourceVersion(order2) != 2 {
+		t.Error("the model should be correct:", m)
+	}
+
+	// Models should be removed when projected to nil.
+	projector.remove = true
+	if err := h.HandleEvent(ctx, newOrderEvent(order2, 3)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if entities, err := repo.FindAll(ctx); err != nil || len(entities) != 0 {
+		t.Error("the models should be removed:", entities, err)
+	}
+	projector.remove = false
+
+	// Models should only be removed when the updated models have been saved,
+	// and models that are already removed should not be an error.
+	order3, customer3, customer4 := uuid.New(), uuid.New(), uuid.New()
+	projector.ids[order3] = []uuid.UUID{customer4, customer3}
+	if err := h.HandleEvent(ctx, newOrderEvent(order3, 1)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	projector.removeID = customer3
+	projector.before = func() {
+		projector.before = nil
+		m := findTotal(t, repo, customer4)
+		m.SetSourceVersion(uuid.New(), 1)
+		if err := repo.Save(ctx, m); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+	if err := h.HandleEvent(ctx, newOrderEvent(order3, 2)); !errors.Is(err, eh.ErrIncorrectEntityVersion) {
+		t.Error("there should be an incorrect version error:", err)
+	}
+	if m := findTotal(t, repo, customer3); m.Total != 1 {
+		t.Error("the model should not be removed:", m)
+	}
+	projector.before = func() {
+		projector.before = nil
+		if err := repo.Remove(ctx, customer3); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+	if err := h.HandleEvent(ctx, newOrderEvent(order3, 2)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := repo.Find(ctx, customer3); !errors.Is(err, eh.ErrEntityNotFound) {
+		t.Error("the model should be removed:", err)
+	}
+	if m := findTotal(t, repo, customer4); m.Total != 2 || m.SourceVersion(order3) != 2 {
+		t.Error("the model should be correct:", m)
+	}
+	projector.removeID = uuid.Nil
+	if err := repo.Remove(ctx, customer4); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Models with another ID should be an error.
+	projector.wrongID = true
+	if err := h.HandleEvent(ctx, newOrderEvent(order1, 4)); !errors.Is(err, ErrIncorrectEntityID) {
+		t.Error("there should be an incorrect ID error:", err)
+	}
+	projector.wrongID = false
+
+	// Projector errors should be returned.
+	projector.err = errors.New("projector error")
+	if err := h.HandleEvent(ctx, newOrderEvent(order1, 4)); !errors.Is(err, projector.err) {
+		t.Error("there should be a projector error:", err)
+	}
+	projector.err = nil
+
+	// Gaps should be allowed with the option.
+	h = NewMultiEventHandler(projector, repo, WithSourceVersionGaps())
+	h.SetEntityFactory(func() eh.Entity { return &totalModel{} })
+	if err := h.HandleEvent(ctx, newOrderEvent(order1, 1)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := h.HandleEvent(ctx, newOrderEvent(order1, 3)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if m := findTotal(t, repo, customer1); m.Total != 2 || m.SourceVersion(order1) != 3 {
+		t.Error("the model should be correct:", m)
+	}
+}
+
+func newOrderEvent(id uuid.UUID, version int) eh.Event {
+	return eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "order"}, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, id, version))
+}
+
+func findTotal(t *testing.T, repo eh.ReadRepo, id uuid.UUID) *totalModel {
+	entity, err := repo.Find(context.Background(), id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	return entity.(*totalModel)
+}
+
+type totalModel struct {
+	ID    uuid.UUID
+	Total int
+	SourceVersions
+}
+
+func (m *totalModel) EntityID() uuid.UUID {
+	return m.ID
+}
+
+type testMultiProjector struct {
+	ids      map[uuid.UUID][]uuid.UUID
+	remove   bool
+	removeID uuid.UUID
+	wrongID  bool
+	before   func()
+	err      error
+}
+
+func (p *testMultiProjector) ProjectorType() Type {
+	return TestProjectorType
+}
+
+func (p *testMultiProjector) EntityIDs(ctx context.Context, event eh.Event) ([]uuid.UUID, error) {
+	return p.ids[event.AggregateID()], nil
+}
+
+func (p *testMultiProjector) Project(ctx context.Context, event eh.Event, id uuid.UUID, entity eh.Entity) (eh.Entity, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.before != nil {
+		p.before()
+	}
+	if p.remove || id == p.removeID {
+		return nil, nil
+	}
+	m := entity.(*totalModel)
+	m.ID = id
+	if p.wrongID {
+		m.ID = uuid.New()
+	}
+	m.Total++
+	return m, nil
+}
+
+// batchRepo counts the batches saved.
+type batchRepo struct {
+	*memory.Repo
+	batches int
+}
+
+func (r *batchRepo) SaveAll(ctx context.Context, entities []eh.Entity) error {
+	r.batches++
+	return r.Repo.SaveAll(ctx, entities)
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebuild
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/projector"
+)
+
+var (
+	// ErrMissingRepo is when no new repo was created for a rebuild.
+	ErrMissingRepo = errors.New("missing repo")
+	// ErrMissingEntityFactory is when no entity factory is provided.
+	ErrMissingEntityFactory = errors.New("missing entity factory")
+	// ErrNotVersionable is when the entities of the model are not versionable,
+	// which is required to swap the model safely.
+	ErrNotVersionable = errors.New("entity is not versionable")
+)
+
+// DefaultSwapThreshold is the default max number of events left to catch up
+// with when swapping the live repo.
+var DefaultSwapThreshold = 100
+
+// maxCatchUpRounds is the max number of catch-ups before swapping, to not
+// chase a source that receives events faster than they are projected.
+const maxCatchUpRounds = 10
+
+// Progress is the progress of a rebuild, reported after each batch of events.
+type Progress struct {
+	// Events is the number of events projected so far.
+	Events int
+	// Batches is the number of batches read from the source so far.
+	Batches int
+	// CatchUp is set when projecting events saved since the first pass.
+	CatchUp bool
+	// Swapping is set when projecting the final events while the live repo is
+	// blocked for the swap.
+	Swapping bool
+}
+
+// Rebuilder rebuilds a read model by replaying events through a projector into
+// a new repo, and then swaps the live repo for it (blue/green).
+//
+// The live projector can keep handling events to the live repo during a
+// rebuild. Events that it handles after the swap that have already been
+// projected by the rebuild are ignored by the projector for models that
+// implement eventhorizon.Versionable, which is required for a safe swap.
+//
+// The new repo must also check the versions of saved entities, for example
+// with the WithVersionCheck option of the repos. A save by the live projector
+// that was blocked during the swap has read the entity from the previous live
+// repo, and would otherwise overwrite a newer entity in the rebuilt repo.
+type Rebuilder struct {
+	projector     projector.Projector
+	live          *SwapRepo
+	newRepo       func(context.Context) (eh.ReadWriteRepo, error)
+	factoryFn     func() eh.Entity
+	progress      func(Progress)
+	swapThreshold int
+}
+
+// NewRebuilder creates a new Rebuilder for a projector and the live repo that
+// it projects to. The newRepo func should create a new empty repo with a
+// version check, for example using a new collection, to build the model in.
+// The entities created by the factory must implement eventhorizon.Versionable.
+func NewRebuilder(p projector.Projector, live *SwapRepo,
+	newRepo func(context.Context) (eh.ReadWriteRepo, error),
+	factory func() eh.Entity, options ...Option) (*Rebuilder, error) {
+	if factory == nil {
+		return nil, ErrMissingEntityFactory
+	}
+	if _, ok := factory().(eh.Versionable); !ok {
+		return nil, ErrNotVersionable
+	}
+
+	r := &Rebuilder{
+		projector:     p,
+		live:          live,
+		newRepo:       newRepo,
+		factoryFn:     factory,
+		swapThreshold: DefaultSwapThreshold,
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	return r, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Rebuilder)
+
+// WithProgress reports the progress of a rebuild to a func.
+func WithProgress(f func(Progress)) Option {
+	return func(r *Rebuilder) {
+		r.progress = f
+	}
+}
+
+// WithSwapThreshold sets the max number of events left to catch up with when
+// swapping. The rebuild catches up without blocking the live repo until at
+// most this many events were projected in a pass, keeping the final catch-up
+// while blocking the live repo short.
+func WithSwapThreshold(events int) Option {
+	return func(r *Rebuilder) {
+		r.swapThreshold = events
+	}
+}
+
+// Rebuild builds the model from all events in the source into a new repo and
+// swaps it for the live repo. Events saved during the build are caught up with
+// before the swap, and the last events are read while blocking the live repo
+// to not miss any events saved during the swap. The last events are read
+// without waiting if the source is a NonBlockingSource, events that are not
+// yet visible then are handled by the live projector after the swap. Returns
+// the previous live repo, which can be removed by the caller.
+func (r *Rebuilder) Rebuild(ctx context.Context, source Source) (eh.ReadWriteRepo, error) {
+	repo, h, err := r.build(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	// Catch up without blocking the live repo until few events are left.
+	for i := 0; i < maxCatchUpRounds; i++ {
+		p := Progress{CatchUp: true}
+		if err := r.project(ctx, source.Next, h, &p); err != nil {
+			return nil, fmt.Errorf("could not catch up: %w", err)
+		}
+		if p.Events <= r.swapThreshold {
+			break
+		}
+	}
+
+	next := source.Next
+	if s, ok := source.(NonBlockingSource); ok {
+		next = s.NextNow
+	}
+	old, err := r.live.Swap(func() (eh.ReadWriteRepo, error) {
+		p := Progress{CatchUp: true, Swapping: true}
+		if err := r.project(ctx, next, h, &p); err != nil {
+			return nil, fmt.Errorf("could not catch up: %w", err)
+		}
+
+		return repo, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return old, nil
+}
+
+// Diff is the difference between a rebuilt and a live model.
+type Diff struct {
+	// Added are entities only in the rebuilt model.
+	Added []uuid.UUID
+	// Removed are entities only in the live model.
+	Removed []uuid.UUID
+	// Changed are entities that differ between the models.
+	Changed []uuid.UUID
+}
+
+// Empty returns true if the models are equal.
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DryRun builds the model from all events in the source into a new repo and
+// compares it with the live repo, without swapping. The new repo is left as it
+// is, for the caller to inspect or remove.
+func (r *Rebuilder) DryRun(ctx context.Context, source Source) (*Diff, error) {
+	repo, _, err := r.build(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stream the rebuilt model and compare with the live model by ID, keeping
+	// only the IDs in memory.
+	d := &Diff{}
+	seen := map[uuid.UUID]bool{}
+	if err := iterate(ctx, repo, func(e eh.Entity) error {
+		seen[e.EntityID()] = true
+
+		l, err := r.live.Find(ctx, e.EntityID())
+		if rrErr, ok := err.(eh.RepoError); ok && rrErr.Err == eh.ErrEntityNotFound {
+			d.Added = append(d.Added, e.EntityID())
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("could not load live entity: %w", err)
+		}
+
+		if !reflect.DeepEqual(l, e) {
+			d.Changed = append(d.Changed, e.EntityID())
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("could not compare rebuilt model: %w", err)
+	}
+
+	if err := iterate(ctx, r.live, func(e eh.Entity) error {
+		if !seen[e.EntityID()] {
+			d.Removed = append(d.Removed, e.EntityID())
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("could not compare live model: %w", err)
+	}
+
+	return d, nil
+}
+
+// iterate calls a func for each entity in a repo, streaming them if possible.
+func iterate(ctx context.Context, repo eh.ReadRepo, f func(eh.Entity) error) error {
+	i, err := eh.FindAllIter(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	for i.Next(ctx) {
+		if e, ok := i.Value().(eh.Entity); ok {
+			if err := f(e); err != nil {
+				i.Close(ctx)
+				return err
+			}
+		}
+	}
+
+	return i.Close(ctx)
+}
+
+// build creates a new repo and projects all events in the source to it.
+func (r *Rebuilder) build(ctx context.Context, source Source) (eh.ReadWriteRepo, eh.EventHandler, error) {
+	repo, err := r.newRepo(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create repo: %w", err)
+	}
+	if repo == nil {
+		return nil, nil, ErrMissingRepo
+	}
+
+	h := projector.NewEventHandler(r.projector, repo)
+	h.SetEntityFactory(r.factoryFn)
+
+	var p Progress
+	if err := r.project(ctx, source.Next, h, &p); err != nil {
+		return nil, nil, err
+	}
+
+	return repo, h, nil
+}
+
+// project handles events from the next func of a source until there are no
+// more.
+func (r *Rebuilder) project(ctx context.Context, next func(context.Context) ([]eh.Event, error),
+	h eh.EventHandler, p *Progress) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		events, err := next(ctx)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		for _, event := range events {
+			if err := h.HandleEvent(ctx, event); err != nil {
+				return fmt.Errorf("could not project event %s: %w", event, err)
+			}
+		}
+
+		p.Events += len(events)
+		p.Batches++
+		if r.progress != nil {
+			r.progress(*p)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebuild
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/projector"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	"github.com/looplab/eventhorizon/mocks"
+	repoMemory "github.com/looplab/eventhorizon/repo/memory"
+)
+
+func TestRebuilder(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewEventStore()
+	id1, id2 := uuid.New(), uuid.New()
+	saveEvents(t, store, id1, 0, "a", "b")
+	saveEvents(t, store, id2, 0, "c")
+
+	liveRepo := newRepo()
+	live := NewSwapRepo(liveRepo)
+
+	// A live model with a stale entity, and one that is unknown to the store.
+	id3 := uuid.New()
+	if err := live.Save(ctx, &mocks.Model{ID: id1, Version: 2, Content: "old"}); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := live.Save(ctx, &mocks.Model{ID: id3, Version: 1, Content: "x"}); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	var progress []Progress
+	r, err := NewRebuilder(&testProjector{}, live,
+		func(context.Context) (eh.ReadWriteRepo, error) { return newRepo(), nil },
+		func() eh.Entity { return &mocks.Model{} },
+		WithProgress(func(p Progress) { progress = append(progress, p) }),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// A dry run should diff the models without swapping.
+	d, err := r.DryRun(ctx, NewStreamSource(store, 2))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(d.Added) != 1 || d.Added[0] != id2 {
+		t.Error("the added entities should be correct:", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0] != id3 {
+		t.Error("the removed entities should be correct:", d.Removed)
+	}
+	if len(d.Changed) != 1 || d.Changed[0] != id1 {
+		t.Error("the changed entities should be correct:", d.Changed)
+	}
+	if d.Empty() {
+		t.Error("the diff should not be empty")
+	}
+	if live.Live() != liveRepo {
+		t.Error("the live repo should not be swapped")
+	}
+	if len(progress) != 2 || progress[1].Events != 3 || progress[1].Batches != 2 {
+		t.Error("the progress should be reported:", progress)
+	}
+
+	// A rebuild should swap the live repo.
+	progress = nil
+	source := &hookSource{Source: NewStreamSource(store, 0)}
+	old, err := r.Rebuild(ctx, source)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if old != liveRepo {
+		t.Error("the old repo should be returned")
+	}
+	if live.Live() == liveRepo {
+		t.Error("the live repo should be swapped")
+	}
+	entities, err := live.FindAll(ctx)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(entities) != 2 {
+		t.Error("there should be 2 entities:", entities)
+	}
+	if e, err := live.Find(ctx, id1); err != nil || e.(*mocks.Model).Content != "ab" {
+		t.Error("the entity should be rebuilt:", e, err)
+	}
+	if len(progress) != 1 || progress[0].Events != 3 || progress[0].CatchUp {
+		t.Error("the progress should be reported:", progress)
+	}
+
+	// A dry run against the rebuilt model should be empty.
+	if d, err := r.DryRun(ctx, NewStreamSource(store, 0)); err != nil || !d.Empty() {
+		t.Error("the diff should be empty:", d, err)
+	}
+}
+
+func TestRebuilderCatchUp(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewEventStore()
+	id := uuid.New()
+	saveEvents(t, store, id, 0, "a")
+
+	live := NewSwapRepo(newRepo())
+	var progress []Progress
+	r, err := NewRebuilder(&testProjector{}, live,
+		func(context.Context) (eh.ReadWriteRepo, error) { return newRepo(), nil },
+		func() eh.Entity { return &mocks.Model{} },
+		WithProgress(func(p Progress) { progress = append(progress, p) }),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Events saved after the first pass should be projected before the swap.
+	source := &hookSource{
+		Source: NewAggregateSource(store, []uuid.UUID{id}),
+		done: func() {
+			saveEvents(t, store, id, 1, "b")
+		},
+	}
+	if _, err := r.Rebuild(ctx, source); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if e, err := live.Find(ctx, id); err != nil || e.(*mocks.Model).Content != "ab" {
+		t.Error("the entity should be caught up:", e, err)
+	}
+	if len(progress) != 2 || !progress[1].CatchUp || progress[1].Swapping || progress[1].Events != 1 {
+		t.Error("the catch up progress should be reported:", progress)
+	}
+
+	// Failing projections should not swap the live repo.
+	liveRepo := live.Live()
+	projErr := errors.New("projector error")
+	r.projector = &testProjector{err: projErr}
+	if _, err := r.Rebuild(ctx, NewStreamSource(store, 0)); !errors.Is(err, projErr) {
+		t.Error("there should be a projector error:", err)
+	}
+	if live.Live() != liveRepo {
+		t.Error("the live repo should not be swapped")
+	}
+
+	// A missing repo should be an error.
+	r.newRepo = func(context.Context) (eh.ReadWriteRepo, error) { return nil, nil }
+	if _, err := r.Rebuild(ctx, NewStreamSource(store, 0)); !errors.Is(err, ErrMissingRepo) {
+		t.Error("there should be a missing repo error:", err)
+	}
+}
+
+func TestNewRebuilder(t *testing.T) {
+	live := NewSwapRepo(newRepo())
+	newRepoFn := func(context.Context) (eh.ReadWriteRepo, error) { return newRepo(), nil }
+
+	if _, err := NewRebuilder(&testProjector{}, live, newRepoFn, nil); err != ErrMissingEntityFactory {
+		t.Error("there should be a missing entity factory error:", err)
+	}
+	if _, err := NewRebuilder(&testProjector{}, live, newRepoFn,
+		func() eh.Entity { return &mocks.SimpleModel{} },
+	); err != ErrNotVersionable {
+		t.Error("there should be a not versionable error:", err)
+	}
+}
+
+func TestStreamSourceGap(t *testing.T) {
+	ctx := context.Background()
+	store := &gapStore{EventStore: memory.NewEventStore()}
+	id := uuid.New()
+	store.hide(2)
+	saveEvents(t, store, id, 0, "a", "b", "c")
+
+	// Events up to the gap should be returned first.
+	source := NewStreamSource(store, 0, WithSettleWindow(100*time.Millisecond))
+	events, err := source.Next(ctx)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(events) != 1 || events[0].Version() != 1 {
+		t.Error("the events before the gap should be returned:", events)
+	}
+
+	// The gap should be waited for, and then passed.
+	start := time.Now()
+	events, err = source.Next(ctx)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(events) != 1 || events[0].Version() != 3 {
+		t.Error("the events after the gap should be returned:", events)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("the source should wait for the gap to settle")
+	}
+
+	// Reading without waiting should move past new gaps right away.
+	source = NewStreamSource(store, 0, WithSettleWindow(time.Minute))
+	start = time.Now()
+	events, err = source.NextNow(ctx)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(events) != 2 {
+		t.Error("the events after the gap should be returned:", events)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("the source should not wait for the gap")
+	}
+}
+
+func saveEvents(t *testing.T, store eh.EventStore, id uuid.UUID, version int, contents ...string) {
+	var events []eh.Event
+	for i, c := range contents {
+		events = append(events, eh.NewEvent(mocks.EventType, &mocks.EventData{Content: c},
+			time.Now(), eh.ForAggregate(mocks.AggregateType, id, version+i+1)))
+	}
+	if err := store.Save(context.Background(), events, version); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+}
+
+func newRepo() eh.ReadWriteRepo {
+	r := repoMemory.NewRepo(repoMemory.WithVersionCheck())
+	r.SetEntityFactory(func() eh.Entity { return &mocks.Model{} })
+	return r
+}
+
+// hookSource calls done the first time the source has no more events.
+type hookSource struct {
+	Source
+	done func()
+}
+
+func (s *hookSource) Next(ctx context.Context) ([]eh.Event, error) {
+	events, err := s.Source.Next(ctx)
+	if len(events) == 0 && s.done != nil {
+		s.done()
+		s.done = nil
+	}
+	return events, err
+}
+
+// testProjector appends the content of all events.
+type testProjector struct {
+	err error
+}
+
+func (p *testProjector) ProjectorType() projector.Type {
+	return projector.Type("test")
+}
+
+func (p *testProjector) Project(ctx context.Context, event eh.Event, entity eh.Entity) (eh.Entity, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	m := entity.(*mocks.Model)
+	m.ID = event.AggregateID()
+	m.Version = event.Version()
+	m.Content += event.Data().(*mocks.EventData).Content
+	return m, nil
+}
+
+// gapStore hides an event position from LoadAll, as if its save has not yet
+// been committed.
+type gapStore struct {
+	*memory.EventStore
+	hidden int64
+}
+
+func (s *gapStore) hide(position int64) {
+	s.hidden = position
+}
+
+func (s *gapStore) LoadAll(ctx context.Context, fromPosition int64, limit int) ([]eh.Event, error) {
+	events, err := s.EventStore.LoadAll(ctx, fromPosition, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var visible []eh.Event
+	for _, e := range events {
+		if e.(eh.PositionedEvent).Position() != s.hidden {
+			visible = append(visible, e)
+		}
+	}
+	return visible, nil
+}
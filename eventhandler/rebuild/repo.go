@@ -0,0 +1,110 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebuild
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// SwapRepo is a repo that delegates to a live repo which can be swapped for a
+// rebuilt one. Both the projector and the readers of a model should use the
+// SwapRepo for the rebuilt model to be used by all of them at the same time.
+type SwapRepo struct {
+	repo   eh.ReadWriteRepo
+	repoMu sync.RWMutex
+}
+
+// NewSwapRepo creates a new SwapRepo with a live repo.
+func NewSwapRepo(repo eh.ReadWriteRepo) *SwapRepo {
+	return &SwapRepo{
+		repo: repo,
+	}
+}
+
+// Parent implements the Parent method of the eventhorizon.ReadRepo interface.
+func (r *SwapRepo) Parent() eh.ReadRepo {
+	return r.Live()
+}
+
+// Find implements the Find method of the eventhorizon.ReadRepo interface.
+func (r *SwapRepo) Find(ctx context.Context, id uuid.UUID) (eh.Entity, error) {
+	r.repoMu.RLock()
+	defer r.repoMu.RUnlock()
+
+	return r.repo.Find(ctx, id)
+}
+
+// FindAll implements the FindAll method of the eventhorizon.ReadRepo interface.
+func (r *SwapRepo) FindAll(ctx context.Context) ([]eh.Entity, error) {
+	r.repoMu.RLock()
+	defer r.repoMu.RUnlock()
+
+	return r.repo.FindAll(ctx)
+}
+
+// FindAllIter implements the FindAllIter method of the eventhorizon.Iterable
+// interface. The iterator uses the live repo when it is created.
+func (r *SwapRepo) FindAllIter(ctx context.Context) (eh.Iter, error) {
+	return eh.FindAllIter(ctx, r.Live())
+}
+
+// Save implements the Save method of the eventhorizon.WriteRepo interface.
+func (r *SwapRepo) Save(ctx context.Context, entity eh.Entity) error {
+	r.repoMu.RLock()
+	defer r.repoMu.RUnlock()
+
+	return r.repo.Save(ctx, entity)
+}
+
+// Remove implements the Remove method of the eventhorizon.WriteRepo interface.
+func (r *SwapRepo) Remove(ctx context.Context, id uuid.UUID) error {
+	r.repoMu.RLock()
+	defer r.repoMu.RUnlock()
+
+	return r.repo.Remove(ctx, id)
+}
+
+// Live returns the live repo.
+func (r *SwapRepo) Live() eh.ReadWriteRepo {
+	r.repoMu.RLock()
+	defer r.repoMu.RUnlock()
+
+	return r.repo
+}
+
+// Swap calls prepare while blocking all use of the repo, and then swaps the
+// live repo for the returned repo, unless prepare fails. Returns the previous
+// live repo. Prepare should be short and never wait, as all reads and writes
+// wait for it. Writes that were blocked are done to the new repo, which should
+// check the versions of saved entities to reject writes based on the old repo.
+func (r *SwapRepo) Swap(prepare func() (eh.ReadWriteRepo, error)) (eh.ReadWriteRepo, error) {
+	r.repoMu.Lock()
+	defer r.repoMu.Unlock()
+
+	repo, err := prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	old := r.repo
+	r.repo = repo
+
+	return old, nil
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebuild
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// DefaultBatchSize is the default number of events loaded at a time from a
+// global stream.
+var DefaultBatchSize = 1000
+
+// DefaultSettleWindow is the default time to wait for a missing position in a
+// global stream to become visible before moving past it.
+var DefaultSettleWindow = 5 * time.Second
+
+// gapPollInterval is the interval for reloading events while waiting for a
+// missing position.
+const gapPollInterval = 50 * time.Millisecond
+
+// Source is a source of events for a rebuild. Sources are stateful, reading
+// each event once.
+type Source interface {
+	// Next returns the next batch of events, or no events when all events have
+	// been read. Calling it again after that returns events saved since.
+	Next(ctx context.Context) ([]eh.Event, error)
+}
+
+// NonBlockingSource is a Source that can wait for events in Next, and that can
+// also read the events without waiting. It is used for the final catch-up
+// while swapping, which blocks the live repo.
+type NonBlockingSource interface {
+	Source
+
+	// NextNow returns the next batch of events like Next, without waiting.
+	NextNow(ctx context.Context) ([]eh.Event, error)
+}
+
+// StreamSource reads all events in a namespace in the order of their global
+// positions, see eventhorizon.EventStorePositionLoader.
+//
+// Concurrent saves can make positions visible out of order. When a position is
+// missing, the source waits up to the settle window for it to appear before
+// moving past it, blocking in Next, see eventhorizon.PositionGaps. NextNow
+// moves past missing positions without waiting.
+type StreamSource struct {
+	store        eh.EventStorePositionLoader
+	batchSize    int
+	settleWindow time.Duration
+	gaps         *eh.PositionGaps
+	position     int64
+}
+
+var _ = NonBlockingSource(&StreamSource{})
+
+// NewStreamSource creates a new StreamSource, reading batchSize events at a
+// time or DefaultBatchSize if 0.
+func NewStreamSource(store eh.EventStorePositionLoader, batchSize int, options ...StreamOption) *StreamSource {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	s := &StreamSource{
+		store:        store,
+		batchSize:    batchSize,
+		settleWindow: DefaultSettleWindow,
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+	s.gaps = eh.NewPositionGaps(s.settleWindow)
+
+	return s
+}
+
+// StreamOption is an option setter used to configure a StreamSource.
+type StreamOption func(*StreamSource)
+
+// WithSettleWindow sets the time to wait for a missing position to become
+// visible before moving past it. Use 0 to never wait, which can skip events
+// from concurrent saves.
+func WithSettleWindow(window time.Duration) StreamOption {
+	return func(s *StreamSource) {
+		s.settleWindow = window
+	}
+}
+
+// Next implements the Next method of the Source interface.
+func (s *StreamSource) Next(ctx context.Context) ([]eh.Event, error) {
+	for {
+		events, err := s.store.LoadAll(ctx, s.position, s.batchSize)
+		if err != nil {
+			return nil, fmt.Errorf("could not load events: %w", err)
+		}
+
+		// Return the events up to the first unsettled gap, or wait for the
+		// gap at the start of the batch and load again.
+		settled, wait := s.gaps.Settled(s.position, events)
+		if settled > 0 || wait == 0 {
+			return s.pass(events[:settled]), nil
+		}
+		if wait > gapPollInterval {
+			wait = gapPollInterval
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// NextNow implements the NextNow method of the NonBlockingSource interface.
+func (s *StreamSource) NextNow(ctx context.Context) ([]eh.Event, error) {
+	events, err := s.store.LoadAll(ctx, s.position, s.batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not load events: %w", err)
+	}
+
+	return s.pass(events), nil
+}
+
+// pass moves the position past the events.
+func (s *StreamSource) pass(events []eh.Event) []eh.Event {
+	for _, event := range events {
+		if e, ok := event.(eh.PositionedEvent); ok {
+			s.position = e.Position()
+		}
+	}
+
+	return events
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// AggregateSource reads the events of a list of aggregates, one aggregate at a
+// time, for event stores without a global stream.
+type AggregateSource struct {
+	store    eh.EventStore
+	ids      []uuid.UUID
+	versions map[uuid.UUID]int
+	next     int
+}
+
+// NewAggregateSource creates a new AggregateSource for the aggregates.
+func NewAggregateSource(store eh.EventStore, ids []uuid.UUID) *AggregateSource {
+	return &AggregateSource{
+		store:    store,
+		ids:      ids,
+		versions: map[uuid.UUID]int{},
+	}
+}
+
+// Next implements the Next method of the Source interface.
+func (s *AggregateSource) Next(ctx context.Context) ([]eh.Event, error) {
+	for s.next < len(s.ids) {
+		id := s.ids[s.next]
+		s.next++
+
+		events, err := s.load(ctx, id, s.versions[id])
+		if err != nil {
+			return nil, fmt.Errorf("could not load events for %s: %w", id, err)
+		}
+		if len(events) > 0 {
+			s.versions[id] = events[len(events)-1].Version()
+			return events, nil
+		}
+	}
+
+	// Start over to read new events on the next call.
+	s.next = 0
+
+	return nil, nil
+}
+
+func (s *AggregateSource) load(ctx context.Context, id uuid.UUID, version int) ([]eh.Event, error) {
+	if store, ok := s.store.(eh.EventStoreVersionLoader); ok {
+		return store.LoadFrom(ctx, id, version)
+	}
+
+	events, err := s.store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range events {
+		if e.Version() > version {
+			return events[i:], nil
+		}
+	}
+
+	return nil, nil
+}
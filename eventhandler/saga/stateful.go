@@ -0,0 +1,483 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrMissingRepo is when no repo is provided for the saga state.
+var ErrMissingRepo = errors.New("missing repo")
+
+// DefaultPollInterval is the default interval for checking for due timeouts.
+var DefaultPollInterval = time.Second
+
+// timeoutPageSize is the number of instances with due timeouts that are loaded
+// at a time.
+const timeoutPageSize = 100
+
+// StatefulSaga is a saga, or process manager, with state that is kept per
+// saga instance. Events are routed to an instance by a correlation ID and the
+// state of the instance is persisted between events.
+type StatefulSaga interface {
+	// SagaType returns the type of the saga.
+	SagaType() Type
+
+	// CorrelationID returns the ID of the saga instance for an event, or false
+	// if the event should not be handled. A new instance is started for an ID
+	// that has no state yet.
+	CorrelationID(context.Context, eh.Event) (uuid.UUID, bool)
+
+	// NewState returns a new empty state for a saga instance.
+	NewState() State
+
+	// RunSaga handles an event for a saga instance, which can update the state
+	// and return commands. If an error is returned the state is not saved and
+	// the event will be run again.
+	RunSaga(context.Context, eh.Event, State, eh.CommandHandler) error
+
+	// RunTimeout handles a due timeout for a saga instance, in the same way as
+	// RunSaga.
+	RunTimeout(context.Context, string, State, eh.CommandHandler) error
+}
+
+// State is the state of a saga instance. It is persisted as an entity with the
+// correlation ID as entity ID, and must embed Instance.
+type State interface {
+	eh.Entity
+	eh.Versionable
+
+	instance() *Instance
+}
+
+// Instance keeps the ID, version, completion and timeouts of a saga instance.
+// It must be embedded in all saga states, for MongoDB with `bson:",inline"`.
+//
+// NextTimeout is the time of the earliest timeout, which is kept up to date
+// when the state is saved and is used to query for instances with due timeouts.
+type Instance struct {
+	ID          uuid.UUID      `json:"id"                     bson:"_id"`
+	Version     int            `json:"version"                bson:"version"`
+	Completed   bool           `json:"completed"              bson:"completed"`
+	Timeouts    []Timeout      `json:"timeouts"               bson:"timeouts"`
+	NextTimeout *time.Time     `json:"next_timeout,omitempty" bson:"next_timeout,omitempty"`
+	Sources     map[string]int `json:"sources"                bson:"sources"`
+}
+
+// Timeout is a named timeout of a saga instance.
+type Timeout struct {
+	Name string    `json:"name" bson:"name"`
+	At   time.Time `json:"at"   bson:"at"`
+}
+
+// EntityID implements the EntityID method of the eventhorizon.Entity interface.
+func (i *Instance) EntityID() uuid.UUID {
+	return i.ID
+}
+
+// AggregateVersion implements the AggregateVersion method of the
+// eventhorizon.Versionable interface.
+func (i *Instance) AggregateVersion() int {
+	return i.Version
+}
+
+// IsNew returns true if the instance has not been saved yet.
+func (i *Instance) IsNew() bool {
+	return i.Version == 0
+}
+
+// Complete completes the instance, cancelling all timeouts. Events for
+// completed instances are ignored.
+func (i *Instance) Complete() {
+	i.Completed = true
+	i.Timeouts = nil
+}
+
+// ScheduleTimeout schedules a named timeout, replacing any timeout with the
+// same name. Timeouts are persisted with the state.
+func (i *Instance) ScheduleTimeout(name string, at time.Time) {
+	i.CancelTimeout(name)
+	i.Timeouts = append(i.Timeouts, Timeout{Name: name, At: at})
+}
+
+// CancelTimeout cancels a named timeout.
+func (i *Instance) CancelTimeout(name string) {
+	for j, t := range i.Timeouts {
+		if t.Name == name {
+			i.Timeouts = append(i.Timeouts[:j], i.Timeouts[j+1:]...)
+			return
+		}
+	}
+}
+
+// instance implements the instance method of the State interface.
+func (i *Instance) instance() *Instance {
+	return i
+}
+
+// updateNextTimeout sets the time of the earliest timeout, or nil if there are
+// no timeouts.
+func (i *Instance) updateNextTimeout() {
+	i.NextTimeout = nil
+	for _, t := range i.Timeouts {
+		if i.NextTimeout == nil || t.At.Before(*i.NextTimeout) {
+			at := t.At
+			i.NextTimeout = &at
+		}
+	}
+}
+
+// dueTimeouts returns the due timeouts, ordered by time.
+func (i *Instance) dueTimeouts(now time.Time) []Timeout {
+	var due []Timeout
+	for _, t := range i.Timeouts {
+		if !t.At.After(now) {
+			due = append(due, t)
+		}
+	}
+	sort.Slice(due, func(a, b int) bool {
+		return due[a].At.Before(due[b].At)
+	})
+
+	return due
+}
+
+// StatefulEventHandler is a CQRS saga handler to run a StatefulSaga
+// implementation, with the state persisted in a repo.
+//
+// Events already handled by an instance are ignored by their aggregate
+// versions. Commands are handled while running the saga, before the state is
+// saved, so they can be issued again if saving fails and should be idempotent.
+// Sagas can save their progress while running with Checkpoint.
+//
+// The repo must be created with a strict version check, such as
+// memory.WithStrictVersionCheck or mongodb.WithStrictVersionCheck, for saving
+// the state to fail with eventhorizon.ErrIncorrectEntityVersion if it was saved
+// by someone else since it was loaded. Instances are also locked within the
+// handler.
+//
+// Due timeouts are found by querying for NextTimeout if the repo implements
+// eventhorizon.QueryRepo, otherwise all states are loaded for each poll.
+type StatefulEventHandler struct {
+	saga           StatefulSaga
+	repo           eh.ReadWriteRepo
+	commandHandler eh.CommandHandler
+	pollInterval   time.Duration
+	locks          [32]sync.Mutex
+	errCh          chan eh.EventBusError
+	done           chan struct{}
+}
+
+var _ = eh.EventHandler(&StatefulEventHandler{})
+
+// NewStatefulEventHandler creates a new StatefulEventHandler with a repo for
+// the saga states.
+func NewStatefulEventHandler(saga StatefulSaga, repo eh.ReadWriteRepo,
+	commandHandler eh.CommandHandler, options ...StatefulOption) (*StatefulEventHandler, error) {
+	if repo == nil {
+		return nil, ErrMissingRepo
+	}
+
+	h := &StatefulEventHandler{
+		saga:           saga,
+		repo:           repo,
+		commandHandler: commandHandler,
+		pollInterval:   DefaultPollInterval,
+		errCh:          make(chan eh.EventBusError, 100),
+		done:           make(chan struct{}),
+	}
+
+	for _, option := range options {
+		if err := option(h); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return h, nil
+}
+
+// StatefulOption is an option setter used to configure creation.
+type StatefulOption func(*StatefulEventHandler) error
+
+// WithPollInterval sets the interval for checking for due timeouts.
+func WithPollInterval(interval time.Duration) StatefulOption {
+	return func(h *StatefulEventHandler) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid poll interval: %s", interval)
+		}
+		h.pollInterval = interval
+		return nil
+	}
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
+func (h *StatefulEventHandler) HandlerType() eh.EventHandlerType {
+	return eh.EventHandlerType("saga_" + h.saga.SagaType())
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
+func (h *StatefulEventHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	id, ok := h.saga.CorrelationID(ctx, event)
+	if !ok {
+		return nil
+	}
+
+	if err := h.run(ctx, id, func(ctx context.Context, state State) error {
+		i := state.instance()
+
+		// Ignore old/duplicate events.
+		if event.AggregateID() != uuid.Nil {
+			source := event.AggregateID().String()
+			if i.Sources[source] >= event.Version() {
+				return errSkip
+			}
+			if i.Sources == nil {
+				i.Sources = map[string]int{}
+			}
+			i.Sources[source] = event.Version()
+		}
+
+		return h.saga.RunSaga(ctx, event, state, h.commandHandler)
+	}); err != nil {
+		return Error{
+			Err:       err,
+			Saga:      h.saga.SagaType().String(),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// Start polls for due timeouts in the background until the context is
+// cancelled. Timeouts are handled for the namespace of the context.
+func (h *StatefulEventHandler) Start(ctx context.Context) {
+	go func() {
+		defer close(h.done)
+
+		ticker := time.NewTicker(h.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := h.RunTimeouts(ctx, time.Now()); err != nil {
+					h.error(ctx, err)
+				}
+			}
+		}
+	}()
+}
+
+// Errors returns an error channel where timeout errors are sent.
+func (h *StatefulEventHandler) Errors() <-chan eh.EventBusError {
+	return h.errCh
+}
+
+// Wait waits for the timeout polling to stop after its context is cancelled.
+func (h *StatefulEventHandler) Wait() {
+	<-h.done
+}
+
+// RunTimeouts runs all timeouts that are due at a time, for the namespace of
+// the context. Errors for single instances are sent on the error channel.
+func (h *StatefulEventHandler) RunTimeouts(ctx context.Context, now time.Time) error {
+	if repo, ok := h.repo.(eh.QueryRepo); ok {
+		q := eh.Query{
+			Filters: []eh.Filter{{Field: "next_timeout", Op: eh.FilterLte, Value: now}},
+			Sort:    []eh.SortField{{Field: "next_timeout"}},
+			Limit:   timeoutPageSize,
+		}
+
+		for {
+			result, err := repo.Query(ctx, q)
+			if errors.Is(err, eh.ErrQueryNotSupported) {
+				break
+			} else if err != nil {
+				return fmt.Errorf("could not query saga states: %w", err)
+			}
+
+			h.runTimeouts(ctx, result.Entities, now)
+
+			if result.Cursor == "" || ctx.Err() != nil {
+				return nil
+			}
+			q.Cursor = result.Cursor
+		}
+	}
+
+	entities, err := h.repo.FindAll(ctx)
+	if err != nil {
+		return fmt.Errorf("could not load saga states: %w", err)
+	}
+
+	h.runTimeouts(ctx, entities, now)
+
+	return nil
+}
+
+// runTimeouts runs the due timeouts of the states.
+func (h *StatefulEventHandler) runTimeouts(ctx context.Context, entities []eh.Entity, now time.Time) {
+	for _, entity := range entities {
+		if ctx.Err() != nil {
+			return
+		}
+
+		state, ok := entity.(State)
+		if !ok || state.instance().Completed || len(state.instance().dueTimeouts(now)) == 0 {
+			continue
+		}
+
+		if err := h.run(ctx, state.EntityID(), func(ctx context.Context, state State) error {
+			i := state.instance()
+			due := i.dueTimeouts(now)
+			if len(due) == 0 {
+				return errSkip
+			}
+
+			for _, t := range due {
+				i.CancelTimeout(t.Name)
+				if err := h.saga.RunTimeout(ctx, t.Name, state, h.commandHandler); err != nil {
+					return fmt.Errorf("could not run timeout %s: %w", t.Name, err)
+				}
+				if i.Completed {
+					break
+				}
+			}
+
+			return nil
+		}); err != nil {
+			h.error(ctx, Error{
+				Err:       err,
+				Saga:      h.saga.SagaType().String(),
+				Namespace: eh.NamespaceFromContext(ctx),
+			})
+		}
+	}
+}
+
+// errSkip is used to not save the state after running a saga.
+var errSkip = errors.New("skip")
+
+// run loads or creates the state of an instance, runs a func on it and saves
+// it if it was updated.
+func (h *StatefulEventHandler) run(ctx context.Context, id uuid.UUID, f func(context.Context, State) error) error {
+	mu := &h.locks[int(id[0])%len(h.locks)]
+	mu.Lock()
+	defer mu.Unlock()
+
+	state, err := h.load(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	i := state.instance()
+	if i.Completed {
+		return nil
+	}
+
+	c := &checkpoint{h: h, state: state, version: i.Version}
+	if err := f(context.WithValue(ctx, checkpointKey, c), state); errors.Is(err, errSkip) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return c.save(ctx)
+}
+
+// checkpoint saves the state of an instance while running it.
+type checkpoint struct {
+	h       *StatefulEventHandler
+	state   State
+	version int
+}
+
+func (c *checkpoint) save(ctx context.Context) error {
+	if err := c.h.save(ctx, c.state, c.version); err != nil {
+		return err
+	}
+	c.version++
+
+	return nil
+}
+
+type contextKey int
+
+const checkpointKey contextKey = iota
+
+// Checkpoint saves the state of the saga instance that is running with the
+// context, to persist progress before continuing. It is a no-op for contexts
+// not from a StatefulEventHandler.
+func Checkpoint(ctx context.Context) error {
+	c, ok := ctx.Value(checkpointKey).(*checkpoint)
+	if !ok {
+		return nil
+	}
+
+	return c.save(ctx)
+}
+
+func (h *StatefulEventHandler) load(ctx context.Context, id uuid.UUID) (State, error) {
+	entity, err := h.repo.Find(ctx, id)
+	if rrErr, ok := err.(eh.RepoError); ok && rrErr.Err == eh.ErrEntityNotFound {
+		state := h.saga.NewState()
+		state.instance().ID = id
+		return state, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not load saga state: %w", err)
+	}
+
+	state, ok := entity.(State)
+	if !ok {
+		return nil, fmt.Errorf("incorrect saga state type: %T", entity)
+	}
+
+	return state, nil
+}
+
+// save saves the state with the version following the version it was loaded
+// with. The repo fails the save if the stored version has changed since then.
+func (h *StatefulEventHandler) save(ctx context.Context, state State, version int) error {
+	i := state.instance()
+	i.Version = version + 1
+	i.updateNextTimeout()
+	if err := h.repo.Save(ctx, state); err != nil {
+		i.Version = version
+		return fmt.Errorf("could not save saga state: %w", err)
+	}
+
+	return nil
+}
+
+func (h *StatefulEventHandler) error(ctx context.Context, err error) {
+	select {
+	case h.errCh <- eh.EventBusError{Err: err, Ctx: ctx}:
+	default:
+		log.Printf("eventhorizon: missed error in saga timeouts: %s", err)
+	}
+}
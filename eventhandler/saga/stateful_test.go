@@ -0,0 +1,216 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package saga
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/repo/memory"
+)
+
+func TestStatefulEventHandler(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewRepo(memory.WithStrictVersionCheck())
+	repo.SetEntityFactory(func() eh.Entity { return &testState{} })
+	commandHandler := &mocks.CommandHandler{}
+	saga := &testStatefulSaga{correlation: map[uuid.UUID]uuid.UUID{}}
+
+	if _, err := NewStatefulEventHandler(saga, nil, commandHandler); !errors.Is(err, ErrMissingRepo) {
+		t.Error("there should be a missing repo error:", err)
+	}
+	h, err := NewStatefulEventHandler(saga, repo, commandHandler)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if h.HandlerType() != eh.EventHandlerType("saga_"+TestSagaType) {
+		t.Error("the handler type should be correct:", h.HandlerType())
+	}
+
+	// Events from several aggregates should be handled by one instance.
+	id, agg1, agg2 := uuid.New(), uuid.New(), uuid.New()
+	saga.correlation[agg1] = id
+	saga.correlation[agg2] = id
+	for _, event := range []eh.Event{
+		newSagaEvent(mocks.EventType, agg1, 1),
+		newSagaEvent(mocks.EventType, agg2, 1),
+		newSagaEvent(mocks.EventType, agg1, 1),       // Duplicate.
+		newSagaEvent(mocks.EventType, uuid.New(), 1), // Not correlated.
+	} {
+		if err := h.HandleEvent(ctx, event); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+	state := findState(t, repo, id)
+	if state.Count != 2 || state.Version != 2 || len(state.Timeouts) != 1 {
+		t.Error("the state should be correct:", state)
+	}
+	if state.NextTimeout == nil || !state.NextTimeout.Equal(state.Timeouts[0].At) {
+		t.Error("the next timeout should be set:", state.NextTimeout)
+	}
+
+	// Failing sagas should not save the state.
+	saga.err = errors.New("saga error")
+	if err := h.HandleEvent(ctx, newSagaEvent(mocks.EventType, agg1, 2)); !errors.Is(err, saga.err) {
+		t.Error("there should be a saga error:", err)
+	}
+	saga.err = nil
+	if state := findState(t, repo, id); state.Count != 2 {
+		t.Error("the state should not be saved:", state)
+	}
+
+	// Concurrently saved states should not be overwritten.
+	saga.before = func() {
+		s := findState(t, repo, id)
+		s.Version++
+		if err := repo.Save(ctx, s); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+	if err := h.HandleEvent(ctx, newSagaEvent(mocks.EventType, agg1, 2)); !errors.Is(err, eh.ErrIncorrectEntityVersion) {
+		t.Error("there should be an incorrect version error:", err)
+	}
+	saga.before = nil
+
+	// Timeouts should run when due, with the persisted state.
+	if err := h.RunTimeouts(ctx, time.Now()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(commandHandler.Commands) != 0 {
+		t.Error("there should be no commands:", commandHandler.Commands)
+	}
+	h, err = NewStatefulEventHandler(saga, repo, commandHandler)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := h.RunTimeouts(ctx, time.Now().Add(2*time.Hour)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(commandHandler.Commands) != 1 {
+		t.Error("the timeout command should be handled:", commandHandler.Commands)
+	}
+	state = findState(t, repo, id)
+	if !state.Completed || len(state.Timeouts) != 0 || state.NextTimeout != nil {
+		t.Error("the instance should be completed:", state)
+	}
+
+	// Completed instances should ignore events.
+	if err := h.HandleEvent(ctx, newSagaEvent(mocks.EventType, agg2, 2)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if s := findState(t, repo, id); s.Count != state.Count || s.Version != state.Version {
+		t.Error("the state should not be updated:", s)
+	}
+}
+
+func TestStatefulEventHandlerPolling(t *testing.T) {
+	repo := memory.NewRepo(memory.WithStrictVersionCheck())
+	repo.SetEntityFactory(func() eh.Entity { return &testState{} })
+	commandHandler := &mocks.CommandHandler{}
+	saga := &testStatefulSaga{correlation: map[uuid.UUID]uuid.UUID{}, timeout: time.Millisecond}
+	h, err := NewStatefulEventHandler(saga, repo, commandHandler, WithPollInterval(5*time.Millisecond))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.Start(ctx)
+
+	id := uuid.New()
+	saga.correlation[id] = id
+	if err := h.HandleEvent(ctx, newSagaEvent(mocks.EventType, id, 1)); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	h.Wait()
+
+	commandHandler.RLock()
+	defer commandHandler.RUnlock()
+	if len(commandHandler.Commands) != 1 {
+		t.Error("the timeout command should be handled:", commandHandler.Commands)
+	}
+
+	if _, err := NewStatefulEventHandler(saga, repo, commandHandler, WithPollInterval(0)); err == nil {
+		t.Error("there should be an error for an invalid poll interval")
+	}
+}
+
+func newSagaEvent(eventType eh.EventType, id uuid.UUID, version int) eh.Event {
+	return eh.NewEvent(eventType, &mocks.EventData{Content: "event"}, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, id, version))
+}
+
+func findState(t *testing.T, repo eh.ReadRepo, id uuid.UUID) *testState {
+	entity, err := repo.Find(context.Background(), id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	return entity.(*testState)
+}
+
+type testState struct {
+	Instance
+	Count int
+}
+
+type testStatefulSaga struct {
+	correlation map[uuid.UUID]uuid.UUID
+	timeout     time.Duration
+	before      func()
+	err         error
+}
+
+func (s *testStatefulSaga) SagaType() Type {
+	return TestSagaType
+}
+
+func (s *testStatefulSaga) CorrelationID(ctx context.Context, event eh.Event) (uuid.UUID, bool) {
+	id, ok := s.correlation[event.AggregateID()]
+	return id, ok
+}
+
+func (s *testStatefulSaga) NewState() State {
+	return &testState{}
+}
+
+func (s *testStatefulSaga) RunSaga(ctx context.Context, event eh.Event, state State, h eh.CommandHandler) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.before != nil {
+		s.before()
+	}
+	st := state.(*testState)
+	st.Count++
+	timeout := s.timeout
+	if timeout == 0 {
+		timeout = time.Hour
+	}
+	st.ScheduleTimeout("expire", time.Now().Add(timeout))
+	return nil
+}
+
+func (s *testStatefulSaga) RunTimeout(ctx context.Context, name string, state State, h eh.CommandHandler) error {
+	state.(*testState).Complete()
+	return h.HandleCommand(ctx, &mocks.Command{ID: state.EntityID(), Content: name})
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ResumeTimeout is the name of the timeout used by StepSaga to resume steps or
+// compensations that were interrupted, for example by a crash.
+const ResumeTimeout = "resume"
+
+// DefaultResumeAfter is the default time after which interrupted steps or
+// compensations are resumed.
+var DefaultResumeAfter = time.Minute
+
+// ErrCompensationFailed is when a compensating command could not be handled.
+var ErrCompensationFailed = errors.New("compensation failed")
+
+// StepFunc returns the command for a step or compensation of a saga instance,
+// or nil for no command.
+type StepFunc func(context.Context, StepState) (eh.Command, error)
+
+// Step is a step of a StepSaga, with an action and an optional compensation
+// that undoes the action.
+type Step struct {
+	Name       string
+	Action     StepFunc
+	Compensate StepFunc
+}
+
+// StepState is the state of a StepSaga instance, which must embed Progress.
+type StepState interface {
+	State
+
+	progress() *Progress
+}
+
+// Progress keeps the progress of the steps of a StepSaga instance. It must be
+// embedded in all step saga states instead of Instance, for MongoDB with
+// `bson:",inline"`.
+type Progress struct {
+	Instance `bson:",inline"`
+
+	// Step is the number of steps that are done and not compensated.
+	Step int `json:"step" bson:"step"`
+	// Compensating is set when a step has failed.
+	Compensating bool `json:"compensating" bson:"compensating"`
+	// Failure is the error of the failed step.
+	Failure string `json:"failure" bson:"failure"`
+}
+
+// Compensated returns true if the steps have been compensated after a failure.
+func (p *Progress) Compensated() bool {
+	return p.Completed && p.Compensating
+}
+
+// progress implements the progress method of the StepState interface.
+func (p *Progress) progress() *Progress {
+	return p
+}
+
+// StepSaga is a StatefulSaga that runs ordered steps, each handling an action
+// command. If an action fails, the compensations of the done steps are
+// handled in reverse order. The progress is saved after each step and
+// compensation, and is resumed by a timeout if it was interrupted, which
+// requires the timeouts of the StatefulEventHandler to be started.
+//
+// Steps are started by the first event of an instance. All events are first
+// passed to the OnEvent func, which can keep data for the steps in the state.
+// Commands can be handled again when resuming, and should be idempotent.
+type StepSaga struct {
+	sagaType    Type
+	correlate   func(context.Context, eh.Event) (uuid.UUID, bool)
+	newState    func() StepState
+	onEvent     func(context.Context, eh.Event, StepState) error
+	steps       []Step
+	resumeAfter time.Duration
+}
+
+var _ = StatefulSaga(&StepSaga{})
+
+// NewStepSaga creates a new StepSaga, with a func that returns the correlation
+// ID of an event and a func that creates a new state.
+func NewStepSaga(sagaType Type,
+	correlate func(context.Context, eh.Event) (uuid.UUID, bool),
+	newState func() StepState) *StepSaga {
+	return &StepSaga{
+		sagaType:    sagaType,
+		correlate:   correlate,
+		newState:    newState,
+		resumeAfter: DefaultResumeAfter,
+	}
+}
+
+// OnEvent sets a func that is called for all events before running the steps.
+func (s *StepSaga) OnEvent(f func(context.Context, eh.Event, StepState) error) *StepSaga {
+	s.onEvent = f
+	return s
+}
+
+// Step adds a step with an action and an optional compensation.
+func (s *StepSaga) Step(name string, action, compensate StepFunc) *StepSaga {
+	s.steps = append(s.steps, Step{
+		Name:       name,
+		Action:     action,
+		Compensate: compensate,
+	})
+	return s
+}
+
+// ResumeAfter sets the time after which interrupted steps are resumed.
+func (s *StepSaga) ResumeAfter(d time.Duration) *StepSaga {
+	s.resumeAfter = d
+	return s
+}
+
+// SagaType implements the SagaType method of the StatefulSaga interface.
+func (s *StepSaga) SagaType() Type {
+	return s.sagaType
+}
+
+// CorrelationID implements the CorrelationID method of the StatefulSaga interface.
+func (s *StepSaga) CorrelationID(ctx context.Context, event eh.Event) (uuid.UUID, bool) {
+	return s.correlate(ctx, event)
+}
+
+// NewState implements the NewState method of the StatefulSaga interface.
+func (s *StepSaga) NewState() State {
+	return s.newState()
+}
+
+// RunSaga implements the RunSaga method of the StatefulSaga interface.
+func (s *StepSaga) RunSaga(ctx context.Context, event eh.Event, state State, h eh.CommandHandler) error {
+	st, ok := state.(StepState)
+	if !ok {
+		return fmt.Errorf("incorrect saga state type: %T", state)
+	}
+
+	if s.onEvent != nil {
+		if err := s.onEvent(ctx, event, st); err != nil {
+			return err
+		}
+	}
+
+	return s.run(ctx, st, h)
+}
+
+// RunTimeout implements the RunTimeout method of the StatefulSaga interface.
+func (s *StepSaga) RunTimeout(ctx context.Context, name string, state State, h eh.CommandHandler) error {
+	st, ok := state.(StepState)
+	if !ok {
+		return fmt.Errorf("incorrect saga state type: %T", state)
+	}
+
+	if name != ResumeTimeout {
+		return nil
+	}
+
+	return s.run(ctx, st, h)
+}
+
+// run runs the remaining steps or compensations, saving the progress after
+// each one.
+func (s *StepSaga) run(ctx context.Context, state StepState, h eh.CommandHandler) error {
+	p := state.progress()
+
+	// Resume later if interrupted or failed. The timeout is saved before
+	// handling any command, so that a failed compensation is retried after
+	// resumeAfter and not on every poll.
+	if p.Compensating || p.Step < len(s.steps) {
+		p.ScheduleTimeout(ResumeTimeout, time.Now().Add(s.resumeAfter))
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+	}
+
+	for !p.Compensating && p.Step < len(s.steps) {
+		step := s.steps[p.Step]
+		if err := s.handle(ctx, step.Action, state, h); err != nil {
+			p.Compensating = true
+			p.Failure = fmt.Sprintf("%s: %s", step.Name, err)
+		} else {
+			p.Step++
+		}
+
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+	}
+
+	for p.Compensating && p.Step > 0 {
+		step := s.steps[p.Step-1]
+		if err := s.handle(ctx, step.Compensate, state, h); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrCompensationFailed, step.Name, err)
+		}
+		p.Step--
+
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+	}
+
+	p.Complete()
+
+	return nil
+}
+
+func (s *StepSaga) handle(ctx context.Context, f StepFunc, state StepState, h eh.CommandHandler) error {
+	if f == nil {
+		return nil
+	}
+
+	cmd, err := f(ctx, state)
+	if err != nil {
+		return err
+	}
+	if cmd == nil {
+		return nil
+	}
+
+	return h.HandleCommand(ctx, cmd)
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package saga
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/repo/memory"
+)
+
+func TestStepSaga(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewRepo(memory.WithStrictVersionCheck())
+	repo.SetEntityFactory(func() eh.Entity { return &testStepState{} })
+	commandHandler := &failingCommandHandler{fail: map[string]bool{}}
+
+	cmd := func(content string) StepFunc {
+		return func(ctx context.Context, state StepState) (eh.Command, error) {
+			return &mocks.Command{ID: state.EntityID(), Content: content}, nil
+		}
+	}
+	saga := NewStepSaga(TestSagaType,
+		func(ctx context.Context, event eh.Event) (uuid.UUID, bool) {
+			return event.AggregateID(), true
+		},
+		func() StepState { return &testStepState{} },
+	).OnEvent(func(ctx context.Context, event eh.Event, state StepState) error {
+		state.(*testStepState).Content = event.Data().(*mocks.EventData).Content
+		return nil
+	}).
+		Step("reserve", cmd("reserve"), cmd("unreserve")).
+		Step("notify", cmd("notify"), nil).
+		Step("charge", cmd("charge"), cmd("refund"))
+
+	h, err := NewStatefulEventHandler(saga, repo, commandHandler)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// All steps should be run in order.
+	id := uuid.New()
+	if err := h.HandleEvent(ctx, newSagaEvent(mocks.EventType, id, 1)); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(commandHandler.handled, []string{"reserve", "notify", "charge"}) {
+		t.Error("the steps should be run:", commandHandler.handled)
+	}
+	state := findStepState(t, repo, id)
+	if !state.Completed || state.Compensated() || state.Step != 3 || state.Content != "event" {
+		t.Error("the steps should be completed:", state)
+	}
+	if len(state.Timeouts) != 0 {
+		t.Error("there should be no timeouts:", state.Timeouts)
+	}
+
+	// Failing steps should be compensated in reverse order.
+	commandHandler.handled = nil
+	commandHandler.fail["charge"] = true
+	id = uuid.New()
+	if err := h.HandleEvent(ctx, newSagaEvent(mocks.EventType, id, 1)); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(commandHandler.handled, []string{"reserve", "notify", "unreserve"}) {
+		t.Error("the steps should be compensated:", commandHandler.handled)
+	}
+	state = findStepState(t, repo, id)
+	if !state.Compensated() || state.Step != 0 || state.Failure != "charge: command error" {
+		t.Error("the steps should be compensated:", state)
+	}
+
+	// Failing compensations should be resumed from the saved progress.
+	commandHandler.handled = nil
+	commandHandler.fail["unreserve"] = true
+	id = uuid.New()
+	if err := h.HandleEvent(ctx, newSagaEvent(mocks.EventType, id, 1)); !errors.Is(err, ErrCompensationFailed) {
+		t.Error("there should be a compensation failed error:", err)
+	}
+	state = findStepState(t, repo, id)
+	if state.Completed || !state.Compensating || state.Step != 1 || len(state.Timeouts) != 1 {
+		t.Error("the progress should be saved:", state)
+	}
+
+	// Redelivered events should not restart the steps.
+	if err := h.HandleEvent(ctx, newSagaEvent(mocks.EventType, id, 1)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Failing resumed compensations should reschedule the resume timeout.
+	resumeAt := state.Timeouts[0].At
+	if err := h.RunTimeouts(ctx, time.Now().Add(2*DefaultResumeAfter)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := <-h.Errors(); !errors.Is(err, ErrCompensationFailed) {
+		t.Error("there should be a compensation failed error:", err)
+	}
+	state = findStepState(t, repo, id)
+	if state.Completed || len(state.Timeouts) != 1 || !state.Timeouts[0].At.After(resumeAt) {
+		t.Error("the resume timeout should be rescheduled:", state)
+	}
+
+	commandHandler.handled = nil
+	delete(commandHandler.fail, "unreserve")
+	h, err = NewStatefulEventHandler(saga, repo, commandHandler)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := h.RunTimeouts(ctx, time.Now().Add(2*DefaultResumeAfter)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(commandHandler.handled, []string{"unreserve"}) {
+		t.Error("the compensation should be resumed:", commandHandler.handled)
+	}
+	if state = findStepState(t, repo, id); !state.Compensated() || len(state.Timeouts) != 0 {
+		t.Error("the steps should be compensated:", state)
+	}
+}
+
+func findStepState(t *testing.T, repo eh.ReadRepo, id uuid.UUID) *testStepState {
+	entity, err := repo.Find(context.Background(), id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	return entity.(*testStepState)
+}
+
+type testStepState struct {
+	Progress
+	Content string
+}
+
+// failingCommandHandler fails commands by content.
+type failingCommandHandler struct {
+	handled []string
+	fail    map[string]bool
+}
+
+func (h *failingCommandHandler) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	content := cmd.(*mocks.Command).Content
+	if h.fail[content] {
+		return errors.New("command error")
+	}
+	h.handled = append(h.handled, content)
+	return nil
+}
@@ -17,6 +17,7 @@ package eventhorizon
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -30,6 +31,121 @@ type EventStore interface {
 	Load(context.Context, uuid.UUID) ([]Event, error)
 }
 
+// EventStoreVersionLoader is an optional interface for an EventStore that can
+// load only the events after a specific version of an aggregate.
+type EventStoreVersionLoader interface {
+	EventStore
+
+	// LoadFrom loads all events with a version greater than version for the
+	// aggregate id from the store.
+	LoadFrom(ctx context.Context, id uuid.UUID, version int) ([]Event, error)
+}
+
+// EventStorePositionLoader is an optional interface for an EventStore that
+// keeps a global order of all events in a namespace, useful for reading the
+// whole store in order, for example when rebuilding projections. The position
+// order is not guaranteed to be the commit order, see LoadAll.
+type EventStorePositionLoader interface {
+	EventStore
+
+	// LoadAll loads up to limit events (or all if limit is 0) for all aggregates
+	// with a position greater than fromPosition, ordered by position. The loaded
+	// events implement PositionedEvent, the position of the last event can be
+	// used as fromPosition to load the next batch.
+	//
+	// Positions can have gaps from failed saves, and depending on the store
+	// concurrent saves can become visible out of order, with a lower position
+	// appearing after a higher one. Consumers following the head of the store
+	// must therefore not move past a gap until it has had time to settle, for
+	// example using PositionGaps, or they can miss events.
+	LoadAll(ctx context.Context, fromPosition int64, limit int) ([]Event, error)
+}
+
+// PositionGaps keeps track of missing positions in events loaded by position
+// with EventStorePositionLoader, for consumers that should not move past a gap
+// before it has had time to settle. A gap is timed from when it was first seen,
+// not by the timestamps of the events, which are set before the events are
+// saved. All gaps in a batch are timed together, which makes a replay of the
+// store wait at most one settle window for each batch with gaps.
+//
+// PositionGaps is not safe for concurrent use.
+type PositionGaps struct {
+	window time.Duration
+	seen   map[int64]time.Time
+}
+
+// NewPositionGaps creates a PositionGaps that waits for gaps for a settle
+// window. Use 0 to never wait, which can skip events from concurrent saves.
+func NewPositionGaps(window time.Duration) *PositionGaps {
+	return &PositionGaps{
+		window: window,
+		seen:   map[int64]time.Time{},
+	}
+}
+
+// Settled returns the number of events from the start of a batch loaded after
+// a position that can be passed, and if that is not all events, the time left
+// until the first missing position after them has settled.
+func (g *PositionGaps) Settled(position int64, events []Event) (int, time.Duration) {
+	// Forget gaps that have been passed.
+	for p := range g.seen {
+		if p <= position {
+			delete(g.seen, p)
+		}
+	}
+	if g.window <= 0 {
+		return len(events), 0
+	}
+
+	now := time.Now()
+	settled, wait := len(events), time.Duration(0)
+	for i, event := range events {
+		e, ok := event.(PositionedEvent)
+		if !ok {
+			continue
+		}
+
+		// Time all gaps in the batch, keyed by the position after the gap.
+		if e.Position() > position+1 {
+			since, ok := g.seen[e.Position()]
+			if !ok {
+				since = now
+				g.seen[e.Position()] = now
+			}
+			if left := g.window - now.Sub(since); left > 0 && settled == len(events) {
+				settled, wait = i, left
+			}
+		}
+		position = e.Position()
+	}
+
+	return settled, wait
+}
+
+// EventStoreOutbox is an optional interface for an EventStore that records
+// saved events as unpublished in the same atomic operation as saving them.
+// Together with an outbox relay it makes sure that all saved events are
+// eventually published, even if the process stops right after saving.
+type EventStoreOutbox interface {
+	EventStore
+
+	// LoadUnpublished loads up to limit events (or all if limit is 0) that were
+	// saved before a time and not yet marked as published. The events of an
+	// aggregate are ordered by version.
+	LoadUnpublished(ctx context.Context, savedBefore time.Time, limit int) ([]UnpublishedEvent, error)
+
+	// MarkPublished marks an event as published, removing it from the outbox.
+	MarkPublished(ctx context.Context, event Event) error
+}
+
+// UnpublishedEvent is an event in the outbox of an event store.
+type UnpublishedEvent struct {
+	// Ctx is the context the event was saved with, restored with UnmarshalContext.
+	Ctx context.Context
+	// Event is the unpublished event.
+	Event Event
+}
+
 // EventStoreMaintainer is an interface for a maintainer of an EventStore.
 // NOTE: Should not be used in apps, useful for migration tools etc.
 type EventStoreMaintainer interface {
@@ -80,3 +196,9 @@ var ErrInvalidEvent = errors.New("invalid event")
 
 // ErrIncorrectEventVersion is when an event is for an other version of the aggregate.
 var ErrIncorrectEventVersion = errors.New("mismatching event version")
+
+// ErrEventConflictFromOtherSave is when the aggregate has been changed by an
+// other save since it was loaded, for example by a concurrent command. All
+// event stores should return it (wrapped in an EventStoreError) for
+// optimistic concurrency conflicts, making it possible to retry the command.
+var ErrEventConflictFromOtherSave = errors.New("event conflict from other save")
@@ -16,6 +16,7 @@ package eventstore
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -67,6 +68,15 @@ func AcceptanceTest(t *testing.T, ctx context.Context, store eh.EventStore) []eh
 		t.Error("there should be a ErrIncerrectEventVersion error:", err)
 	}
 
+	// Try to save an event from an outdated version of the aggregate, as
+	// happens with concurrent commands.
+	conflictingEvent := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id, 1))
+	err = store.Save(ctx, []eh.Event{conflictingEvent}, 0)
+	if !errors.Is(err, eh.ErrEventConflictFromOtherSave) {
+		t.Error("there should be a ErrEventConflictFromOtherSave error:", err)
+	}
+
 	// Save event, version 2, with metadata.
 	event2 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp,
 		eh.ForAggregate(mocks.AggregateType, id, 2),
@@ -267,6 +277,461 @@ func MaintainerAcceptanceTest(t *testing.T, ctx context.Context, store eh.EventS
 	}
 }
 
+// LoadFromAcceptanceTest is the acceptance test that all implementations of
+// EventStoreVersionLoader should pass. It should manually be called from a
+// test case in each implementation:
+//
+//   func TestEventStore(t *testing.T) {
+//       ctx := context.Background() // Or other when testing namespaces.
+//       store := NewEventStore()
+//       eventstore.LoadFromAcceptanceTest(t, ctx, store)
+//   }
+//
+func LoadFromAcceptanceTest(t *testing.T, ctx context.Context, store eh.EventStoreVersionLoader) {
+	ctx = context.WithValue(ctx, "testkey", "testval")
+
+	// Load events for non-existing aggregate.
+	events, err := store.LoadFrom(ctx, uuid.New(), 1)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(events) != 0 {
+		t.Error("there should be no loaded events:", eventsToString(events))
+	}
+
+	// Save some events.
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id, 1))
+	event2 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id, 2))
+	event3 := eh.NewEvent(mocks.EventOtherType, nil, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id, 3))
+	if err := store.Save(ctx, []eh.Event{event1, event2, event3}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Load events from the start.
+	events, err = store.LoadFrom(ctx, id, 0)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	expectedEvents := []eh.Event{event1, event2, event3}
+	if len(events) != len(expectedEvents) {
+		t.Fatal("there should be three loaded events:", eventsToString(events))
+	}
+	for i, event := range events {
+		if err := eh.CompareEvents(event, expectedEvents[i]); err != nil {
+			t.Error("the event was incorrect:", err)
+		}
+	}
+
+	// Load events after a version.
+	events, err = store.LoadFrom(ctx, id, 1)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	expectedEvents = []eh.Event{event2, event3}
+	if len(events) != len(expectedEvents) {
+		t.Fatal("there should be two loaded events:", eventsToString(events))
+	}
+	for i, event := range events {
+		if err := eh.CompareEvents(event, expectedEvents[i]); err != nil {
+			t.Error("the event was incorrect:", err)
+		}
+	}
+
+	// Load events after the last version.
+	events, err = store.LoadFrom(ctx, id, 3)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(events) != 0 {
+		t.Error("there should be no loaded events:", eventsToString(events))
+	}
+}
+
+// LoadAllAcceptanceTest is the acceptance test that all implementations of
+// EventStorePositionLoader should pass. It should manually be called from a
+// test case in each implementation:
+//
+//   func TestEventStore(t *testing.T) {
+//       ctx := context.Background() // Or other when testing namespaces.
+//       store := NewEventStore()
+//       eventstore.LoadAllAcceptanceTest(t, ctx, store)
+//   }
+//
+func LoadAllAcceptanceTest(t *testing.T, ctx context.Context, store eh.EventStorePositionLoader) {
+	ctx = context.WithValue(ctx, "testkey", "testval")
+
+	// Find the current last position, the store may contain other events.
+	events, err := store.LoadAll(ctx, 0, 0)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	var lastPosition int64
+	for _, event := range events {
+		e, ok := event.(eh.PositionedEvent)
+		if !ok {
+			t.Fatal("the event should have a position:", event)
+		}
+		if e.Position() <= lastPosition {
+			t.Error("the positions should be increasing:", e.Position(), lastPosition)
+		}
+		lastPosition = e.Position()
+	}
+
+	// Load all events after the last position.
+	events, err = store.LoadAll(ctx, lastPosition, 0)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(events) != 0 {
+		t.Error("there should be no loaded events:", eventsToString(events))
+	}
+
+	// Save interleaved events for two aggregates.
+	id1, id2 := uuid.New(), uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id1, 1))
+	event2 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id2, 1))
+	event3 := eh.NewEvent(mocks.EventOtherType, nil, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id1, 2))
+	event4 := eh.NewEvent(mocks.EventOtherType, nil, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id1, 3))
+	if err := store.Save(ctx, []eh.Event{event1}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Save(ctx, []eh.Event{event2}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Save(ctx, []eh.Event{event3, event4}, 1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Load all new events, in commit order.
+	events, err = store.LoadAll(ctx, lastPosition, 0)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	expectedEvents := []eh.Event{event1, event2, event3, event4}
+	if len(events) != len(expectedEvents) {
+		t.Fatal("there should be four loaded events:", eventsToString(events))
+	}
+	positions := make([]int64, len(events))
+	for i, event := range events {
+		if err := eh.CompareEvents(event, expectedEvents[i]); err != nil {
+			t.Error("the event was incorrect:", err)
+		}
+		e, ok := event.(eh.PositionedEvent)
+		if !ok {
+			t.Fatal("the event should have a position:", event)
+		}
+		if e.Position() <= lastPosition || (i > 0 && e.Position() <= positions[i-1]) {
+			t.Error("the positions should be increasing:", e.Position())
+		}
+		positions[i] = e.Position()
+	}
+
+	// Load in batches using the position of the last loaded event.
+	events, err = store.LoadAll(ctx, lastPosition, 3)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(events) != 3 {
+		t.Fatal("there should be three loaded events:", eventsToString(events))
+	}
+	if err := eh.CompareEvents(events[2], event3); err != nil {
+		t.Error("the event was incorrect:", err)
+	}
+	events, err = store.LoadAll(ctx, events[2].(eh.PositionedEvent).Position(), 3)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(events) != 1 {
+		t.Fatal("there should be one loaded event:", eventsToString(events))
+	}
+	if err := eh.CompareEvents(events[0], event4); err != nil {
+		t.Error("the event was incorrect:", err)
+	}
+
+	// The positions should be the same when loading per aggregate.
+	events, err = store.Load(ctx, id1)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	expectedPositions := []int64{positions[0], positions[2], positions[3]}
+	if len(events) != len(expectedPositions) {
+		t.Fatal("there should be three loaded events:", eventsToString(events))
+	}
+	for i, event := range events {
+		if e, ok := event.(eh.PositionedEvent); !ok || e.Position() != expectedPositions[i] {
+			t.Error("the position should be correct:", event, expectedPositions[i])
+		}
+	}
+}
+
+// OutboxAcceptanceTest is the acceptance test that all implementations of
+// EventStoreOutbox should pass, with the outbox enabled. It should manually
+// be called from a test case in each implementation:
+//
+//   func TestEventStoreOutbox(t *testing.T) {
+//       ctx := context.Background() // Or other when testing namespaces.
+//       store := NewEventStore(WithOutbox())
+//       eventstore.OutboxAcceptanceTest(t, ctx, store)
+//   }
+//
+func OutboxAcceptanceTest(t *testing.T, ctx context.Context, store eh.EventStoreOutbox) {
+	ctx = context.WithValue(ctx, "testkey", "testval")
+
+	// Mark any previously saved events as published.
+	unpublished, err := store.LoadUnpublished(ctx, time.Now().Add(time.Second), 0)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	for _, u := range unpublished {
+		if err := store.MarkPublished(ctx, u.Event); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+
+	// Save events for two aggregates.
+	before := time.Now().Add(-time.Second)
+	id1, id2 := uuid.New(), uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id1, 1))
+	event2 := eh.NewEvent(mocks.EventOtherType, nil, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id1, 2))
+	event3 := eh.NewEvent(mocks.EventOtherType, nil, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id1, 3))
+	event4 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event4"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id2, 1))
+	if err := store.Save(ctx, []eh.Event{event1}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Save(ctx, []eh.Event{event2, event3}, 1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Save(ctx, []eh.Event{event4}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Nothing was saved before the test.
+	unpublished, err = store.LoadUnpublished(ctx, before, 0)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(unpublished) != 0 {
+		t.Error("there should be no unpublished events:", unpublished)
+	}
+
+	// All events should be unpublished, ordered per aggregate.
+	unpublished, err = store.LoadUnpublished(ctx, time.Now().Add(time.Second), 0)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(unpublished) != 4 {
+		t.Fatal("there should be four unpublished events:", unpublished)
+	}
+	var aggregate1 []eh.Event
+	for _, u := range unpublished {
+		if ns := eh.NamespaceFromContext(u.Ctx); ns != eh.NamespaceFromContext(ctx) {
+			t.Error("the namespace should be correct:", ns)
+		}
+		if u.Event.AggregateID() == id1 {
+			aggregate1 = append(aggregate1, u.Event)
+		} else if err := eh.CompareEvents(u.Event, event4); err != nil {
+			t.Error("the event was incorrect:", err)
+		}
+	}
+	expectedEvents := []eh.Event{event1, event2, event3}
+	if len(aggregate1) != len(expectedEvents) {
+		t.Fatal("there should be three unpublished events:", eventsToString(aggregate1))
+	}
+	for i, event := range aggregate1 {
+		if err := eh.CompareEvents(event, expectedEvents[i]); err != nil {
+			t.Error("the event was incorrect:", err)
+		}
+	}
+
+	// Load with a limit.
+	unpublished, err = store.LoadUnpublished(ctx, time.Now().Add(time.Second), 2)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(unpublished) != 2 {
+		t.Error("there should be two unpublished events:", unpublished)
+	}
+
+	// Mark events as published.
+	if err := store.MarkPublished(ctx, event1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.MarkPublished(ctx, event4); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	unpublished, err = store.LoadUnpublished(ctx, time.Now().Add(time.Second), 0)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(unpublished) != 2 {
+		t.Fatal("there should be two unpublished events:", unpublished)
+	}
+	for i, u := range unpublished {
+		if err := eh.CompareEvents(u.Event, expectedEvents[i+1]); err != nil {
+			t.Error("the event was incorrect:", err)
+		}
+	}
+
+	// The events should still be loaded as usual.
+	events, err := store.Load(ctx, id1)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(events) != 3 {
+		t.Error("there should be three loaded events:", eventsToString(events))
+	}
+
+	if err := store.MarkPublished(ctx, event2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.MarkPublished(ctx, event3); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	unpublished, err = store.LoadUnpublished(ctx, time.Now().Add(time.Second), 0)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(unpublished) != 0 {
+		t.Error("there should be no unpublished events:", unpublished)
+	}
+}
+
+// SnapshotAcceptanceTest is the acceptance test that all implementations of
+// SnapshotStore should pass. It should manually be called from a test case in
+// each implementation:
+//
+//   func TestEventStore(t *testing.T) {
+//       ctx := context.Background() // Or other when testing namespaces.
+//       store := NewEventStore()
+//       eventstore.SnapshotAcceptanceTest(t, ctx, store)
+//   }
+//
+func SnapshotAcceptanceTest(t *testing.T, ctx context.Context, store interface {
+	eh.EventStore
+	eh.SnapshotStore
+}) {
+	ctx = context.WithValue(ctx, "testkey", "testval")
+
+	// Load snapshot for non-existing aggregate.
+	snapshot, err := store.LoadSnapshot(ctx, uuid.New())
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if snapshot != nil {
+		t.Error("there should be no snapshot:", snapshot)
+	}
+
+	// Save snapshot for non-existing aggregate.
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	if err := store.SaveSnapshot(ctx, uuid.New(), eh.Snapshot{
+		Version:       1,
+		AggregateType: mocks.AggregateType,
+		Timestamp:     timestamp,
+	}); err != eh.ErrAggregateNotFound {
+		t.Error("there should be an aggregate not found error:", err)
+	}
+
+	// Save some events.
+	id := uuid.New()
+	event1 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id, 1))
+	event2 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id, 2))
+	if err := store.Save(ctx, []eh.Event{event1, event2}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Load snapshot for aggregate without snapshot.
+	snapshot, err = store.LoadSnapshot(ctx, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if snapshot != nil {
+		t.Error("there should be no snapshot:", snapshot)
+	}
+
+	// Save and load snapshot.
+	expected := eh.Snapshot{
+		Version:       2,
+		AggregateType: mocks.AggregateType,
+		Timestamp:     timestamp,
+		State:         &mocks.SnapshotData{Content: "state"},
+	}
+	if err := store.SaveSnapshot(ctx, id, expected); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	snapshot, err = store.LoadSnapshot(ctx, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if snapshot == nil {
+		t.Fatal("there should be a snapshot")
+	}
+	if snapshot.Version != expected.Version ||
+		snapshot.AggregateType != expected.AggregateType ||
+		!snapshot.Timestamp.Equal(expected.Timestamp) {
+		t.Error("the snapshot should be correct:", snapshot)
+	}
+	if state, ok := snapshot.State.(*mocks.SnapshotData); !ok || state.Content != "state" {
+		t.Error("the snapshot state should be correct:", snapshot.State)
+	}
+
+	// Save more events, the snapshot should be kept.
+	event3 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event3"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id, 3))
+	if err := store.Save(ctx, []eh.Event{event3}, 2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	snapshot, err = store.LoadSnapshot(ctx, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if snapshot == nil || snapshot.Version != 2 {
+		t.Error("the snapshot should be kept:", snapshot)
+	}
+
+	// Replace the snapshot with a newer one.
+	expected.Version = 3
+	expected.State = &mocks.SnapshotData{Content: "state3"}
+	if err := store.SaveSnapshot(ctx, id, expected); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	snapshot, err = store.LoadSnapshot(ctx, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if snapshot == nil || snapshot.Version != 3 {
+		t.Fatal("the snapshot should be replaced:", snapshot)
+	}
+	if state, ok := snapshot.State.(*mocks.SnapshotData); !ok || state.Content != "state3" {
+		t.Error("the snapshot state should be correct:", snapshot.State)
+	}
+
+	// The events should not be affected by the snapshots.
+	events, err := store.Load(ctx, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(events) != 3 {
+		t.Error("there should be three events:", eventsToString(events))
+	}
+}
+
 func eventsToString(events []eh.Event) string {
 	parts := make([]string, len(events))
 	for i, e := range events {
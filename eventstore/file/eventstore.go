@@ -0,0 +1,751 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+var (
+	// ErrCouldNotOpenNamespace is when the files of a namespace could not be opened.
+	ErrCouldNotOpenNamespace = errors.New("could not open namespace")
+	// ErrCorruptSegment is when a segment file contains invalid data.
+	ErrCorruptSegment = errors.New("corrupt segment")
+	// ErrLocked is when the directory is used by another EventStore.
+	ErrLocked = errors.New("directory locked by another event store")
+	// ErrCouldNotClearDB is when the database could not be cleared.
+	ErrCouldNotClearDB = errors.New("could not clear database")
+	// ErrCouldNotMarshalEvent is when an event could not be marshaled into JSON.
+	ErrCouldNotMarshalEvent = errors.New("could not marshal event")
+	// ErrCouldNotUnmarshalEvent is when an event could not be unmarshaled into a concrete type.
+	ErrCouldNotUnmarshalEvent = errors.New("could not unmarshal event")
+	// ErrCouldNotLoadAggregate is when an aggregate could not be loaded.
+	ErrCouldNotLoadAggregate = errors.New("could not load aggregate")
+	// ErrCouldNotSaveAggregate is when an aggregate could not be saved.
+	ErrCouldNotSaveAggregate = errors.New("could not save aggregate")
+)
+
+// SyncMode is how often written events are flushed to disk.
+type SyncMode int
+
+const (
+	// SyncEverySave flushes every save to disk before returning, surviving
+	// both process and OS crashes. This is the default.
+	SyncEverySave SyncMode = iota
+	// SyncNever leaves flushing to the OS, which is faster but can lose the
+	// latest saves if the OS crashes. Sync can be used to flush manually.
+	SyncNever
+)
+
+// DefaultSegmentSize is the size at which a new segment file is started.
+const DefaultSegmentSize = 64 * 1024 * 1024
+
+// EventStore implements an EventStore using append-only segment files on
+// disk. Each namespace is stored in its own directory. Replaced and renamed
+// events are appended as new versions of the events, old data is never
+// overwritten.
+//
+// The store keeps an index of the location of all events in memory, which
+// is built when a namespace is first used. Only one EventStore may use a
+// directory at a time, which is enforced with a lock file where supported.
+type EventStore struct {
+	dir         string
+	lock        *os.File
+	syncMode    SyncMode
+	segmentSize int64
+	namespaces  map[string]*namespace
+	namespaceMu sync.Mutex
+}
+
+// NewEventStore creates a new EventStore storing events in a directory,
+// which is created if it does not exist. It returns ErrLocked if the directory
+// is used by another EventStore, which holds the lock until it is closed.
+func NewEventStore(dir string, options ...Option) (*EventStore, error) {
+	s := &EventStore{
+		dir:         dir,
+		syncMode:    SyncEverySave,
+		segmentSize: DefaultSegmentSize,
+		namespaces:  map[string]*namespace{},
+	}
+
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create directory: %w", err)
+	}
+
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not lock directory: %w", err)
+	}
+	s.lock = lock
+
+	return s, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*EventStore) error
+
+// WithSyncMode sets how often written events are flushed to disk.
+func WithSyncMode(mode SyncMode) Option {
+	return func(s *EventStore) error {
+		s.syncMode = mode
+		return nil
+	}
+}
+
+// WithSegmentSize sets the size at which a new segment file is started. A
+// single save is never split, which can make segments larger.
+func WithSegmentSize(size int64) Option {
+	return func(s *EventStore) error {
+		if size <= 0 {
+			return fmt.Errorf("invalid segment size: %d", size)
+		}
+		s.segmentSize = size
+		return nil
+	}
+}
+
+// Save implements the Save method of the eventhorizon.EventStore interface.
+func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersion int) error {
+	if len(events) == 0 {
+		return eh.EventStoreError{
+			Err:       eh.ErrNoEventsToAppend,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Validate all events, with incrementing versions starting from the
+	// original aggregate version.
+	aggregateID := events[0].AggregateID()
+	for i, event := range events {
+		// Only accept events belonging to the same aggregate.
+		if event.AggregateID() != aggregateID {
+			return eh.EventStoreError{
+				Err:       eh.ErrInvalidEvent,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		// Only accept events that apply to the correct aggregate version.
+		if event.Version() != originalVersion+i+1 {
+			return eh.EventStoreError{
+				Err:       eh.ErrIncorrectEventVersion,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	n, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// Only insert if version of aggregate is matching (ie not changed since
+	// loading the aggregate).
+	if len(n.index[aggregateID]) != originalVersion {
+		return eh.EventStoreError{
+			Err:       eh.ErrEventConflictFromOtherSave,
+			BaseErr:   fmt.Errorf("invalid original version %d", originalVersion),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Create the event records with their global positions.
+	records := make([]evt, len(events))
+	position := int64(len(n.log))
+	for i, event := range events {
+		position++
+		e, err := newEvt(ctx, event, position)
+		if err != nil {
+			return err
+		}
+		records[i] = *e
+	}
+
+	if err := s.write(ctx, n, records); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotSaveAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// Load implements the Load method of the eventhorizon.EventStore interface.
+func (s *EventStore) Load(ctx context.Context, id uuid.UUID) ([]eh.Event, error) {
+	return s.LoadFrom(ctx, id, 0)
+}
+
+// LoadFrom implements the LoadFrom method of the eventhorizon.EventStoreVersionLoader interface.
+func (s *EventStore) LoadFrom(ctx context.Context, id uuid.UUID, version int) ([]eh.Event, error) {
+	n, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	locs := n.index[id]
+	if version < 0 {
+		version = 0
+	}
+	if version >= len(locs) {
+		return []eh.Event{}, nil
+	}
+
+	return n.events(ctx, locs[version:])
+}
+
+// LoadAll implements the LoadAll method of the eventhorizon.EventStorePositionLoader interface.
+func (s *EventStore) LoadAll(ctx context.Context, fromPosition int64, limit int) ([]eh.Event, error) {
+	n, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	log := n.log
+	if fromPosition < 0 {
+		fromPosition = 0
+	}
+	if fromPosition >= int64(len(log)) {
+		return []eh.Event{}, nil
+	}
+	log = log[fromPosition:]
+	if limit > 0 && limit < len(log) {
+		log = log[:limit]
+	}
+
+	locs := make([]location, len(log))
+	for i, entry := range log {
+		locs[i] = n.index[entry.aggregateID][entry.version-1]
+	}
+
+	return n.events(ctx, locs)
+}
+
+// Replace implements the Replace method of the eventhorizon.EventStore interface.
+func (s *EventStore) Replace(ctx context.Context, event eh.Event) error {
+	n, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	locs, ok := n.index[event.AggregateID()]
+	if !ok {
+		return eh.ErrAggregateNotFound
+	}
+	if event.Version() < 1 || event.Version() > len(locs) {
+		return eh.ErrInvalidEvent
+	}
+
+	// Create the event record, keeping the position.
+	e, err := newEvt(ctx, event, locs[event.Version()-1].position)
+	if err != nil {
+		return err
+	}
+
+	if err := s.write(ctx, n, []evt{*e}); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotSaveAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// RenameEvent implements the RenameEvent method of the eventhorizon.EventStore interface.
+func (s *EventStore) RenameEvent(ctx context.Context, from, to eh.EventType) error {
+	n, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// Append a renamed copy of all matching events, in a single write.
+	var records []evt
+	frames := map[location][]evt{}
+	for _, locs := range n.index {
+		for _, loc := range locs {
+			e, err := n.record(ctx, frames, loc)
+			if err != nil {
+				return err
+			}
+			if e.EventType == from {
+				e.EventType = to
+				records = append(records, e)
+			}
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	if err := s.write(ctx, n, records); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotSaveAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// Sync flushes all written events to disk, for use with SyncNever. Segments
+// are flushed when a new segment is started, leaving only the active segment
+// and the directory of each namespace to flush.
+func (s *EventStore) Sync() error {
+	s.namespaceMu.Lock()
+	defer s.namespaceMu.Unlock()
+
+	for _, n := range s.namespaces {
+		n.mu.Lock()
+		err := n.active().file.Sync()
+		if err == nil {
+			err = syncDir(n.dir)
+		}
+		n.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Clear clears the event storage of the namespace in the context.
+func (s *EventStore) Clear(ctx context.Context) error {
+	ns := eh.NamespaceFromContext(ctx)
+
+	s.namespaceMu.Lock()
+	defer s.namespaceMu.Unlock()
+
+	if n, ok := s.namespaces[ns]; ok {
+		n.mu.Lock()
+		n.close()
+		n.mu.Unlock()
+		delete(s.namespaces, ns)
+	}
+
+	if err := os.RemoveAll(s.namespaceDir(ns)); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotClearDB,
+			BaseErr:   err,
+			Namespace: ns,
+		}
+	}
+
+	return nil
+}
+
+// Close closes all open files and releases the lock of the directory.
+func (s *EventStore) Close() error {
+	s.namespaceMu.Lock()
+	defer s.namespaceMu.Unlock()
+
+	var err error
+	for ns, n := range s.namespaces {
+		n.mu.Lock()
+		if e := n.close(); e != nil && err == nil {
+			err = e
+		}
+		n.mu.Unlock()
+		delete(s.namespaces, ns)
+	}
+
+	if s.lock != nil {
+		if e := s.lock.Close(); e != nil && err == nil {
+			err = e
+		}
+		s.lock = nil
+	}
+
+	return err
+}
+
+// namespace returns the namespace in the context, opening it if needed.
+func (s *EventStore) namespace(ctx context.Context) (*namespace, error) {
+	ns := eh.NamespaceFromContext(ctx)
+
+	s.namespaceMu.Lock()
+	defer s.namespaceMu.Unlock()
+
+	if n, ok := s.namespaces[ns]; ok {
+		return n, nil
+	}
+
+	n, err := openNamespace(ctx, s.namespaceDir(ns))
+	if err != nil {
+		return nil, eh.EventStoreError{
+			Err:       ErrCouldNotOpenNamespace,
+			BaseErr:   err,
+			Namespace: ns,
+		}
+	}
+	s.namespaces[ns] = n
+
+	return n, nil
+}
+
+// lockFile is the name of the lock file in the directory of the store, which
+// can not clash with a namespace as those never start with a ".".
+const lockFile = ".lock"
+
+// namespaceDir returns the directory of a namespace. The name is escaped to
+// be safe to use as a single path element.
+func (s *EventStore) namespaceDir(ns string) string {
+	return filepath.Join(s.dir, strings.ReplaceAll(url.PathEscape(ns), ".", "%2E"))
+}
+
+// write appends event records as a single frame to the active segment,
+// starting a new segment if it is full, and adds them to the index. Must be
+// called with the namespace locked.
+func (s *EventStore) write(ctx context.Context, n *namespace, records []evt) error {
+	payload, err := json.Marshal(records)
+	if err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotMarshalEvent,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	sync := s.syncMode == SyncEverySave
+	seg := n.active()
+	if seg.size > 0 && seg.size+frameHeaderSize+int64(len(payload)) > s.segmentSize {
+		// Flush the full segment, as only the active segment is flushed later.
+		if !sync {
+			if err := seg.file.Sync(); err != nil {
+				return err
+			}
+		}
+		if seg, err = openSegment(n.dir, seg.id+1); err != nil {
+			return err
+		}
+		if sync {
+			if err := syncDir(n.dir); err != nil {
+				seg.file.Close()
+				return err
+			}
+		}
+		n.segments = append(n.segments, seg)
+	}
+
+	offset, err := seg.append(payload, sync)
+	if err != nil {
+		return err
+	}
+
+	for i, e := range records {
+		if err := n.apply(e, location{seg, offset, i, e.Position}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// namespace is the open segments and index of a namespace.
+type namespace struct {
+	dir      string
+	segments []*segment
+	// index is the location of the events of each aggregate, by version.
+	index map[uuid.UUID][]location
+	// log is the events in the order of their global positions.
+	log []logEntry
+	mu  sync.RWMutex
+}
+
+type location struct {
+	segment  *segment
+	offset   int64
+	index    int
+	position int64
+}
+
+type logEntry struct {
+	aggregateID uuid.UUID
+	version     int
+}
+
+// openNamespace opens all segments in a namespace directory and builds the
+// index. A partly written frame at the end of the last segment is removed.
+func openNamespace(ctx context.Context, dir string) (*namespace, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".log") {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ".log"))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	if len(ids) == 0 {
+		ids = []int{1}
+	}
+
+	n := &namespace{
+		dir:   dir,
+		index: map[uuid.UUID][]location{},
+	}
+	for i, id := range ids {
+		seg, err := openSegment(dir, id)
+		if err != nil {
+			n.close()
+			return nil, err
+		}
+		n.segments = append(n.segments, seg)
+
+		end, err := seg.scan(func(offset int64, payload []byte) error {
+			var records []evt
+			if err := json.Unmarshal(payload, &records); err != nil {
+				return fmt.Errorf("%w: %s", ErrCorruptSegment, err)
+			}
+			for i, e := range records {
+				if err := n.apply(e, location{seg, offset, i, e.Position}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			n.close()
+			return nil, err
+		}
+
+		if end < seg.size {
+			// Only the last segment can have a partly written frame.
+			if i != len(ids)-1 {
+				n.close()
+				return nil, fmt.Errorf("%w: %s", ErrCorruptSegment, segmentName(id))
+			}
+			if err := seg.truncate(end); err != nil {
+				n.close()
+				return nil, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// apply adds an event record to the index, either as the next version of its
+// aggregate or as a replacement of an existing version.
+func (n *namespace) apply(e evt, loc location) error {
+	locs := n.index[e.AggregateID]
+	switch {
+	case e.Version == len(locs)+1:
+		n.index[e.AggregateID] = append(locs, loc)
+		n.log = append(n.log, logEntry{e.AggregateID, e.Version})
+	case e.Version >= 1 && e.Version <= len(locs):
+		locs[e.Version-1] = loc
+	default:
+		return fmt.Errorf("%w: invalid version %d for aggregate %s",
+			ErrCorruptSegment, e.Version, e.AggregateID)
+	}
+
+	return nil
+}
+
+// active returns the segment that is written to.
+func (n *namespace) active() *segment {
+	return n.segments[len(n.segments)-1]
+}
+
+// events reads and decodes the events at a list of locations.
+func (n *namespace) events(ctx context.Context, locs []location) ([]eh.Event, error) {
+	frames := map[location][]evt{}
+	events := make([]eh.Event, len(locs))
+	for i, loc := range locs {
+		e, err := n.record(ctx, frames, loc)
+		if err != nil {
+			return nil, err
+		}
+		if events[i], err = e.event(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return events, nil
+}
+
+// record reads the event record at a location. Frames are cached by their
+// location, with the index zeroed, as a frame often holds several events.
+func (n *namespace) record(ctx context.Context, frames map[location][]evt, loc location) (evt, error) {
+	key := location{segment: loc.segment, offset: loc.offset}
+	records, ok := frames[key]
+	if !ok {
+		payload, err := loc.segment.read(loc.offset)
+		if err != nil {
+			return evt{}, eh.EventStoreError{
+				Err:       ErrCouldNotLoadAggregate,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if err := json.Unmarshal(payload, &records); err != nil {
+			return evt{}, eh.EventStoreError{
+				Err:       ErrCouldNotUnmarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		frames[key] = records
+	}
+	if loc.index >= len(records) {
+		return evt{}, eh.EventStoreError{
+			Err:       ErrCouldNotLoadAggregate,
+			BaseErr:   ErrCorruptSegment,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return records[loc.index], nil
+}
+
+// close closes all segment files.
+func (n *namespace) close() error {
+	var err error
+	for _, seg := range n.segments {
+		if e := seg.file.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// evt is the internal event record for the file event store used
+// to save and load events from disk.
+type evt struct {
+	Position      int64                  `json:"position"`
+	EventType     eh.EventType           `json:"event_type"`
+	RawData       json.RawMessage        `json:"data,omitempty"`
+	Timestamp     time.Time              `json:"timestamp"`
+	AggregateType eh.AggregateType       `json:"aggregate_type"`
+	AggregateID   uuid.UUID              `json:"aggregate_id"`
+	Version       int                    `json:"version"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// newEvt returns a new evt for an event.
+func newEvt(ctx context.Context, event eh.Event, position int64) (*evt, error) {
+	e := &evt{
+		Position:      position,
+		EventType:     event.EventType(),
+		Timestamp:     event.Timestamp(),
+		AggregateType: event.AggregateType(),
+		AggregateID:   event.AggregateID(),
+		Version:       event.Version(),
+		Metadata:      event.Metadata(),
+	}
+
+	// Marshal event data if there is any.
+	if event.Data() != nil {
+		var err error
+		if e.RawData, err = json.Marshal(event.Data()); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotMarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// event returns an event from an evt, decoding the data to a concrete type.
+func (e evt) event(ctx context.Context) (eh.Event, error) {
+	// Create an event of the correct type and decode from raw JSON.
+	var data eh.EventData
+	if len(e.RawData) > 0 {
+		var err error
+		if data, err = eh.CreateEventData(e.EventType); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotUnmarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if err := json.Unmarshal(e.RawData, data); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotUnmarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	return eh.NewEvent(
+		e.EventType,
+		data,
+		e.Timestamp,
+		eh.ForAggregate(
+			e.AggregateType,
+			e.AggregateID,
+			e.Version,
+		),
+		eh.WithMetadata(e.Metadata),
+		eh.WithPosition(e.Position),
+	), nil
+}
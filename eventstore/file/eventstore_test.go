@@ -0,0 +1,211 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventstore"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestEventStore(t *testing.T) {
+	store, err := NewEventStore(t.TempDir())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+	defer store.Close()
+
+	// Run the actual test suite, both for default and custom namespace.
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	eventstore.AcceptanceTest(t, context.Background(), store)
+	eventstore.AcceptanceTest(t, ctx, store)
+	eventstore.MaintainerAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadFromAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadAllAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadAllAcceptanceTest(t, ctx, store)
+}
+
+func TestEventStoreSmallSegments(t *testing.T) {
+	store, err := NewEventStore(t.TempDir(),
+		WithSegmentSize(1),
+		WithSyncMode(SyncNever),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer store.Close()
+
+	eventstore.AcceptanceTest(t, context.Background(), store)
+	eventstore.MaintainerAcceptanceTest(t, context.Background(), store)
+
+	if err := store.Sync(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestEventStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	store, err := NewEventStore(dir)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, eh.ForAggregate(mocks.AggregateType, id, 1))
+	if err := store.Save(ctx, []eh.Event{event1}, 0); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	event1Replaced := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "replaced"},
+		timestamp, eh.ForAggregate(mocks.AggregateType, id, 1))
+	if err := store.Replace(ctx, event1Replaced); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Simulate a crash during a write by appending a partial frame.
+	segmentFile := filepath.Join(dir, "ns", segmentName(1))
+	f, err := os.OpenFile(segmentFile, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if _, err := f.Write([]byte{0, 0, 1, 0, 1, 2, 3}); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	f.Close()
+
+	store, err = NewEventStore(dir)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer store.Close()
+
+	events, err := store.Load(ctx, id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(events) != 1 {
+		t.Fatal("there should be one event:", events)
+	}
+	if events[0].Data().(*mocks.EventData).Content != "replaced" {
+		t.Error("the event should be replaced:", events[0])
+	}
+
+	// The store should continue after the last valid frame.
+	event2 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"},
+		timestamp, eh.ForAggregate(mocks.AggregateType, id, 2))
+	if err := store.Save(ctx, []eh.Event{event2}, 1); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	events, err = store.LoadAll(ctx, 0, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(events) != 2 {
+		t.Fatal("there should be two events:", events)
+	}
+	if pe, ok := events[1].(eh.PositionedEvent); !ok || pe.Position() != 2 {
+		t.Error("the position should be correct:", events[1])
+	}
+}
+
+func TestEventStoreCorruptSegment(t *testing.T) {
+	dir := t.TempDir()
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	store, err := NewEventStore(dir)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	for i := 1; i <= 2; i++ {
+		event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"},
+			timestamp, eh.ForAggregate(mocks.AggregateType, id, i))
+		if err := store.Save(ctx, []eh.Event{event}, i-1); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Corrupt the payload of the first frame, which is followed by another.
+	segmentFile := filepath.Join(dir, "ns", segmentName(1))
+	f, err := os.OpenFile(segmentFile, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if _, err := f.WriteAt([]byte{'X'}, frameHeaderSize+1); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	f.Close()
+
+	store, err = NewEventStore(dir)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer store.Close()
+
+	_, err = store.Load(ctx, id)
+	var storeErr eh.EventStoreError
+	if !errors.As(err, &storeErr) || !errors.Is(storeErr.BaseErr, ErrCorruptSegment) {
+		t.Error("the error should be correct:", err)
+	}
+	info, err := os.Stat(segmentFile)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if info.Size() <= frameHeaderSize {
+		t.Error("the segment should not be truncated:", info.Size())
+	}
+}
+
+func TestEventStoreLocked(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewEventStore(dir)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if _, err := NewEventStore(dir); !errors.Is(err, ErrLocked) {
+		t.Error("the error should be correct:", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	store, err = NewEventStore(dir)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	store.Close()
+}
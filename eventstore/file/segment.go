@@ -0,0 +1,196 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A segment file is a sequence of frames, each holding the events of one
+// write. A frame is a big endian uint32 payload length, a CRC-32C checksum of
+// the payload and the payload itself. A frame that is only partly written,
+// for example after a crash, is at the end of the segment and is cut off when
+// the segment is opened again, making every write all or nothing. An invalid
+// frame that is followed by other data is corruption and is not cut off.
+const frameHeaderSize = 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type segment struct {
+	id   int
+	file *os.File
+	size int64
+}
+
+// segmentName returns the file name of a segment, sortable by ID.
+func segmentName(id int) string {
+	return fmt.Sprintf("%08d.log", id)
+}
+
+// openSegment opens or creates a segment file in a directory.
+func openSegment(dir string, id int) (*segment, error) {
+	f, err := os.OpenFile(filepath.Join(dir, segmentName(id)), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &segment{
+		id:   id,
+		file: f,
+		size: info.Size(),
+	}, nil
+}
+
+// append writes a frame at the end of the segment and returns its offset.
+// A failed write is truncated away, to not leave a partial frame behind.
+func (s *segment) append(payload []byte, sync bool) (int64, error) {
+	buf := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	copy(buf[frameHeaderSize:], payload)
+
+	offset := s.size
+	if _, err := s.file.WriteAt(buf, offset); err != nil {
+		s.file.Truncate(offset)
+		return 0, err
+	}
+	if sync {
+		if err := s.file.Sync(); err != nil {
+			s.file.Truncate(offset)
+			return 0, err
+		}
+	}
+	s.size += int64(len(buf))
+
+	return offset, nil
+}
+
+// read returns the payload of the frame at an offset.
+func (s *segment) read(offset int64) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := s.file.ReadAt(header[:], offset); err != nil {
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	if offset+frameHeaderSize+length > s.size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, length)
+	if _, err := s.file.ReadAt(payload, offset+frameHeaderSize); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, ErrCorruptSegment
+	}
+
+	return payload, nil
+}
+
+// scan reads all frames of the segment in order. It returns the offset after
+// the last valid frame, which is less than the size if the segment ends with
+// a partly written frame. An invalid frame before the end is returned as
+// ErrCorruptSegment.
+func (s *segment) scan(f func(offset int64, payload []byte) error) (int64, error) {
+	var offset int64
+	for offset < s.size {
+		payload, err := s.read(offset)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || err == ErrCorruptSegment ||
+			(err == nil && len(payload) == 0) {
+			torn, tornErr := s.isTornTail(offset)
+			if tornErr != nil {
+				return offset, tornErr
+			} else if !torn {
+				return offset, fmt.Errorf("%w: invalid frame at offset %d in %s",
+					ErrCorruptSegment, offset, segmentName(s.id))
+			}
+			return offset, nil
+		} else if err != nil {
+			return offset, err
+		}
+		if err := f(offset, payload); err != nil {
+			return offset, err
+		}
+		offset += frameHeaderSize + int64(len(payload))
+	}
+
+	return offset, nil
+}
+
+// isTornTail returns true if an invalid frame at an offset is a partly written
+// last frame: either its length reaches the end of the segment, or the rest of
+// the segment is zeros, as left by some file systems after a crash.
+func (s *segment) isTornTail(offset int64) (bool, error) {
+	var header [frameHeaderSize]byte
+	n, err := s.file.ReadAt(header[:], offset)
+	if err == io.EOF && n < frameHeaderSize {
+		// Not even the header was written.
+		return true, nil
+	} else if err != nil && err != io.EOF {
+		return false, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	if length > 0 && offset+frameHeaderSize+length >= s.size {
+		return true, nil
+	}
+
+	buf := make([]byte, 32*1024)
+	for pos := offset; pos < s.size; {
+		n, err := s.file.ReadAt(buf, pos)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		pos += int64(n)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// truncate cuts the segment at an offset.
+func (s *segment) truncate(offset int64) error {
+	if err := s.file.Truncate(offset); err != nil {
+		return err
+	}
+	s.size = offset
+
+	return s.file.Sync()
+}
+
+// syncDir flushes a directory, making created files durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
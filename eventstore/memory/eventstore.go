@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jinzhu/copier"
@@ -30,23 +31,49 @@ var (
 	ErrCouldNotSaveAggregate = errors.New("could not save aggregate")
 	// ErrCouldNotCreateEvent is when event data could not be created.
 	ErrCouldNotCreateEvent = errors.New("could not create event")
+	// ErrCouldNotCreateSnapshot is when snapshot data could not be created.
+	ErrCouldNotCreateSnapshot = errors.New("could not create snapshot")
 )
 
 // EventStore implements EventStore as an in memory structure.
 type EventStore struct {
 	// The outer map is with namespace as key, the inner with aggregate ID.
-	db   map[string]map[uuid.UUID]aggregateRecord
-	dbMu sync.RWMutex
+	db map[string]map[uuid.UUID]aggregateRecord
+	// The global event log per namespace, the position of an event is its
+	// index in the log plus one.
+	log map[string][]logEntry
+	// The unpublished events per namespace, if the outbox is used.
+	outbox    map[string][]outboxEntry
+	useOutbox bool
+	dbMu      sync.RWMutex
 }
 
 // NewEventStore creates a new EventStore using memory as storage.
-func NewEventStore() *EventStore {
+func NewEventStore(options ...Option) *EventStore {
 	s := &EventStore{
-		db: map[string]map[uuid.UUID]aggregateRecord{},
+		db:     map[string]map[uuid.UUID]aggregateRecord{},
+		log:    map[string][]logEntry{},
+		outbox: map[string][]outboxEntry{},
 	}
+
+	for _, option := range options {
+		option(s)
+	}
+
 	return s
 }
 
+// Option is an option setter used to configure creation.
+type Option func(*EventStore)
+
+// WithOutbox records all saved events as unpublished, to be published by an
+// outbox relay. See eventhorizon.EventStoreOutbox.
+func WithOutbox() Option {
+	return func(s *EventStore) {
+		s.useOutbox = true
+	}
+}
+
 // Save implements the Save method of the eventhorizon.EventStore interface.
 func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersion int) error {
 	if len(events) == 0 {
@@ -56,9 +83,8 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 		}
 	}
 
-	// Build all event records, with incrementing versions starting from the
+	// Validate all events, with incrementing versions starting from the
 	// original aggregate version.
-	dbEvents := make([]eh.Event, len(events))
 	aggregateID := events[0].AggregateID()
 	for i, event := range events {
 		// Only accept events belonging to the same aggregate.
@@ -76,13 +102,6 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 				Namespace: eh.NamespaceFromContext(ctx),
 			}
 		}
-
-		// Create the event record with timestamp.
-		e, err := copyEvent(ctx, event)
-		if err != nil {
-			return err
-		}
-		dbEvents[i] = e
 	}
 
 	ns := s.namespace(ctx)
@@ -90,39 +109,66 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 	s.dbMu.Lock()
 	defer s.dbMu.Unlock()
 
-	// Either insert a new aggregate or append to an existing.
-	if originalVersion == 0 {
-		aggregate := aggregateRecord{
-			AggregateID: aggregateID,
-			Version:     len(dbEvents),
-			Events:      dbEvents,
+	// Only insert if version of aggregate is matching (ie not changed since
+	// loading the aggregate).
+	aggregate, ok := s.db[ns][aggregateID]
+	if originalVersion == 0 && ok {
+		return eh.EventStoreError{
+			Err:       eh.ErrEventConflictFromOtherSave,
+			Namespace: eh.NamespaceFromContext(ctx),
 		}
+	} else if originalVersion != 0 && (!ok || aggregate.Version != originalVersion) {
+		return eh.EventStoreError{
+			Err:       eh.ErrEventConflictFromOtherSave,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
 
-		s.db[ns][aggregateID] = aggregate
-	} else {
-		// Increment aggregate version on insert of new event record, and
-		// only insert if version of aggregate is matching (ie not changed
-		// since loading the aggregate).
-		if aggregate, ok := s.db[ns][aggregateID]; ok {
-			if aggregate.Version != originalVersion {
-				return eh.EventStoreError{
-					Err:       ErrCouldNotSaveAggregate,
-					Namespace: eh.NamespaceFromContext(ctx),
-				}
+	// Create the event records with their global positions.
+	dbEvents := make([]eh.Event, len(events))
+	entries := make([]logEntry, len(events))
+	var unpublished []outboxEntry
+	if s.useOutbox {
+		unpublished = make([]outboxEntry, len(events))
+	}
+	now := time.Now()
+	position := int64(len(s.log[ns]))
+	for i, event := range events {
+		position++
+		e, err := copyEvent(ctx, event, eh.WithPosition(position))
+		if err != nil {
+			return err
+		}
+		dbEvents[i] = e
+		entries[i] = logEntry{aggregateID, event.Version()}
+		if s.useOutbox {
+			unpublished[i] = outboxEntry{
+				logEntry: entries[i],
+				ctxVals:  eh.MarshalContext(ctx),
+				savedAt:  now,
 			}
-
-			aggregate.Version += len(dbEvents)
-			aggregate.Events = append(aggregate.Events, dbEvents...)
-
-			s.db[ns][aggregateID] = aggregate
 		}
 	}
 
+	// Either insert a new aggregate or append to an existing, incrementing
+	// the aggregate version.
+	aggregate.AggregateID = aggregateID
+	aggregate.Version += len(dbEvents)
+	aggregate.Events = append(aggregate.Events, dbEvents...)
+	s.db[ns][aggregateID] = aggregate
+	s.log[ns] = append(s.log[ns], entries...)
+	s.outbox[ns] = append(s.outbox[ns], unpublished...)
+
 	return nil
 }
 
 // Load implements the Load method of the eventhorizon.EventStore interface.
 func (s *EventStore) Load(ctx context.Context, id uuid.UUID) ([]eh.Event, error) {
+	return s.LoadFrom(ctx, id, 0)
+}
+
+// LoadFrom implements the LoadFrom method of the eventhorizon.EventStoreVersionLoader interface.
+func (s *EventStore) LoadFrom(ctx context.Context, id uuid.UUID, version int) ([]eh.Event, error) {
 	s.dbMu.RLock()
 	defer s.dbMu.RUnlock()
 
@@ -136,8 +182,44 @@ func (s *EventStore) Load(ctx context.Context, id uuid.UUID) ([]eh.Event, error)
 		return []eh.Event{}, nil
 	}
 
-	events := make([]eh.Event, len(aggregate.Events))
-	for i, event := range aggregate.Events {
+	events := make([]eh.Event, 0, len(aggregate.Events))
+	for _, event := range aggregate.Events {
+		if event.Version() <= version {
+			continue
+		}
+		e, err := copyEvent(ctx, event)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+// LoadAll implements the LoadAll method of the eventhorizon.EventStorePositionLoader interface.
+func (s *EventStore) LoadAll(ctx context.Context, fromPosition int64, limit int) ([]eh.Event, error) {
+	// Ensure that the namespace exists.
+	ns := s.namespace(ctx)
+
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+
+	log := s.log[ns]
+	if fromPosition < 0 {
+		fromPosition = 0
+	}
+	if fromPosition >= int64(len(log)) {
+		return []eh.Event{}, nil
+	}
+	log = log[fromPosition:]
+	if limit > 0 && limit < len(log) {
+		log = log[:limit]
+	}
+
+	events := make([]eh.Event, len(log))
+	for i, entry := range log {
+		event := s.db[ns][entry.aggregateID].Events[entry.version-1]
 		e, err := copyEvent(ctx, event)
 		if err != nil {
 			return nil, err
@@ -153,19 +235,13 @@ func (s *EventStore) Replace(ctx context.Context, event eh.Event) error {
 	// Ensure that the namespace exists.
 	ns := s.namespace(ctx)
 
-	s.dbMu.RLock()
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
 	aggregate, ok := s.db[ns][event.AggregateID()]
 	if !ok {
-		s.dbMu.RUnlock()
 		return eh.ErrAggregateNotFound
 	}
-	s.dbMu.RUnlock()
-
-	// Create the event record for the Database.
-	e, err := copyEvent(ctx, event)
-	if err != nil {
-		return err
-	}
 
 	// Find the event to replace.
 	idx := -1
@@ -179,9 +255,13 @@ func (s *EventStore) Replace(ctx context.Context, event eh.Event) error {
 		return eh.ErrInvalidEvent
 	}
 
+	// Create the event record for the Database, keeping the position.
+	e, err := copyEvent(ctx, event, eh.WithPosition(position(aggregate.Events[idx])))
+	if err != nil {
+		return err
+	}
+
 	// Replace event.
-	s.dbMu.Lock()
-	defer s.dbMu.Unlock()
 	aggregate.Events[idx] = e
 
 	return nil
@@ -199,20 +279,24 @@ func (s *EventStore) RenameEvent(ctx context.Context, from, to eh.EventType) err
 	for id, aggregate := range s.db[ns] {
 		events := make([]eh.Event, len(aggregate.Events))
 		for i, e := range aggregate.Events {
-			if e.EventType() == from {
-				// Rename any matching event by duplicating.
-				events[i] = eh.NewEvent(
-					to,
-					e.Data(),
-					e.Timestamp(),
-					eh.ForAggregate(
-						e.AggregateType(),
-						e.AggregateID(),
-						e.Version(),
-					),
-					eh.WithMetadata(e.Metadata()),
-				)
+			if e.EventType() != from {
+				events[i] = e
+				continue
 			}
+
+			// Rename any matching event by duplicating.
+			events[i] = eh.NewEvent(
+				to,
+				e.Data(),
+				e.Timestamp(),
+				eh.ForAggregate(
+					e.AggregateType(),
+					e.AggregateID(),
+					e.Version(),
+				),
+				eh.WithMetadata(e.Metadata()),
+				eh.WithPosition(position(e)),
+			)
 		}
 		aggregate.Events = events
 		updated[id] = aggregate
@@ -225,6 +309,95 @@ func (s *EventStore) RenameEvent(ctx context.Context, from, to eh.EventType) err
 	return nil
 }
 
+// LoadUnpublished implements the LoadUnpublished method of the eventhorizon.EventStoreOutbox interface.
+func (s *EventStore) LoadUnpublished(ctx context.Context, savedBefore time.Time, limit int) ([]eh.UnpublishedEvent, error) {
+	// Ensure that the namespace exists.
+	ns := s.namespace(ctx)
+
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+
+	events := []eh.UnpublishedEvent{}
+	for _, entry := range s.outbox[ns] {
+		if limit > 0 && len(events) == limit {
+			break
+		}
+		if !entry.savedAt.Before(savedBefore) {
+			continue
+		}
+
+		event := s.db[ns][entry.aggregateID].Events[entry.version-1]
+		e, err := copyEvent(ctx, event)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, eh.UnpublishedEvent{
+			Ctx:   eh.UnmarshalContext(ctx, entry.ctxVals),
+			Event: e,
+		})
+	}
+
+	return events, nil
+}
+
+// MarkPublished implements the MarkPublished method of the eventhorizon.EventStoreOutbox interface.
+func (s *EventStore) MarkPublished(ctx context.Context, event eh.Event) error {
+	// Ensure that the namespace exists.
+	ns := s.namespace(ctx)
+
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	outbox := s.outbox[ns]
+	for i, entry := range outbox {
+		if entry.aggregateID == event.AggregateID() && entry.version == event.Version() {
+			s.outbox[ns] = append(outbox[:i:i], outbox[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// LoadSnapshot implements the LoadSnapshot method of the eventhorizon.SnapshotStore interface.
+func (s *EventStore) LoadSnapshot(ctx context.Context, id uuid.UUID) (*eh.Snapshot, error) {
+	// Ensure that the namespace exists.
+	ns := s.namespace(ctx)
+
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+
+	aggregate, ok := s.db[ns][id]
+	if !ok || aggregate.Snapshot == nil {
+		return nil, nil
+	}
+
+	return copySnapshot(ctx, *aggregate.Snapshot)
+}
+
+// SaveSnapshot implements the SaveSnapshot method of the eventhorizon.SnapshotStore interface.
+func (s *EventStore) SaveSnapshot(ctx context.Context, id uuid.UUID, snapshot eh.Snapshot) error {
+	// Ensure that the namespace exists.
+	ns := s.namespace(ctx)
+
+	sn, err := copySnapshot(ctx, snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	aggregate, ok := s.db[ns][id]
+	if !ok {
+		return eh.ErrAggregateNotFound
+	}
+	aggregate.Snapshot = sn
+	s.db[ns][id] = aggregate
+
+	return nil
+}
+
 // Helper to get the namespace and ensure that its data exists.
 func (s *EventStore) namespace(ctx context.Context) string {
 	s.dbMu.Lock()
@@ -236,15 +409,35 @@ func (s *EventStore) namespace(ctx context.Context) string {
 	return ns
 }
 
+type logEntry struct {
+	aggregateID uuid.UUID
+	version     int
+}
+
+type outboxEntry struct {
+	logEntry
+	ctxVals map[string]interface{}
+	savedAt time.Time
+}
+
 type aggregateRecord struct {
 	AggregateID uuid.UUID
 	Version     int
 	Events      []eh.Event
-	// Snapshot    eh.Aggregate
+	Snapshot    *eh.Snapshot
+}
+
+// position returns the global position of an event, or 0 if it has none.
+func position(event eh.Event) int64 {
+	if e, ok := event.(eh.PositionedEvent); ok {
+		return e.Position()
+	}
+	return 0
 }
 
-// copyEvent duplicates an event.
-func copyEvent(ctx context.Context, event eh.Event) (eh.Event, error) {
+// copyEvent duplicates an event, including its position. Any options are
+// applied to the new event.
+func copyEvent(ctx context.Context, event eh.Event, options ...eh.EventOption) (eh.Event, error) {
 	// Copy data if there is any.
 	var data eh.EventData
 	if event.Data() != nil {
@@ -259,15 +452,39 @@ func copyEvent(ctx context.Context, event eh.Event) (eh.Event, error) {
 		copier.Copy(data, event.Data())
 	}
 
-	return eh.NewEvent(
-		event.EventType(),
-		data,
-		event.Timestamp(),
+	options = append([]eh.EventOption{
 		eh.ForAggregate(
 			event.AggregateType(),
 			event.AggregateID(),
 			event.Version(),
 		),
 		eh.WithMetadata(event.Metadata()),
+		eh.WithPosition(position(event)),
+	}, options...)
+
+	return eh.NewEvent(
+		event.EventType(),
+		data,
+		event.Timestamp(),
+		options...,
 	), nil
 }
+
+// copySnapshot duplicates a snapshot.
+func copySnapshot(ctx context.Context, snapshot eh.Snapshot) (*eh.Snapshot, error) {
+	// Copy state if there is any.
+	if snapshot.State != nil {
+		state, err := eh.CreateSnapshotData(snapshot.AggregateType)
+		if err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotCreateSnapshot,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		copier.Copy(state, snapshot.State)
+		snapshot.State = state
+	}
+
+	return &snapshot, nil
+}
@@ -33,4 +33,21 @@ func TestEventStore(t *testing.T) {
 	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
 	eventstore.AcceptanceTest(t, ctx, store)
 	eventstore.MaintainerAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadFromAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadAllAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadAllAcceptanceTest(t, ctx, store)
+	eventstore.SnapshotAcceptanceTest(t, context.Background(), store)
+}
+
+func TestEventStoreOutbox(t *testing.T) {
+	store := NewEventStore(WithOutbox())
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	// Run the actual test suite, both for default and custom namespace.
+	eventstore.AcceptanceTest(t, context.Background(), store)
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	eventstore.OutboxAcceptanceTest(t, context.Background(), store)
+	eventstore.OutboxAcceptanceTest(t, ctx, store)
 }
@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -32,6 +33,7 @@ import (
 	_ "github.com/looplab/eventhorizon/codec/bson"
 
 	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mongoutils"
 )
 
 var (
@@ -49,13 +51,28 @@ var (
 	ErrCouldNotLoadAggregate = errors.New("could not load aggregate")
 	// ErrCouldNotSaveAggregate is when an aggregate could not be saved.
 	ErrCouldNotSaveAggregate = errors.New("could not save aggregate")
+	// ErrCouldNotCreateIndex is when the position index could not be created.
+	ErrCouldNotCreateIndex = errors.New("could not create index")
+	// ErrCouldNotLoadSnapshot is when a snapshot could not be loaded.
+	ErrCouldNotLoadSnapshot = errors.New("could not load snapshot")
+	// ErrCouldNotMarshalSnapshot is when a snapshot could not be marshaled into BSON.
+	ErrCouldNotMarshalSnapshot = errors.New("could not marshal snapshot")
+	// ErrCouldNotUnmarshalSnapshot is when a snapshot could not be unmarshaled into a concrete type.
+	ErrCouldNotUnmarshalSnapshot = errors.New("could not unmarshal snapshot")
+	// ErrCouldNotSaveSnapshot is when a snapshot could not be saved.
+	ErrCouldNotSaveSnapshot = errors.New("could not save snapshot")
 )
 
 // EventStore implements an EventStore for MongoDB.
 type EventStore struct {
-	client   *mongo.Client
-	dbPrefix string
-	dbName   func(ctx context.Context) string
+	client    *mongo.Client
+	dbPrefix  string
+	dbName    func(ctx context.Context) string
+	useOutbox bool
+
+	// The DBs where the position index has been created.
+	indexes   map[string]bool
+	indexesMu sync.Mutex
 }
 
 // NewEventStore creates a new EventStore with a MongoDB URI: `mongodb://hostname`.
@@ -81,6 +98,7 @@ func NewEventStoreWithClient(client *mongo.Client, dbPrefix string, options ...O
 	s := &EventStore{
 		client:   client,
 		dbPrefix: dbPrefix,
+		indexes:  map[string]bool{},
 	}
 
 	// Use the a prefix and namespcae from the context for DB name.
@@ -120,6 +138,15 @@ func WithDBName(dbName func(context.Context) string) Option {
 	}
 }
 
+// WithOutbox records all saved events as unpublished in the aggregate
+// document, to be published by an outbox relay. See eventhorizon.EventStoreOutbox.
+func WithOutbox() Option {
+	return func(s *EventStore) error {
+		s.useOutbox = true
+		return nil
+	}
+}
+
 // Save implements the Save method of the eventhorizon.EventStore interface.
 func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersion int) error {
 	if len(events) == 0 {
@@ -158,6 +185,35 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 		dbEvents[i] = *e
 	}
 
+	if err := s.ensureIndex(ctx); err != nil {
+		return err
+	}
+
+	// Allocate the global positions for the events. A failed save will leave a
+	// gap in the positions, but they will always be increasing.
+	last, err := s.allocatePositions(ctx, len(dbEvents))
+	if err != nil {
+		return err
+	}
+	for i := range dbEvents {
+		dbEvents[i].Position = last - int64(len(dbEvents)-i-1)
+	}
+
+	// Record the events as unpublished in the same document, which makes it
+	// a single atomic write.
+	var unpublished []outboxRecord
+	if s.useOutbox {
+		ctxVals := eh.MarshalContext(ctx)
+		now := time.Now()
+		for _, e := range dbEvents {
+			unpublished = append(unpublished, outboxRecord{
+				Version: e.Version,
+				Context: ctxVals,
+				SavedAt: now,
+			})
+		}
+	}
+
 	c := s.client.Database(s.dbName(ctx)).Collection("events")
 
 	// Either insert a new aggregate or append to an existing.
@@ -166,9 +222,16 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 			AggregateID: aggregateID,
 			Version:     len(dbEvents),
 			Events:      dbEvents,
+			Outbox:      unpublished,
 		}
 
-		if _, err := c.InsertOne(ctx, aggregate); err != nil {
+		if _, err := c.InsertOne(ctx, aggregate); mongoutils.IsDuplicateKeyError(err) {
+			return eh.EventStoreError{
+				Err:       eh.ErrEventConflictFromOtherSave,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		} else if err != nil {
 			return eh.EventStoreError{
 				Err:       ErrCouldNotSaveAggregate,
 				BaseErr:   err,
@@ -179,13 +242,17 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 		// Increment aggregate version on insert of new event record, and
 		// only insert if version of aggregate is matching (ie not changed
 		// since loading the aggregate).
+		push := bson.M{"events": bson.M{"$each": dbEvents}}
+		if len(unpublished) > 0 {
+			push["outbox"] = bson.M{"$each": unpublished}
+		}
 		if r, err := c.UpdateOne(ctx,
 			bson.M{
 				"_id":     aggregateID,
 				"version": originalVersion,
 			},
 			bson.M{
-				"$push": bson.M{"events": bson.M{"$each": dbEvents}},
+				"$push": push,
 				"$inc":  bson.M{"version": len(dbEvents)},
 			},
 		); err != nil {
@@ -196,7 +263,7 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 			}
 		} else if r.MatchedCount == 0 {
 			return eh.EventStoreError{
-				Err:       ErrCouldNotSaveAggregate,
+				Err:       eh.ErrEventConflictFromOtherSave,
 				BaseErr:   fmt.Errorf("invalid original version %d", originalVersion),
 				Namespace: eh.NamespaceFromContext(ctx),
 			}
@@ -208,58 +275,169 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 
 // Load implements the Load method of the eventhorizon.EventStore interface.
 func (s *EventStore) Load(ctx context.Context, id uuid.UUID) ([]eh.Event, error) {
+	return s.load(ctx, id)
+}
+
+// LoadFrom implements the LoadFrom method of the eventhorizon.EventStoreVersionLoader interface.
+func (s *EventStore) LoadFrom(ctx context.Context, id uuid.UUID, version int) ([]eh.Event, error) {
+	// Only return the matching events from the DB, requires MongoDB 4.4.
+	return s.load(ctx, id, mongoOptions.FindOne().SetProjection(bson.M{
+		"events": bson.M{"$filter": bson.M{
+			"input": "$events",
+			"as":    "e",
+			"cond":  bson.M{"$gt": bson.A{"$$e.version", version}},
+		}},
+	}))
+}
+
+func (s *EventStore) load(ctx context.Context, id uuid.UUID, opts ...*mongoOptions.FindOneOptions) ([]eh.Event, error) {
 	c := s.client.Database(s.dbName(ctx)).Collection("events")
 
 	var aggregate aggregateRecord
-	err := c.FindOne(ctx, bson.M{"_id": id}).Decode(&aggregate)
+	err := c.FindOne(ctx, bson.M{"_id": id}, opts...).Decode(&aggregate)
 	if err == mongo.ErrNoDocuments {
 		return []eh.Event{}, nil
 	} else if err != nil {
 		return nil, eh.EventStoreError{
-			Err:       err,
+			Err:       ErrCouldNotLoadAggregate,
+			BaseErr:   err,
 			Namespace: eh.NamespaceFromContext(ctx),
 		}
 	}
 
 	events := make([]eh.Event, len(aggregate.Events))
 	for i, e := range aggregate.Events {
-		// Create an event of the correct type and decode from raw BSON.
-		if len(e.RawData) > 0 {
-			var err error
-			if e.data, err = eh.CreateEventData(e.EventType); err != nil {
-				return nil, eh.EventStoreError{
-					Err:       ErrCouldNotUnmarshalEvent,
-					BaseErr:   err,
-					Namespace: eh.NamespaceFromContext(ctx),
-				}
-			}
-			if err := bson.Unmarshal(e.RawData, e.data); err != nil {
-				return nil, eh.EventStoreError{
-					Err:       ErrCouldNotUnmarshalEvent,
-					BaseErr:   err,
-					Namespace: eh.NamespaceFromContext(ctx),
-				}
-			}
-			e.RawData = nil
-		}
-
-		event := eh.NewEvent(
-			e.EventType,
-			e.data,
-			e.Timestamp,
-			eh.ForAggregate(
-				e.AggregateType,
-				e.AggregateID,
-				e.Version,
-			),
-			eh.WithMetadata(e.Metadata),
-		)
+		event, err := e.event(ctx)
+		if err != nil {
+			return nil, err
+		}
 		events[i] = event
 	}
 
 	return events, nil
 }
 
+// LoadAll implements the LoadAll method of the eventhorizon.EventStorePositionLoader
+// interface. Positions are allocated before the events are written, which means
+// that position order is not commit order: concurrent saves can become visible
+// out of order, and failed saves leave permanent gaps. Consumers following the
+// head of the store must wait for gaps to settle before moving past them, as
+// done by eventhandler/catchup with eventhorizon.PositionGaps.
+//
+// Each aggregate document with events after the position is read, with only
+// those events kept before sorting. The sort can use temporary files on the
+// server for large results.
+func (s *EventStore) LoadAll(ctx context.Context, fromPosition int64, limit int) ([]eh.Event, error) {
+	if err := s.ensureIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	c := s.client.Database(s.dbName(ctx)).Collection("events")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"events.position": bson.M{"$gt": fromPosition}}}},
+		{{Key: "$project", Value: bson.M{"events": bson.M{"$filter": bson.M{
+			"input": "$events",
+			"as":    "e",
+			"cond":  bson.M{"$gt": bson.A{"$$e.position", fromPosition}},
+		}}}}},
+		{{Key: "$unwind", Value: "$events"}},
+		{{Key: "$sort", Value: bson.M{"events.position": 1}}},
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$events"}}})
+
+	cursor, err := c.Aggregate(ctx, pipeline, mongoOptions.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, eh.EventStoreError{
+			Err:       ErrCouldNotLoadAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	defer cursor.Close(ctx)
+
+	events := []eh.Event{}
+	for cursor.Next(ctx) {
+		var e evt
+		if err := cursor.Decode(&e); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotUnmarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		event, err := e.event(ctx)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, eh.EventStoreError{
+			Err:       ErrCouldNotLoadAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return events, nil
+}
+
+// allocatePositions reserves a number of global positions in the namespace,
+// returning the last reserved position.
+func (s *EventStore) allocatePositions(ctx context.Context, n int) (int64, error) {
+	c := s.client.Database(s.dbName(ctx)).Collection("positions")
+
+	var counter struct {
+		Position int64 `bson:"position"`
+	}
+	if err := c.FindOneAndUpdate(ctx,
+		bson.M{"_id": "events"},
+		bson.M{"$inc": bson.M{"position": n}},
+		mongoOptions.FindOneAndUpdate().
+			SetUpsert(true).
+			SetReturnDocument(mongoOptions.After),
+	).Decode(&counter); err != nil {
+		return 0, eh.EventStoreError{
+			Err:       ErrCouldNotSaveAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return counter.Position, nil
+}
+
+// ensureIndex creates the index of the event positions used by LoadAll, once
+// per DB.
+func (s *EventStore) ensureIndex(ctx context.Context) error {
+	dbName := s.dbName(ctx)
+
+	s.indexesMu.Lock()
+	defer s.indexesMu.Unlock()
+
+	if s.indexes[dbName] {
+		return nil
+	}
+
+	c := s.client.Database(dbName).Collection("events")
+	if _, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "events.position", Value: 1}},
+	}); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotCreateIndex,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	s.indexes[dbName] = true
+
+	return nil
+}
+
 // Replace implements the Replace method of the eventhorizon.EventStore interface.
 func (s *EventStore) Replace(ctx context.Context, event eh.Event) error {
 	c := s.client.Database(s.dbName(ctx)).Collection("events")
@@ -270,7 +448,8 @@ func (s *EventStore) Replace(ctx context.Context, event eh.Event) error {
 		return eh.ErrAggregateNotFound
 	} else if err != nil {
 		return eh.EventStoreError{
-			Err:       err,
+			Err:       ErrCouldNotSaveAggregate,
+			BaseErr:   err,
 			Namespace: eh.NamespaceFromContext(ctx),
 		}
 	}
@@ -281,15 +460,27 @@ func (s *EventStore) Replace(ctx context.Context, event eh.Event) error {
 		return err
 	}
 
+	// Replace all fields except the position, which is kept.
+	set := bson.M{
+		"events.$.event_type":     e.EventType,
+		"events.$.timestamp":      e.Timestamp,
+		"events.$.aggregate_type": e.AggregateType,
+		"events.$.metadata":       e.Metadata,
+	}
+	update := bson.M{"$set": set}
+	if len(e.RawData) > 0 {
+		set["events.$.data"] = e.RawData
+	} else {
+		update["$unset"] = bson.M{"events.$.data": ""}
+	}
+
 	// Find and replace the event.
 	if r, err := c.UpdateOne(ctx,
 		bson.M{
 			"_id":            event.AggregateID(),
 			"events.version": event.Version(),
 		},
-		bson.M{
-			"$set": bson.M{"events.$": *e},
-		},
+		update,
 	); err != nil {
 		return eh.EventStoreError{
 			Err:       ErrCouldNotSaveAggregate,
@@ -327,10 +518,179 @@ func (s *EventStore) RenameEvent(ctx context.Context, from, to eh.EventType) err
 	return nil
 }
 
+// LoadUnpublished implements the LoadUnpublished method of the eventhorizon.EventStoreOutbox interface.
+func (s *EventStore) LoadUnpublished(ctx context.Context, savedBefore time.Time, limit int) ([]eh.UnpublishedEvent, error) {
+	c := s.client.Database(s.dbName(ctx)).Collection("events")
+
+	// Find the aggregates with the oldest unpublished events first.
+	cursor, err := c.Find(ctx,
+		bson.M{"outbox": bson.M{"$elemMatch": bson.M{"saved_at": bson.M{"$lt": savedBefore}}}},
+		mongoOptions.Find().SetSort(bson.M{"outbox.saved_at": 1}),
+	)
+	if err != nil {
+		return nil, eh.EventStoreError{
+			Err:       ErrCouldNotLoadAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	defer cursor.Close(ctx)
+
+	events := []eh.UnpublishedEvent{}
+	for cursor.Next(ctx) && (limit == 0 || len(events) < limit) {
+		var aggregate aggregateRecord
+		if err := cursor.Decode(&aggregate); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotLoadAggregate,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		for _, o := range aggregate.Outbox {
+			if limit > 0 && len(events) == limit {
+				break
+			}
+			if !o.SavedAt.Before(savedBefore) || o.Version < 1 || o.Version > len(aggregate.Events) {
+				continue
+			}
+
+			event, err := aggregate.Events[o.Version-1].event(ctx)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, eh.UnpublishedEvent{
+				Ctx:   eh.UnmarshalContext(ctx, o.Context),
+				Event: event,
+			})
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, eh.EventStoreError{
+			Err:       ErrCouldNotLoadAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return events, nil
+}
+
+// MarkPublished implements the MarkPublished method of the eventhorizon.EventStoreOutbox interface.
+func (s *EventStore) MarkPublished(ctx context.Context, event eh.Event) error {
+	c := s.client.Database(s.dbName(ctx)).Collection("events")
+
+	if _, err := c.UpdateOne(ctx,
+		bson.M{"_id": event.AggregateID()},
+		bson.M{"$pull": bson.M{"outbox": bson.M{"version": event.Version()}}},
+	); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotSaveAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// LoadSnapshot implements the LoadSnapshot method of the eventhorizon.SnapshotStore interface.
+func (s *EventStore) LoadSnapshot(ctx context.Context, id uuid.UUID) (*eh.Snapshot, error) {
+	c := s.client.Database(s.dbName(ctx)).Collection("events")
+
+	var aggregate aggregateRecord
+	err := c.FindOne(ctx, bson.M{"_id": id},
+		mongoOptions.FindOne().SetProjection(bson.M{"snapshot": 1}),
+	).Decode(&aggregate)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, eh.EventStoreError{
+			Err:       ErrCouldNotLoadSnapshot,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	if aggregate.Snapshot == nil {
+		return nil, nil
+	}
+	sn := aggregate.Snapshot
+
+	snapshot := &eh.Snapshot{
+		Version:       sn.Version,
+		AggregateType: sn.AggregateType,
+		Timestamp:     sn.Timestamp,
+	}
+
+	// Create the state of the correct type and decode from raw BSON.
+	if len(sn.RawState) > 0 {
+		if snapshot.State, err = eh.CreateSnapshotData(sn.AggregateType); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotUnmarshalSnapshot,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if err := bson.Unmarshal(sn.RawState, snapshot.State); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotUnmarshalSnapshot,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	return snapshot, nil
+}
+
+// SaveSnapshot implements the SaveSnapshot method of the eventhorizon.SnapshotStore interface.
+func (s *EventStore) SaveSnapshot(ctx context.Context, id uuid.UUID, snapshot eh.Snapshot) error {
+	c := s.client.Database(s.dbName(ctx)).Collection("events")
+
+	sn := snapshotRecord{
+		AggregateType: snapshot.AggregateType,
+		Version:       snapshot.Version,
+		Timestamp:     snapshot.Timestamp,
+	}
+
+	// Marshal the state if there is any.
+	if snapshot.State != nil {
+		var err error
+		if sn.RawState, err = bson.Marshal(snapshot.State); err != nil {
+			return eh.EventStoreError{
+				Err:       ErrCouldNotMarshalSnapshot,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	if r, err := c.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"snapshot": sn}},
+	); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotSaveSnapshot,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	} else if r.MatchedCount == 0 {
+		return eh.ErrAggregateNotFound
+	}
+
+	return nil
+}
+
 // Clear clears the event storage.
 func (s *EventStore) Clear(ctx context.Context) error {
 	c := s.client.Database(s.dbName(ctx)).Collection("events")
 
+	// The index is dropped with the collection.
+	s.indexesMu.Lock()
+	delete(s.indexes, s.dbName(ctx))
+	s.indexesMu.Unlock()
+
 	if err := c.Drop(ctx); err != nil {
 		return eh.EventStoreError{
 			Err:       ErrCouldNotClearDB,
@@ -338,6 +698,16 @@ func (s *EventStore) Clear(ctx context.Context) error {
 			Namespace: eh.NamespaceFromContext(ctx),
 		}
 	}
+
+	p := s.client.Database(s.dbName(ctx)).Collection("positions")
+
+	if err := p.Drop(ctx); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotClearDB,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
 	return nil
 }
 
@@ -348,11 +718,27 @@ func (s *EventStore) Close(ctx context.Context) {
 
 // aggregateRecord is the Database representation of an aggregate.
 type aggregateRecord struct {
-	AggregateID uuid.UUID `bson:"_id"`
-	Version     int       `bson:"version"`
-	Events      []evt     `bson:"events"`
+	AggregateID uuid.UUID       `bson:"_id"`
+	Version     int             `bson:"version"`
+	Events      []evt           `bson:"events"`
+	Snapshot    *snapshotRecord `bson:"snapshot,omitempty"`
+	Outbox      []outboxRecord  `bson:"outbox,omitempty"`
 	// Type        string        `bson:"type"`
-	// Snapshot    bson.Raw      `bson:"snapshot"`
+}
+
+// snapshotRecord is the Database representation of an aggregate snapshot.
+type snapshotRecord struct {
+	AggregateType eh.AggregateType `bson:"aggregate_type"`
+	Version       int              `bson:"version"`
+	Timestamp     time.Time        `bson:"timestamp"`
+	RawState      bson.Raw         `bson:"state,omitempty"`
+}
+
+// outboxRecord is the Database representation of an unpublished event.
+type outboxRecord struct {
+	Version int                    `bson:"version"`
+	Context map[string]interface{} `bson:"context"`
+	SavedAt time.Time              `bson:"saved_at"`
 }
 
 // evt is the internal event record for the MongoDB event store used
@@ -366,6 +752,7 @@ type evt struct {
 	AggregateID   uuid.UUID              `bson:"_id"`
 	Version       int                    `bson:"version"`
 	Metadata      map[string]interface{} `bson:"metadata"`
+	Position      int64                  `bson:"position"`
 }
 
 // newEvt returns a new evt for an event.
@@ -394,3 +781,39 @@ func newEvt(ctx context.Context, event eh.Event) (*evt, error) {
 
 	return e, nil
 }
+
+// event returns an event from an evt, decoding the data to a concrete type.
+func (e evt) event(ctx context.Context) (eh.Event, error) {
+	// Create an event of the correct type and decode from raw BSON.
+	if len(e.RawData) > 0 {
+		var err error
+		if e.data, err = eh.CreateEventData(e.EventType); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotUnmarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if err := bson.Unmarshal(e.RawData, e.data); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotUnmarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		e.RawData = nil
+	}
+
+	return eh.NewEvent(
+		e.EventType,
+		e.data,
+		e.Timestamp,
+		eh.ForAggregate(
+			e.AggregateType,
+			e.AggregateID,
+			e.Version,
+		),
+		eh.WithMetadata(e.Metadata),
+		eh.WithPosition(e.Position),
+	), nil
+}
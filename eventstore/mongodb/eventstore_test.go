@@ -59,4 +59,46 @@ func TestEventStoreIntegration(t *testing.T) {
 	eventstore.AcceptanceTest(t, context.Background(), store)
 	eventstore.AcceptanceTest(t, customNamespaceCtx, store)
 	eventstore.MaintainerAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadFromAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadAllAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadAllAcceptanceTest(t, customNamespaceCtx, store)
+	eventstore.SnapshotAcceptanceTest(t, context.Background(), store)
+}
+
+func TestEventStoreOutboxIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Use MongoDB in Docker with fallback to localhost.
+	addr := os.Getenv("MONGODB_ADDR")
+	if addr == "" {
+		addr = "localhost:27017"
+	}
+	url := "mongodb://" + addr
+
+	store, err := NewEventStore(url, "test_outbox", WithOutbox())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	customNamespaceCtx := eh.NewContextWithNamespace(context.Background(), "ns")
+
+	defer store.Close(context.Background())
+	defer func() {
+		if err = store.Clear(context.Background()); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		if err = store.Clear(customNamespaceCtx); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	// Run the actual test suite, both for default and custom namespace.
+	eventstore.AcceptanceTest(t, context.Background(), store)
+	eventstore.OutboxAcceptanceTest(t, context.Background(), store)
+	eventstore.OutboxAcceptanceTest(t, customNamespaceCtx, store)
 }
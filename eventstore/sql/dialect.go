@@ -0,0 +1,103 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect is the SQL dialect specific parts of the event store, making it
+// possible to use it with different databases.
+type Dialect interface {
+	// Placeholder returns the bind parameter for the n:th argument, starting at 1.
+	Placeholder(n int) string
+
+	// CreateTable returns the statement used to create an event table, if it
+	// does not exist. The table must have the columns position (auto
+	// incrementing primary key), aggregate_id, aggregate_type, version,
+	// event_type, timestamp, data and metadata, and a unique constraint on
+	// (aggregate_id, version).
+	CreateTable(table string) string
+
+	// IsUniqueViolation returns true if the error is caused by a unique
+	// constraint, used to detect concurrent saves of the same aggregate.
+	IsUniqueViolation(error) bool
+}
+
+// Postgres is the dialect for PostgreSQL, using for example
+// github.com/lib/pq or github.com/jackc/pgx as driver.
+var Postgres Dialect = postgres{}
+
+type postgres struct{}
+
+// Placeholder implements the Placeholder method of the Dialect interface.
+func (postgres) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// CreateTable implements the CreateTable method of the Dialect interface.
+func (postgres) CreateTable(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		position       BIGSERIAL PRIMARY KEY,
+		aggregate_id   UUID NOT NULL,
+		aggregate_type TEXT NOT NULL,
+		version        INTEGER NOT NULL,
+		event_type     TEXT NOT NULL,
+		timestamp      TIMESTAMPTZ NOT NULL,
+		data           BYTEA,
+		metadata       BYTEA,
+		UNIQUE (aggregate_id, version)
+	)`
+}
+
+// IsUniqueViolation implements the IsUniqueViolation method of the Dialect interface.
+func (postgres) IsUniqueViolation(err error) bool {
+	if e, ok := err.(interface{ SQLState() string }); ok {
+		return e.SQLState() == "23505"
+	}
+	return strings.Contains(err.Error(), "duplicate key value violates unique constraint")
+}
+
+// SQLite is the dialect for SQLite, using for example
+// github.com/mattn/go-sqlite3 as driver.
+var SQLite Dialect = sqlite{}
+
+type sqlite struct{}
+
+// Placeholder implements the Placeholder method of the Dialect interface.
+func (sqlite) Placeholder(n int) string {
+	return "?"
+}
+
+// CreateTable implements the CreateTable method of the Dialect interface.
+func (sqlite) CreateTable(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		position       INTEGER PRIMARY KEY AUTOINCREMENT,
+		aggregate_id   TEXT NOT NULL,
+		aggregate_type TEXT NOT NULL,
+		version        INTEGER NOT NULL,
+		event_type     TEXT NOT NULL,
+		timestamp      TIMESTAMP NOT NULL,
+		data           BLOB,
+		metadata       BLOB,
+		UNIQUE (aggregate_id, version)
+	)`
+}
+
+// IsUniqueViolation implements the IsUniqueViolation method of the Dialect interface.
+func (sqlite) IsUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
@@ -0,0 +1,595 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+var (
+	// ErrCouldNotDialDB is when the database could not be dialed.
+	ErrCouldNotDialDB = errors.New("could not dial database")
+	// ErrNoDB is when no database is set.
+	ErrNoDB = errors.New("no database")
+	// ErrNoDialect is when no SQL dialect is set.
+	ErrNoDialect = errors.New("no dialect")
+	// ErrCouldNotCreateTable is when the event table could not be created.
+	ErrCouldNotCreateTable = errors.New("could not create table")
+	// ErrCouldNotClearDB is when the database could not be cleared.
+	ErrCouldNotClearDB = errors.New("could not clear database")
+	// ErrCouldNotMarshalEvent is when an event could not be marshaled into JSON.
+	ErrCouldNotMarshalEvent = errors.New("could not marshal event")
+	// ErrCouldNotUnmarshalEvent is when an event could not be unmarshaled into a concrete type.
+	ErrCouldNotUnmarshalEvent = errors.New("could not unmarshal event")
+	// ErrCouldNotLoadAggregate is when an aggregate could not be loaded.
+	ErrCouldNotLoadAggregate = errors.New("could not load aggregate")
+	// ErrCouldNotSaveAggregate is when an aggregate could not be saved.
+	ErrCouldNotSaveAggregate = errors.New("could not save aggregate")
+)
+
+// EventStore implements an EventStore for SQL databases using database/sql.
+// Each namespace is stored in its own table.
+type EventStore struct {
+	db          *sql.DB
+	dialect     Dialect
+	tablePrefix string
+	tableName   func(ctx context.Context) string
+	tables      map[string]bool
+	tablesMu    sync.Mutex
+}
+
+// NewEventStore creates a new EventStore by opening a database with a driver
+// and data source name, for example "postgres" and "postgres://hostname/db".
+// The driver must be registered by importing it.
+func NewEventStore(driverName, dataSourceName string, dialect Dialect, tablePrefix string, options ...Option) (*EventStore, error) {
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, ErrCouldNotDialDB
+	}
+
+	return NewEventStoreWithDB(db, dialect, tablePrefix, options...)
+}
+
+// NewEventStoreWithDB creates a new EventStore with a database.
+func NewEventStoreWithDB(db *sql.DB, dialect Dialect, tablePrefix string, options ...Option) (*EventStore, error) {
+	if db == nil {
+		return nil, ErrNoDB
+	}
+	if dialect == nil {
+		return nil, ErrNoDialect
+	}
+
+	s := &EventStore{
+		db:          db,
+		dialect:     dialect,
+		tablePrefix: tablePrefix,
+		tables:      map[string]bool{},
+	}
+
+	// Use the a prefix and namespace from the context for table name.
+	s.tableName = func(ctx context.Context) string {
+		ns := eh.NamespaceFromContext(ctx)
+		return tablePrefix + "_" + ns
+	}
+
+	for _, option := range options {
+		err := option(s)
+		if err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*EventStore) error
+
+// WithPrefixAsTableName uses only the prefix as table name, without namespace support.
+func WithPrefixAsTableName() Option {
+	return func(s *EventStore) error {
+		s.tableName = func(context.Context) string {
+			return s.tablePrefix
+		}
+		return nil
+	}
+}
+
+// WithTableName uses a custom table name function.
+func WithTableName(tableName func(context.Context) string) Option {
+	return func(s *EventStore) error {
+		s.tableName = tableName
+		return nil
+	}
+}
+
+// Save implements the Save method of the eventhorizon.EventStore interface.
+func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersion int) error {
+	if len(events) == 0 {
+		return eh.EventStoreError{
+			Err:       eh.ErrNoEventsToAppend,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Build all event records, with incrementing versions starting from the
+	// original aggregate version.
+	dbEvents := make([]*evt, len(events))
+	aggregateID := events[0].AggregateID()
+	for i, event := range events {
+		// Only accept events belonging to the same aggregate.
+		if event.AggregateID() != aggregateID {
+			return eh.EventStoreError{
+				Err:       eh.ErrInvalidEvent,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		// Only accept events that apply to the correct aggregate version.
+		if event.Version() != originalVersion+i+1 {
+			return eh.EventStoreError{
+				Err:       eh.ErrIncorrectEventVersion,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		// Create the event record for the DB.
+		e, err := newEvt(ctx, event)
+		if err != nil {
+			return err
+		}
+		dbEvents[i] = e
+	}
+
+	table, err := s.table(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotSaveAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	defer tx.Rollback()
+
+	// Only insert if version of aggregate is matching (ie not changed since
+	// loading the aggregate). Concurrent inserts of the same version are
+	// stopped by the unique constraint on (aggregate_id, version).
+	var version int
+	if err := tx.QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(version), 0) FROM "+table+
+			" WHERE aggregate_id = "+s.dialect.Placeholder(1),
+		aggregateID.String(),
+	).Scan(&version); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotSaveAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	if version != originalVersion {
+		return eh.EventStoreError{
+			Err:       eh.ErrEventConflictFromOtherSave,
+			BaseErr:   fmt.Errorf("invalid original version %d", originalVersion),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	insert := "INSERT INTO " + table +
+		" (aggregate_id, aggregate_type, version, event_type, timestamp, data, metadata)" +
+		" VALUES (" + s.placeholders(1, 7) + ")"
+	for _, e := range dbEvents {
+		if _, err := tx.ExecContext(ctx, insert,
+			e.AggregateID.String(),
+			string(e.AggregateType),
+			e.Version,
+			string(e.EventType),
+			e.Timestamp,
+			e.RawData,
+			e.RawMetadata,
+		); err != nil {
+			if s.dialect.IsUniqueViolation(err) {
+				return eh.EventStoreError{
+					Err:       eh.ErrEventConflictFromOtherSave,
+					BaseErr:   fmt.Errorf("invalid original version %d: %w", originalVersion, err),
+					Namespace: eh.NamespaceFromContext(ctx),
+				}
+			}
+			return eh.EventStoreError{
+				Err:       ErrCouldNotSaveAggregate,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotSaveAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// Load implements the Load method of the eventhorizon.EventStore interface.
+func (s *EventStore) Load(ctx context.Context, id uuid.UUID) ([]eh.Event, error) {
+	return s.LoadFrom(ctx, id, 0)
+}
+
+// LoadFrom implements the LoadFrom method of the eventhorizon.EventStoreVersionLoader interface.
+func (s *EventStore) LoadFrom(ctx context.Context, id uuid.UUID, version int) ([]eh.Event, error) {
+	table, err := s.table(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.query(ctx,
+		"SELECT "+columns+" FROM "+table+
+			" WHERE aggregate_id = "+s.dialect.Placeholder(1)+
+			" AND version > "+s.dialect.Placeholder(2)+
+			" ORDER BY version",
+		id.String(), version,
+	)
+}
+
+// LoadAll implements the LoadAll method of the eventhorizon.EventStorePositionLoader
+// interface. Positions are allocated by the database when inserting, concurrent
+// saves can therefore become visible out of order, and rolled back saves leave
+// gaps. Consumers following the head of the store must wait for gaps to settle
+// before moving past them, as done by eventhandler/catchup.
+func (s *EventStore) LoadAll(ctx context.Context, fromPosition int64, limit int) ([]eh.Event, error) {
+	table, err := s.table(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := "SELECT " + columns + " FROM " + table +
+		" WHERE position > " + s.dialect.Placeholder(1) +
+		" ORDER BY position"
+	args := []interface{}{fromPosition}
+	if limit > 0 {
+		q += " LIMIT " + s.dialect.Placeholder(2)
+		args = append(args, limit)
+	}
+
+	return s.query(ctx, q, args...)
+}
+
+// Replace implements the Replace method of the eventhorizon.EventStore interface.
+func (s *EventStore) Replace(ctx context.Context, event eh.Event) error {
+	table, err := s.table(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Create the event record for the Database.
+	e, err := newEvt(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	// Find and replace the event, keeping the position.
+	r, err := s.db.ExecContext(ctx,
+		"UPDATE "+table+" SET"+
+			" aggregate_type = "+s.dialect.Placeholder(1)+
+			", event_type = "+s.dialect.Placeholder(2)+
+			", timestamp = "+s.dialect.Placeholder(3)+
+			", data = "+s.dialect.Placeholder(4)+
+			", metadata = "+s.dialect.Placeholder(5)+
+			" WHERE aggregate_id = "+s.dialect.Placeholder(6)+
+			" AND version = "+s.dialect.Placeholder(7),
+		string(e.AggregateType),
+		string(e.EventType),
+		e.Timestamp,
+		e.RawData,
+		e.RawMetadata,
+		e.AggregateID.String(),
+		e.Version,
+	)
+	if err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotSaveAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	if n, err := r.RowsAffected(); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotSaveAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	} else if n > 0 {
+		return nil
+	}
+
+	// Check if it was the aggregate or the event that was not found.
+	var count int
+	if err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM "+table+" WHERE aggregate_id = "+s.dialect.Placeholder(1),
+		e.AggregateID.String(),
+	).Scan(&count); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotLoadAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	} else if count == 0 {
+		return eh.ErrAggregateNotFound
+	}
+
+	return eh.ErrInvalidEvent
+}
+
+// RenameEvent implements the RenameEvent method of the eventhorizon.EventStore interface.
+func (s *EventStore) RenameEvent(ctx context.Context, from, to eh.EventType) error {
+	table, err := s.table(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx,
+		"UPDATE "+table+" SET event_type = "+s.dialect.Placeholder(1)+
+			" WHERE event_type = "+s.dialect.Placeholder(2),
+		string(to), string(from),
+	); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotSaveAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// Clear clears the event storage.
+func (s *EventStore) Clear(ctx context.Context) error {
+	table := quoteIdentifier(s.tableName(ctx))
+
+	s.tablesMu.Lock()
+	defer s.tablesMu.Unlock()
+
+	if _, err := s.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+		return eh.EventStoreError{
+			Err:       ErrCouldNotClearDB,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	delete(s.tables, table)
+
+	return nil
+}
+
+// Close closes the database.
+func (s *EventStore) Close() error {
+	return s.db.Close()
+}
+
+// table returns the quoted table name for the namespace, creating the table
+// on first use.
+func (s *EventStore) table(ctx context.Context) (string, error) {
+	table := quoteIdentifier(s.tableName(ctx))
+
+	s.tablesMu.Lock()
+	defer s.tablesMu.Unlock()
+
+	if s.tables[table] {
+		return table, nil
+	}
+
+	if _, err := s.db.ExecContext(ctx, s.dialect.CreateTable(table)); err != nil {
+		return "", eh.EventStoreError{
+			Err:       ErrCouldNotCreateTable,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	s.tables[table] = true
+
+	return table, nil
+}
+
+// query loads events using a query selecting the event columns.
+func (s *EventStore) query(ctx context.Context, query string, args ...interface{}) ([]eh.Event, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, eh.EventStoreError{
+			Err:       ErrCouldNotLoadAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	defer rows.Close()
+
+	events := []eh.Event{}
+	for rows.Next() {
+		var e evt
+		var aggregateID, aggregateType, eventType string
+		if err := rows.Scan(
+			&e.Position,
+			&aggregateID,
+			&aggregateType,
+			&e.Version,
+			&eventType,
+			&e.Timestamp,
+			&e.RawData,
+			&e.RawMetadata,
+		); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotLoadAggregate,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if e.AggregateID, err = uuid.Parse(aggregateID); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotUnmarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		e.AggregateType = eh.AggregateType(aggregateType)
+		e.EventType = eh.EventType(eventType)
+
+		event, err := e.event(ctx)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, eh.EventStoreError{
+			Err:       ErrCouldNotLoadAggregate,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return events, nil
+}
+
+// placeholders returns a comma separated list of placeholders.
+func (s *EventStore) placeholders(from, to int) string {
+	p := make([]string, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		p = append(p, s.dialect.Placeholder(i))
+	}
+	return strings.Join(p, ", ")
+}
+
+// quoteIdentifier quotes a table name for use in queries.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// columns is the columns of an event record, in the order scanned by query.
+const columns = "position, aggregate_id, aggregate_type, version, event_type, timestamp, data, metadata"
+
+// evt is the internal event record for the SQL event store used
+// to save and load events from the DB.
+type evt struct {
+	Position      int64
+	EventType     eh.EventType
+	RawData       []byte
+	Timestamp     time.Time
+	AggregateType eh.AggregateType
+	AggregateID   uuid.UUID
+	Version       int
+	RawMetadata   []byte
+}
+
+// newEvt returns a new evt for an event.
+func newEvt(ctx context.Context, event eh.Event) (*evt, error) {
+	e := &evt{
+		EventType:     event.EventType(),
+		Timestamp:     event.Timestamp(),
+		AggregateType: event.AggregateType(),
+		AggregateID:   event.AggregateID(),
+		Version:       event.Version(),
+	}
+
+	// Marshal event data if there is any.
+	if event.Data() != nil {
+		var err error
+		if e.RawData, err = json.Marshal(event.Data()); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotMarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	// Marshal metadata if there is any.
+	if event.Metadata() != nil {
+		var err error
+		if e.RawMetadata, err = json.Marshal(event.Metadata()); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotMarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// event returns an event from an evt, decoding the data to a concrete type.
+func (e evt) event(ctx context.Context) (eh.Event, error) {
+	// Create an event of the correct type and decode from raw JSON.
+	var data eh.EventData
+	if len(e.RawData) > 0 {
+		var err error
+		if data, err = eh.CreateEventData(e.EventType); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotUnmarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if err := json.Unmarshal(e.RawData, data); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotUnmarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	var metadata map[string]interface{}
+	if len(e.RawMetadata) > 0 {
+		if err := json.Unmarshal(e.RawMetadata, &metadata); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       ErrCouldNotUnmarshalEvent,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	return eh.NewEvent(
+		e.EventType,
+		data,
+		e.Timestamp,
+		eh.ForAggregate(
+			e.AggregateType,
+			e.AggregateID,
+			e.Version,
+		),
+		eh.WithMetadata(metadata),
+		eh.WithPosition(e.Position),
+	), nil
+}
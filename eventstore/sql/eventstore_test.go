@@ -0,0 +1,103 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventstore"
+)
+
+// NOTE: This test uses an embedded SQLite database and is therefore not an
+// integration test.
+func TestEventStore(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "events.db")
+	store, err := NewEventStore("sqlite3", dsn, SQLite, "test")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+	defer store.Close()
+
+	// Run the actual test suite, both for default and custom namespace.
+	customNamespaceCtx := eh.NewContextWithNamespace(context.Background(), "ns")
+	eventstore.AcceptanceTest(t, context.Background(), store)
+	eventstore.AcceptanceTest(t, customNamespaceCtx, store)
+	eventstore.MaintainerAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadFromAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadAllAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadAllAcceptanceTest(t, customNamespaceCtx, store)
+
+	if err := store.Clear(context.Background()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	events, err := store.LoadAll(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(events) != 0 {
+		t.Error("there should be no events after clearing:", events)
+	}
+}
+
+func TestEventStorePostgresIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Use PostgreSQL in Docker with fallback to localhost.
+	addr := os.Getenv("POSTGRES_ADDR")
+	if addr == "" {
+		addr = "localhost:5432"
+	}
+	dsn := "postgres://postgres:postgres@" + addr + "/postgres?sslmode=disable"
+
+	store, err := NewEventStore("postgres", dsn, Postgres, "test")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	customNamespaceCtx := eh.NewContextWithNamespace(context.Background(), "ns")
+
+	defer store.Close()
+	defer func() {
+		if err = store.Clear(context.Background()); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		if err = store.Clear(customNamespaceCtx); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	// Run the actual test suite, both for default and custom namespace.
+	eventstore.AcceptanceTest(t, context.Background(), store)
+	eventstore.AcceptanceTest(t, customNamespaceCtx, store)
+	eventstore.MaintainerAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadFromAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadAllAcceptanceTest(t, context.Background(), store)
+	eventstore.LoadAllAcceptanceTest(t, customNamespaceCtx, store)
+}
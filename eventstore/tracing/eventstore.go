@@ -79,3 +79,36 @@ func (s *EventStore) Load(ctx context.Context, id uuid.UUID) ([]eh.Event, error)
 
 	return events, err
 }
+
+// LoadFrom implements the LoadFrom method of the eventhorizon.EventStoreVersionLoader
+// interface. Stores that does not support it will load all events and skip
+// the older ones.
+func (s *EventStore) LoadFrom(ctx context.Context, id uuid.UUID, version int) ([]eh.Event, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "EventStore.LoadFrom")
+
+	var events []eh.Event
+	var err error
+	if store, ok := s.EventStore.(eh.EventStoreVersionLoader); ok {
+		events, err = store.LoadFrom(ctx, id, version)
+	} else if events, err = s.EventStore.Load(ctx, id); err == nil {
+		for len(events) > 0 && events[0].Version() <= version {
+			events = events[1:]
+		}
+	}
+
+	sp.SetTag("eh.from_version", version)
+
+	// Use the first event for tracing metadata.
+	if len(events) > 0 {
+		sp.SetTag("eh.event_type", events[0].EventType())
+		sp.SetTag("eh.aggregate_type", events[0].AggregateType())
+		sp.SetTag("eh.aggregate_id", events[0].AggregateID())
+		sp.SetTag("eh.version", events[0].Version())
+	}
+	if err != nil {
+		ext.LogError(sp, err)
+	}
+	sp.Finish()
+
+	return events, err
+}
@@ -39,4 +39,5 @@ func TestEventStore(t *testing.T) {
 	eventstore.AcceptanceTest(t, context.Background(), store)
 	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
 	eventstore.AcceptanceTest(t, ctx, store)
+	eventstore.LoadFromAcceptanceTest(t, context.Background(), store)
 }
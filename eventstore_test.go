@@ -0,0 +1,55 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPositionGaps(t *testing.T) {
+	events := func(positions ...int64) []Event {
+		var events []Event
+		for _, p := range positions {
+			events = append(events, NewEvent("event", nil, time.Now().Add(-time.Hour),
+				WithPosition(p)))
+		}
+		return events
+	}
+
+	g := NewPositionGaps(50 * time.Millisecond)
+	if n, wait := g.Settled(0, events(1, 2, 3)); n != 3 || wait != 0 {
+		t.Error("events without gaps should be settled:", n, wait)
+	}
+
+	// Gaps are waited for even before old events, and all gaps in a batch
+	// are timed together.
+	batch := events(1, 3, 5)
+	n, wait := g.Settled(0, batch)
+	if n != 1 || wait <= 0 {
+		t.Error("the events before the gap should be settled:", n, wait)
+	}
+	time.Sleep(wait)
+	n, wait = g.Settled(1, batch[1:])
+	if n != 2 || wait != 0 {
+		t.Error("all gaps in the batch should be settled:", n, wait)
+	}
+
+	// No settle window never waits.
+	g = NewPositionGaps(0)
+	if n, wait := g.Settled(0, events(2, 4)); n != 2 || wait != 0 {
+		t.Error("gaps should not be waited for:", n, wait)
+	}
+}
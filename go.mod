@@ -5,6 +5,7 @@ go 1.16
 require (
 	cloud.google.com/go/pubsub v1.10.1
 	github.com/HdrHistogram/hdrhistogram-go v1.0.1 // indirect
+	github.com/alicebob/miniredis/v2 v2.14.3
 	github.com/go-redis/redis/v8 v8.7.1
 	github.com/golangci/golangci-lint v1.31.0 // indirect
 	github.com/google/uuid v1.2.0
@@ -13,6 +14,8 @@ require (
 	github.com/jinzhu/copier v0.2.5
 	github.com/jpillora/backoff v1.0.0
 	github.com/kr/pretty v0.2.1
+	github.com/lib/pq v1.10.0
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/nats-io/nats-server/v2 v2.1.9 // indirect
 	github.com/nats-io/nats.go v1.10.1-0.20210301010025-9faf9b2e34ea
 	github.com/opentracing/opentracing-go v1.2.0
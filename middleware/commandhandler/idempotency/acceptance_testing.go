@@ -0,0 +1,152 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// StoreAcceptanceTest is the acceptance test that all implementations of
+// Store should pass. It should manually be called from a test case in each
+// implementation:
+//
+//	func TestStore(t *testing.T) {
+//	    store := NewStore()
+//	    idempotency.StoreAcceptanceTest(t, store)
+//	}
+func StoreAcceptanceTest(t *testing.T, store Store) {
+	ctx := context.Background()
+	lease := time.Minute
+	retention := time.Hour
+
+	// A new command should be claimed.
+	id := uuid.New()
+	claim, claimed, err := store.Claim(ctx, id, lease)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if !claimed || claim == nil || claim.Token == uuid.Nil {
+		t.Fatal("the command should be claimed with a token:", claim)
+	}
+
+	// A claimed command should not be claimed again.
+	r, claimed, err := store.Claim(ctx, id, lease)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if claimed {
+		t.Error("the command should not be claimed twice")
+	}
+	if r == nil || r.CommandID != id || r.Completed {
+		t.Error("the record should be in progress:", r)
+	}
+
+	// A command should not be completed or released without the token.
+	if err := store.Complete(ctx, id, uuid.New(), retention); !errors.Is(err, ErrNotClaimed) {
+		t.Error("there should be a not claimed error:", err)
+	}
+	if err := store.Release(ctx, id, uuid.New()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, claimed, err = store.Claim(ctx, id, lease); err != nil || claimed {
+		t.Error("the command should still be claimed:", err)
+	}
+
+	// A completed command should not be claimed again.
+	if err := store.Complete(ctx, id, claim.Token, retention); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	r, claimed, err = store.Claim(ctx, id, lease)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if claimed {
+		t.Error("the command should not be claimed after completing")
+	}
+	if r == nil || r.CommandID != id || !r.Completed {
+		t.Error("the record should be completed:", r)
+	}
+	if r != nil && (r.ExpiresAt.Before(time.Now().Add(lease)) || r.ExpiresAt.After(time.Now().Add(retention))) {
+		t.Error("the record should expire after the retention:", r.ExpiresAt)
+	}
+
+	// A released command should be claimed again.
+	id = uuid.New()
+	if claim, claimed, err = store.Claim(ctx, id, lease); err != nil || !claimed {
+		t.Fatal("the command should be claimed:", err)
+	}
+	if err := store.Release(ctx, id, claim.Token); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, claimed, err = store.Claim(ctx, id, lease); err != nil || !claimed {
+		t.Error("the command should be claimed after releasing:", err)
+	}
+
+	// Releasing an unknown command should not be an error.
+	if err := store.Release(ctx, uuid.New(), uuid.New()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// An expired claim should be claimed again, and the first claimant should
+	// not be able to complete it.
+	id = uuid.New()
+	if claim, claimed, err = store.Claim(ctx, id, 10*time.Millisecond); err != nil || !claimed {
+		t.Fatal("the command should be claimed:", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	second, claimed, err := store.Claim(ctx, id, lease)
+	if err != nil || !claimed {
+		t.Fatal("the command should be claimed after the lease:", err)
+	}
+	if err := store.Complete(ctx, id, claim.Token, retention); !errors.Is(err, ErrNotClaimed) {
+		t.Error("there should be a not claimed error:", err)
+	}
+	if err := store.Release(ctx, id, claim.Token); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Complete(ctx, id, second.Token, retention); err != nil {
+		t.Error("the second claim should be completed:", err)
+	}
+
+	// An expired command should be claimed again.
+	id = uuid.New()
+	if claim, claimed, err = store.Claim(ctx, id, lease); err != nil || !claimed {
+		t.Fatal("the command should be claimed:", err)
+	}
+	if err := store.Complete(ctx, id, claim.Token, 10*time.Millisecond); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, claimed, err = store.Claim(ctx, id, lease); err != nil || !claimed {
+		t.Error("the command should be claimed after expiring:", err)
+	}
+
+	// Commands should be claimed per namespace.
+	id = uuid.New()
+	if _, claimed, err = store.Claim(ctx, id, lease); err != nil || !claimed {
+		t.Fatal("the command should be claimed:", err)
+	}
+	nsCtx := eh.NewContextWithNamespace(ctx, "ns")
+	if _, claimed, err = store.Claim(nsCtx, id, lease); err != nil || !claimed {
+		t.Error("the command should be claimed in another namespace:", err)
+	}
+}
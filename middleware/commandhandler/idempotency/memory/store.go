@@ -0,0 +1,125 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/middleware/commandhandler/idempotency"
+)
+
+// purgeInterval is how often expired records are removed.
+const purgeInterval = time.Minute
+
+// Store implements idempotency.Store in memory.
+type Store struct {
+	// The outer map is with namespace as key, the inner with command ID.
+	db        map[string]map[uuid.UUID]idempotency.Record
+	dbMu      sync.Mutex
+	lastPurge time.Time
+}
+
+// NewStore creates a new Store using memory as storage.
+func NewStore() *Store {
+	return &Store{
+		db:        map[string]map[uuid.UUID]idempotency.Record{},
+		lastPurge: time.Now(),
+	}
+}
+
+// Claim implements the Claim method of the idempotency.Store interface.
+func (s *Store) Claim(ctx context.Context, id uuid.UUID, lease time.Duration) (*idempotency.Record, bool, error) {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastPurge) > purgeInterval {
+		s.purge(now)
+	}
+
+	db := s.namespace(ctx)
+	if r, ok := db[id]; ok && r.ExpiresAt.After(now) {
+		return &r, false, nil
+	}
+
+	r := idempotency.Record{
+		CommandID: id,
+		Token:     uuid.New(),
+		ExpiresAt: now.Add(lease),
+	}
+	db[id] = r
+
+	return &r, true, nil
+}
+
+// Complete implements the Complete method of the idempotency.Store interface.
+func (s *Store) Complete(ctx context.Context, id, token uuid.UUID, retention time.Duration) error {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	db := s.namespace(ctx)
+	if r, ok := db[id]; !ok || r.Token != token {
+		return idempotency.ErrNotClaimed
+	}
+	db[id] = idempotency.Record{
+		CommandID: id,
+		Token:     token,
+		Completed: true,
+		ExpiresAt: time.Now().Add(retention),
+	}
+
+	return nil
+}
+
+// Release implements the Release method of the idempotency.Store interface.
+func (s *Store) Release(ctx context.Context, id, token uuid.UUID) error {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	db := s.namespace(ctx)
+	if r, ok := db[id]; ok && r.Token == token {
+		delete(db, id)
+	}
+
+	return nil
+}
+
+// namespace returns the records for the namespace in the context, must be
+// called with the lock held.
+func (s *Store) namespace(ctx context.Context) map[uuid.UUID]idempotency.Record {
+	ns := eh.NamespaceFromContext(ctx)
+	if _, ok := s.db[ns]; !ok {
+		s.db[ns] = map[uuid.UUID]idempotency.Record{}
+	}
+
+	return s.db[ns]
+}
+
+// purge removes all expired records, must be called with the lock held.
+func (s *Store) purge(now time.Time) {
+	for _, db := range s.db {
+		for id, r := range db {
+			if !r.ExpiresAt.After(now) {
+				delete(db, id)
+			}
+		}
+	}
+	s.lastPurge = now
+}
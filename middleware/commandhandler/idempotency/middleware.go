@@ -0,0 +1,120 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package idempotency implements a command handler middleware that handles
+// commands with the same command ID only once, for example when a client
+// retries a command after a timeout.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+var (
+	// ErrCommandInProgress is when a command with the same ID is being handled.
+	ErrCommandInProgress = errors.New("command in progress")
+	// ErrNotClaimed is when completing a command that is no longer claimed with
+	// the token, because the claim expired and the command was claimed again.
+	ErrNotClaimed = errors.New("command not claimed")
+)
+
+// Record is a command ID recorded in a store.
+type Record struct {
+	// CommandID is the ID of the command.
+	CommandID uuid.UUID
+	// Token identifies the claim of the command, and must be used to complete
+	// or release it.
+	Token uuid.UUID
+	// Completed is true when the command has been handled successfully, and
+	// false when it is being handled.
+	Completed bool
+	// ExpiresAt is the time when the record expires and the command can be
+	// handled again.
+	ExpiresAt time.Time
+}
+
+// Store records the IDs of handled commands. Records are kept per namespace.
+type Store interface {
+	// Claim claims a command ID for handling for the lease duration, returning
+	// true and a record with a new token if it could be claimed. If the ID has
+	// already been claimed, and the record has not expired, the existing record
+	// is returned instead. Claiming must be atomic.
+	Claim(ctx context.Context, id uuid.UUID, lease time.Duration) (*Record, bool, error)
+
+	// Complete marks a command claimed with the token as handled, keeping the
+	// record for the retention duration. Returns ErrNotClaimed if the command
+	// is not claimed with the token.
+	Complete(ctx context.Context, id, token uuid.UUID, retention time.Duration) error
+
+	// Release removes a claim with the token, allowing the command to be
+	// handled again. Claims with other tokens are kept.
+	Release(ctx context.Context, id, token uuid.UUID) error
+}
+
+// NewMiddleware returns a new idempotency middleware that handles commands
+// implementing eventhorizon.CommandIDer only once within the retention
+// duration. A duplicate of a handled command returns the original result,
+// which is always nil as failed commands are released to be retried. A
+// duplicate of a command that is still being handled returns
+// ErrCommandInProgress. Commands without an ID are always handled.
+//
+// The lease limits how long a claim is held if the process handling the
+// command stops before it is completed or released, and should be longer than
+// the time it takes to handle a command. A command that takes longer can be
+// handled again by a duplicate after the lease.
+func NewMiddleware(store Store, lease, retention time.Duration) eh.CommandHandlerMiddleware {
+	return eh.CommandHandlerMiddleware(func(h eh.CommandHandler) eh.CommandHandler {
+		return eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+			c, ok := cmd.(eh.CommandIDer)
+			if !ok || c.CommandID() == uuid.Nil {
+				return h.HandleCommand(ctx, cmd)
+			}
+			id := c.CommandID()
+
+			r, claimed, err := store.Claim(ctx, id, lease)
+			if err != nil {
+				return fmt.Errorf("could not claim command: %w", err)
+			}
+			if !claimed {
+				if r.Completed {
+					return nil
+				}
+				return ErrCommandInProgress
+			}
+
+			if err := h.HandleCommand(ctx, cmd); err != nil {
+				if err := store.Release(ctx, id, r.Token); err != nil {
+					log.Printf("eventhorizon: could not release command %s: %s", id, err)
+				}
+				return err
+			}
+
+			// The command has been handled, only log errors to not make the
+			// client retry it.
+			if err := store.Complete(ctx, id, r.Token, retention); err != nil {
+				log.Printf("eventhorizon: could not complete command %s: %s", id, err)
+			}
+
+			return nil
+		})
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestMiddleware(t *testing.T) {
+	inner := &mocks.CommandHandler{}
+	store := &mapStore{records: map[uuid.UUID]Record{}}
+	h := eh.UseCommandHandlerMiddleware(inner, NewMiddleware(store, time.Minute, time.Hour))
+
+	// Commands without an ID should always be handled.
+	cmd := &mocks.Command{ID: uuid.New(), Content: "content"}
+	for i := 0; i < 2; i++ {
+		if err := h.HandleCommand(context.Background(), cmd); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	if len(inner.Commands) != 2 {
+		t.Error("the command should be handled twice:", inner.Commands)
+	}
+
+	// Duplicates should return the original result without being handled.
+	inner.Commands = nil
+	idCmd := &command{Command: cmd, id: uuid.New()}
+	for i := 0; i < 2; i++ {
+		if err := h.HandleCommand(context.Background(), idCmd); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	if len(inner.Commands) != 1 {
+		t.Error("the command should be handled once:", inner.Commands)
+	}
+	if r := store.records[idCmd.id]; !r.Completed || r.ExpiresAt.Before(time.Now().Add(time.Minute)) {
+		t.Error("the command should be completed for the retention:", r)
+	}
+
+	// Failed commands should be released to be retried.
+	inner.Commands = nil
+	handlerErr := errors.New("handler error")
+	inner.Err = handlerErr
+	idCmd = &command{Command: cmd, id: uuid.New()}
+	if err := h.HandleCommand(context.Background(), idCmd); !errors.Is(err, handlerErr) {
+		t.Error("there should be a handler error:", err)
+	}
+	inner.Err = nil
+	if err := h.HandleCommand(context.Background(), idCmd); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(inner.Commands) != 1 {
+		t.Error("the command should be handled after a retry:", inner.Commands)
+	}
+
+	// Commands being handled should not be handled again.
+	idCmd = &command{Command: cmd, id: uuid.New()}
+	store.records[idCmd.id] = Record{CommandID: idCmd.id, ExpiresAt: time.Now().Add(time.Minute)}
+	if err := h.HandleCommand(context.Background(), idCmd); !errors.Is(err, ErrCommandInProgress) {
+		t.Error("there should be a command in progress error:", err)
+	}
+
+	// Store errors should be returned.
+	storeErr := errors.New("store error")
+	store.err = storeErr
+	if err := h.HandleCommand(context.Background(), &command{Command: cmd, id: uuid.New()}); !errors.Is(err, storeErr) {
+		t.Error("there should be a store error:", err)
+	}
+}
+
+// command is a command with a command ID.
+type command struct {
+	*mocks.Command
+	id uuid.UUID
+}
+
+func (c *command) CommandID() uuid.UUID {
+	return c.id
+}
+
+// mapStore is a minimal Store used for testing the middleware.
+type mapStore struct {
+	sync.Mutex
+	records map[uuid.UUID]Record
+	err     error
+}
+
+func (s *mapStore) Claim(ctx context.Context, id uuid.UUID, lease time.Duration) (*Record, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.err != nil {
+		return nil, false, s.err
+	}
+	if r, ok := s.records[id]; ok && r.ExpiresAt.After(time.Now()) {
+		return &r, false, nil
+	}
+	r := Record{CommandID: id, Token: uuid.New(), ExpiresAt: time.Now().Add(lease)}
+	s.records[id] = r
+	return &r, true, nil
+}
+
+func (s *mapStore) Complete(ctx context.Context, id, token uuid.UUID, retention time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.records[id].Token != token {
+		return ErrNotClaimed
+	}
+	s.records[id] = Record{CommandID: id, Token: token, Completed: true, ExpiresAt: time.Now().Add(retention)}
+	return nil
+}
+
+func (s *mapStore) Release(ctx context.Context, id, token uuid.UUID) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.records[id].Token == token {
+		delete(s.records, id)
+	}
+	return nil
+}
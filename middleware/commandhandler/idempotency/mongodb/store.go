@@ -0,0 +1,251 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+
+	// Register uuid.UUID as BSON type.
+	_ "github.com/looplab/eventhorizon/codec/bson"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/middleware/commandhandler/idempotency"
+	"github.com/looplab/eventhorizon/mongoutils"
+)
+
+var (
+	// ErrCouldNotDialDB is when the database could not be dialed.
+	ErrCouldNotDialDB = errors.New("could not dial database")
+	// ErrNoDBClient is when no database client is set.
+	ErrNoDBClient = errors.New("no database client")
+	// ErrCouldNotClearDB is when the database could not be cleared.
+	ErrCouldNotClearDB = errors.New("could not clear database")
+)
+
+// DefaultCollection is the default collection used for the records.
+const DefaultCollection = "commands"
+
+// Store implements idempotency.Store for MongoDB.
+//
+// Expired records are replaced when a command is claimed again. To also remove
+// them from the database a TTL index can be created on the expires_at field.
+type Store struct {
+	client     *mongo.Client
+	dbPrefix   string
+	collection string
+	dbName     func(context.Context) string
+}
+
+// NewStore creates a new Store with a MongoDB URI: `mongodb://hostname`.
+func NewStore(uri, dbPrefix string, options ...Option) (*Store, error) {
+	opts := mongoOptions.Client().ApplyURI(uri)
+	opts.SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+	opts.SetReadConcern(readconcern.Majority())
+	opts.SetReadPreference(readpref.Primary())
+	client, err := mongo.Connect(context.TODO(), opts)
+	if err != nil {
+		return nil, ErrCouldNotDialDB
+	}
+
+	return NewStoreWithClient(client, dbPrefix, options...)
+}
+
+// NewStoreWithClient creates a new Store with a client.
+func NewStoreWithClient(client *mongo.Client, dbPrefix string, options ...Option) (*Store, error) {
+	if client == nil {
+		return nil, ErrNoDBClient
+	}
+
+	s := &Store{
+		client:     client,
+		dbPrefix:   dbPrefix,
+		collection: DefaultCollection,
+	}
+
+	// Use the a prefix and namespace from the context for DB name.
+	s.dbName = func(ctx context.Context) string {
+		ns := eh.NamespaceFromContext(ctx)
+		return dbPrefix + "_" + ns
+	}
+
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Store) error
+
+// WithPrefixAsDBName uses only the prefix as DB name, without namespace support.
+func WithPrefixAsDBName() Option {
+	return func(s *Store) error {
+		s.dbName = func(context.Context) string {
+			return s.dbPrefix
+		}
+		return nil
+	}
+}
+
+// WithDBName uses a custom DB name function.
+func WithDBName(dbName func(context.Context) string) Option {
+	return func(s *Store) error {
+		s.dbName = dbName
+		return nil
+	}
+}
+
+// WithCollection uses a custom collection for the records.
+func WithCollection(collection string) Option {
+	return func(s *Store) error {
+		if collection == "" {
+			return fmt.Errorf("missing collection")
+		}
+		s.collection = collection
+		return nil
+	}
+}
+
+// Claim implements the Claim method of the idempotency.Store interface.
+func (s *Store) Claim(ctx context.Context, id uuid.UUID, lease time.Duration) (*idempotency.Record, bool, error) {
+	c := s.client.Database(s.dbName(ctx)).Collection(s.collection)
+
+	for {
+		// Upsert a new record if there is none or if it has expired. If there
+		// is a record that has not expired the upsert fails with a duplicate key.
+		now := time.Now()
+		r := record{
+			CommandID: id,
+			Token:     uuid.New(),
+			ExpiresAt: now.Add(lease),
+		}
+		if _, err := c.UpdateOne(ctx,
+			bson.M{
+				"_id":        id,
+				"expires_at": bson.M{"$lte": now},
+			},
+			bson.M{
+				"$set": bson.M{
+					"token":      r.Token,
+					"completed":  r.Completed,
+					"expires_at": r.ExpiresAt,
+				},
+			},
+			mongoOptions.Update().SetUpsert(true),
+		); err == nil {
+			return r.toRecord(), true, nil
+		} else if !mongoutils.IsDuplicateKeyError(err) {
+			return nil, false, fmt.Errorf("could not claim command: %w", err)
+		}
+
+		if err := c.FindOne(ctx, bson.M{"_id": id}).Decode(&r); err == nil {
+			return r.toRecord(), false, nil
+		} else if err != mongo.ErrNoDocuments {
+			return nil, false, fmt.Errorf("could not load record: %w", err)
+		}
+
+		// The record was released in between, try again.
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// Complete implements the Complete method of the idempotency.Store interface.
+func (s *Store) Complete(ctx context.Context, id, token uuid.UUID, retention time.Duration) error {
+	c := s.client.Database(s.dbName(ctx)).Collection(s.collection)
+
+	res, err := c.UpdateOne(ctx,
+		bson.M{
+			"_id":   id,
+			"token": token,
+		},
+		bson.M{
+			"$set": bson.M{
+				"completed":  true,
+				"expires_at": time.Now().Add(retention),
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("could not complete command: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return idempotency.ErrNotClaimed
+	}
+
+	return nil
+}
+
+// Release implements the Release method of the idempotency.Store interface.
+func (s *Store) Release(ctx context.Context, id, token uuid.UUID) error {
+	c := s.client.Database(s.dbName(ctx)).Collection(s.collection)
+
+	if _, err := c.DeleteOne(ctx, bson.M{
+		"_id":   id,
+		"token": token,
+	}); err != nil {
+		return fmt.Errorf("could not release command: %w", err)
+	}
+
+	return nil
+}
+
+// Clear clears the records of the namespace in the context.
+func (s *Store) Clear(ctx context.Context) error {
+	c := s.client.Database(s.dbName(ctx)).Collection(s.collection)
+
+	if err := c.Drop(ctx); err != nil {
+		return fmt.Errorf("%s: %w", ErrCouldNotClearDB, err)
+	}
+
+	return nil
+}
+
+// Close closes a database session.
+func (s *Store) Close(ctx context.Context) {
+	s.client.Disconnect(ctx)
+}
+
+// record is the Database representation of a command record.
+type record struct {
+	CommandID uuid.UUID `bson:"_id"`
+	Token     uuid.UUID `bson:"token"`
+	Completed bool      `bson:"completed"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+func (r record) toRecord() *idempotency.Record {
+	return &idempotency.Record{
+		CommandID: r.CommandID,
+		Token:     r.Token,
+		Completed: r.Completed,
+		ExpiresAt: r.ExpiresAt,
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// LockerAcceptanceTest is the acceptance test that all implementations of
+// Locker should pass. It should manually be called from a test case in each
+// implementation:
+//
+//	func TestLocker(t *testing.T) {
+//	    locker := NewLocker()
+//	    lock.LockerAcceptanceTest(t, locker)
+//	}
+func LockerAcceptanceTest(t *testing.T, locker Locker) {
+	ctx := context.Background()
+
+	unlock, err := locker.Lock(ctx, "key")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Other keys should not be locked.
+	otherUnlock, err := locker.Lock(ctx, "other")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := otherUnlock(ctx); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// A locked key should time out with the context.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := locker.Lock(timeoutCtx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("there should be a deadline exceeded error:", err)
+	}
+
+	// A waiting lock should be acquired when unlocked.
+	locked := make(chan error, 1)
+	go func() {
+		unlock, err := locker.Lock(ctx, "key")
+		if err == nil {
+			err = unlock(ctx)
+		}
+		locked <- err
+	}()
+	select {
+	case <-locked:
+		t.Fatal("the key should be locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := unlock(ctx); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Error("there should be no error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the key should be unlocked")
+	}
+
+	// A key should be lockable again after unlocking.
+	unlock, err = locker.Lock(ctx, "key")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := unlock(ctx); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lock
+
+import (
+	"context"
+	"sync"
+)
+
+// LocalLocker is a Locker with a mutex per key, for use within one process.
+type LocalLocker struct {
+	locks   map[string]*localLock
+	locksMu sync.Mutex
+}
+
+// localLock is a lock for one key, kept while it is held or waited for.
+type localLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+// NewLocalLocker creates a new LocalLocker.
+func NewLocalLocker() *LocalLocker {
+	return &LocalLocker{
+		locks: map[string]*localLock{},
+	}
+}
+
+// Lock implements the Lock method of the Locker interface.
+func (l *LocalLocker) Lock(ctx context.Context, key string) (Unlock, error) {
+	l.locksMu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &localLock{ch: make(chan struct{}, 1)}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.locksMu.Unlock()
+
+	select {
+	case lock.ch <- struct{}{}:
+	case <-ctx.Done():
+		l.release(key, lock)
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func(context.Context) error {
+		once.Do(func() {
+			<-lock.ch
+			l.release(key, lock)
+		})
+		return nil
+	}, nil
+}
+
+// release removes a reference to a lock, and the lock when it is unused.
+func (l *LocalLocker) release(key string, lock *localLock) {
+	l.locksMu.Lock()
+	defer l.locksMu.Unlock()
+
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.locks, key)
+	}
+}
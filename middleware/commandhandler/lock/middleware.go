@@ -0,0 +1,66 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lock implements a command handler middleware that handles commands
+// for the same aggregate one at a time, to avoid version conflicts when
+// loading, handling and saving an aggregate concurrently.
+package lock
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Locker locks keys to serialize work on them, within a process or across
+// processes depending on the implementation.
+type Locker interface {
+	// Lock acquires the lock for a key, blocking until it is acquired or the
+	// context is done. The returned function releases the lock.
+	Lock(ctx context.Context, key string) (Unlock, error)
+}
+
+// Unlock releases an acquired lock.
+type Unlock func(ctx context.Context) error
+
+// NewMiddleware returns a new lock middleware that handles commands one at a
+// time per namespace and aggregate ID, using the locker. Waiting for a lock
+// respects the deadline of the context, returning the context error.
+func NewMiddleware(locker Locker) eh.CommandHandlerMiddleware {
+	return eh.CommandHandlerMiddleware(func(h eh.CommandHandler) eh.CommandHandler {
+		return eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+			key := Key(ctx, cmd)
+			unlock, err := locker.Lock(ctx, key)
+			if err != nil {
+				return fmt.Errorf("could not lock aggregate: %w", err)
+			}
+			defer func() {
+				// Use a context that is not cancelled to always release the lock.
+				if err := unlock(context.Background()); err != nil {
+					log.Printf("eventhorizon: could not unlock %s: %s", key, err)
+				}
+			}()
+
+			return h.HandleCommand(ctx, cmd)
+		})
+	})
+}
+
+// Key returns the lock key for a command, which is the namespace in the
+// context and the aggregate ID of the command.
+func Key(ctx context.Context, cmd eh.Command) string {
+	return eh.NamespaceFromContext(ctx) + "/" + cmd.AggregateID().String()
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestMiddleware(t *testing.T) {
+	var running, maxRunning int32
+	inner := eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+	h := eh.UseCommandHandlerMiddleware(inner, NewMiddleware(NewLocalLocker()))
+
+	// Commands for the same aggregate should be handled one at a time.
+	id := uuid.New()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.HandleCommand(context.Background(), &mocks.Command{ID: id}); err != nil {
+				t.Error("there should be no error:", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxRunning != 1 {
+		t.Error("the commands should be handled one at a time:", maxRunning)
+	}
+
+	// Commands for different aggregates should be handled concurrently.
+	maxRunning = 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.HandleCommand(context.Background(), &mocks.Command{ID: uuid.New()}); err != nil {
+				t.Error("there should be no error:", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxRunning < 2 {
+		t.Error("the commands should be handled concurrently:", maxRunning)
+	}
+}
+
+func TestMiddleware_Timeout(t *testing.T) {
+	inner := &mocks.CommandHandler{}
+	locker := NewLocalLocker()
+	h := eh.UseCommandHandlerMiddleware(inner, NewMiddleware(locker))
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	cmd := &mocks.Command{ID: uuid.New()}
+	unlock, err := locker.Lock(ctx, Key(ctx, cmd))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// The same aggregate in another namespace should not be locked.
+	if err := h.HandleCommand(context.Background(), cmd); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := h.HandleCommand(timeoutCtx, cmd); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("there should be a deadline exceeded error:", err)
+	}
+	if len(inner.Commands) != 1 {
+		t.Error("the command should not be handled:", inner.Commands)
+	}
+
+	if err := unlock(ctx); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := h.HandleCommand(ctx, cmd); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(inner.Commands) != 2 {
+		t.Error("the command should be handled:", inner.Commands)
+	}
+}
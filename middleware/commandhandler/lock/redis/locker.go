@@ -0,0 +1,180 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"github.com/looplab/eventhorizon/middleware/commandhandler/lock"
+)
+
+// ErrNotLocked is when a lock was not held when unlocking, for example if it
+// expired.
+var ErrNotLocked = errors.New("not locked")
+
+// DefaultTTL is the default time before a lock expires if it is not unlocked.
+var DefaultTTL = 30 * time.Second
+
+// DefaultRetryInterval is the default interval for trying to acquire a lock
+// that is held by someone else.
+var DefaultRetryInterval = 10 * time.Millisecond
+
+// DefaultPrefix is the default prefix for the lock keys in Redis.
+const DefaultPrefix = "eventhorizon:lock:"
+
+// unlockScript deletes a lock only if it is still held with the same token.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Locker is a lock.Locker using Redis, for serializing work across processes.
+// Locks expire after a TTL to not be held forever by a process that stops, the
+// TTL should be longer than the time it takes to handle a command.
+type Locker struct {
+	client        *redis.Client
+	clientOpts    *redis.Options
+	prefix        string
+	ttl           time.Duration
+	retryInterval time.Duration
+}
+
+// NewLocker creates a Locker, with optional settings.
+func NewLocker(addr string, options ...Option) (*Locker, error) {
+	l := &Locker{
+		prefix:        DefaultPrefix,
+		ttl:           DefaultTTL,
+		retryInterval: DefaultRetryInterval,
+	}
+
+	// Apply configuration options.
+	for _, option := range options {
+		if option == nil {
+			continue
+		}
+		if err := option(l); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	// Default client options.
+	if l.clientOpts == nil {
+		l.clientOpts = &redis.Options{
+			Addr: addr,
+		}
+	}
+
+	// Create client and check connection.
+	l.client = redis.NewClient(l.clientOpts)
+	ctx := context.Background()
+	if res, err := l.client.Ping(ctx).Result(); err != nil || res != "PONG" {
+		return nil, fmt.Errorf("could not check Redis server: %w", err)
+	}
+
+	return l, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Locker) error
+
+// WithRedisOptions uses the Redis options for the underlying client, instead of the defaults.
+func WithRedisOptions(opts *redis.Options) Option {
+	return func(l *Locker) error {
+		l.clientOpts = opts
+		return nil
+	}
+}
+
+// WithPrefix uses a custom prefix for the lock keys.
+func WithPrefix(prefix string) Option {
+	return func(l *Locker) error {
+		l.prefix = prefix
+		return nil
+	}
+}
+
+// WithTTL sets the time before a lock expires if it is not unlocked.
+func WithTTL(ttl time.Duration) Option {
+	return func(l *Locker) error {
+		if ttl <= 0 {
+			return fmt.Errorf("invalid TTL: %s", ttl)
+		}
+		l.ttl = ttl
+		return nil
+	}
+}
+
+// WithRetryInterval sets the interval for trying to acquire a held lock.
+func WithRetryInterval(interval time.Duration) Option {
+	return func(l *Locker) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid retry interval: %s", interval)
+		}
+		l.retryInterval = interval
+		return nil
+	}
+}
+
+// Lock implements the Lock method of the lock.Locker interface.
+func (l *Locker) Lock(ctx context.Context, key string) (lock.Unlock, error) {
+	key = l.prefix + key
+	token := uuid.New().String()
+
+	ticker := time.NewTicker(l.retryInterval)
+	defer ticker.Stop()
+
+	for {
+		ok, err := l.client.SetNX(ctx, key, token, l.ttl).Result()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("could not acquire lock: %w", err)
+		}
+		if ok {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return func(ctx context.Context) error {
+		n, err := unlockScript.Run(ctx, l.client, []string{key}, token).Int()
+		if err != nil {
+			return fmt.Errorf("could not release lock: %w", err)
+		}
+		if n == 0 {
+			return ErrNotLocked
+		}
+		return nil
+	}, nil
+}
+
+// Close closes the Redis client.
+func (l *Locker) Close() error {
+	return l.client.Close()
+}
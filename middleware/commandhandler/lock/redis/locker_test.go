@@ -0,0 +1,89 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/looplab/eventhorizon/middleware/commandhandler/lock"
+)
+
+func TestLocker(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer s.Close()
+
+	locker, err := NewLocker(s.Addr(), WithTTL(time.Minute))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer locker.Close()
+
+	lock.LockerAcceptanceTest(t, locker)
+
+	// An expired lock should be acquired by someone else.
+	ctx := context.Background()
+	unlock, err := locker.Lock(ctx, "key")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	s.FastForward(2 * time.Minute)
+	otherUnlock, err := locker.Lock(ctx, "key")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// The expired lock should not release the new lock.
+	if err := unlock(ctx); !errors.Is(err, ErrNotLocked) {
+		t.Error("there should be a not locked error:", err)
+	}
+	if !s.Exists(DefaultPrefix + "key") {
+		t.Error("the new lock should be held")
+	}
+	if err := otherUnlock(ctx); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if s.Exists(DefaultPrefix + "key") {
+		t.Error("the lock should be released")
+	}
+}
+
+func TestLockerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Use Redis in Docker with fallback to localhost.
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+
+	locker, err := NewLocker(addr, WithPrefix("test:lock:"))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer locker.Close()
+
+	lock.LockerAcceptanceTest(t, locker)
+}
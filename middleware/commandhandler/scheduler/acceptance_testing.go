@@ -0,0 +1,218 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// StoreAcceptanceTest is the acceptance test that all implementations of
+// Store should pass. It should manually be called from a test case in each
+// implementation:
+//
+//	func TestStore(t *testing.T) {
+//	    store := NewStore()
+//	    scheduler.StoreAcceptanceTest(t, store)
+//	}
+func StoreAcceptanceTest(t *testing.T, store Store) {
+	if _, err := eh.CreateCommand(mocks.CommandType); errors.Is(err, eh.ErrCommandNotRegistered) {
+		eh.RegisterCommand(func() eh.Command { return &mocks.Command{} })
+	}
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "scheduler")
+	now := time.Now().Round(time.Millisecond).UTC()
+
+	// No commands.
+	list, err := store.List(ctx)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(list) != 0 {
+		t.Error("there should be no commands:", list)
+	}
+
+	// Add commands out of order.
+	sc1 := &ScheduledCommand{
+		ID:        uuid.New(),
+		Command:   &mocks.Command{ID: uuid.New(), Content: "cmd1"},
+		Context:   eh.MarshalContext(ctx),
+		ExecuteAt: now.Add(time.Minute),
+	}
+	sc2 := &ScheduledCommand{
+		ID:        uuid.New(),
+		Command:   &mocks.Command{ID: uuid.New(), Content: "cmd2"},
+		Context:   eh.MarshalContext(ctx),
+		ExecuteAt: now.Add(-time.Minute),
+	}
+	sc3 := &ScheduledCommand{
+		ID:        uuid.New(),
+		Command:   &mocks.Command{ID: uuid.New(), Content: "cmd3"},
+		Context:   eh.MarshalContext(ctx),
+		ExecuteAt: now.Add(-time.Hour),
+	}
+	for _, sc := range []*ScheduledCommand{sc1, sc2, sc3} {
+		if err := store.Add(ctx, sc); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+
+	// List commands ordered by execution time.
+	list, err = store.List(ctx)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(list) != 3 {
+		t.Fatal("there should be 3 commands:", list)
+	}
+	for i, sc := range []*ScheduledCommand{sc3, sc2, sc1} {
+		if list[i].ID != sc.ID || !list[i].ExecuteAt.Equal(sc.ExecuteAt) {
+			t.Error("the command should be correct:", list[i])
+		}
+		if !reflect.DeepEqual(list[i].Command, sc.Command) {
+			t.Error("the command should be decoded:", list[i].Command)
+		}
+		if eh.NamespaceFromContext(eh.UnmarshalContext(context.Background(), list[i].Context)) != "scheduler" {
+			t.Error("the context should be correct:", list[i].Context)
+		}
+	}
+
+	// Commands should be listed per namespace.
+	if list, err := store.List(context.Background()); err != nil || len(list) != 0 {
+		t.Error("there should be no commands in another namespace:", list, err)
+	}
+
+	// Claim due commands, one at a time.
+	claimed, err := store.Claim(ctx, now, time.Minute, 1)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != sc3.ID {
+		t.Fatal("the first due command should be claimed:", claimed)
+	}
+	claimed, err = store.Claim(ctx, now, time.Minute, 10)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != sc2.ID {
+		t.Fatal("the second due command should be claimed:", claimed)
+	}
+
+	// Claimed commands should not be claimed again until the lease expires.
+	if claimed, err := store.Claim(ctx, now, time.Minute, 10); err != nil || len(claimed) != 0 {
+		t.Error("there should be no claimable commands:", claimed, err)
+	}
+	claimed, err = store.Claim(ctx, now.Add(2*time.Minute), time.Minute, 10)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(claimed) != 3 {
+		t.Error("all commands should be claimable after the lease:", claimed)
+	}
+	attempts := map[uuid.UUID]int{sc1.ID: 1, sc2.ID: 2, sc3.ID: 2}
+	for _, sc := range claimed {
+		if sc.Attempts != attempts[sc.ID] {
+			t.Error("the attempts should be counted:", sc.ID, sc.Attempts)
+		}
+	}
+
+	// Remove commands.
+	if err := store.Remove(ctx, sc2.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Remove(ctx, sc2.ID); !errors.Is(err, ErrCommandNotFound) {
+		t.Error("there should be a command not found error:", err)
+	}
+	list, err = store.List(ctx)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(list) != 2 || list[0].ID != sc3.ID || list[1].ID != sc1.ID {
+		t.Error("the command should be removed:", list)
+	}
+	for _, sc := range list {
+		if err := store.Remove(ctx, sc.ID); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+
+	// Commands that can not be decoded should be reported once, without
+	// stopping the valid commands from being claimed.
+	invalid := &ScheduledCommand{
+		ID:        uuid.New(),
+		Command:   &unregisteredCommand{Command: mocks.Command{ID: uuid.New(), Content: "invalid"}},
+		Context:   eh.MarshalContext(ctx),
+		ExecuteAt: now.Add(-time.Hour),
+	}
+	valid := &ScheduledCommand{
+		ID:        uuid.New(),
+		Command:   &mocks.Command{ID: uuid.New(), Content: "valid"},
+		Context:   eh.MarshalContext(ctx),
+		ExecuteAt: now.Add(-time.Minute),
+	}
+	for _, sc := range []*ScheduledCommand{invalid, valid} {
+		if err := store.Add(ctx, sc); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+	claimed, err = store.Claim(ctx, now, time.Minute, 10)
+	if !errors.Is(err, ErrInvalidCommand) {
+		t.Error("there should be an invalid command error:", err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != valid.ID {
+		t.Error("the valid command should be claimed:", claimed)
+	}
+	claimed, err = store.Claim(ctx, now.Add(2*time.Minute), time.Minute, 10)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != valid.ID {
+		t.Error("the invalid command should not be claimed again:", claimed)
+	}
+
+	// Commands that can not be decoded should be reported when listing, without
+	// stopping the valid commands from being listed.
+	list, err = store.List(ctx)
+	if !errors.Is(err, ErrInvalidCommand) || !strings.Contains(err.Error(), invalid.ID.String()) {
+		t.Error("there should be an invalid command error:", err)
+	}
+	if len(list) != 1 || list[0].ID != valid.ID {
+		t.Error("the valid command should be listed:", list)
+	}
+	for _, sc := range []*ScheduledCommand{invalid, valid} {
+		if err := store.Remove(ctx, sc.ID); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+}
+
+// unregisteredCommand is a command with a type that is not registered, which
+// can be stored but not decoded.
+type unregisteredCommand struct {
+	mocks.Command
+}
+
+func (c *unregisteredCommand) CommandType() eh.CommandType {
+	return "UnregisteredCommand"
+}
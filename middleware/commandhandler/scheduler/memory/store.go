@@ -0,0 +1,201 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/middleware/commandhandler/scheduler"
+)
+
+// Store implements scheduler.Store in memory. Commands are stored encoded to
+// behave as a persistent store.
+type Store struct {
+	// The outer map is with namespace as key, the inner with command ID.
+	db   map[string]map[uuid.UUID]*record
+	dbMu sync.Mutex
+}
+
+// NewStore creates a new Store using memory as storage.
+func NewStore() *Store {
+	return &Store{
+		db: map[string]map[uuid.UUID]*record{},
+	}
+}
+
+type record struct {
+	id          uuid.UUID
+	commandType eh.CommandType
+	data        []byte
+	context     map[string]interface{}
+	executeAt   time.Time
+	leasedUntil time.Time
+	attempts    int
+	invalid     bool
+}
+
+// Add implements the Add method of the scheduler.Store interface.
+func (s *Store) Add(ctx context.Context, sc *scheduler.ScheduledCommand) error {
+	data, err := json.Marshal(sc.Command)
+	if err != nil {
+		return fmt.Errorf("could not encode command: %w", err)
+	}
+
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	s.namespace(ctx)[sc.ID] = &record{
+		id:          sc.ID,
+		commandType: sc.Command.CommandType(),
+		data:        data,
+		context:     sc.Context,
+		executeAt:   sc.ExecuteAt,
+	}
+
+	return nil
+}
+
+// List implements the List method of the scheduler.Store interface.
+func (s *Store) List(ctx context.Context) ([]*scheduler.ScheduledCommand, error) {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	records := s.sorted(ctx, func(r *record) bool { return true }, 0)
+	result := make([]*scheduler.ScheduledCommand, 0, len(records))
+	var invalid []string
+	for _, r := range records {
+		sc, err := r.decode()
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %s", r.id, err))
+			continue
+		}
+		result = append(result, sc)
+	}
+
+	if len(invalid) > 0 {
+		return result, fmt.Errorf("%w: %s", scheduler.ErrInvalidCommand, strings.Join(invalid, ", "))
+	}
+
+	return result, nil
+}
+
+// Remove implements the Remove method of the scheduler.Store interface.
+func (s *Store) Remove(ctx context.Context, id uuid.UUID) error {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	db := s.namespace(ctx)
+	if _, ok := db[id]; !ok {
+		return scheduler.ErrCommandNotFound
+	}
+	delete(db, id)
+
+	return nil
+}
+
+// Claim implements the Claim method of the scheduler.Store interface.
+func (s *Store) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*scheduler.ScheduledCommand, error) {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	records := s.sorted(ctx, func(r *record) bool {
+		return !r.invalid && !r.executeAt.After(now) && !r.leasedUntil.After(now)
+	}, 0)
+
+	var (
+		result  []*scheduler.ScheduledCommand
+		invalid []string
+	)
+	for _, r := range records {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+
+		sc, err := r.decode()
+		if err != nil {
+			r.invalid = true
+			invalid = append(invalid, fmt.Sprintf("%s: %s", r.id, err))
+			continue
+		}
+
+		r.leasedUntil = now.Add(lease)
+		r.attempts++
+		sc.Attempts = r.attempts
+		result = append(result, sc)
+	}
+
+	if len(invalid) > 0 {
+		return result, fmt.Errorf("%w: %s", scheduler.ErrInvalidCommand, strings.Join(invalid, ", "))
+	}
+
+	return result, nil
+}
+
+// sorted returns up to limit (or all if 0) matching records, ordered by
+// execution time. Must be called with the lock held.
+func (s *Store) sorted(ctx context.Context, match func(*record) bool, limit int) []*record {
+	var records []*record
+	for _, r := range s.namespace(ctx) {
+		if match(r) {
+			records = append(records, r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].executeAt.Before(records[j].executeAt)
+	})
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+
+	return records
+}
+
+func (r *record) decode() (*scheduler.ScheduledCommand, error) {
+	cmd, err := eh.CreateCommand(r.commandType)
+	if err != nil {
+		return nil, fmt.Errorf("could not create command %s: %w", r.commandType, err)
+	}
+	if err := json.Unmarshal(r.data, cmd); err != nil {
+		return nil, fmt.Errorf("could not decode command: %w", err)
+	}
+
+	return &scheduler.ScheduledCommand{
+		ID:        r.id,
+		Command:   cmd,
+		Context:   r.context,
+		ExecuteAt: r.executeAt,
+		Attempts:  r.attempts,
+	}, nil
+}
+
+// namespace returns the records for the namespace in the context, must be
+// called with the lock held.
+func (s *Store) namespace(ctx context.Context) map[uuid.UUID]*record {
+	ns := eh.NamespaceFromContext(ctx)
+	if _, ok := s.db[ns]; !ok {
+		s.db[ns] = map[uuid.UUID]*record{}
+	}
+
+	return s.db[ns]
+}
@@ -24,6 +24,8 @@ import (
 
 // NewMiddleware returns a new async handling middleware that returns any errors
 // on a error channel.
+// Scheduled commands are kept in memory and lost on restart, see Scheduler for
+// a durable alternative.
 func NewMiddleware() (eh.CommandHandlerMiddleware, chan Error) {
 	errCh := make(chan Error, 20)
 	return eh.CommandHandlerMiddleware(func(h eh.CommandHandler) eh.CommandHandler {
@@ -92,6 +94,9 @@ type Error struct {
 
 // Error implements the Error method of the error interface.
 func (e Error) Error() string {
+	if e.Command == nil {
+		return e.Err.Error()
+	}
 	return fmt.Sprintf("%s (%s): %s", e.Command.CommandType(), e.Command.AggregateID(), e.Err.Error())
 }
 
@@ -0,0 +1,355 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+
+	// Register uuid.UUID as BSON type.
+	_ "github.com/looplab/eventhorizon/codec/bson"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/middleware/commandhandler/scheduler"
+)
+
+var (
+	// ErrCouldNotDialDB is when the database could not be dialed.
+	ErrCouldNotDialDB = errors.New("could not dial database")
+	// ErrNoDBClient is when no database client is set.
+	ErrNoDBClient = errors.New("no database client")
+	// ErrCouldNotClearDB is when the database could not be cleared.
+	ErrCouldNotClearDB = errors.New("could not clear database")
+	// ErrCouldNotCreateIndex is when the index could not be created.
+	ErrCouldNotCreateIndex = errors.New("could not create index")
+)
+
+// DefaultCollection is the default collection used for scheduled commands.
+const DefaultCollection = "scheduled_commands"
+
+// Store implements scheduler.Store for MongoDB. Commands are encoded as BSON.
+// An index on the execute_at and leased_until fields is created when first
+// claiming commands in a DB.
+type Store struct {
+	client     *mongo.Client
+	dbPrefix   string
+	collection string
+	dbName     func(context.Context) string
+	indexes    map[string]bool
+	indexesMu  sync.Mutex
+}
+
+// NewStore creates a new Store with a MongoDB URI: `mongodb://hostname`.
+func NewStore(uri, dbPrefix string, options ...Option) (*Store, error) {
+	opts := mongoOptions.Client().ApplyURI(uri)
+	opts.SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+	opts.SetReadConcern(readconcern.Majority())
+	opts.SetReadPreference(readpref.Primary())
+	client, err := mongo.Connect(context.TODO(), opts)
+	if err != nil {
+		return nil, ErrCouldNotDialDB
+	}
+
+	return NewStoreWithClient(client, dbPrefix, options...)
+}
+
+// NewStoreWithClient creates a new Store with a client.
+func NewStoreWithClient(client *mongo.Client, dbPrefix string, options ...Option) (*Store, error) {
+	if client == nil {
+		return nil, ErrNoDBClient
+	}
+
+	s := &Store{
+		client:     client,
+		dbPrefix:   dbPrefix,
+		collection: DefaultCollection,
+		indexes:    map[string]bool{},
+	}
+
+	// Use the a prefix and namespace from the context for DB name.
+	s.dbName = func(ctx context.Context) string {
+		ns := eh.NamespaceFromContext(ctx)
+		return dbPrefix + "_" + ns
+	}
+
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Store) error
+
+// WithPrefixAsDBName uses only the prefix as DB name, without namespace support.
+func WithPrefixAsDBName() Option {
+	return func(s *Store) error {
+		s.dbName = func(context.Context) string {
+			return s.dbPrefix
+		}
+		return nil
+	}
+}
+
+// WithDBName uses a custom DB name function.
+func WithDBName(dbName func(context.Context) string) Option {
+	return func(s *Store) error {
+		s.dbName = dbName
+		return nil
+	}
+}
+
+// WithCollection uses a custom collection for the scheduled commands.
+func WithCollection(collection string) Option {
+	return func(s *Store) error {
+		if collection == "" {
+			return fmt.Errorf("missing collection")
+		}
+		s.collection = collection
+		return nil
+	}
+}
+
+// Add implements the Add method of the scheduler.Store interface.
+func (s *Store) Add(ctx context.Context, sc *scheduler.ScheduledCommand) error {
+	data, err := bson.Marshal(sc.Command)
+	if err != nil {
+		return fmt.Errorf("could not encode command: %w", err)
+	}
+
+	c := s.client.Database(s.dbName(ctx)).Collection(s.collection)
+
+	if _, err := c.InsertOne(ctx, record{
+		ID:          sc.ID,
+		CommandType: sc.Command.CommandType(),
+		Command:     data,
+		Context:     sc.Context,
+		ExecuteAt:   sc.ExecuteAt,
+	}); err != nil {
+		return fmt.Errorf("could not add command: %w", err)
+	}
+
+	return nil
+}
+
+// List implements the List method of the scheduler.Store interface.
+func (s *Store) List(ctx context.Context) ([]*scheduler.ScheduledCommand, error) {
+	c := s.client.Database(s.dbName(ctx)).Collection(s.collection)
+
+	cursor, err := c.Find(ctx, bson.M{},
+		mongoOptions.Find().SetSort(bson.M{"execute_at": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("could not list commands: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var (
+		result  []*scheduler.ScheduledCommand
+		invalid []string
+	)
+	for cursor.Next(ctx) {
+		sc, err := decodeRecord(cursor.Current)
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %s", cursor.Current.Lookup("_id"), err))
+			continue
+		}
+		result = append(result, sc)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("could not list commands: %w", err)
+	}
+
+	if len(invalid) > 0 {
+		return result, fmt.Errorf("%w: %s", scheduler.ErrInvalidCommand, strings.Join(invalid, ", "))
+	}
+
+	return result, nil
+}
+
+// Remove implements the Remove method of the scheduler.Store interface.
+func (s *Store) Remove(ctx context.Context, id uuid.UUID) error {
+	c := s.client.Database(s.dbName(ctx)).Collection(s.collection)
+
+	res, err := c.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("could not remove command: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return scheduler.ErrCommandNotFound
+	}
+
+	return nil
+}
+
+// Claim implements the Claim method of the scheduler.Store interface.
+func (s *Store) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*scheduler.ScheduledCommand, error) {
+	if err := s.ensureIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	c := s.client.Database(s.dbName(ctx)).Collection(s.collection)
+
+	// Claim one command at a time, each atomically, as MongoDB can not update
+	// and return many documents in one operation.
+	var (
+		result  []*scheduler.ScheduledCommand
+		invalid []string
+	)
+	for limit <= 0 || len(result) < limit {
+		var raw bson.Raw
+		if err := c.FindOneAndUpdate(ctx,
+			bson.M{
+				"execute_at": bson.M{"$lte": now},
+				"$or": bson.A{
+					bson.M{"leased_until": bson.M{"$exists": false}},
+					bson.M{"leased_until": bson.M{"$lte": now}},
+				},
+				"invalid": bson.M{"$ne": true},
+			},
+			bson.M{
+				"$set": bson.M{"leased_until": now.Add(lease)},
+				"$inc": bson.M{"attempts": 1},
+			},
+			mongoOptions.FindOneAndUpdate().
+				SetSort(bson.M{"execute_at": 1}).
+				SetReturnDocument(mongoOptions.After),
+		).Decode(&raw); err == mongo.ErrNoDocuments {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("could not claim command: %w", err)
+		}
+
+		sc, err := decodeRecord(raw)
+		if err != nil {
+			// Keep the command for inspection, without claiming it again.
+			id := raw.Lookup("_id")
+			if _, err := c.UpdateOne(ctx,
+				bson.M{"_id": id},
+				bson.M{"$set": bson.M{"invalid": true}},
+			); err != nil {
+				return nil, fmt.Errorf("could not mark command as invalid: %w", err)
+			}
+			invalid = append(invalid, fmt.Sprintf("%s: %s", id, err))
+			continue
+		}
+		result = append(result, sc)
+	}
+
+	if len(invalid) > 0 {
+		return result, fmt.Errorf("%w: %s", scheduler.ErrInvalidCommand, strings.Join(invalid, ", "))
+	}
+
+	return result, nil
+}
+
+// ensureIndex creates the index used to claim due commands, once per DB.
+func (s *Store) ensureIndex(ctx context.Context) error {
+	dbName := s.dbName(ctx)
+
+	s.indexesMu.Lock()
+	defer s.indexesMu.Unlock()
+
+	if s.indexes[dbName] {
+		return nil
+	}
+
+	c := s.client.Database(dbName).Collection(s.collection)
+	if _, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "execute_at", Value: 1},
+			{Key: "leased_until", Value: 1},
+		},
+	}); err != nil {
+		return fmt.Errorf("%s: %w", ErrCouldNotCreateIndex, err)
+	}
+	s.indexes[dbName] = true
+
+	return nil
+}
+
+// Clear clears the scheduled commands of the namespace in the context.
+func (s *Store) Clear(ctx context.Context) error {
+	c := s.client.Database(s.dbName(ctx)).Collection(s.collection)
+
+	s.indexesMu.Lock()
+	delete(s.indexes, s.dbName(ctx))
+	s.indexesMu.Unlock()
+
+	if err := c.Drop(ctx); err != nil {
+		return fmt.Errorf("%s: %w", ErrCouldNotClearDB, err)
+	}
+
+	return nil
+}
+
+// Close closes a database session.
+func (s *Store) Close(ctx context.Context) {
+	s.client.Disconnect(ctx)
+}
+
+// record is the Database representation of a scheduled command.
+type record struct {
+	ID          uuid.UUID              `bson:"_id"`
+	CommandType eh.CommandType         `bson:"command_type"`
+	Command     bson.Raw               `bson:"command"`
+	Context     map[string]interface{} `bson:"context"`
+	ExecuteAt   time.Time              `bson:"execute_at"`
+	LeasedUntil *time.Time             `bson:"leased_until,omitempty"`
+	Attempts    int                    `bson:"attempts"`
+	Invalid     bool                   `bson:"invalid,omitempty"`
+}
+
+// decodeRecord decodes a scheduled command from a raw record.
+func decodeRecord(raw bson.Raw) (*scheduler.ScheduledCommand, error) {
+	var r record
+	if err := bson.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("could not decode record: %w", err)
+	}
+
+	return r.toScheduledCommand()
+}
+
+func (r record) toScheduledCommand() (*scheduler.ScheduledCommand, error) {
+	cmd, err := eh.CreateCommand(r.CommandType)
+	if err != nil {
+		return nil, fmt.Errorf("could not create command %s: %w", r.CommandType, err)
+	}
+	if err := bson.Unmarshal(r.Command, cmd); err != nil {
+		return nil, fmt.Errorf("could not decode command: %w", err)
+	}
+
+	return &scheduler.ScheduledCommand{
+		ID:        r.ID,
+		Command:   cmd,
+		Context:   r.Context,
+		ExecuteAt: r.ExecuteAt,
+		Attempts:  r.Attempts,
+	}, nil
+}
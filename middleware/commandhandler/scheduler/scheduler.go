@@ -0,0 +1,321 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+var (
+	// ErrMissingStore is when no store is provided.
+	ErrMissingStore = errors.New("missing store")
+	// ErrMissingHandler is when no command handler is provided.
+	ErrMissingHandler = errors.New("missing command handler")
+	// ErrCommandNotFound is when a scheduled command could not be found.
+	ErrCommandNotFound = errors.New("scheduled command not found")
+	// ErrInvalidCommand is when a stored command could not be decoded.
+	ErrInvalidCommand = errors.New("invalid scheduled command")
+	// ErrMaxAttempts is when a command has been claimed more times than the
+	// max attempts, it is then removed without being executed.
+	ErrMaxAttempts = errors.New("max attempts reached")
+)
+
+// DefaultPollInterval is the default interval for checking for due commands.
+var DefaultPollInterval = time.Second
+
+// DefaultLease is the default time that a claimed command is hidden from other
+// schedulers while it is executed.
+var DefaultLease = time.Minute
+
+// DefaultBatchSize is the default number of due commands claimed at a time.
+var DefaultBatchSize = 100
+
+// ScheduledCommand is a command stored for later execution.
+type ScheduledCommand struct {
+	// ID is the ID of the scheduled command, used to cancel it.
+	ID uuid.UUID
+	// Command is the command to execute. It is stored by its command type and
+	// must be registered with eventhorizon.RegisterCommand.
+	Command eh.Command
+	// Context is the marshaled context of the command, see
+	// eventhorizon.MarshalContext.
+	Context map[string]interface{}
+	// ExecuteAt is the time when the command will execute.
+	ExecuteAt time.Time
+	// Attempts is the number of times the command has been claimed, including
+	// the current claim.
+	Attempts int
+}
+
+// Store is a persistent store of scheduled commands. All methods use the
+// namespace of the context.
+type Store interface {
+	// Add adds a command to be executed at its time.
+	Add(context.Context, *ScheduledCommand) error
+
+	// List returns all scheduled commands, ordered by execution time. Stored
+	// commands that can not be decoded are skipped and reported with an error
+	// wrapping ErrInvalidCommand, which is returned together with the valid
+	// commands.
+	List(context.Context) ([]*ScheduledCommand, error)
+
+	// Remove removes a scheduled command, or returns ErrCommandNotFound.
+	Remove(context.Context, uuid.UUID) error
+
+	// Claim returns up to limit commands that are due at a time and that are
+	// not claimed by someone else, ordered by execution time. The returned
+	// commands are leased until now plus the lease duration, after which
+	// they can be claimed again if they have not been removed, and their
+	// attempts are incremented.
+	//
+	// Stored commands that can not be decoded are kept in the store but are
+	// not claimed again. They are reported with an error wrapping
+	// ErrInvalidCommand, which is returned together with the valid commands.
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*ScheduledCommand, error)
+}
+
+// Scheduler is a durable command scheduler. Scheduled commands are saved in a
+// store and executed by polling for due commands. Execution is at least once:
+// a command is removed after it has been handled, and is retried after the
+// lease if it fails or if the scheduler stops, up to the max attempts if set.
+// Multiple schedulers can share a store by claiming commands.
+//
+// A scheduler handles the namespace of the context it is started with.
+type Scheduler struct {
+	store        Store
+	handler      eh.CommandHandler
+	pollInterval time.Duration
+	lease        time.Duration
+	batchSize    int
+	maxAttempts  int
+	errCh        chan Error
+	done         chan struct{}
+}
+
+// NewScheduler creates a scheduler that executes commands from a store on a
+// command handler, usually a command bus.
+func NewScheduler(store Store, handler eh.CommandHandler, options ...Option) (*Scheduler, error) {
+	if store == nil {
+		return nil, ErrMissingStore
+	}
+	if handler == nil {
+		return nil, ErrMissingHandler
+	}
+
+	s := &Scheduler{
+		store:        store,
+		handler:      handler,
+		pollInterval: DefaultPollInterval,
+		lease:        DefaultLease,
+		batchSize:    DefaultBatchSize,
+		errCh:        make(chan Error, 100),
+		done:         make(chan struct{}),
+	}
+
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Scheduler) error
+
+// WithPollInterval sets the interval for checking for due commands.
+func WithPollInterval(interval time.Duration) Option {
+	return func(s *Scheduler) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid poll interval: %s", interval)
+		}
+		s.pollInterval = interval
+		return nil
+	}
+}
+
+// WithLease sets the time that a claimed command is hidden from others while
+// it is executed, which should be longer than the time to handle it.
+func WithLease(lease time.Duration) Option {
+	return func(s *Scheduler) error {
+		if lease <= 0 {
+			return fmt.Errorf("invalid lease: %s", lease)
+		}
+		s.lease = lease
+		return nil
+	}
+}
+
+// WithBatchSize sets the number of due commands claimed at a time.
+func WithBatchSize(size int) Option {
+	return func(s *Scheduler) error {
+		if size <= 0 {
+			return fmt.Errorf("invalid batch size: %d", size)
+		}
+		s.batchSize = size
+		return nil
+	}
+}
+
+// WithMaxAttempts sets the number of times a command is claimed for execution
+// before it is given up on. Commands that have failed that many times are
+// removed and sent on the error channel with ErrMaxAttempts. The default is to
+// retry until the command succeeds.
+func WithMaxAttempts(attempts int) Option {
+	return func(s *Scheduler) error {
+		if attempts <= 0 {
+			return fmt.Errorf("invalid max attempts: %d", attempts)
+		}
+		s.maxAttempts = attempts
+		return nil
+	}
+}
+
+// Middleware returns a middleware that schedules commands that implement
+// Command with an execution time, and handles other commands immediately.
+func (s *Scheduler) Middleware() eh.CommandHandlerMiddleware {
+	return eh.CommandHandlerMiddleware(func(h eh.CommandHandler) eh.CommandHandler {
+		return eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+			if c, ok := cmd.(Command); ok && !c.ExecuteAt().IsZero() {
+				if wrapped, ok := c.(*command); ok {
+					cmd = wrapped.Command
+				}
+				_, err := s.Schedule(ctx, cmd, c.ExecuteAt())
+				return err
+			}
+
+			return h.HandleCommand(ctx, cmd)
+		})
+	})
+}
+
+// Schedule saves a command to be executed at a time, with the values of the
+// context. Returns the ID of the scheduled command.
+func (s *Scheduler) Schedule(ctx context.Context, cmd eh.Command, executeAt time.Time) (uuid.UUID, error) {
+	sc := &ScheduledCommand{
+		ID:        uuid.New(),
+		Command:   cmd,
+		Context:   eh.MarshalContext(ctx),
+		ExecuteAt: executeAt,
+	}
+	if err := s.store.Add(ctx, sc); err != nil {
+		return uuid.Nil, fmt.Errorf("could not schedule command: %w", err)
+	}
+
+	return sc.ID, nil
+}
+
+// List returns all scheduled commands in the namespace of the context. Commands
+// that can not be decoded are reported with an error wrapping ErrInvalidCommand,
+// together with the valid commands, and can still be cancelled by ID.
+func (s *Scheduler) List(ctx context.Context) ([]*ScheduledCommand, error) {
+	return s.store.List(ctx)
+}
+
+// Cancel cancels a scheduled command by ID.
+func (s *Scheduler) Cancel(ctx context.Context, id uuid.UUID) error {
+	return s.store.Remove(ctx, id)
+}
+
+// Start polls for due commands in the background until the context is
+// cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.RunDue(ctx, time.Now()); err != nil {
+					s.error(Error{Err: err, Ctx: ctx})
+				}
+			}
+		}
+	}()
+}
+
+// Errors returns an error channel where execution errors are sent.
+func (s *Scheduler) Errors() <-chan Error {
+	return s.errCh
+}
+
+// Wait waits for the polling to stop after its context is cancelled.
+func (s *Scheduler) Wait() {
+	<-s.done
+}
+
+// RunDue claims and executes all commands that are due at a time. Errors for
+// single commands are sent on the error channel.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) error {
+	for {
+		due, err := s.store.Claim(ctx, now, s.lease, s.batchSize)
+		if errors.Is(err, ErrInvalidCommand) {
+			// Report the invalid commands and execute the valid ones.
+			s.error(Error{Err: err, Ctx: ctx})
+		} else if err != nil {
+			return fmt.Errorf("could not claim commands: %w", err)
+		}
+
+		for _, sc := range due {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			cmdCtx := eh.UnmarshalContext(ctx, sc.Context)
+			if s.maxAttempts > 0 && sc.Attempts > s.maxAttempts {
+				if err := s.store.Remove(ctx, sc.ID); err != nil && !errors.Is(err, ErrCommandNotFound) {
+					s.error(Error{Err: fmt.Errorf("could not remove command: %w", err), Ctx: cmdCtx, Command: sc.Command})
+					continue
+				}
+				s.error(Error{Err: ErrMaxAttempts, Ctx: cmdCtx, Command: sc.Command})
+				continue
+			}
+
+			if err := s.handler.HandleCommand(cmdCtx, sc.Command); err != nil {
+				s.error(Error{Err: err, Ctx: cmdCtx, Command: sc.Command})
+				continue
+			}
+			if err := s.store.Remove(ctx, sc.ID); err != nil && !errors.Is(err, ErrCommandNotFound) {
+				s.error(Error{Err: fmt.Errorf("could not remove command: %w", err), Ctx: cmdCtx, Command: sc.Command})
+			}
+		}
+
+		if len(due) < s.batchSize {
+			return nil
+		}
+	}
+}
+
+func (s *Scheduler) error(err Error) {
+	select {
+	case s.errCh <- err:
+	default:
+		log.Printf("eventhorizon: missed error in command scheduler: %s", err)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestScheduler(t *testing.T) {
+	inner := &mocks.CommandHandler{}
+	store := &mapStore{}
+	if _, err := NewScheduler(nil, inner); !errors.Is(err, ErrMissingStore) {
+		t.Error("there should be a missing store error:", err)
+	}
+	if _, err := NewScheduler(store, nil); !errors.Is(err, ErrMissingHandler) {
+		t.Error("there should be a missing handler error:", err)
+	}
+	if _, err := NewScheduler(store, inner, WithLease(0)); err == nil {
+		t.Error("there should be an error for an invalid lease")
+	}
+
+	s, err := NewScheduler(store, inner, WithBatchSize(1))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	h := eh.UseCommandHandlerMiddleware(inner, s.Middleware())
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+
+	// Commands without an execution time should be handled immediately.
+	cmd := &mocks.Command{ID: uuid.New(), Content: "now"}
+	if err := h.HandleCommand(ctx, cmd); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(inner.Commands) != 1 {
+		t.Error("the command should be handled:", inner.Commands)
+	}
+	inner.Commands = nil
+
+	// Commands with an execution time should be stored.
+	now := time.Now()
+	cmd1 := &mocks.Command{ID: uuid.New(), Content: "cmd1"}
+	if err := h.HandleCommand(ctx, CommandWithExecuteTime(cmd1, now.Add(time.Second))); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	cmd2 := &mocks.Command{ID: uuid.New(), Content: "cmd2"}
+	if err := h.HandleCommand(ctx, CommandWithExecuteTime(cmd2, now.Add(2*time.Second))); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	cmd3 := &mocks.Command{ID: uuid.New(), Content: "cmd3"}
+	id3, err := s.Schedule(ctx, cmd3, now.Add(time.Second))
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	list, err := s.List(ctx)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(list) != 3 || list[0].Command != cmd1 {
+		t.Error("the commands should be scheduled:", list)
+	}
+	if len(inner.Commands) != 0 {
+		t.Error("the commands should not be handled yet:", inner.Commands)
+	}
+
+	// Cancelled commands should not be executed.
+	if err := s.Cancel(ctx, id3); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := s.Cancel(ctx, id3); !errors.Is(err, ErrCommandNotFound) {
+		t.Error("there should be a command not found error:", err)
+	}
+
+	// Due commands should be executed with their context and removed.
+	if err := s.RunDue(context.Background(), now.Add(3*time.Second)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(inner.Commands) != 2 || inner.Commands[0] != cmd1 || inner.Commands[1] != cmd2 {
+		t.Error("the commands should be handled:", inner.Commands)
+	}
+	if ns := eh.NamespaceFromContext(inner.Context); ns != "ns" {
+		t.Error("the context should be correct:", ns)
+	}
+	if list, err := s.List(ctx); err != nil || len(list) != 0 {
+		t.Error("the commands should be removed:", list, err)
+	}
+
+	// Failing commands should be kept and retried after the lease.
+	inner.Commands = nil
+	inner.Err = errors.New("handler error")
+	if _, err := s.Schedule(ctx, cmd1, now); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := s.RunDue(ctx, now); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	select {
+	case err := <-s.Errors():
+		if !errors.Is(err, inner.Err) || err.Command != cmd1 {
+			t.Error("there should be a handler error:", err)
+		}
+	default:
+		t.Error("there should be an error")
+	}
+	inner.Err = nil
+	if err := s.RunDue(ctx, now); err != nil || len(inner.Commands) != 0 {
+		t.Error("the command should be leased:", inner.Commands, err)
+	}
+	if err := s.RunDue(ctx, now.Add(2*DefaultLease)); err != nil || len(inner.Commands) != 1 {
+		t.Error("the command should be retried:", inner.Commands, err)
+	}
+
+	// Store errors should be returned.
+	store.err = errors.New("store error")
+	if _, err := s.Schedule(ctx, cmd1, now); !errors.Is(err, store.err) {
+		t.Error("there should be a store error:", err)
+	}
+	if err := s.RunDue(ctx, now); !errors.Is(err, store.err) {
+		t.Error("there should be a store error:", err)
+	}
+}
+
+func TestSchedulerMaxAttempts(t *testing.T) {
+	inner := &mocks.CommandHandler{Err: errors.New("handler error")}
+	store := &mapStore{}
+	if _, err := NewScheduler(store, inner, WithMaxAttempts(0)); err == nil {
+		t.Error("there should be an error for invalid max attempts")
+	}
+	s, err := NewScheduler(store, inner, WithMaxAttempts(2))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	ctx := context.Background()
+	now := time.Now()
+
+	cmd := &mocks.Command{ID: uuid.New(), Content: "cmd"}
+	if _, err := s.Schedule(ctx, cmd, now); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Failing commands should be given up on after the max attempts.
+	for i := 0; i < 3; i++ {
+		if err := s.RunDue(ctx, now.Add(time.Duration(i)*2*DefaultLease)); err != nil {
+			t.Error("there should be no error:", err)
+		}
+		err := <-s.Errors()
+		if i < 2 && !errors.Is(err, inner.Err) {
+			t.Error("there should be a handler error:", err)
+		} else if i == 2 && (!errors.Is(err, ErrMaxAttempts) || err.Command != cmd) {
+			t.Error("there should be a max attempts error:", err)
+		}
+	}
+	if len(inner.Commands) != 0 {
+		t.Error("the command should not be handled:", inner.Commands)
+	}
+	if list, err := s.List(ctx); err != nil || len(list) != 0 {
+		t.Error("the command should be removed:", list, err)
+	}
+
+	// Invalid commands should be reported, and valid commands executed.
+	inner.Err = nil
+	store.claimErr = fmt.Errorf("%w: bad", ErrInvalidCommand)
+	if _, err := s.Schedule(ctx, cmd, now); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := s.RunDue(ctx, now); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := <-s.Errors(); !errors.Is(err, ErrInvalidCommand) {
+		t.Error("there should be an invalid command error:", err)
+	}
+	if len(inner.Commands) != 1 {
+		t.Error("the command should be handled:", inner.Commands)
+	}
+}
+
+func TestSchedulerPolling(t *testing.T) {
+	inner := &mocks.CommandHandler{}
+	s, err := NewScheduler(&mapStore{}, inner, WithPollInterval(5*time.Millisecond))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+
+	if _, err := s.Schedule(ctx, &mocks.Command{ID: uuid.New()}, time.Now().Add(10*time.Millisecond)); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	s.Wait()
+
+	inner.RLock()
+	defer inner.RUnlock()
+	if len(inner.Commands) != 1 {
+		t.Error("the command should be handled:", inner.Commands)
+	}
+}
+
+// mapStore is a minimal Store used for testing the scheduler, ignoring
+// namespaces and encoding.
+type mapStore struct {
+	sync.Mutex
+	commands []*ScheduledCommand
+	leases   map[uuid.UUID]time.Time
+	err      error
+	claimErr error
+}
+
+func (s *mapStore) Add(ctx context.Context, sc *ScheduledCommand) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.commands = append(s.commands, sc)
+	sort.SliceStable(s.commands, func(i, j int) bool {
+		return s.commands[i].ExecuteAt.Before(s.commands[j].ExecuteAt)
+	})
+	return nil
+}
+
+func (s *mapStore) List(ctx context.Context) ([]*ScheduledCommand, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return append([]*ScheduledCommand{}, s.commands...), s.err
+}
+
+func (s *mapStore) Remove(ctx context.Context, id uuid.UUID) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, sc := range s.commands {
+		if sc.ID == id {
+			s.commands = append(s.commands[:i], s.commands[i+1:]...)
+			return nil
+		}
+	}
+	return ErrCommandNotFound
+}
+
+func (s *mapStore) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*ScheduledCommand, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.leases == nil {
+		s.leases = map[uuid.UUID]time.Time{}
+	}
+	var claimed []*ScheduledCommand
+	for _, sc := range s.commands {
+		if len(claimed) == limit {
+			break
+		}
+		if sc.ExecuteAt.After(now) || s.leases[sc.ID].After(now) {
+			continue
+		}
+		s.leases[sc.ID] = now.Add(lease)
+		sc.Attempts++
+		claimed = append(claimed, sc)
+	}
+	return claimed, s.claimErr
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// StoreAcceptanceTest is the acceptance test that all implementations of
+// Store should pass. It should manually be called from a test case in each
+// implementation:
+//
+//	func TestStore(t *testing.T) {
+//	    store, err := NewStore()
+//	    if err != nil {
+//	        t.Fatal("there should be no error:", err)
+//	    }
+//	    inbox.StoreAcceptanceTest(t, store)
+//	}
+func StoreAcceptanceTest(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	calls := 0
+	fn := func(context.Context) error {
+		calls++
+		return nil
+	}
+	handle := func(ctx context.Context, ht eh.EventHandlerType, event eh.Event, expected int) {
+		t.Helper()
+
+		calls = 0
+		if err := store.Handle(ctx, ht, event, fn); err != nil {
+			t.Error("there should be no error:", err)
+		}
+		if calls != expected {
+			t.Error("the handler should be called the correct number of times:", calls, expected)
+		}
+	}
+
+	id := uuid.New()
+	event1 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, id, 1))
+	event2 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, id, 2))
+
+	// An event should be handled once per handler type.
+	handle(ctx, "handler", event1, 1)
+	handle(ctx, "handler", event1, 0)
+	handle(ctx, "other", event1, 1)
+	handle(ctx, "handler", event2, 1)
+
+	// Events should be handled once per namespace.
+	nsCtx := eh.NewContextWithNamespace(ctx, "ns")
+	handle(nsCtx, "handler", event1, 1)
+	handle(nsCtx, "handler", event1, 0)
+
+	// Failed events should not be recorded.
+	handlerErr := errors.New("handler error")
+	event3 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event3"}, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, id, 3))
+	if err := store.Handle(ctx, "handler", event3, func(context.Context) error {
+		return handlerErr
+	}); !errors.Is(err, handlerErr) {
+		t.Error("there should be a handler error:", err)
+	}
+	handle(ctx, "handler", event3, 1)
+	handle(ctx, "handler", event3, 0)
+
+	// Concurrent handling of the same event should fail.
+	event4 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event4"}, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, id, 4))
+	if err := store.Handle(ctx, "handler", event4, func(ctx context.Context) error {
+		return store.Handle(ctx, "handler", event4, fn)
+	}); !errors.Is(err, ErrEventInProgress) {
+		t.Error("there should be an event in progress error:", err)
+	}
+	handle(ctx, "handler", event4, 1)
+}
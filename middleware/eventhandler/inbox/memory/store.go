@@ -0,0 +1,120 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/middleware/eventhandler/inbox"
+)
+
+// DefaultMaxSize is the default number of handled events that are remembered.
+const DefaultMaxSize = 100000
+
+// Store implements inbox.Store in memory. Concurrent handling of the same event
+// by the same handler type returns inbox.ErrEventInProgress. Only the most
+// recently handled events are remembered, up to the max size.
+type Store struct {
+	handled    map[key]*list.Element
+	order      *list.List
+	inProgress map[key]bool
+	maxSize    int
+	mu         sync.Mutex
+}
+
+type key struct {
+	namespace   string
+	handlerType eh.EventHandlerType
+	id          uuid.UUID
+	version     int
+}
+
+// NewStore creates a new Store using memory as storage.
+func NewStore(options ...Option) (*Store, error) {
+	s := &Store{
+		handled:    map[key]*list.Element{},
+		order:      list.New(),
+		inProgress: map[key]bool{},
+		maxSize:    DefaultMaxSize,
+	}
+
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Store) error
+
+// WithMaxSize sets the number of handled events that are remembered, which
+// must be at least 1. The oldest handled events are forgotten first, and are
+// handled again if they are delivered again.
+func WithMaxSize(maxSize int) Option {
+	return func(s *Store) error {
+		if maxSize <= 0 {
+			return fmt.Errorf("invalid max size: %d", maxSize)
+		}
+		s.maxSize = maxSize
+		return nil
+	}
+}
+
+// Handle implements the Handle method of the inbox.Store interface.
+func (s *Store) Handle(ctx context.Context, handlerType eh.EventHandlerType, event eh.Event, fn func(context.Context) error) error {
+	k := key{
+		namespace:   eh.NamespaceFromContext(ctx),
+		handlerType: handlerType,
+		id:          event.AggregateID(),
+		version:     event.Version(),
+	}
+
+	s.mu.Lock()
+	if _, ok := s.handled[k]; ok {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.inProgress[k] {
+		s.mu.Unlock()
+		return inbox.ErrEventInProgress
+	}
+	s.inProgress[k] = true
+	s.mu.Unlock()
+
+	err := fn(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inProgress, k)
+	if err == nil {
+		s.handled[k] = s.order.PushBack(k)
+		for s.order.Len() > s.maxSize {
+			oldest := s.order.Front()
+			s.order.Remove(oldest)
+			delete(s.handled, oldest.Value.(key))
+		}
+	}
+
+	return err
+}
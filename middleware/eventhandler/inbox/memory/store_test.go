@@ -0,0 +1,71 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/middleware/eventhandler/inbox"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestStore(t *testing.T) {
+	store, err := NewStore()
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	inbox.StoreAcceptanceTest(t, store)
+
+	for _, size := range []int{0, -1} {
+		if _, err := NewStore(WithMaxSize(size)); err == nil {
+			t.Error("there should be an error for an invalid max size:", size)
+		}
+	}
+
+	// The oldest handled events should be forgotten.
+	store, err = NewStore(WithMaxSize(2))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	id := uuid.New()
+	events := make([]eh.Event, 3)
+	for i := range events {
+		events[i] = eh.NewEvent(mocks.EventType, nil, time.Now(),
+			eh.ForAggregate(mocks.AggregateType, id, i+1))
+	}
+	calls := 0
+	fn := func(context.Context) error {
+		calls++
+		return nil
+	}
+	for _, event := range events {
+		if err := store.Handle(context.Background(), "handler", event, fn); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if err := store.Handle(context.Background(), "handler", events[i], fn); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	if calls != 4 {
+		t.Error("only the oldest event should be handled again:", calls)
+	}
+}
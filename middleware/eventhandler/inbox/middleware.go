@@ -0,0 +1,65 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inbox implements an event handler middleware that handles each event
+// only once per handler, for event buses that deliver events at least once.
+package inbox
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrEventInProgress is when the same event is being handled concurrently by
+// the same handler type, the event should be delivered again later.
+var ErrEventInProgress = errors.New("event in progress")
+
+// Store records the events that have been handled, by handler type, aggregate
+// ID and version. Records are kept per namespace.
+type Store interface {
+	// Handle calls fn unless the event has already been handled by the handler
+	// type, and records the event as handled if fn succeeds. Stores that support
+	// it record the event in the same transaction as fn, which is then called
+	// with a context for the transaction.
+	Handle(ctx context.Context, handlerType eh.EventHandlerType, event eh.Event, fn func(context.Context) error) error
+}
+
+// NewMiddleware returns a new inbox middleware that skips events that have
+// already been handled by the handler type, according to the store. Events
+// without an aggregate ID are always handled.
+func NewMiddleware(store Store) eh.EventHandlerMiddleware {
+	return eh.EventHandlerMiddleware(func(h eh.EventHandler) eh.EventHandler {
+		return &eventHandler{h, store}
+	})
+}
+
+type eventHandler struct {
+	eh.EventHandler
+	store Store
+}
+
+// HandleEvent implements the HandleEvent method of the EventHandler.
+func (h *eventHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	if event.AggregateID() == uuid.Nil {
+		return h.EventHandler.HandleEvent(ctx, event)
+	}
+
+	return h.store.Handle(ctx, h.HandlerType(), event, func(ctx context.Context) error {
+		return h.EventHandler.HandleEvent(ctx, event)
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestMiddleware(t *testing.T) {
+	inner := mocks.NewEventHandler("handler")
+	store := mapStore{}
+	h := eh.UseEventHandlerMiddleware(inner, NewMiddleware(store))
+
+	// Redelivered events should be handled once.
+	event := eh.NewEvent(mocks.EventType, nil, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	for i := 0; i < 2; i++ {
+		if err := h.HandleEvent(context.Background(), event); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	if len(inner.Events) != 1 {
+		t.Error("the event should be handled once:", inner.Events)
+	}
+	if !store[fmt.Sprintf("handler/%s/1", event.AggregateID())] {
+		t.Error("the event should be recorded:", store)
+	}
+
+	// Failed events should be handled again.
+	handlerErr := errors.New("handler error")
+	inner.Err = handlerErr
+	event = eh.NewEvent(mocks.EventType, nil, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, event.AggregateID(), 2))
+	if err := h.HandleEvent(context.Background(), event); !errors.Is(err, handlerErr) {
+		t.Error("there should be a handler error:", err)
+	}
+	inner.Err = nil
+	if err := h.HandleEvent(context.Background(), event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(inner.Events) != 2 {
+		t.Error("the event should be handled:", inner.Events)
+	}
+
+	// Events without an aggregate should always be handled.
+	event = eh.NewEvent(mocks.EventType, nil, time.Now())
+	for i := 0; i < 2; i++ {
+		if err := h.HandleEvent(context.Background(), event); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	if len(inner.Events) != 4 {
+		t.Error("the event should be handled twice:", inner.Events)
+	}
+}
+
+// mapStore is a minimal Store used for testing the middleware.
+type mapStore map[string]bool
+
+func (s mapStore) Handle(ctx context.Context, ht eh.EventHandlerType, event eh.Event, fn func(context.Context) error) error {
+	key := fmt.Sprintf("%s/%s/%d", ht, event.AggregateID(), event.Version())
+	if s[key] {
+		return nil
+	}
+	if err := fn(ctx); err != nil {
+		return err
+	}
+	s[key] = true
+	return nil
+}
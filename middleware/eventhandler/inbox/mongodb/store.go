@@ -0,0 +1,306 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+
+	// Register uuid.UUID as BSON type.
+	_ "github.com/looplab/eventhorizon/codec/bson"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/middleware/eventhandler/inbox"
+	"github.com/looplab/eventhorizon/mongoutils"
+)
+
+var (
+	// ErrCouldNotDialDB is when the database could not be dialed.
+	ErrCouldNotDialDB = errors.New("could not dial database")
+	// ErrNoDBClient is when no database client is set.
+	ErrNoDBClient = errors.New("no database client")
+	// ErrCouldNotClearDB is when the database could not be cleared.
+	ErrCouldNotClearDB = errors.New("could not clear database")
+)
+
+// DefaultCollection is the default collection used for the records.
+const DefaultCollection = "inbox"
+
+// DefaultLease is the default duration an event is claimed for while it is
+// being handled without transactions.
+const DefaultLease = time.Minute
+
+// Store implements inbox.Store for MongoDB.
+type Store struct {
+	client          *mongo.Client
+	dbPrefix        string
+	collection      string
+	dbName          func(context.Context) string
+	lease           time.Duration
+	useTransactions bool
+}
+
+// NewStore creates a new Store with a MongoDB URI: `mongodb://hostname`.
+func NewStore(uri, dbPrefix string, options ...Option) (*Store, error) {
+	opts := mongoOptions.Client().ApplyURI(uri)
+	opts.SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+	opts.SetReadConcern(readconcern.Majority())
+	opts.SetReadPreference(readpref.Primary())
+	client, err := mongo.Connect(context.TODO(), opts)
+	if err != nil {
+		return nil, ErrCouldNotDialDB
+	}
+
+	return NewStoreWithClient(client, dbPrefix, options...)
+}
+
+// NewStoreWithClient creates a new Store with a client.
+func NewStoreWithClient(client *mongo.Client, dbPrefix string, options ...Option) (*Store, error) {
+	if client == nil {
+		return nil, ErrNoDBClient
+	}
+
+	s := &Store{
+		client:     client,
+		dbPrefix:   dbPrefix,
+		collection: DefaultCollection,
+		lease:      DefaultLease,
+	}
+
+	// Use the a prefix and namespace from the context for DB name.
+	s.dbName = func(ctx context.Context) string {
+		ns := eh.NamespaceFromContext(ctx)
+		return dbPrefix + "_" + ns
+	}
+
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Store) error
+
+// WithPrefixAsDBName uses only the prefix as DB name, without namespace support.
+func WithPrefixAsDBName() Option {
+	return func(s *Store) error {
+		s.dbName = func(context.Context) string {
+			return s.dbPrefix
+		}
+		return nil
+	}
+}
+
+// WithDBName uses a custom DB name function.
+func WithDBName(dbName func(context.Context) string) Option {
+	return func(s *Store) error {
+		s.dbName = dbName
+		return nil
+	}
+}
+
+// WithCollection uses a custom collection for the records.
+func WithCollection(collection string) Option {
+	return func(s *Store) error {
+		if collection == "" {
+			return fmt.Errorf("missing collection")
+		}
+		s.collection = collection
+		return nil
+	}
+}
+
+// WithLease sets how long an event is claimed for while it is being handled
+// without transactions. A claim held by a stopped process can be taken over
+// after the lease, which should be longer than the time it takes to handle an
+// event.
+func WithLease(lease time.Duration) Option {
+	return func(s *Store) error {
+		if lease <= 0 {
+			return fmt.Errorf("invalid lease: %s", lease)
+		}
+		s.lease = lease
+		return nil
+	}
+}
+
+// WithTransactions records events in a transaction together with handling them.
+// The handler is called with a mongo.SessionContext, any writes it makes with
+// the same client and that context are committed atomically with the record.
+// The transaction is not retried on transient errors, which are returned for
+// the event to be delivered again, so that the handler is called once per
+// delivery. Transactions require a MongoDB replica set.
+func WithTransactions() Option {
+	return func(s *Store) error {
+		s.useTransactions = true
+		return nil
+	}
+}
+
+// Handle implements the Handle method of the inbox.Store interface. Without
+// transactions the event is first claimed by inserting its record, which makes
+// concurrent handling of the same event return inbox.ErrEventInProgress. The
+// claim is marked as handled if fn succeeds and removed if it fails.
+func (s *Store) Handle(ctx context.Context, handlerType eh.EventHandlerType, event eh.Event, fn func(context.Context) error) error {
+	c := s.client.Database(s.dbName(ctx)).Collection(s.collection)
+	r := record{
+		ID:          fmt.Sprintf("%s/%s/%d", handlerType, event.AggregateID(), event.Version()),
+		HandlerType: handlerType,
+		AggregateID: event.AggregateID(),
+		Version:     event.Version(),
+	}
+
+	if s.useTransactions {
+		return s.handleInTransaction(ctx, c, r, fn)
+	}
+
+	now := time.Now()
+	r.Token = uuid.New()
+	r.ClaimedUntil = now.Add(s.lease)
+	if _, err := c.InsertOne(ctx, r); mongoutils.IsDuplicateKeyError(err) {
+		// Take over the claim if its lease has expired.
+		res, err := c.UpdateOne(ctx,
+			bson.M{
+				"_id":           r.ID,
+				"handled":       false,
+				"claimed_until": bson.M{"$lt": now},
+			},
+			bson.M{"$set": bson.M{
+				"token":         r.Token,
+				"claimed_until": r.ClaimedUntil,
+			}},
+		)
+		if err != nil {
+			return fmt.Errorf("could not claim event: %w", err)
+		}
+
+		if res.MatchedCount == 0 {
+			var existing record
+			if err := c.FindOne(ctx, bson.M{"_id": r.ID}).Decode(&existing); err == mongo.ErrNoDocuments {
+				// The claim was released after the insert failed.
+				return inbox.ErrEventInProgress
+			} else if err != nil {
+				return fmt.Errorf("could not find record: %w", err)
+			}
+
+			if existing.Handled {
+				return nil
+			}
+
+			return inbox.ErrEventInProgress
+		}
+	} else if err != nil {
+		return fmt.Errorf("could not claim event: %w", err)
+	}
+
+	if err := fn(ctx); err != nil {
+		// A claim that could not be removed expires after the lease.
+		_, _ = c.DeleteOne(ctx, bson.M{"_id": r.ID, "token": r.Token})
+
+		return err
+	}
+
+	if _, err := c.UpdateOne(ctx,
+		bson.M{"_id": r.ID, "token": r.Token},
+		bson.M{"$set": bson.M{
+			"handled":    true,
+			"handled_at": time.Now(),
+		}},
+	); err != nil {
+		return fmt.Errorf("could not record event: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) handleInTransaction(ctx context.Context, c *mongo.Collection, r record, fn func(context.Context) error) error {
+	sess, err := s.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("could not start session: %w", err)
+	}
+	defer sess.EndSession(ctx)
+
+	return mongo.WithSession(ctx, sess, func(sessCtx mongo.SessionContext) error {
+		if err := sess.StartTransaction(); err != nil {
+			return fmt.Errorf("could not start transaction: %w", err)
+		}
+
+		r.Handled = true
+		r.HandledAt = time.Now()
+		if _, err := c.InsertOne(sessCtx, r); mongoutils.IsDuplicateKeyError(err) {
+			_ = sess.AbortTransaction(sessCtx)
+
+			return nil
+		} else if err != nil {
+			_ = sess.AbortTransaction(sessCtx)
+
+			return fmt.Errorf("could not record event: %w", err)
+		}
+
+		if err := fn(sessCtx); err != nil {
+			_ = sess.AbortTransaction(sessCtx)
+
+			return err
+		}
+
+		if err := sess.CommitTransaction(sessCtx); err != nil {
+			return fmt.Errorf("could not commit transaction: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// Clear clears the records of the namespace in the context.
+func (s *Store) Clear(ctx context.Context) error {
+	c := s.client.Database(s.dbName(ctx)).Collection(s.collection)
+
+	if err := c.Drop(ctx); err != nil {
+		return fmt.Errorf("%s: %w", ErrCouldNotClearDB, err)
+	}
+
+	return nil
+}
+
+// Close closes a database session.
+func (s *Store) Close(ctx context.Context) {
+	s.client.Disconnect(ctx)
+}
+
+// record is the Database representation of a handled event.
+type record struct {
+	ID           string              `bson:"_id"`
+	HandlerType  eh.EventHandlerType `bson:"handler_type"`
+	AggregateID  uuid.UUID           `bson:"aggregate_id"`
+	Version      int                 `bson:"version"`
+	Token        uuid.UUID           `bson:"token"`
+	ClaimedUntil time.Time           `bson:"claimed_until"`
+	Handled      bool                `bson:"handled"`
+	HandledAt    time.Time           `bson:"handled_at"`
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ordered implements an event handler middleware that handles events
+// concurrently with a pool of workers, while keeping the order of the events
+// of each aggregate.
+package ordered
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrStopped is when an event is handled after the dispatcher has stopped.
+var ErrStopped = errors.New("dispatcher stopped")
+
+// DefaultWorkers is the default number of workers per handler.
+var DefaultWorkers = 8
+
+// DefaultQueueSize is the default number of queued events per worker.
+var DefaultQueueSize = 10
+
+// Dispatcher partitions events by namespace and aggregate ID over a pool of
+// workers per handler. The events of an aggregate are always handled by the
+// same worker, in the order they were received, while the events of different
+// aggregates are handled in parallel. Receiving events blocks when the queue
+// of a worker is full, which bounds the work in flight.
+//
+// When the context of the dispatcher is cancelled no more events are accepted
+// and the workers finish the events already queued, see Wait.
+type Dispatcher struct {
+	ctx       context.Context
+	workers   int
+	queueSize int
+	errCh     chan eh.EventBusError
+	wg        sync.WaitGroup
+}
+
+// NewDispatcher creates a new Dispatcher that runs until the context is cancelled.
+func NewDispatcher(ctx context.Context, options ...Option) (*Dispatcher, error) {
+	d := &Dispatcher{
+		ctx:       ctx,
+		workers:   DefaultWorkers,
+		queueSize: DefaultQueueSize,
+		errCh:     make(chan eh.EventBusError, 100),
+	}
+
+	for _, option := range options {
+		if err := option(d); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return d, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Dispatcher) error
+
+// WithWorkers sets the number of workers per handler.
+func WithWorkers(workers int) Option {
+	return func(d *Dispatcher) error {
+		if workers <= 0 {
+			return fmt.Errorf("invalid number of workers: %d", workers)
+		}
+		d.workers = workers
+		return nil
+	}
+}
+
+// WithQueueSize sets the number of events that can be queued per worker.
+func WithQueueSize(size int) Option {
+	return func(d *Dispatcher) error {
+		if size < 0 {
+			return fmt.Errorf("invalid queue size: %d", size)
+		}
+		d.queueSize = size
+		return nil
+	}
+}
+
+// Middleware is an eventhorizon.EventHandlerMiddleware that dispatches the
+// events of the handler over a pool of workers. Handling errors are sent on the
+// error channel of the dispatcher, as the events are handled asynchronously.
+func (d *Dispatcher) Middleware(h eh.EventHandler) eh.EventHandler {
+	w := &eventHandler{
+		EventHandler: h,
+		d:            d,
+		queues:       make([]chan queued, d.workers),
+	}
+
+	for i := range w.queues {
+		w.queues[i] = make(chan queued, d.queueSize)
+		d.wg.Add(1)
+		go w.work(w.queues[i])
+	}
+
+	// Stop accepting events and let the workers drain their queues when the
+	// dispatcher is stopped.
+	go func() {
+		<-d.ctx.Done()
+		w.queuesMu.Lock()
+		defer w.queuesMu.Unlock()
+		w.stopped = true
+		for _, q := range w.queues {
+			close(q)
+		}
+	}()
+
+	return w
+}
+
+// Errors returns an error channel where handling errors are sent.
+func (d *Dispatcher) Errors() <-chan eh.EventBusError {
+	return d.errCh
+}
+
+// Wait waits for all queued events to be handled after the context of the
+// dispatcher is cancelled.
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+type queued struct {
+	ctx   context.Context
+	event eh.Event
+}
+
+type eventHandler struct {
+	eh.EventHandler
+	d        *Dispatcher
+	queues   []chan queued
+	queuesMu sync.RWMutex
+	stopped  bool
+}
+
+// HandleEvent implements the HandleEvent method of the EventHandler, queueing
+// the event for the worker of its aggregate.
+func (h *eventHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	h.queuesMu.RLock()
+	defer h.queuesMu.RUnlock()
+
+	if h.stopped {
+		return ErrStopped
+	}
+
+	select {
+	case h.queues[h.partition(ctx, event)] <- queued{ctx, event}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// partition returns the worker for the namespace and aggregate of an event.
+func (h *eventHandler) partition(ctx context.Context, event eh.Event) int {
+	id := event.AggregateID()
+	f := fnv.New32a()
+	f.Write([]byte(eh.NamespaceFromContext(ctx)))
+	f.Write(id[:])
+	return int(f.Sum32() % uint32(len(h.queues)))
+}
+
+func (h *eventHandler) work(queue <-chan queued) {
+	defer h.d.wg.Done()
+
+	for q := range queue {
+		if err := h.EventHandler.HandleEvent(q.ctx, q.event); err != nil {
+			err = fmt.Errorf("could not handle event (%s): %w", h.HandlerType(), err)
+			select {
+			case h.d.errCh <- eh.EventBusError{Err: err, Ctx: q.ctx, Event: q.event}:
+			default:
+				log.Printf("eventhorizon: missed error in ordered dispatcher: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ordered
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestMiddleware(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d, err := NewDispatcher(ctx, WithWorkers(4), WithQueueSize(2))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	inner := &recordingHandler{versions: map[uuid.UUID][]int{}}
+	h := eh.UseEventHandlerMiddleware(inner, d.Middleware)
+	if h.HandlerType() != inner.HandlerType() {
+		t.Error("the handler type should be kept:", h.HandlerType())
+	}
+
+	// Publish the events of many aggregates interleaved.
+	ids := make([]uuid.UUID, 10)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+	for v := 1; v <= 10; v++ {
+		for _, id := range ids {
+			event := eh.NewEvent(mocks.EventType, nil, time.Now(),
+				eh.ForAggregate(mocks.AggregateType, id, v))
+			if err := h.HandleEvent(context.Background(), event); err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+		}
+	}
+
+	// All queued events should be handled after stopping.
+	cancel()
+	d.Wait()
+	event := eh.NewEvent(mocks.EventType, nil, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, ids[0], 11))
+	if err := h.HandleEvent(context.Background(), event); !errors.Is(err, ErrStopped) {
+		t.Error("there should be a stopped error:", err)
+	}
+
+	// The events of each aggregate should be handled in order.
+	for _, id := range ids {
+		versions := inner.versions[id]
+		if len(versions) != 10 {
+			t.Fatal("all events should be handled:", versions)
+		}
+		for i, v := range versions {
+			if v != i+1 {
+				t.Error("the events should be handled in order:", versions)
+				break
+			}
+		}
+	}
+	if inner.maxRunning < 2 {
+		t.Error("the events should be handled in parallel:", inner.maxRunning)
+	}
+}
+
+func TestMiddlewareErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d, err := NewDispatcher(ctx)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	inner := mocks.NewEventHandler("handler")
+	handlerErr := errors.New("handler error")
+	inner.Err = handlerErr
+	h := eh.UseEventHandlerMiddleware(inner, d.Middleware)
+
+	event := eh.NewEvent(mocks.EventType, nil, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	if err := h.HandleEvent(context.Background(), event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	select {
+	case err := <-d.Errors():
+		if !errors.Is(err, handlerErr) || err.Event != event {
+			t.Error("the error should be correct:", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("there should be an error")
+	}
+
+	if _, err := NewDispatcher(ctx, WithWorkers(0)); err == nil {
+		t.Error("there should be an error")
+	}
+}
+
+// recordingHandler records the versions of handled events per aggregate, and
+// the max number of concurrently handled events.
+type recordingHandler struct {
+	sync.Mutex
+	versions   map[uuid.UUID][]int
+	running    int32
+	maxRunning int32
+}
+
+func (h *recordingHandler) HandlerType() eh.EventHandlerType {
+	return "recording"
+}
+
+func (h *recordingHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	n := atomic.AddInt32(&h.running, 1)
+	defer atomic.AddInt32(&h.running, -1)
+	time.Sleep(time.Millisecond)
+
+	h.Lock()
+	defer h.Unlock()
+	if n > h.maxRunning {
+		h.maxRunning = n
+	}
+	h.versions[event.AggregateID()] = append(h.versions[event.AggregateID()], event.Version())
+	return nil
+}
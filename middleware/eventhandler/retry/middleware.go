@@ -0,0 +1,124 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retry implements an event handler middleware that retries handling
+// events that fail with transient errors.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jpillora/backoff"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// DefaultMaxAttempts is the default max number of attempts to handle an event.
+var DefaultMaxAttempts = 5
+
+// DefaultBackoff returns the default backoff, exponential from 100ms up to
+// 10s with jitter.
+func DefaultBackoff() *backoff.Backoff {
+	return &backoff.Backoff{
+		Min:    100 * time.Millisecond,
+		Max:    10 * time.Second,
+		Factor: 2,
+		Jitter: true,
+	}
+}
+
+// Policy is the policy for retrying events.
+type Policy struct {
+	// MaxAttempts is the max number of times to handle an event, including the
+	// first attempt. Uses DefaultMaxAttempts if less than 1.
+	MaxAttempts int
+	// Backoff is used to get the delay before each retry, using the attempt
+	// number starting at 0 for the first retry. Uses DefaultBackoff if nil.
+	Backoff *backoff.Backoff
+	// IsRetryable returns true for errors that should be retried. Uses
+	// IsTransient if nil.
+	IsRetryable func(error) bool
+}
+
+// IsTransient returns true for errors that are likely to succeed on a retry:
+// incorrect entity versions in projections, conflicting saves to the event
+// store, deadlines and errors that report themselves as temporary or timeouts.
+func IsTransient(err error) bool {
+	if errors.Is(err, eh.ErrIncorrectEntityVersion) ||
+		errors.Is(err, eh.ErrEventConflictFromOtherSave) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+
+	return false
+}
+
+// NewMiddleware returns a new retry middleware that handles events again, after
+// a delay, when the handler returns a retryable error. The last error is
+// returned if all attempts fail. Waiting for a retry is cancelled with the
+// context of the event, returning the last error.
+func NewMiddleware(policy Policy) eh.EventHandlerMiddleware {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = DefaultMaxAttempts
+	}
+	if policy.Backoff == nil {
+		policy.Backoff = DefaultBackoff()
+	}
+	if policy.IsRetryable == nil {
+		policy.IsRetryable = IsTransient
+	}
+
+	return eh.EventHandlerMiddleware(func(h eh.EventHandler) eh.EventHandler {
+		return &eventHandler{h, policy}
+	})
+}
+
+type eventHandler struct {
+	eh.EventHandler
+	policy Policy
+}
+
+// HandleEvent implements the HandleEvent method of the EventHandler.
+func (h *eventHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	for attempt := 1; ; attempt++ {
+		err := h.EventHandler.HandleEvent(ctx, event)
+		if err == nil || attempt >= h.policy.MaxAttempts || !h.policy.IsRetryable(err) {
+			if err != nil && attempt > 1 {
+				return fmt.Errorf("failed after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+
+		// The attempt number of the backoff starts at 0 for the first retry.
+		delay := time.NewTimer(h.policy.Backoff.ForAttempt(float64(attempt - 1)))
+		select {
+		case <-ctx.Done():
+			delay.Stop()
+			return err
+		case <-delay.C:
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jpillora/backoff"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestMiddleware(t *testing.T) {
+	inner := &failingHandler{EventHandler: mocks.NewEventHandler("handler")}
+	m := NewMiddleware(Policy{
+		MaxAttempts: 3,
+		Backoff:     &backoff.Backoff{Min: time.Millisecond, Max: 10 * time.Millisecond, Jitter: true},
+	})
+	h := eh.UseEventHandlerMiddleware(inner, m)
+	event := eh.NewEvent(mocks.EventType, nil, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	conflict := eh.RepoError{Err: eh.ErrIncorrectEntityVersion}
+
+	// Transient errors should be retried.
+	inner.errs = []error{conflict, conflict}
+	if err := h.HandleEvent(context.Background(), event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if inner.attempts != 3 || len(inner.Events) != 1 {
+		t.Error("the event should be handled on the third attempt:", inner.attempts)
+	}
+
+	// The last error should be returned after the last attempt.
+	inner.attempts = 0
+	inner.errs = []error{conflict, conflict, conflict}
+	if err := h.HandleEvent(context.Background(), event); !errors.Is(err, eh.ErrIncorrectEntityVersion) {
+		t.Error("there should be an incorrect entity version error:", err)
+	}
+	if inner.attempts != 3 {
+		t.Error("the event should be handled three times:", inner.attempts)
+	}
+
+	// Other errors should not be retried.
+	inner.attempts = 0
+	otherErr := errors.New("other error")
+	inner.errs = []error{otherErr}
+	if err := h.HandleEvent(context.Background(), event); err != otherErr {
+		t.Error("there should be an other error:", err)
+	}
+	if inner.attempts != 1 {
+		t.Error("the event should be handled once:", inner.attempts)
+	}
+
+	// Waiting should be cancelled with the context.
+	h = eh.UseEventHandlerMiddleware(inner, NewMiddleware(Policy{
+		Backoff: &backoff.Backoff{Min: time.Hour, Max: time.Hour},
+		IsRetryable: func(err error) bool {
+			return err == otherErr
+		},
+	}))
+	inner.attempts = 0
+	inner.errs = []error{otherErr}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := h.HandleEvent(ctx, event); err != otherErr {
+		t.Error("there should be an other error:", err)
+	}
+	if inner.attempts != 1 {
+		t.Error("the event should be handled once:", inner.attempts)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	if !IsTransient(eh.RepoError{Err: eh.ErrIncorrectEntityVersion}) {
+		t.Error("incorrect entity versions should be transient")
+	}
+	if !IsTransient(eh.EventStoreError{Err: eh.ErrEventConflictFromOtherSave}) {
+		t.Error("event conflicts should be transient")
+	}
+	if !IsTransient(context.DeadlineExceeded) {
+		t.Error("deadlines should be transient")
+	}
+	if !IsTransient(timeoutError{}) {
+		t.Error("timeouts should be transient")
+	}
+	if IsTransient(errors.New("error")) {
+		t.Error("other errors should not be transient")
+	}
+}
+
+// failingHandler returns the errors in order before handling events.
+type failingHandler struct {
+	*mocks.EventHandler
+	errs     []error
+	attempts int
+}
+
+func (h *failingHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	h.attempts++
+	if len(h.errs) > 0 {
+		err := h.errs[0]
+		h.errs = h.errs[1:]
+		return err
+	}
+	return h.EventHandler.HandleEvent(ctx, event)
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "timeout" }
+func (timeoutError) Timeout() bool { return true }
@@ -29,6 +29,7 @@ func init() {
 	})
 
 	eh.RegisterEventData(EventType, func() eh.EventData { return &EventData{} })
+	eh.RegisterSnapshotData(AggregateType, func() eh.SnapshotData { return &SnapshotData{} })
 }
 
 const (
@@ -98,6 +99,11 @@ type EventData struct {
 	Content string
 }
 
+// SnapshotData is a mocked snapshot state, useful in testing.
+type SnapshotData struct {
+	Content string
+}
+
 // Command is a mocked eventhorizon.Command, useful in testing.
 type Command struct {
 	ID      uuid.UUID
@@ -0,0 +1,49 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mongoutils contains helpers shared by the MongoDB implementations.
+package mongoutils
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// duplicateKeyCode is the MongoDB error code of a duplicate key.
+const duplicateKeyCode = 11000
+
+// IsDuplicateKeyError returns true if the error is caused by writing a document
+// with a unique key that already exists, for single and bulk writes.
+func IsDuplicateKeyError(err error) bool {
+	var e mongo.WriteException
+	if errors.As(err, &e) {
+		for _, we := range e.WriteErrors {
+			if we.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+
+	var be mongo.BulkWriteException
+	if errors.As(err, &be) {
+		for _, we := range be.WriteErrors {
+			if we.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+
+	return false
+}
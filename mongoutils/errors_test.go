@@ -0,0 +1,65 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongoutils
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestIsDuplicateKeyError(t *testing.T) {
+	testCases := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"nil": {
+			nil,
+			false,
+		},
+		"other error": {
+			errors.New("error"),
+			false,
+		},
+		"write exception": {
+			mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}},
+			true,
+		},
+		"other write exception": {
+			mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 1}}},
+			false,
+		},
+		"bulk write exception": {
+			mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+				{WriteError: mongo.WriteError{Code: 11000}},
+			}},
+			true,
+		},
+		"wrapped": {
+			fmt.Errorf("wrapped: %w", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}),
+			true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if IsDuplicateKeyError(tc.err) != tc.expected {
+				t.Error("the result should be correct:", tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package outbox implements a relay that publishes the unpublished events in
+// the outbox of an event store, making publishing reliable when used together
+// with events.WithOutbox for the aggregate store.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+var (
+	// ErrMissingStore is when no event store is provided.
+	ErrMissingStore = errors.New("missing event store")
+	// ErrMissingHandler is when no event handler is provided.
+	ErrMissingHandler = errors.New("missing event handler")
+)
+
+// DefaultPollInterval is the default interval for checking the outbox.
+var DefaultPollInterval = time.Second
+
+// DefaultMinAge is the default age of unpublished events before they are
+// published by the relay, giving the aggregate store time to publish them.
+var DefaultMinAge = 5 * time.Second
+
+// DefaultBatchSize is the default number of events loaded at a time.
+var DefaultBatchSize = 100
+
+// Relay publishes the events in the outbox of an event store to an event
+// handler, usually an event bus, and marks them as published. Delivery is at
+// least once: an event can be published again if the relay stops before
+// marking it, or if it is published by the aggregate store at the same time.
+//
+// A relay handles the namespace of the context it is started with.
+type Relay struct {
+	store        eh.EventStoreOutbox
+	handler      eh.EventHandler
+	pollInterval time.Duration
+	minAge       time.Duration
+	batchSize    int
+	errCh        chan eh.EventBusError
+	done         chan struct{}
+}
+
+// NewRelay creates a relay that publishes events from the outbox of a store
+// to an event handler.
+func NewRelay(store eh.EventStoreOutbox, handler eh.EventHandler, options ...Option) (*Relay, error) {
+	if store == nil {
+		return nil, ErrMissingStore
+	}
+	if handler == nil {
+		return nil, ErrMissingHandler
+	}
+
+	r := &Relay{
+		store:        store,
+		handler:      handler,
+		pollInterval: DefaultPollInterval,
+		minAge:       DefaultMinAge,
+		batchSize:    DefaultBatchSize,
+		errCh:        make(chan eh.EventBusError, 100),
+		done:         make(chan struct{}),
+	}
+
+	for _, option := range options {
+		if err := option(r); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return r, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Relay) error
+
+// WithPollInterval sets the interval for checking the outbox.
+func WithPollInterval(interval time.Duration) Option {
+	return func(r *Relay) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid poll interval: %s", interval)
+		}
+		r.pollInterval = interval
+		return nil
+	}
+}
+
+// WithMinAge sets the age of unpublished events before they are published,
+// to not publish events that the aggregate store is about to publish.
+func WithMinAge(age time.Duration) Option {
+	return func(r *Relay) error {
+		if age < 0 {
+			return fmt.Errorf("invalid min age: %s", age)
+		}
+		r.minAge = age
+		return nil
+	}
+}
+
+// WithBatchSize sets the number of events loaded from the outbox at a time.
+func WithBatchSize(size int) Option {
+	return func(r *Relay) error {
+		if size <= 0 {
+			return fmt.Errorf("invalid batch size: %d", size)
+		}
+		r.batchSize = size
+		return nil
+	}
+}
+
+// Start first runs a recovery scan that publishes all events older than the
+// min age, for example saved by a process that stopped before it could
+// publish them. The relay then polls the outbox in the background
+// until the context is cancelled. Returns an error if the recovery scan
+// could not read the outbox.
+func (r *Relay) Start(ctx context.Context) error {
+	if err := r.relay(ctx, time.Now().Add(-r.minAge)); err != nil {
+		close(r.done)
+		return fmt.Errorf("could not recover outbox: %w", err)
+	}
+
+	go r.run(ctx)
+
+	return nil
+}
+
+// Errors returns an error channel where publishing errors are sent.
+func (r *Relay) Errors() <-chan eh.EventBusError {
+	return r.errCh
+}
+
+// Wait waits for the relay to stop after its context is cancelled.
+func (r *Relay) Wait() {
+	<-r.done
+}
+
+func (r *Relay) run(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.relay(ctx, time.Now().Add(-r.minAge)); err != nil {
+				r.error(ctx, err, nil)
+			}
+		}
+	}
+}
+
+// relay publishes the events saved before a time, until the outbox is empty
+// or no more events could be published. After a failure the events of an
+// aggregate are skipped for the rest of the pass, to keep the order of its
+// events and to not retry the failed event for every batch.
+func (r *Relay) relay(ctx context.Context, savedBefore time.Time) error {
+	failed := map[uuid.UUID]bool{}
+	for {
+		unpublished, err := r.store.LoadUnpublished(ctx, savedBefore, r.batchSize)
+		if err != nil {
+			return fmt.Errorf("could not load unpublished events: %w", err)
+		}
+
+		published := 0
+		for _, u := range unpublished {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if failed[u.Event.AggregateID()] {
+				continue
+			}
+
+			if err := r.handler.HandleEvent(u.Ctx, u.Event); err != nil {
+				r.error(u.Ctx, fmt.Errorf("could not publish event (%s): %w",
+					r.handler.HandlerType(), err), u.Event)
+				failed[u.Event.AggregateID()] = true
+				continue
+			}
+			if err := r.store.MarkPublished(ctx, u.Event); err != nil {
+				r.error(u.Ctx, fmt.Errorf("could not mark event as published: %w", err), u.Event)
+				failed[u.Event.AggregateID()] = true
+				continue
+			}
+			published++
+		}
+
+		if len(unpublished) < r.batchSize || published == 0 {
+			return nil
+		}
+	}
+}
+
+func (r *Relay) error(ctx context.Context, err error, event eh.Event) {
+	select {
+	case r.errCh <- eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
+	default:
+		log.Printf("eventhorizon: missed error in outbox relay: %s", err)
+	}
+}
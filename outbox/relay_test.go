@@ -0,0 +1,195 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestNewRelay(t *testing.T) {
+	store := memory.NewEventStore(memory.WithOutbox())
+	h := mocks.NewEventHandler("handler")
+	if _, err := NewRelay(nil, h); err != ErrMissingStore {
+		t.Error("there should be a missing store error:", err)
+	}
+	if _, err := NewRelay(store, nil); err != ErrMissingHandler {
+		t.Error("there should be a missing handler error:", err)
+	}
+	if _, err := NewRelay(store, h, WithBatchSize(0)); err == nil {
+		t.Error("there should be an option error")
+	}
+}
+
+func TestRelay(t *testing.T) {
+	store := memory.NewEventStore(memory.WithOutbox())
+	h := mocks.NewEventHandler("handler")
+	r, err := NewRelay(store, h,
+		WithPollInterval(10*time.Millisecond),
+		WithMinAge(0),
+		WithBatchSize(2),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Events saved before starting should be published by the recovery scan.
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	id := uuid.New()
+	save(t, ctx, store, id, 0, 3)
+
+	ctx, cancel := context.WithCancel(ctx)
+	if err := r.Start(ctx); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	checkVersions(t, h, 1, 2, 3)
+	if ns := eh.NamespaceFromContext(h.Context); ns != "ns" {
+		t.Error("the namespace should be correct:", ns)
+	}
+	checkUnpublished(t, ctx, store, 0)
+
+	// Failed events should be kept and retried.
+	handlerErr := errors.New("handler error")
+	h.Lock()
+	h.Err = handlerErr
+	h.Unlock()
+	save(t, ctx, store, id, 3, 1)
+	select {
+	case err := <-r.Errors():
+		if !errors.Is(err, handlerErr) {
+			t.Error("the error should be correct:", err)
+		}
+		if err.Event == nil || err.Event.Version() != 4 {
+			t.Error("the event should be correct:", err.Event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("there should be an error")
+	}
+	checkUnpublished(t, ctx, store, 1)
+
+	h.Lock()
+	h.Err = nil
+	h.Unlock()
+	if !waitForVersions(h, 4) {
+		t.Error("the event should be published")
+	}
+	checkVersions(t, h, 1, 2, 3, 4)
+
+	cancel()
+	r.Wait()
+	checkUnpublished(t, context.Background(), store, 0)
+}
+
+func TestRelaySkipFailed(t *testing.T) {
+	store := memory.NewEventStore(memory.WithOutbox())
+	handlerErr := errors.New("handler error")
+	failedID := uuid.New()
+	var handled []eh.Event
+	h := eh.EventHandlerFunc(func(ctx context.Context, event eh.Event) error {
+		if event.AggregateID() == failedID {
+			return handlerErr
+		}
+		handled = append(handled, event)
+		return nil
+	})
+	r, err := NewRelay(store, h, WithMinAge(0), WithBatchSize(2))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	save(t, ctx, store, failedID, 0, 1)
+	save(t, ctx, store, uuid.New(), 0, 3)
+
+	// The failed event should only be tried once per pass, while the events
+	// of other aggregates are published.
+	if err := r.relay(ctx, time.Now()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(handled) != 3 {
+		t.Error("the other events should be published:", handled)
+	}
+	if len(r.errCh) != 1 {
+		t.Error("there should be one error:", len(r.errCh))
+	}
+	checkUnpublished(t, ctx, store, 1)
+}
+
+func save(t *testing.T, ctx context.Context, store eh.EventStore, id uuid.UUID, version, count int) {
+	t.Helper()
+
+	events := make([]eh.Event, count)
+	for i := range events {
+		events[i] = eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"},
+			time.Now(), eh.ForAggregate(mocks.AggregateType, id, version+i+1))
+	}
+	if err := store.Save(ctx, events, version); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+}
+
+func waitForVersions(h *mocks.EventHandler, count int) bool {
+	timeout := time.After(time.Second)
+	for {
+		h.RLock()
+		n := len(h.Events)
+		h.RUnlock()
+		if n >= count {
+			return true
+		}
+
+		select {
+		case <-h.Recv:
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+func checkVersions(t *testing.T, h *mocks.EventHandler, versions ...int) {
+	t.Helper()
+
+	h.RLock()
+	defer h.RUnlock()
+	if len(h.Events) != len(versions) {
+		t.Fatal("there should be the correct number of events:", h.Events)
+	}
+	for i, v := range versions {
+		if h.Events[i].Version() != v {
+			t.Error("the event version should be correct:", h.Events[i])
+		}
+	}
+}
+
+func checkUnpublished(t *testing.T, ctx context.Context, store eh.EventStoreOutbox, count int) {
+	t.Helper()
+
+	ctx = eh.NewContextWithNamespace(ctx, "ns")
+	unpublished, err := store.LoadUnpublished(ctx, time.Now().Add(time.Second), 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(unpublished) != count {
+		t.Error("there should be the correct number of unpublished events:", unpublished)
+	}
+}
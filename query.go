@@ -0,0 +1,96 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+)
+
+// QueryRepo is an optional interface for a ReadRepo that can query entities
+// by their fields, with sorting and pagination.
+type QueryRepo interface {
+	ReadRepo
+
+	// Query returns the entities matching a query, and a cursor for the next
+	// page if there are more entities.
+	Query(context.Context, Query) (*QueryResult, error)
+}
+
+// Query is a backend neutral query for entities in a QueryRepo.
+//
+// Fields are named as in the stored documents, for example by the JSON tags for
+// the memory repo and the BSON tags for the MongoDB repo, with dots for nested
+// fields. Models that should work with several repos should use the same names.
+type Query struct {
+	// Filters are the predicates that entities must match, all of them.
+	Filters []Filter
+	// Sort is the sort order, with the entity ID used to order equal entities.
+	Sort []SortField
+	// Limit is the max number of entities to return, or 0 for all.
+	Limit int
+	// Cursor is the cursor from a previous result with the same filters and
+	// sort order, to get the next page.
+	Cursor string
+}
+
+// Filter is a predicate on a field of an entity.
+type Filter struct {
+	Field string
+	Op    FilterOp
+	// Value is the value to compare with, or a slice of values for FilterIn.
+	Value interface{}
+}
+
+// FilterOp is a comparison operator for a Filter.
+type FilterOp string
+
+const (
+	// FilterEq matches fields equal to the value.
+	FilterEq FilterOp = "eq"
+	// FilterNe matches fields not equal to the value.
+	FilterNe FilterOp = "ne"
+	// FilterLt matches fields less than the value.
+	FilterLt FilterOp = "lt"
+	// FilterLte matches fields less than or equal to the value.
+	FilterLte FilterOp = "lte"
+	// FilterGt matches fields greater than the value.
+	FilterGt FilterOp = "gt"
+	// FilterGte matches fields greater than or equal to the value.
+	FilterGte FilterOp = "gte"
+	// FilterIn matches fields equal to one of the values.
+	FilterIn FilterOp = "in"
+)
+
+// SortField is the sort order for a field.
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// QueryResult is a page of entities from a query.
+type QueryResult struct {
+	// Entities are the matching entities in the sort order.
+	Entities []Entity
+	// Cursor is used to get the next page, empty if there are no more entities.
+	Cursor string
+}
+
+var (
+	// ErrInvalidQuery is when a query has an invalid filter or cursor.
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrQueryNotSupported is when a repo does not support queries.
+	ErrQueryNotSupported = errors.New("query not supported")
+)
@@ -49,6 +49,32 @@ type ReadWriteRepo interface {
 	WriteRepo
 }
 
+// BatchWriteRepo is an optional interface for a WriteRepo that can save many
+// entities in one operation, for example when a projector updates several
+// entities for one event.
+type BatchWriteRepo interface {
+	WriteRepo
+
+	// SaveAll saves a list of entities in the storage.
+	SaveAll(context.Context, []Entity) error
+}
+
+// SaveAll saves a list of entities in a repo, in one operation if the repo is
+// a BatchWriteRepo and otherwise one at a time, stopping at the first error.
+func SaveAll(ctx context.Context, repo WriteRepo, entities []Entity) error {
+	if repo, ok := repo.(BatchWriteRepo); ok {
+		return repo.SaveAll(ctx, entities)
+	}
+
+	for _, entity := range entities {
+		if err := repo.Save(ctx, entity); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Versionable is an item that has a version number,
 // used by version.ReadRepo.FindMinVersion().
 type Versionable interface {
@@ -67,6 +93,60 @@ type Iter interface {
 	Close(context.Context) error
 }
 
+// Iterable is an optional interface for a ReadRepo that can stream all its
+// entities, instead of loading them all at once as FindAll.
+type Iterable interface {
+	ReadRepo
+
+	// FindAllIter returns an iterator over all entities in the repository,
+	// with an Entity as the value.
+	FindAllIter(context.Context) (Iter, error)
+}
+
+// FindAllIter returns an iterator over all entities in a repo, which streams
+// the entities if the repo is Iterable and otherwise iterates over FindAll.
+func FindAllIter(ctx context.Context, repo ReadRepo) (Iter, error) {
+	if repo, ok := repo.(Iterable); ok {
+		return repo.FindAllIter(ctx)
+	}
+
+	entities, err := repo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sliceIter{entities: entities, pos: -1}, nil
+}
+
+// sliceIter is an Iter over a slice of entities.
+type sliceIter struct {
+	entities []Entity
+	pos      int
+}
+
+// Next implements the Next method of the Iter interface.
+func (i *sliceIter) Next(ctx context.Context) bool {
+	if i.pos+1 >= len(i.entities) {
+		return false
+	}
+	i.pos++
+	return true
+}
+
+// Value implements the Value method of the Iter interface.
+func (i *sliceIter) Value() interface{} {
+	if i.pos < 0 || i.pos >= len(i.entities) {
+		return nil
+	}
+	return i.entities[i.pos]
+}
+
+// Close implements the Close method of the Iter interface.
+func (i *sliceIter) Close(ctx context.Context) error {
+	i.entities = nil
+	return nil
+}
+
 var (
 	// ErrEntityNotFound is when a entity could not be found.
 	ErrEntityNotFound = errors.New("could not find entity")
@@ -16,6 +16,8 @@ package repo
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
@@ -158,4 +160,311 @@ func AcceptanceTest(t *testing.T, ctx context.Context, repo eh.ReadWriteRepo) {
 	if rrErr, ok := err.(eh.RepoError); !ok || rrErr.Err != eh.ErrEntityNotFound {
 		t.Error("there should be a ErrEntityNotFound error:", err)
 	}
+
+	// Save items in a batch, if supported.
+	if batchRepo, ok := repo.(eh.BatchWriteRepo); ok {
+		if err := batchRepo.SaveAll(ctx, []eh.Entity{entityMissingID}); err == nil {
+			t.Error("there should be an error for a missing ID")
+		}
+
+		entity3 := &mocks.Model{
+			ID:        uuid.New(),
+			Content:   "entity3",
+			CreatedAt: time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC),
+		}
+		entity4 := &mocks.Model{
+			ID:        uuid.New(),
+			Content:   "entity4",
+			CreatedAt: time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC),
+		}
+		if err := batchRepo.SaveAll(ctx, []eh.Entity{entity3, entity4}); err != nil {
+			t.Error("there should be no error:", err)
+		}
+		for _, e := range []*mocks.Model{entity3, entity4} {
+			entity, err := repo.Find(ctx, e.ID)
+			if err != nil {
+				t.Error("there should be no error:", err)
+			}
+			if !reflect.DeepEqual(entity, e) {
+				t.Error("the item should be correct:", entity)
+			}
+			if err := repo.Remove(ctx, e.ID); err != nil {
+				t.Error("there should be no error:", err)
+			}
+		}
+	}
+
+	// Iterate over all items, if supported.
+	if iterable, ok := repo.(eh.Iterable); ok {
+		all, err := repo.FindAll(ctx)
+		if err != nil {
+			t.Error("there should be no error:", err)
+		}
+
+		iter, err := iterable.FindAllIter(ctx)
+		if err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		var iterated []eh.Entity
+		for iter.Next(ctx) {
+			entity, ok := iter.Value().(eh.Entity)
+			if !ok {
+				t.Error("the value should be an entity:", iter.Value())
+			}
+			iterated = append(iterated, entity)
+		}
+		if err := iter.Close(ctx); err != nil {
+			t.Error("there should be no error:", err)
+		}
+		if len(iterated) != len(all) {
+			t.Error("all items should be iterated:", len(iterated), len(all))
+		}
+		for _, e := range all {
+			found := false
+			for _, i := range iterated {
+				if reflect.DeepEqual(e, i) {
+					found = true
+				}
+			}
+			if !found {
+				t.Error("the item should be iterated:", e)
+			}
+		}
+	}
+
+	// Query items, if supported.
+	if queryRepo, ok := repo.(eh.QueryRepo); ok {
+		queryAcceptanceTest(t, ctx, repo, queryRepo)
+	}
+}
+
+func queryAcceptanceTest(t *testing.T, ctx context.Context, repo eh.ReadWriteRepo, queryRepo eh.QueryRepo) {
+	if _, err := queryRepo.Query(ctx, eh.Query{}); errors.Is(err, eh.ErrQueryNotSupported) {
+		return
+	}
+
+	// Query only the items saved here, returning the versions.
+	contents := []string{"a", "b", "b", "c", "d"}
+	query := func(q eh.Query) ([]int, string) {
+		t.Helper()
+
+		q.Filters = append([]eh.Filter{{Field: "content", Op: eh.FilterIn, Value: contents}}, q.Filters...)
+		result, err := queryRepo.Query(ctx, q)
+		if err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		versions := []int{}
+		for _, e := range result.Entities {
+			versions = append(versions, e.(*mocks.Model).Version)
+		}
+		return versions, result.Cursor
+	}
+
+	// Save items, with fields named the same by JSON and BSON.
+	created := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	var ids []uuid.UUID
+	for i, content := range contents {
+		id := uuid.New()
+		if err := repo.Save(ctx, &mocks.Model{
+			ID:        id,
+			Version:   i + 1,
+			Content:   content,
+			CreatedAt: created.Add(time.Duration(i) * time.Hour),
+		}); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		ids = append(ids, id)
+	}
+	defer func() {
+		for _, id := range ids {
+			if err := repo.Remove(ctx, id); err != nil {
+				t.Error("there should be no error:", err)
+			}
+		}
+	}()
+
+	// Query with filters.
+	sortByVersion := []eh.SortField{{Field: "version"}}
+	for _, tc := range []struct {
+		filters  []eh.Filter
+		versions []int
+	}{
+		{nil, []int{1, 2, 3, 4, 5}},
+		{[]eh.Filter{{Field: "content", Op: eh.FilterEq, Value: "b"}}, []int{2, 3}},
+		{[]eh.Filter{{Field: "content", Op: eh.FilterNe, Value: "b"}}, []int{1, 4, 5}},
+		{[]eh.Filter{{Field: "content", Op: eh.FilterIn, Value: []string{"a", "d"}}}, []int{1, 5}},
+		{[]eh.Filter{
+			{Field: "version", Op: eh.FilterGt, Value: 2},
+			{Field: "version", Op: eh.FilterLte, Value: 4},
+		}, []int{3, 4}},
+		{[]eh.Filter{
+			{Field: "version", Op: eh.FilterGte, Value: 2},
+			{Field: "version", Op: eh.FilterLt, Value: 3},
+		}, []int{2}},
+		{[]eh.Filter{{Field: "created_at", Op: eh.FilterGte, Value: created.Add(2 * time.Hour)}}, []int{3, 4, 5}},
+		{[]eh.Filter{{Field: "content", Op: eh.FilterEq, Value: "x"}}, []int{}},
+	} {
+		versions, cursor := query(eh.Query{Filters: tc.filters, Sort: sortByVersion})
+		if !reflect.DeepEqual(versions, tc.versions) {
+			t.Error("the items should be correct:", tc.filters, versions)
+		}
+		if cursor != "" {
+			t.Error("there should be no cursor:", cursor)
+		}
+	}
+
+	// Query with descending sort order and pages.
+	q := eh.Query{Sort: []eh.SortField{{Field: "version", Descending: true}}, Limit: 2}
+	var pages [][]int
+	for {
+		versions, cursor := query(q)
+		pages = append(pages, versions)
+		if cursor == "" {
+			break
+		}
+		q.Cursor = cursor
+	}
+	if !reflect.DeepEqual(pages, [][]int{{5, 4}, {3, 2}, {1}}) {
+		t.Error("the pages should be correct:", pages)
+	}
+
+	// Query pages with equal sort values, ordered by ID.
+	q = eh.Query{
+		Filters: []eh.Filter{{Field: "content", Op: eh.FilterNe, Value: "d"}},
+		Sort:    []eh.SortField{{Field: "content"}},
+		Limit:   1,
+	}
+	var all []int
+	for {
+		versions, cursor := query(q)
+		all = append(all, versions...)
+		if cursor == "" {
+			break
+		}
+		q.Cursor = cursor
+	}
+	if len(all) != 4 || all[0] != 1 || all[3] != 4 ||
+		!((all[1] == 2 && all[2] == 3) || (all[1] == 3 && all[2] == 2)) {
+		t.Error("all items should be paged in order:", all)
+	}
+
+	// Query with invalid filters and cursors.
+	if _, err := queryRepo.Query(ctx, eh.Query{
+		Filters: []eh.Filter{{Field: "version", Op: "invalid", Value: 1}},
+	}); !errors.Is(err, eh.ErrInvalidQuery) {
+		t.Error("there should be an invalid query error:", err)
+	}
+	if _, err := queryRepo.Query(ctx, eh.Query{Cursor: "invalid"}); !errors.Is(err, eh.ErrInvalidQuery) {
+		t.Error("there should be an invalid query error:", err)
+	}
+}
+
+// VersionAcceptanceTest is the acceptance test for repos that check the
+// version of eventhorizon.Versionable entities when saving, with or without
+// a strict version check. It should manually be called from a test case in
+// each implementation that supports it:
+//
+//	func TestRepoVersionCheck(t *testing.T) {
+//	    ctx := context.Background()
+//	    store := NewRepo(WithVersionCheck())
+//	    repo.VersionAcceptanceTest(t, ctx, store, false)
+//	}
+func VersionAcceptanceTest(t *testing.T, ctx context.Context, repo eh.ReadWriteRepo, strict bool) {
+	isVersionErr := func(err error) bool {
+		return errors.Is(err, eh.ErrIncorrectEntityVersion)
+	}
+
+	// Save a new item.
+	id := uuid.New()
+	if err := repo.Save(ctx, &mocks.Model{ID: id, Version: 1, Content: "v1"}); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Save the same version again.
+	if err := repo.Save(ctx, &mocks.Model{ID: id, Version: 1, Content: "v1 again"}); !isVersionErr(err) {
+		t.Error("there should be an incorrect version error:", err)
+	}
+
+	// Save the next version.
+	if err := repo.Save(ctx, &mocks.Model{ID: id, Version: 2, Content: "v2"}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Save a version with a gap.
+	err := repo.Save(ctx, &mocks.Model{ID: id, Version: 4, Content: "v4"})
+	if strict && !isVersionErr(err) {
+		t.Error("there should be an incorrect version error:", err)
+	} else if !strict && err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Save an older version.
+	if err := repo.Save(ctx, &mocks.Model{ID: id, Version: 1, Content: "v1 old"}); !isVersionErr(err) {
+		t.Error("there should be an incorrect version error:", err)
+	}
+
+	// Save a new item with a version after 1.
+	err = repo.Save(ctx, &mocks.Model{ID: uuid.New(), Version: 3, Content: "v3"})
+	if strict && !isVersionErr(err) {
+		t.Error("there should be an incorrect version error:", err)
+	} else if !strict && err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	entity, err := repo.Find(ctx, id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	version := entity.(*mocks.Model).Version
+	if (strict && version != 2) || (!strict && version != 4) {
+		t.Error("the version should be correct:", version)
+	}
+
+	// Concurrent writers of the next version, only one should succeed.
+	const writers = 10
+	errCh := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			errCh <- repo.Save(ctx, &mocks.Model{
+				ID:      id,
+				Version: version + 1,
+				Content: fmt.Sprintf("writer %d", i),
+			})
+		}(i)
+	}
+	saved := 0
+	for i := 0; i < writers; i++ {
+		if err := <-errCh; err == nil {
+			saved++
+		} else if !isVersionErr(err) {
+			t.Error("there should be an incorrect version error:", err)
+		}
+	}
+	if saved != 1 {
+		t.Error("only one writer should save the version:", saved)
+	}
+
+	// Concurrent writers of increasing versions should never overwrite a
+	// newer version, in non-strict mode.
+	if !strict {
+		version++
+		for i := 1; i <= writers; i++ {
+			go func(v int) {
+				errCh <- repo.Save(ctx, &mocks.Model{ID: id, Version: v})
+			}(version + i)
+		}
+		for i := 0; i < writers; i++ {
+			if err := <-errCh; err != nil && !isVersionErr(err) {
+				t.Error("there should be an incorrect version error:", err)
+			}
+		}
+
+		entity, err := repo.Find(ctx, id)
+		if err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		if v := entity.(*mocks.Model).Version; v != version+writers {
+			t.Error("the newest version should be saved:", v)
+		}
+	}
 }
@@ -0,0 +1,181 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+
+	"github.com/google/uuid"
+)
+
+// Key is the key of a cached entity.
+type Key struct {
+	Namespace string
+	ID        uuid.UUID
+}
+
+// Policy is an eviction policy, deciding which entry to evict when the cache
+// is full. It is only used while holding the cache lock and does not need to be
+// thread safe.
+type Policy interface {
+	// Added is called when a key is added to the cache.
+	Added(key Key)
+	// Accessed is called when a cached key is read or updated.
+	Accessed(key Key)
+	// Removed is called when a key is removed from the cache.
+	Removed(key Key)
+	// Victim returns the key to evict, or false if there are no keys.
+	Victim() (Key, bool)
+}
+
+// NewLRU returns a policy evicting the least recently used entry.
+func NewLRU() Policy {
+	return &lru{
+		order:    list.New(),
+		elements: map[Key]*list.Element{},
+	}
+}
+
+type lru struct {
+	// The most recently used key is at the front.
+	order    *list.List
+	elements map[Key]*list.Element
+}
+
+// Added implements the Added method of the Policy interface.
+func (p *lru) Added(key Key) {
+	if e, ok := p.elements[key]; ok {
+		p.order.MoveToFront(e)
+		return
+	}
+	p.elements[key] = p.order.PushFront(key)
+}
+
+// Accessed implements the Accessed method of the Policy interface.
+func (p *lru) Accessed(key Key) {
+	if e, ok := p.elements[key]; ok {
+		p.order.MoveToFront(e)
+	}
+}
+
+// Removed implements the Removed method of the Policy interface.
+func (p *lru) Removed(key Key) {
+	if e, ok := p.elements[key]; ok {
+		p.order.Remove(e)
+		delete(p.elements, key)
+	}
+}
+
+// Victim implements the Victim method of the Policy interface.
+func (p *lru) Victim() (Key, bool) {
+	e := p.order.Back()
+	if e == nil {
+		return Key{}, false
+	}
+	return e.Value.(Key), true
+}
+
+// NewLFU returns a policy evicting the least frequently used entry, and the
+// least recently used of those on ties.
+func NewLFU() Policy {
+	return &lfu{
+		items: map[Key]*lfuItem{},
+	}
+}
+
+type lfu struct {
+	heap  lfuHeap
+	items map[Key]*lfuItem
+	tick  uint64
+}
+
+type lfuItem struct {
+	key   Key
+	count uint64
+	tick  uint64
+	index int
+}
+
+// Added implements the Added method of the Policy interface.
+func (p *lfu) Added(key Key) {
+	if _, ok := p.items[key]; ok {
+		p.Accessed(key)
+		return
+	}
+	p.tick++
+	item := &lfuItem{key: key, count: 1, tick: p.tick}
+	p.items[key] = item
+	heap.Push(&p.heap, item)
+}
+
+// Accessed implements the Accessed method of the Policy interface.
+func (p *lfu) Accessed(key Key) {
+	if item, ok := p.items[key]; ok {
+		p.tick++
+		item.count++
+		item.tick = p.tick
+		heap.Fix(&p.heap, item.index)
+	}
+}
+
+// Removed implements the Removed method of the Policy interface.
+func (p *lfu) Removed(key Key) {
+	if item, ok := p.items[key]; ok {
+		heap.Remove(&p.heap, item.index)
+		delete(p.items, key)
+	}
+}
+
+// Victim implements the Victim method of the Policy interface.
+func (p *lfu) Victim() (Key, bool) {
+	if len(p.heap) == 0 {
+		return Key{}, false
+	}
+	return p.heap[0].key, true
+}
+
+// lfuHeap implements heap.Interface with the least used item first.
+type lfuHeap []*lfuItem
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	item := x.(*lfuItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
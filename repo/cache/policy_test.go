@@ -0,0 +1,60 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPolicies(t *testing.T) {
+	a := Key{ID: uuid.New()}
+	b := Key{ID: uuid.New()}
+	c := Key{ID: uuid.New()}
+
+	testCases := map[string]struct {
+		policy Policy
+		victim Key
+	}{
+		// b is the least recently accessed.
+		"lru": {NewLRU(), b},
+		// c is the least frequently accessed.
+		"lfu": {NewLFU(), c},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			p := tc.policy
+			if _, ok := p.Victim(); ok {
+				t.Error("there should be no victim")
+			}
+
+			p.Added(a)
+			p.Added(b)
+			p.Accessed(b)
+			p.Accessed(a)
+			p.Added(c)
+			if victim, ok := p.Victim(); !ok || victim != tc.victim {
+				t.Error("the victim should be correct:", victim)
+			}
+
+			p.Removed(tc.victim)
+			if victim, ok := p.Victim(); !ok || victim == tc.victim {
+				t.Error("the removed key should not be a victim:", victim)
+			}
+		})
+	}
+}
@@ -16,37 +16,138 @@ package cache
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
 )
 
-type namespace string
+// DefaultHandlerTypePrefix is the prefix of the default handler type, which is
+// followed by a random ID created with the repo.
+const DefaultHandlerTypePrefix = "repo-cache-"
 
 // Repo is a middleware that adds caching to a read repository. It will update
 // the cache when it receives events affecting the cached items. The primary
 // purpose is to use it with smaller collections accessed often.
-// Note that there is no limit to the cache size.
+// By default there is no limit to the cache size and entries never expire,
+// use WithMaxSize and WithTTL to bound the cache for larger collections.
 type Repo struct {
 	eh.ReadWriteRepo
 
-	cache   map[namespace]map[uuid.UUID]eh.Entity
-	cacheMu sync.RWMutex
+	entries map[Key]*entry
+	fetches map[Key]*fetch
+	busts   uint64
+	mu      sync.Mutex
+	stats   Stats
+
+	policy      Policy
+	maxSize     int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	handlerType eh.EventHandlerType
+	now         func() time.Time
+}
+
+// entry is a cached entity, or a cached miss if the entity is nil.
+type entry struct {
+	entity    eh.Entity
+	expiresAt time.Time
+}
+
+// fetch is the generation of a key that is being fetched from the underlying
+// repo. The generation is increased when the key is busted during the fetch,
+// which would otherwise cache an outdated entity.
+type fetch struct {
+	gen  uint64
+	refs int
+}
+
+// Stats is the statistics of a cache.
+type Stats struct {
+	// Hits is the number of entities, or cached misses, found in the cache.
+	Hits uint64
+	// Misses is the number of entities fetched from the underlying repo.
+	Misses uint64
+	// Evictions is the number of entries evicted to keep the size limit.
+	Evictions uint64
+	// Expirations is the number of entries removed after their TTL.
+	Expirations uint64
+	// Size is the current number of entries.
+	Size int
 }
 
 // NewRepo creates a new Repo.
-func NewRepo(repo eh.ReadWriteRepo) *Repo {
-	return &Repo{
+func NewRepo(repo eh.ReadWriteRepo, options ...Option) *Repo {
+	r := &Repo{
 		ReadWriteRepo: repo,
-		cache:         map[namespace]map[uuid.UUID]eh.Entity{},
+		entries:       map[Key]*entry{},
+		fetches:       map[Key]*fetch{},
+		handlerType:   eh.EventHandlerType(DefaultHandlerTypePrefix + uuid.New().String()),
+		now:           time.Now,
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	if r.policy == nil {
+		r.policy = NewLRU()
+	}
+
+	return r
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Repo)
+
+// WithMaxSize limits the number of cached entries, evicting entries chosen by
+// the eviction policy when full. The limit is shared by all namespaces.
+func WithMaxSize(size int) Option {
+	return func(r *Repo) {
+		r.maxSize = size
+	}
+}
+
+// WithPolicy uses an eviction policy other than the default NewLRU, for
+// example NewLFU.
+func WithPolicy(policy Policy) Option {
+	return func(r *Repo) {
+		r.policy = policy
+	}
+}
+
+// WithTTL expires cached entities after a duration.
+func WithTTL(ttl time.Duration) Option {
+	return func(r *Repo) {
+		r.ttl = ttl
 	}
 }
 
-// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
+// WithNegativeTTL caches eventhorizon.ErrEntityNotFound from the underlying repo
+// for a duration. The cached miss is busted on saves and events like entities.
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(r *Repo) {
+		r.negativeTTL = ttl
+	}
+}
+
+// WithHandlerType uses a fixed handler type for cache invalidation. The
+// default handler type is unique to each repo, which is enough for the local
+// event bus. Networked event buses create a subscription for each handler type,
+// use this option with a handler type that is unique to the repo and process,
+// but stable across restarts, to not create new subscriptions on each start.
+func WithHandlerType(handlerType eh.EventHandlerType) Option {
+	return func(r *Repo) {
+		r.handlerType = handlerType
+	}
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler
+// interface. The handler type is the same for the lifetime of the repo.
 func (r *Repo) HandlerType() eh.EventHandlerType {
-	return eh.EventHandlerType(fmt.Sprintf("repo-cache-%s", uuid.New()))
+	return r.handlerType
 }
 
 // HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
@@ -54,10 +155,9 @@ func (r *Repo) HandlerType() eh.EventHandlerType {
 // The repo should be added with a eh.MatchAny or eh.MatchAggregate for best
 // effect (depending on if the underlying repo is used for all or individual aggregate types).
 func (r *Repo) HandleEvent(ctx context.Context, event eh.Event) error {
-	ns := r.namespace(ctx)
-	r.cacheMu.Lock()
-	delete(r.cache[ns], event.AggregateID())
-	r.cacheMu.Unlock()
+	r.mu.Lock()
+	r.bust(key(ctx, event.AggregateID()))
+	r.mu.Unlock()
 	return nil
 }
 
@@ -68,79 +168,259 @@ func (r *Repo) Parent() eh.ReadRepo {
 
 // Find implements the Find method of the eventhorizon.ReadModel interface.
 func (r *Repo) Find(ctx context.Context, id uuid.UUID) (eh.Entity, error) {
-	ns := r.namespace(ctx)
+	k := key(ctx, id)
 
 	// First check the cache.
-	r.cacheMu.RLock()
-	entity, ok := r.cache[ns][id]
-	r.cacheMu.RUnlock()
+	r.mu.Lock()
+	e, ok := r.lookup(k)
+	var gen uint64
+	if !ok {
+		gen = r.startFetch(k)
+	}
+	r.mu.Unlock()
 	if ok {
-		return entity, nil
+		if e.entity == nil {
+			return nil, eh.RepoError{
+				Err:       eh.ErrEntityNotFound,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		return e.entity, nil
 	}
 
-	// Fetch and store the entity in the cache.
+	// Fetch and store the entity, or a missing entity, in the cache, unless
+	// the key was busted during the fetch.
 	entity, err := r.ReadWriteRepo.Find(ctx, id)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.endFetch(k, gen) {
+		return entity, err
+	}
 	if err != nil {
+		if r.negativeTTL > 0 && errors.Is(err, eh.ErrEntityNotFound) {
+			r.store(k, nil, r.negativeTTL)
+		}
 		return nil, err
 	}
-	r.cacheMu.Lock()
-	r.cache[ns][id] = entity
-	r.cacheMu.Unlock()
+	r.store(k, entity, r.ttl)
 
 	return entity, nil
 }
 
 // FindAll implements the FindAll method of the eventhorizon.ReadRepo interface.
 func (r *Repo) FindAll(ctx context.Context) ([]eh.Entity, error) {
+	r.mu.Lock()
+	busts := r.busts
+	r.mu.Unlock()
+
 	entities, err := r.ReadWriteRepo.FindAll(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Cache all items.
-	ns := r.namespace(ctx)
-	r.cacheMu.Lock()
-	for _, entity := range entities {
-		r.cache[ns][entity.EntityID()] = entity
-	}
-	r.cacheMu.Unlock()
+	r.storeAll(ctx, entities, busts)
 
 	return entities, nil
 }
 
+// FindAllIter implements the FindAllIter method of the eventhorizon.Iterable
+// interface. The entities are streamed from the underlying repo if possible,
+// without being cached.
+func (r *Repo) FindAllIter(ctx context.Context) (eh.Iter, error) {
+	return eh.FindAllIter(ctx, r.ReadWriteRepo)
+}
+
+// Query implements the Query method of the eventhorizon.QueryRepo interface.
+// The query is passed to the underlying repo and the entities are cached.
+func (r *Repo) Query(ctx context.Context, q eh.Query) (*eh.QueryResult, error) {
+	repo, ok := r.ReadWriteRepo.(eh.QueryRepo)
+	if !ok {
+		return nil, eh.RepoError{
+			Err:       eh.ErrQueryNotSupported,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	r.mu.Lock()
+	busts := r.busts
+	r.mu.Unlock()
+
+	result, err := repo.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	r.storeAll(ctx, result.Entities, busts)
+
+	return result, nil
+}
+
 // Save implements the Save method of the eventhorizon.WriteRepo interface.
 func (r *Repo) Save(ctx context.Context, entity eh.Entity) error {
-	// Bust the cache on save.
-	ns := r.namespace(ctx)
-	r.cacheMu.Lock()
-	delete(r.cache[ns], entity.EntityID())
-	r.cacheMu.Unlock()
+	// Bust the cache after the save, to also bust concurrent fetches.
+	defer func() {
+		r.mu.Lock()
+		r.bust(key(ctx, entity.EntityID()))
+		r.mu.Unlock()
+	}()
 
 	return r.ReadWriteRepo.Save(ctx, entity)
 }
 
+// SaveAll implements the SaveAll method of the eventhorizon.BatchWriteRepo
+// interface. The entities are saved in one operation if the underlying repo
+// supports it.
+func (r *Repo) SaveAll(ctx context.Context, entities []eh.Entity) error {
+	// Bust the cache after the save, to also bust concurrent fetches.
+	defer func() {
+		r.mu.Lock()
+		for _, entity := range entities {
+			r.bust(key(ctx, entity.EntityID()))
+		}
+		r.mu.Unlock()
+	}()
+
+	return eh.SaveAll(ctx, r.ReadWriteRepo, entities)
+}
+
 // Remove implements the Remove method of the eventhorizon.WriteRepo interface.
 func (r *Repo) Remove(ctx context.Context, id uuid.UUID) error {
-	// Bust the cache on remove.
-	ns := r.namespace(ctx)
-	r.cacheMu.Lock()
-	delete(r.cache[ns], id)
-	r.cacheMu.Unlock()
+	// Bust the cache after the remove, to also bust concurrent fetches.
+	defer func() {
+		r.mu.Lock()
+		r.bust(key(ctx, id))
+		r.mu.Unlock()
+	}()
 
 	return r.ReadWriteRepo.Remove(ctx, id)
 }
 
-// Helper to get the namespace and ensure that its data exists.
-func (r *Repo) namespace(ctx context.Context) namespace {
-	ns := namespace(eh.NamespaceFromContext(ctx))
+// Stats returns the current statistics of the cache.
+func (r *Repo) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.Size = len(r.entries)
+	return stats
+}
+
+// lookup returns a cached entry and updates the statistics, removing the entry
+// if it has expired. The lock must be held.
+func (r *Repo) lookup(k Key) (*entry, bool) {
+	e, ok := r.entries[k]
+	if ok && !e.expiresAt.IsZero() && !r.now().Before(e.expiresAt) {
+		r.remove(k)
+		r.stats.Expirations++
+		ok = false
+	}
+
+	if !ok {
+		r.stats.Misses++
+		return nil, false
+	}
+
+	r.stats.Hits++
+	r.policy.Accessed(k)
+	return e, true
+}
+
+// store adds or updates a cached entry, evicting entries if the cache is full.
+// The lock must be held.
+func (r *Repo) store(k Key, entity eh.Entity, ttl time.Duration) {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = r.now().Add(ttl)
+	}
 
-	r.cacheMu.Lock()
-	defer r.cacheMu.Unlock()
-	if _, ok := r.cache[ns]; !ok {
-		r.cache[ns] = map[uuid.UUID]eh.Entity{}
+	if e, ok := r.entries[k]; ok {
+		e.entity = entity
+		e.expiresAt = expiresAt
+		r.policy.Accessed(k)
+		return
 	}
 
-	return ns
+	for r.maxSize > 0 && len(r.entries) >= r.maxSize {
+		victim, ok := r.policy.Victim()
+		if !ok {
+			break
+		}
+		r.remove(victim)
+		r.stats.Evictions++
+	}
+
+	r.entries[k] = &entry{
+		entity:    entity,
+		expiresAt: expiresAt,
+	}
+	r.policy.Added(k)
+}
+
+// storeAll caches all entities, unless any key was busted since the number of
+// busts was read before fetching them.
+func (r *Repo) storeAll(ctx context.Context, entities []eh.Entity, busts uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.busts != busts {
+		return
+	}
+
+	for _, entity := range entities {
+		r.store(key(ctx, entity.EntityID()), entity, r.ttl)
+	}
+}
+
+// startFetch registers a fetch of a key and returns its generation. The lock
+// must be held.
+func (r *Repo) startFetch(k Key) uint64 {
+	f, ok := r.fetches[k]
+	if !ok {
+		f = &fetch{}
+		r.fetches[k] = f
+	}
+	f.refs++
+
+	return f.gen
+}
+
+// endFetch unregisters a fetch of a key and returns true if the key was not
+// busted since the fetch started. The lock must be held.
+func (r *Repo) endFetch(k Key, gen uint64) bool {
+	f := r.fetches[k]
+	if f.refs--; f.refs == 0 {
+		delete(r.fetches, k)
+	}
+
+	return f.gen == gen
+}
+
+// bust removes a cached entry after it has changed, and makes any fetches of
+// it in progress skip caching the fetched entity. The lock must be held.
+func (r *Repo) bust(k Key) {
+	r.remove(k)
+	r.busts++
+	if f, ok := r.fetches[k]; ok {
+		f.gen++
+	}
+}
+
+// remove removes a cached entry. The lock must be held.
+func (r *Repo) remove(k Key) {
+	if _, ok := r.entries[k]; ok {
+		delete(r.entries, k)
+		r.policy.Removed(k)
+	}
+}
+
+// key returns the cache key for an ID in the namespace of the context.
+func key(ctx context.Context, id uuid.UUID) Key {
+	return Key{
+		Namespace: eh.NamespaceFromContext(ctx),
+		ID:        id,
+	}
 }
 
 // Repository returns a parent ReadRepo if there is one.
@@ -16,9 +16,14 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
 
 	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventbus/local"
 	"github.com/looplab/eventhorizon/mocks"
 	"github.com/looplab/eventhorizon/repo"
 	"github.com/looplab/eventhorizon/repo/memory"
@@ -48,6 +53,236 @@ func TestReadRepo(t *testing.T) {
 
 }
 
+func TestRepoMaxSize(t *testing.T) {
+	ctx := context.Background()
+	baseRepo, entities := newBaseRepo(t, 3)
+
+	r := NewRepo(baseRepo, WithMaxSize(2))
+	for _, e := range entities {
+		if _, err := r.Find(ctx, e.ID); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	stats := r.Stats()
+	if stats.Misses != 3 || stats.Evictions != 1 || stats.Size != 2 {
+		t.Error("the stats should be correct:", stats)
+	}
+
+	// The least recently used entity should have been evicted.
+	if _, err := r.Find(ctx, entities[2].ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := r.Find(ctx, entities[0].ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	stats = r.Stats()
+	if stats.Hits != 1 || stats.Misses != 4 || stats.Evictions != 2 {
+		t.Error("the stats should be correct:", stats)
+	}
+}
+
+func TestRepoTTL(t *testing.T) {
+	ctx := context.Background()
+	baseRepo, entities := newBaseRepo(t, 1)
+
+	now := time.Now()
+	r := NewRepo(baseRepo, WithTTL(time.Minute), WithNegativeTTL(time.Second))
+	r.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Find(ctx, entities[0].ID); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	if stats := r.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Error("the stats should be correct:", stats)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := r.Find(ctx, entities[0].ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if stats := r.Stats(); stats.Misses != 2 || stats.Expirations != 1 {
+		t.Error("the stats should be correct:", stats)
+	}
+
+	// Missing entities are cached for the negative TTL.
+	id := uuid.New()
+	for i := 0; i < 2; i++ {
+		if _, err := r.Find(ctx, id); !errors.Is(err, eh.ErrEntityNotFound) {
+			t.Error("there should be a ErrEntityNotFound error:", err)
+		}
+	}
+	if stats := r.Stats(); stats.Hits != 2 || stats.Misses != 3 {
+		t.Error("the stats should be correct:", stats)
+	}
+	now = now.Add(time.Second)
+	if _, err := r.Find(ctx, id); !errors.Is(err, eh.ErrEntityNotFound) {
+		t.Error("there should be a ErrEntityNotFound error:", err)
+	}
+	if stats := r.Stats(); stats.Misses != 4 || stats.Expirations != 2 {
+		t.Error("the stats should be correct:", stats)
+	}
+
+	// Saving busts the cached miss.
+	entity := &mocks.Model{ID: id, Content: "entity"}
+	if err := r.Save(ctx, entity); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := r.Find(ctx, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestRepoHandleEvent(t *testing.T) {
+	ctx := context.Background()
+	baseRepo, entities := newBaseRepo(t, 1)
+
+	r := NewRepo(baseRepo)
+	if r.HandlerType() != r.HandlerType() {
+		t.Error("the handler type should be stable:", r.HandlerType())
+	}
+	if r := NewRepo(baseRepo, WithHandlerType("cache")); r.HandlerType() != "cache" {
+		t.Error("the handler type should be correct:", r.HandlerType())
+	}
+
+	if _, err := r.Find(ctx, entities[0].ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	event := eh.NewEvent(mocks.EventType, nil, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, entities[0].ID, 1))
+	if err := r.HandleEvent(ctx, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if stats := r.Stats(); stats.Size != 0 {
+		t.Error("the entity should be busted:", stats)
+	}
+
+	// Saving should bust the cache.
+	if _, err := r.Find(ctx, entities[0].ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	entities[0].Content = "updated"
+	if err := r.SaveAll(ctx, []eh.Entity{entities[0]}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if stats := r.Stats(); stats.Size != 0 {
+		t.Error("the entity should be busted:", stats)
+	}
+	if entity, err := r.Find(ctx, entities[0].ID); err != nil ||
+		entity.(*mocks.Model).Content != "updated" {
+		t.Error("the entity should be saved:", entity, err)
+	}
+}
+
+func TestRepoLocalEventBus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	baseRepo, entities := newBaseRepo(t, 1)
+
+	// Several caches should be able to handle events from the same bus.
+	bus := local.NewEventBus()
+	caches := []*Repo{NewRepo(baseRepo), NewRepo(baseRepo)}
+	for _, r := range caches {
+		if err := bus.AddHandler(ctx, eh.MatchAll{}, r); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		if _, err := r.Find(ctx, entities[0].ID); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+
+	event := eh.NewEvent(mocks.EventType, nil, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, entities[0].ID, 1))
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	for _, r := range caches {
+		deadline := time.Now().Add(time.Second)
+		for r.Stats().Size != 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if stats := r.Stats(); stats.Size != 0 {
+			t.Error("the entity should be busted in all caches:", stats)
+		}
+	}
+
+	cancel()
+	bus.Wait()
+}
+
+func TestRepoBustDuringFind(t *testing.T) {
+	ctx := context.Background()
+	baseRepo, entities := newBaseRepo(t, 1)
+	hookRepo := &findHookRepo{ReadWriteRepo: baseRepo}
+	r := NewRepo(hookRepo, WithNegativeTTL(time.Minute))
+
+	// An entity busted while being fetched should not be cached.
+	event := eh.NewEvent(mocks.EventType, nil, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, entities[0].ID, 1))
+	hookRepo.onFind = func() {
+		if err := r.HandleEvent(ctx, event); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	if _, err := r.Find(ctx, entities[0].ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if stats := r.Stats(); stats.Size != 0 {
+		t.Error("the entity should not be cached:", stats)
+	}
+
+	// A miss should not be cached if the entity is saved while being fetched.
+	entity := &mocks.Model{ID: uuid.New(), Content: "entity"}
+	hookRepo.onFind = func() {
+		if err := r.Save(ctx, entity); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	if _, err := r.Find(ctx, entity.ID); err == nil {
+		t.Error("there should be an error")
+	}
+	hookRepo.onFind = nil
+	if _, err := r.Find(ctx, entity.ID); err != nil {
+		t.Error("the saved entity should be found:", err)
+	}
+	if len(r.fetches) != 0 {
+		t.Error("there should be no fetches left:", r.fetches)
+	}
+}
+
+// findHookRepo calls a hook before finding an entity, to simulate changes made
+// while fetching it.
+type findHookRepo struct {
+	eh.ReadWriteRepo
+	onFind func()
+}
+
+func (r *findHookRepo) Find(ctx context.Context, id uuid.UUID) (eh.Entity, error) {
+	entity, err := r.ReadWriteRepo.Find(ctx, id)
+	if r.onFind != nil {
+		r.onFind()
+	}
+	return entity, err
+}
+
+func newBaseRepo(t *testing.T, n int) (eh.ReadWriteRepo, []*mocks.Model) {
+	baseRepo := memory.NewRepo()
+	baseRepo.SetEntityFactory(func() eh.Entity {
+		return &mocks.Model{}
+	})
+
+	var entities []*mocks.Model
+	for i := 0; i < n; i++ {
+		entity := &mocks.Model{ID: uuid.New(), Content: "entity"}
+		if err := baseRepo.Save(context.Background(), entity); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		entities = append(entities, entity)
+	}
+
+	return baseRepo, entities
+}
+
 func TestRepository(t *testing.T) {
 	if r := Repository(nil); r != nil {
 		t.Error("the parent repository should be nil:", r)
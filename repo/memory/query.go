@@ -0,0 +1,333 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Query implements the Query method of the eventhorizon.QueryRepo interface.
+// Fields are named by the JSON encoding of the entities, and all entities in
+// the namespace are scanned for each query.
+func (r *Repo) Query(ctx context.Context, q eh.Query) (*eh.QueryResult, error) {
+	if r.factoryFn == nil {
+		return nil, eh.RepoError{
+			Err:       ErrModelNotSet,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	filters, err := normalizeFilters(q.Filters)
+	if err != nil {
+		return nil, eh.RepoError{
+			Err:       eh.ErrInvalidQuery,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	var after *cursor
+	if q.Cursor != "" {
+		if after, err = decodeCursor(q.Cursor, len(q.Sort)); err != nil {
+			return nil, eh.RepoError{
+				Err:       eh.ErrInvalidQuery,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	ns := r.namespace(ctx)
+
+	// Find the matching documents.
+	var docs []*document
+	r.dbMu.RLock()
+	for _, id := range r.ids[ns] {
+		b, ok := r.db[ns][id]
+		if !ok {
+			continue
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(b, &fields); err != nil {
+			r.dbMu.RUnlock()
+			return nil, eh.RepoError{
+				Err:       eh.ErrCouldNotLoadEntity,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		if matchFilters(fields, filters) {
+			d := &document{id: id.String(), data: b}
+			for _, s := range q.Sort {
+				d.keys = append(d.keys, lookup(fields, s.Field))
+			}
+			docs = append(docs, d)
+		}
+	}
+	r.dbMu.RUnlock()
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		return compareDocs(docs[i].keys, docs[i].id, docs[j].keys, docs[j].id, q.Sort) < 0
+	})
+
+	// Skip to the document after the cursor.
+	if after != nil {
+		i := sort.Search(len(docs), func(i int) bool {
+			return compareDocs(docs[i].keys, docs[i].id, after.Keys, after.ID, q.Sort) > 0
+		})
+		docs = docs[i:]
+	}
+
+	result := &eh.QueryResult{}
+	if q.Limit > 0 && len(docs) > q.Limit {
+		docs = docs[:q.Limit]
+		last := docs[len(docs)-1]
+		if result.Cursor, err = encodeCursor(cursor{Keys: last.keys, ID: last.id}); err != nil {
+			return nil, eh.RepoError{
+				Err:       eh.ErrCouldNotLoadEntity,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	result.Entities = make([]eh.Entity, 0, len(docs))
+	for _, d := range docs {
+		entity := r.factoryFn()
+		if err := json.Unmarshal(d.data, &entity); err != nil {
+			return nil, eh.RepoError{
+				Err:       eh.ErrCouldNotLoadEntity,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		result.Entities = append(result.Entities, entity)
+	}
+
+	return result, nil
+}
+
+// document is a decoded entity with its sort keys.
+type document struct {
+	id   string
+	keys []interface{}
+	data []byte
+}
+
+// cursor is the position of the last document in a page.
+type cursor struct {
+	Keys []interface{} `json:"k"`
+	ID   string        `json:"id"`
+}
+
+func encodeCursor(c cursor) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(s string, sortFields int) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode cursor: %w", err)
+	}
+
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("could not decode cursor: %w", err)
+	}
+	if len(c.Keys) != sortFields {
+		return nil, fmt.Errorf("cursor does not match sort order")
+	}
+
+	return &c, nil
+}
+
+// normalizeFilters converts the filter values to their JSON representation,
+// to be compared with the stored fields.
+func normalizeFilters(filters []eh.Filter) ([]eh.Filter, error) {
+	result := make([]eh.Filter, len(filters))
+	for i, f := range filters {
+		switch f.Op {
+		case eh.FilterEq, eh.FilterNe, eh.FilterLt, eh.FilterLte, eh.FilterGt, eh.FilterGte, eh.FilterIn:
+		default:
+			return nil, fmt.Errorf("unknown filter operator: %q", f.Op)
+		}
+
+		b, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode filter value: %w", err)
+		}
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("could not decode filter value: %w", err)
+		}
+		if _, ok := v.([]interface{}); f.Op == eh.FilterIn && !ok {
+			return nil, fmt.Errorf("filter value for %q must be a slice", f.Field)
+		}
+
+		result[i] = eh.Filter{Field: f.Field, Op: f.Op, Value: v}
+	}
+
+	return result, nil
+}
+
+func matchFilters(fields map[string]interface{}, filters []eh.Filter) bool {
+	for _, f := range filters {
+		v := lookup(fields, f.Field)
+		switch f.Op {
+		case eh.FilterEq:
+			if !equal(v, f.Value) {
+				return false
+			}
+		case eh.FilterNe:
+			if equal(v, f.Value) {
+				return false
+			}
+		case eh.FilterIn:
+			found := false
+			for _, value := range f.Value.([]interface{}) {
+				if equal(v, value) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		default:
+			// Values of different types never match a range.
+			if typeRank(v) != typeRank(f.Value) || v == nil {
+				return false
+			}
+			c := compare(v, f.Value)
+			if (f.Op == eh.FilterLt && c >= 0) ||
+				(f.Op == eh.FilterLte && c > 0) ||
+				(f.Op == eh.FilterGt && c <= 0) ||
+				(f.Op == eh.FilterGte && c < 0) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// lookup returns the value of a dotted field, or nil if it is missing.
+func lookup(fields map[string]interface{}, field string) interface{} {
+	var v interface{} = fields
+	for _, name := range strings.Split(field, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[name]
+	}
+
+	return v
+}
+
+func compareDocs(keys1 []interface{}, id1 string, keys2 []interface{}, id2 string, order []eh.SortField) int {
+	for i, s := range order {
+		if c := compare(keys1[i], keys2[i]); c != 0 {
+			if s.Descending {
+				return -c
+			}
+			return c
+		}
+	}
+
+	return strings.Compare(id1, id2)
+}
+
+func equal(v1, v2 interface{}) bool {
+	if typeRank(v1) == typeRank(v2) && typeRank(v1) < 4 {
+		return compare(v1, v2) == 0
+	}
+
+	return reflect.DeepEqual(v1, v2)
+}
+
+// compare compares two JSON values, ordering values of different types by
+// their type. Strings that are both times are compared as times.
+func compare(v1, v2 interface{}) int {
+	if r1, r2 := typeRank(v1), typeRank(v2); r1 != r2 {
+		if r1 < r2 {
+			return -1
+		}
+		return 1
+	}
+
+	switch v1 := v1.(type) {
+	case float64:
+		v2 := v2.(float64)
+		if v1 < v2 {
+			return -1
+		} else if v1 > v2 {
+			return 1
+		}
+	case string:
+		v2 := v2.(string)
+		if t1, err := time.Parse(time.RFC3339Nano, v1); err == nil {
+			if t2, err := time.Parse(time.RFC3339Nano, v2); err == nil {
+				if t1.Before(t2) {
+					return -1
+				} else if t1.After(t2) {
+					return 1
+				}
+				return 0
+			}
+		}
+		return strings.Compare(v1, v2)
+	case bool:
+		v2 := v2.(bool)
+		if !v1 && v2 {
+			return -1
+		} else if v1 && !v2 {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case float64:
+		return 1
+	case string:
+		return 2
+	case bool:
+		return 3
+	default:
+		return 4
+	}
+}
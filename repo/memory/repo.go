@@ -40,17 +40,47 @@ type Repo struct {
 	// The outer map is for the namespace.
 	ids       map[namespace][]uuid.UUID
 	factoryFn func() eh.Entity
+
+	versionCheck       bool
+	strictVersionCheck bool
 }
 
 // NewRepo creates a new Repo.
-func NewRepo() *Repo {
+func NewRepo(options ...Option) *Repo {
 	r := &Repo{
 		ids: map[namespace][]uuid.UUID{},
 		db:  map[namespace]map[uuid.UUID][]byte{},
 	}
+
+	for _, option := range options {
+		option(r)
+	}
+
 	return r
 }
 
+// Option is an option setter used to configure creation.
+type Option func(*Repo)
+
+// WithVersionCheck only saves eventhorizon.Versionable entities with a higher
+// version than the stored entity, returning eventhorizon.ErrIncorrectEntityVersion
+// otherwise. Other entities are always saved.
+func WithVersionCheck() Option {
+	return func(r *Repo) {
+		r.versionCheck = true
+	}
+}
+
+// WithStrictVersionCheck only saves eventhorizon.Versionable entities with the
+// version following the version of the stored entity, or version 1 for new
+// entities, returning eventhorizon.ErrIncorrectEntityVersion otherwise.
+func WithStrictVersionCheck() Option {
+	return func(r *Repo) {
+		r.versionCheck = true
+		r.strictVersionCheck = true
+	}
+}
+
 // Parent implements the Parent method of the eventhorizon.ReadRepo interface.
 func (r *Repo) Parent() eh.ReadRepo {
 	return nil
@@ -123,6 +153,79 @@ func (r *Repo) FindAll(ctx context.Context) ([]eh.Entity, error) {
 	return result, nil
 }
 
+// FindAllIter implements the FindAllIter method of the eventhorizon.Iterable
+// interface. Entities are decoded one at a time, and entities saved after the
+// iterator was created are not included.
+func (r *Repo) FindAllIter(ctx context.Context) (eh.Iter, error) {
+	if r.factoryFn == nil {
+		return nil, eh.RepoError{
+			Err:       ErrModelNotSet,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	ns := r.namespace(ctx)
+
+	r.dbMu.RLock()
+	ids := make([]uuid.UUID, len(r.ids[ns]))
+	copy(ids, r.ids[ns])
+	r.dbMu.RUnlock()
+
+	return &iter{
+		repo: r,
+		ns:   ns,
+		ids:  ids,
+	}, nil
+}
+
+// iter is an iterator over the entities of a namespace, it is not thread safe.
+type iter struct {
+	repo      *Repo
+	ns        namespace
+	ids       []uuid.UUID
+	data      eh.Entity
+	decodeErr error
+}
+
+func (i *iter) Next(ctx context.Context) bool {
+	for len(i.ids) > 0 && i.decodeErr == nil {
+		id := i.ids[0]
+		i.ids = i.ids[1:]
+
+		i.repo.dbMu.RLock()
+		b, ok := i.repo.db[i.ns][id]
+		i.repo.dbMu.RUnlock()
+		if !ok {
+			// Removed since the iterator was created.
+			continue
+		}
+
+		entity := i.repo.factoryFn()
+		if err := json.Unmarshal(b, &entity); err != nil {
+			i.decodeErr = eh.RepoError{
+				Err:       eh.ErrCouldNotLoadEntity,
+				BaseErr:   err,
+				Namespace: string(i.ns),
+			}
+			return false
+		}
+		i.data = entity
+
+		return true
+	}
+
+	return false
+}
+
+func (i *iter) Value() interface{} {
+	return i.data
+}
+
+func (i *iter) Close(ctx context.Context) error {
+	i.ids = nil
+	return i.decodeErr
+}
+
 // Save implements the Save method of the eventhorizon.WriteRepo interface.
 func (r *Repo) Save(ctx context.Context, entity eh.Entity) error {
 	if r.factoryFn == nil {
@@ -156,6 +259,10 @@ func (r *Repo) Save(ctx context.Context, entity eh.Entity) error {
 		}
 	}
 
+	if err := r.checkVersion(ctx, ns, entity); err != nil {
+		return err
+	}
+
 	// Update ID index if the item is new.
 	if _, ok := r.db[ns][id]; !ok {
 		r.ids[ns] = append(r.ids[ns], id)
@@ -165,6 +272,59 @@ func (r *Repo) Save(ctx context.Context, entity eh.Entity) error {
 	return nil
 }
 
+// SaveAll implements the SaveAll method of the eventhorizon.BatchWriteRepo
+// interface. Either all or none of the entities are saved.
+func (r *Repo) SaveAll(ctx context.Context, entities []eh.Entity) error {
+	if r.factoryFn == nil {
+		return eh.RepoError{
+			Err:       ErrModelNotSet,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	ns := r.namespace(ctx)
+
+	data := make([][]byte, len(entities))
+	for i, entity := range entities {
+		if entity.EntityID() == uuid.Nil {
+			return eh.RepoError{
+				Err:       eh.ErrCouldNotSaveEntity,
+				BaseErr:   eh.ErrMissingEntityID,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		b, err := json.Marshal(entity)
+		if err != nil {
+			return eh.RepoError{
+				Err:       eh.ErrCouldNotSaveEntity,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		data[i] = b
+	}
+
+	r.dbMu.Lock()
+	defer r.dbMu.Unlock()
+
+	for _, entity := range entities {
+		if err := r.checkVersion(ctx, ns, entity); err != nil {
+			return err
+		}
+	}
+
+	for i, entity := range entities {
+		id := entity.EntityID()
+		if _, ok := r.db[ns][id]; !ok {
+			r.ids[ns] = append(r.ids[ns], id)
+		}
+		r.db[ns][id] = data[i]
+	}
+
+	return nil
+}
+
 // Remove implements the Remove method of the eventhorizon.WriteRepo interface.
 func (r *Repo) Remove(ctx context.Context, id uuid.UUID) error {
 	ns := r.namespace(ctx)
@@ -192,6 +352,40 @@ func (r *Repo) Remove(ctx context.Context, id uuid.UUID) error {
 	}
 }
 
+// checkVersion checks the version of an entity against the stored entity, if
+// enabled. Must be called with the lock held.
+func (r *Repo) checkVersion(ctx context.Context, ns namespace, entity eh.Entity) error {
+	versionable, ok := entity.(eh.Versionable)
+	if !r.versionCheck || !ok {
+		return nil
+	}
+
+	storedVersion := 0
+	if b, ok := r.db[ns][entity.EntityID()]; ok {
+		stored := r.factoryFn()
+		if err := json.Unmarshal(b, &stored); err != nil {
+			return eh.RepoError{
+				Err:       eh.ErrCouldNotSaveEntity,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if stored, ok := stored.(eh.Versionable); ok {
+			storedVersion = stored.AggregateVersion()
+		}
+	}
+
+	version := versionable.AggregateVersion()
+	if version <= storedVersion || (r.strictVersionCheck && version != storedVersion+1) {
+		return eh.RepoError{
+			Err:       eh.ErrIncorrectEntityVersion,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
 // SetEntityFactory sets a factory function that creates concrete entity types.
 func (r *Repo) SetEntityFactory(f func() eh.Entity) {
 	r.factoryFn = f
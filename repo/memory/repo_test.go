@@ -46,6 +46,21 @@ func TestReadRepo(t *testing.T) {
 
 }
 
+func TestRepoVersionCheck(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		option := WithVersionCheck()
+		if strict {
+			option = WithStrictVersionCheck()
+		}
+		r := NewRepo(option)
+		r.SetEntityFactory(func() eh.Entity {
+			return &mocks.Model{}
+		})
+
+		repo.VersionAcceptanceTest(t, context.Background(), r, strict)
+	}
+}
+
 func TestRepository(t *testing.T) {
 	if r := Repository(nil); r != nil {
 		t.Error("the parent repository should be nil:", r)
@@ -0,0 +1,229 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongodb
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Query implements the Query method of the eventhorizon.QueryRepo interface.
+// Fields are named by the BSON encoding of the entities. Sort fields should
+// have values of the same type in all entities for pagination to work, and
+// should be indexed together with _id for large collections.
+func (r *Repo) Query(ctx context.Context, q eh.Query) (*eh.QueryResult, error) {
+	if r.factoryFn == nil {
+		return nil, eh.RepoError{
+			Err:       ErrModelNotSet,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	conditions, err := queryConditions(q.Filters)
+	if err != nil {
+		return nil, eh.RepoError{
+			Err:       eh.ErrInvalidQuery,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	if q.Cursor != "" {
+		after, err := decodeCursor(q.Cursor, len(q.Sort))
+		if err != nil {
+			return nil, eh.RepoError{
+				Err:       eh.ErrInvalidQuery,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		conditions = append(conditions, after.condition(q.Sort))
+	}
+
+	filter := bson.M{}
+	if len(conditions) > 0 {
+		filter = bson.M{"$and": conditions}
+	}
+
+	sortDoc := bson.D{}
+	for _, s := range q.Sort {
+		sortDoc = append(sortDoc, bson.E{Key: s.Field, Value: sortDirection(s.Descending)})
+	}
+	sortDoc = append(sortDoc, bson.E{Key: "_id", Value: 1})
+
+	opts := options.Find().SetSort(sortDoc)
+	if q.Limit > 0 {
+		// Get one more to know if there is a next page.
+		opts.SetLimit(int64(q.Limit) + 1)
+	}
+
+	c := r.client.Database(r.dbName(ctx)).Collection(r.collection)
+
+	mongoCursor, err := c.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, eh.RepoError{
+			Err:       eh.ErrCouldNotLoadEntity,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	defer mongoCursor.Close(ctx)
+
+	result := &eh.QueryResult{Entities: []eh.Entity{}}
+	var last bson.Raw
+	for mongoCursor.Next(ctx) {
+		if q.Limit > 0 && len(result.Entities) == q.Limit {
+			if result.Cursor, err = encodeCursor(last, q.Sort); err != nil {
+				return nil, eh.RepoError{
+					Err:       eh.ErrCouldNotLoadEntity,
+					BaseErr:   err,
+					Namespace: eh.NamespaceFromContext(ctx),
+				}
+			}
+			break
+		}
+
+		entity := r.factoryFn()
+		if err := mongoCursor.Decode(entity); err != nil {
+			return nil, eh.RepoError{
+				Err:       eh.ErrCouldNotLoadEntity,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		result.Entities = append(result.Entities, entity)
+		last = append(bson.Raw{}, mongoCursor.Current...)
+	}
+	if err := mongoCursor.Err(); err != nil {
+		return nil, eh.RepoError{
+			Err:       eh.ErrCouldNotLoadEntity,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return result, nil
+}
+
+var filterOps = map[eh.FilterOp]string{
+	eh.FilterEq:  "$eq",
+	eh.FilterNe:  "$ne",
+	eh.FilterLt:  "$lt",
+	eh.FilterLte: "$lte",
+	eh.FilterGt:  "$gt",
+	eh.FilterGte: "$gte",
+	eh.FilterIn:  "$in",
+}
+
+func queryConditions(filters []eh.Filter) (bson.A, error) {
+	conditions := bson.A{}
+	for _, f := range filters {
+		op, ok := filterOps[f.Op]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter operator: %q", f.Op)
+		}
+		conditions = append(conditions, bson.M{f.Field: bson.M{op: f.Value}})
+	}
+
+	return conditions, nil
+}
+
+func sortDirection(descending bool) int {
+	if descending {
+		return -1
+	}
+	return 1
+}
+
+// cursor is the position of the last document in a page.
+type cursor struct {
+	Keys []bson.RawValue `bson:"k"`
+	ID   bson.RawValue   `bson:"id"`
+}
+
+func encodeCursor(doc bson.Raw, order []eh.SortField) (string, error) {
+	c := cursor{ID: doc.Lookup("_id")}
+	for _, s := range order {
+		v := doc.Lookup(strings.Split(s.Field, ".")...)
+		if v.Type == 0 {
+			v = bson.RawValue{Type: bsontype.Null}
+		}
+		c.Keys = append(c.Keys, v)
+	}
+
+	b, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(s string, sortFields int) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode cursor: %w", err)
+	}
+
+	var c cursor
+	if err := bson.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("could not decode cursor: %w", err)
+	}
+	if len(c.Keys) != sortFields {
+		return nil, fmt.Errorf("cursor does not match sort order")
+	}
+
+	return &c, nil
+}
+
+// condition returns a condition for the documents after the cursor, in the
+// sort order followed by _id.
+func (c *cursor) condition(order []eh.SortField) bson.M {
+	fields := make([]string, 0, len(order)+1)
+	ops := make([]string, 0, len(order)+1)
+	values := make([]bson.RawValue, 0, len(order)+1)
+	for i, s := range order {
+		fields = append(fields, s.Field)
+		if s.Descending {
+			ops = append(ops, "$lt")
+		} else {
+			ops = append(ops, "$gt")
+		}
+		values = append(values, c.Keys[i])
+	}
+	fields = append(fields, "_id")
+	ops = append(ops, "$gt")
+	values = append(values, c.ID)
+
+	or := bson.A{}
+	for i := range fields {
+		and := bson.M{}
+		for j := 0; j < i; j++ {
+			and[fields[j]] = bson.M{"$eq": values[j]}
+		}
+		and[fields[i]] = bson.M{ops[i]: values[i]}
+		or = append(or, and)
+	}
+
+	return bson.M{"$or": or}
+}
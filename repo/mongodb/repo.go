@@ -32,6 +32,7 @@ import (
 	_ "github.com/looplab/eventhorizon/codec/bson"
 
 	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mongoutils"
 )
 
 var (
@@ -54,8 +55,16 @@ type Repo struct {
 	collection string
 	factoryFn  func() eh.Entity
 	dbName     func(context.Context) string
+
+	versionCheck       bool
+	strictVersionCheck bool
+	versionField       string
 }
 
+// DefaultVersionField is the default BSON field of the entity version, used
+// when checking versions.
+const DefaultVersionField = "version"
+
 // NewRepo creates a new Repo.
 func NewRepo(uri, dbPrefix, collection string, options ...Option) (*Repo, error) {
 	opts := mongoOptions.Client().ApplyURI(uri)
@@ -77,9 +86,10 @@ func NewRepoWithClient(client *mongo.Client, dbPrefix, collection string, option
 	}
 
 	r := &Repo{
-		client:     client,
-		dbPrefix:   dbPrefix,
-		collection: collection,
+		client:       client,
+		dbPrefix:     dbPrefix,
+		collection:   collection,
+		versionField: DefaultVersionField,
 	}
 
 	// Use the a prefix and namespcae from the context for DB name.
@@ -118,6 +128,39 @@ func WithDBName(dbName func(context.Context) string) Option {
 	}
 }
 
+// WithVersionCheck only saves eventhorizon.Versionable entities with a higher
+// version than the stored entity, returning eventhorizon.ErrIncorrectEntityVersion
+// otherwise. Other entities are always saved.
+func WithVersionCheck() Option {
+	return func(r *Repo) error {
+		r.versionCheck = true
+		return nil
+	}
+}
+
+// WithStrictVersionCheck only saves eventhorizon.Versionable entities with the
+// version following the version of the stored entity, or version 1 for new
+// entities, returning eventhorizon.ErrIncorrectEntityVersion otherwise.
+func WithStrictVersionCheck() Option {
+	return func(r *Repo) error {
+		r.versionCheck = true
+		r.strictVersionCheck = true
+		return nil
+	}
+}
+
+// WithVersionField sets the BSON field of the entity version, used when
+// checking versions, if other than DefaultVersionField.
+func WithVersionField(field string) Option {
+	return func(r *Repo) error {
+		if field == "" {
+			return fmt.Errorf("missing version field")
+		}
+		r.versionField = field
+		return nil
+	}
+}
+
 // Parent implements the Parent method of the eventhorizon.ReadRepo interface.
 func (r *Repo) Parent() eh.ReadRepo {
 	return nil
@@ -196,6 +239,14 @@ func (r *Repo) FindAll(ctx context.Context) ([]eh.Entity, error) {
 	return result, nil
 }
 
+// FindAllIter implements the FindAllIter method of the eventhorizon.Iterable
+// interface, streaming the entities from a MongoDB cursor.
+func (r *Repo) FindAllIter(ctx context.Context) (eh.Iter, error) {
+	return r.FindCustomIter(ctx, func(ctx context.Context, c *mongo.Collection) (*mongo.Cursor, error) {
+		return c.Find(ctx, bson.M{})
+	})
+}
+
 // The iterator is not thread safe.
 type iter struct {
 	cursor    *mongo.Cursor
@@ -324,15 +375,31 @@ func (r *Repo) Save(ctx context.Context, entity eh.Entity) error {
 
 	c := r.client.Database(r.dbName(ctx)).Collection(r.collection)
 
-	if _, err := c.UpdateOne(ctx,
-		bson.M{
-			"_id": entity.EntityID().String(),
-		},
-		bson.M{
-			"$set": entity,
-		},
-		options.Update().SetUpsert(true),
-	); err != nil {
+	var (
+		err    error
+		missed bool
+	)
+	if r.insertOnly(entity) {
+		_, err = c.InsertOne(ctx, entity)
+	} else {
+		filter, upsert := r.saveFilter(entity)
+		var res *mongo.UpdateResult
+		res, err = c.UpdateOne(ctx,
+			filter,
+			bson.M{
+				"$set": entity,
+			},
+			options.Update().SetUpsert(upsert),
+		)
+		missed = err == nil && !upsert && res.MatchedCount == 0
+	}
+	if mongoutils.IsDuplicateKeyError(err) || missed {
+		// The stored entity has a version that could not be replaced.
+		return eh.RepoError{
+			Err:       eh.ErrIncorrectEntityVersion,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	} else if err != nil {
 		return eh.RepoError{
 			Err:       eh.ErrCouldNotSaveEntity,
 			BaseErr:   err,
@@ -342,6 +409,86 @@ func (r *Repo) Save(ctx context.Context, entity eh.Entity) error {
 	return nil
 }
 
+// insertOnly returns true if the entity must be inserted as a new document,
+// which is the case for the first version with a strict version check. An
+// existing entity fails the insert with a duplicate key.
+func (r *Repo) insertOnly(entity eh.Entity) bool {
+	versionable, ok := entity.(eh.Versionable)
+	return r.versionCheck && r.strictVersionCheck && ok &&
+		versionable.AggregateVersion() == 1
+}
+
+// saveFilter returns the filter for saving an entity, with the version check
+// if enabled, and if the entity can be inserted if not matched. An upsert that
+// does not match an existing entity fails with a duplicate key.
+func (r *Repo) saveFilter(entity eh.Entity) (bson.M, bool) {
+	filter := bson.M{"_id": entity.EntityID().String()}
+
+	versionable, ok := entity.(eh.Versionable)
+	if !r.versionCheck || !ok {
+		return filter, true
+	}
+
+	version := versionable.AggregateVersion()
+	if !r.strictVersionCheck {
+		filter[r.versionField] = bson.M{"$lt": version}
+		return filter, true
+	}
+
+	filter[r.versionField] = version - 1
+	return filter, false
+}
+
+// SaveAll implements the SaveAll method of the eventhorizon.BatchWriteRepo
+// interface. The entities are saved in order in one bulk write, which is not
+// atomic; entities before a failing one are saved.
+func (r *Repo) SaveAll(ctx context.Context, entities []eh.Entity) error {
+	if len(entities) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, len(entities))
+	for i, entity := range entities {
+		if entity.EntityID() == uuid.Nil {
+			return eh.RepoError{
+				Err:       eh.ErrCouldNotSaveEntity,
+				BaseErr:   eh.ErrMissingEntityID,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		if r.insertOnly(entity) {
+			models[i] = mongo.NewInsertOneModel().SetDocument(entity)
+			continue
+		}
+
+		filter, upsert := r.saveFilter(entity)
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(bson.M{"$set": entity}).
+			SetUpsert(upsert)
+	}
+
+	c := r.client.Database(r.dbName(ctx)).Collection(r.collection)
+
+	res, err := c.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(true))
+	if mongoutils.IsDuplicateKeyError(err) || (err == nil &&
+		res.InsertedCount+res.MatchedCount+res.UpsertedCount != int64(len(models))) {
+		return eh.RepoError{
+			Err:       eh.ErrIncorrectEntityVersion,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	} else if err != nil {
+		return eh.RepoError{
+			Err:       eh.ErrCouldNotSaveEntity,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
 // Remove implements the Remove method of the eventhorizon.WriteRepo interface.
 func (r *Repo) Remove(ctx context.Context, id uuid.UUID) error {
 	c := r.client.Database(r.dbName(ctx)).Collection(r.collection)
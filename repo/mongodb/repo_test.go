@@ -77,6 +77,40 @@ func TestReadRepoIntegration(t *testing.T) {
 
 }
 
+func TestRepoVersionCheckIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Use MongoDB in Docker with fallback to localhost.
+	addr := os.Getenv("MONGODB_ADDR")
+	if addr == "" {
+		addr = "localhost:27017"
+	}
+	url := "mongodb://" + addr
+
+	for _, strict := range []bool{false, true} {
+		option := WithVersionCheck()
+		if strict {
+			option = WithStrictVersionCheck()
+		}
+		r, err := NewRepo(url, "test_version", "mocks.Model", option)
+		if err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		r.SetEntityFactory(func() eh.Entity {
+			return &mocks.Model{}
+		})
+
+		repo.VersionAcceptanceTest(t, context.Background(), r, strict)
+
+		if err := r.Clear(context.Background()); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		r.Close(context.Background())
+	}
+}
+
 func extraRepoTests(t *testing.T, ctx context.Context, r *Repo) {
 	// Insert a custom item.
 	modelCustom := &mocks.Model{
@@ -0,0 +1,97 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect is the SQL dialect specific parts of the repo, making it possible to
+// use it with different databases.
+type Dialect interface {
+	// Placeholder returns the bind parameter for the n:th argument, starting at 1.
+	Placeholder(n int) string
+
+	// CreateSchema returns the statement used to create a schema, if it does
+	// not exist, or an empty string if schemas are not supported.
+	CreateSchema(schema string) string
+
+	// CreateTable returns the statement used to create an entity table, if it
+	// does not exist. The table must have the columns id (text primary key),
+	// data (JSON document) and version (integer, 0 for entities that are not
+	// versionable), followed by the mapped columns.
+	CreateTable(table string, columns []Column) string
+}
+
+// Postgres is the dialect for PostgreSQL, using for example
+// github.com/lib/pq or github.com/jackc/pgx as driver.
+var Postgres Dialect = postgres{}
+
+type postgres struct{}
+
+// Placeholder implements the Placeholder method of the Dialect interface.
+func (postgres) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// CreateSchema implements the CreateSchema method of the Dialect interface.
+func (postgres) CreateSchema(schema string) string {
+	return `CREATE SCHEMA IF NOT EXISTS ` + schema
+}
+
+// CreateTable implements the CreateTable method of the Dialect interface.
+func (postgres) CreateTable(table string, columns []Column) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		id      TEXT PRIMARY KEY,
+		data    JSONB NOT NULL,
+		version BIGINT NOT NULL DEFAULT 0` + columnDefinitions(columns) + `
+	)`
+}
+
+// SQLite is the dialect for SQLite, using for example
+// github.com/mattn/go-sqlite3 as driver. Schemas are not supported.
+var SQLite Dialect = sqlite{}
+
+type sqlite struct{}
+
+// Placeholder implements the Placeholder method of the Dialect interface.
+func (sqlite) Placeholder(n int) string {
+	return "?"
+}
+
+// CreateSchema implements the CreateSchema method of the Dialect interface.
+func (sqlite) CreateSchema(schema string) string {
+	return ""
+}
+
+// CreateTable implements the CreateTable method of the Dialect interface.
+func (sqlite) CreateTable(table string, columns []Column) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		id      TEXT PRIMARY KEY,
+		data    TEXT NOT NULL,
+		version INTEGER NOT NULL DEFAULT 0` + columnDefinitions(columns) + `
+	)`
+}
+
+// columnDefinitions returns the definitions of mapped columns, each preceded
+// by a comma.
+func columnDefinitions(columns []Column) string {
+	var b strings.Builder
+	for _, c := range columns {
+		b.WriteString(",\n\t\t" + quoteIdentifier(c.Name) + " " + c.Type)
+	}
+	return b.String()
+}
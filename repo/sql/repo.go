@@ -0,0 +1,667 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+var (
+	// ErrCouldNotDialDB is when the database could not be dialed.
+	ErrCouldNotDialDB = errors.New("could not dial database")
+	// ErrNoDB is when no database is set.
+	ErrNoDB = errors.New("no database")
+	// ErrNoDialect is when no SQL dialect is set.
+	ErrNoDialect = errors.New("no dialect")
+	// ErrInvalidColumn is when a mapped column is missing a name, type or value.
+	ErrInvalidColumn = errors.New("invalid column")
+	// ErrSchemaNotSupported is when schemas are used with a dialect without
+	// schema support.
+	ErrSchemaNotSupported = errors.New("schema not supported")
+	// ErrCouldNotCreateTable is when the entity table could not be created.
+	ErrCouldNotCreateTable = errors.New("could not create table")
+	// ErrCouldNotClearDB is when the database could not be cleared.
+	ErrCouldNotClearDB = errors.New("could not clear database")
+	// ErrModelNotSet is when an model factory is not set on the Repo.
+	ErrModelNotSet = errors.New("model not set")
+)
+
+// Repo implements a repository of entities for SQL databases using
+// database/sql. Entities are stored as JSON documents, with optional columns
+// mapped from the entities for indexing. Each namespace is stored in its own
+// table, or in its own schema if supported by the dialect.
+//
+// The repo does not implement the eventhorizon.QueryRepo interface, as the
+// JSON documents can not be queried in the same way for all dialects. Use
+// FindWhere with mapped columns instead.
+type Repo struct {
+	db                 *sql.DB
+	dialect            Dialect
+	tablePrefix        string
+	tableName          func(ctx context.Context) string
+	schemaName         func(ctx context.Context) string
+	columns            []Column
+	factoryFn          func() eh.Entity
+	tables             map[string]bool
+	tablesMu           sync.Mutex
+	versionCheck       bool
+	strictVersionCheck bool
+}
+
+// Column is a column mapped from the entities, stored next to the JSON
+// document and indexed.
+type Column struct {
+	// Name is the name of the column.
+	Name string
+	// Type is the column type in the SQL dialect used, for example "TEXT".
+	Type string
+	// Value returns the column value for an entity.
+	Value func(eh.Entity) interface{}
+}
+
+// NewRepo creates a new Repo by opening a database with a driver and data
+// source name, for example "postgres" and "postgres://hostname/db". The driver
+// must be registered by importing it.
+func NewRepo(driverName, dataSourceName string, dialect Dialect, tablePrefix string, options ...Option) (*Repo, error) {
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, ErrCouldNotDialDB
+	}
+
+	return NewRepoWithDB(db, dialect, tablePrefix, options...)
+}
+
+// NewRepoWithDB creates a new Repo with a database.
+func NewRepoWithDB(db *sql.DB, dialect Dialect, tablePrefix string, options ...Option) (*Repo, error) {
+	if db == nil {
+		return nil, ErrNoDB
+	}
+	if dialect == nil {
+		return nil, ErrNoDialect
+	}
+
+	r := &Repo{
+		db:          db,
+		dialect:     dialect,
+		tablePrefix: tablePrefix,
+		tables:      map[string]bool{},
+	}
+
+	// Use the a prefix and namespace from the context for table name.
+	r.tableName = func(ctx context.Context) string {
+		ns := eh.NamespaceFromContext(ctx)
+		return tablePrefix + "_" + ns
+	}
+	r.schemaName = func(context.Context) string {
+		return ""
+	}
+
+	for _, option := range options {
+		if err := option(r); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return r, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Repo) error
+
+// WithPrefixAsTableName uses only the prefix as table name, without namespace support.
+func WithPrefixAsTableName() Option {
+	return func(r *Repo) error {
+		r.tableName = func(context.Context) string {
+			return r.tablePrefix
+		}
+		return nil
+	}
+}
+
+// WithTableName uses a custom table name function.
+func WithTableName(tableName func(context.Context) string) Option {
+	return func(r *Repo) error {
+		r.tableName = tableName
+		return nil
+	}
+}
+
+// WithSchemaAsNamespace stores each namespace in its own schema, using the
+// prefix as table name. The schemas are created on first use.
+func WithSchemaAsNamespace() Option {
+	return func(r *Repo) error {
+		if r.dialect.CreateSchema("") == "" {
+			return ErrSchemaNotSupported
+		}
+		r.schemaName = eh.NamespaceFromContext
+		r.tableName = func(context.Context) string {
+			return r.tablePrefix
+		}
+		return nil
+	}
+}
+
+// WithColumns maps columns from the entities, which are stored and indexed
+// next to the JSON documents. The columns can be used with FindWhere.
+func WithColumns(columns ...Column) Option {
+	return func(r *Repo) error {
+		for _, c := range columns {
+			if c.Name == "" || c.Type == "" || c.Value == nil ||
+				c.Name == "id" || c.Name == "data" || c.Name == "version" {
+				return fmt.Errorf("%w: %q", ErrInvalidColumn, c.Name)
+			}
+		}
+		r.columns = append(r.columns, columns...)
+		return nil
+	}
+}
+
+// WithVersionCheck only saves eventhorizon.Versionable entities with a higher
+// version than the stored entity, returning eventhorizon.ErrIncorrectEntityVersion
+// otherwise. Other entities are always saved.
+func WithVersionCheck() Option {
+	return func(r *Repo) error {
+		r.versionCheck = true
+		return nil
+	}
+}
+
+// WithStrictVersionCheck only saves eventhorizon.Versionable entities with the
+// version following the version of the stored entity, or version 1 for new
+// entities, returning eventhorizon.ErrIncorrectEntityVersion otherwise.
+func WithStrictVersionCheck() Option {
+	return func(r *Repo) error {
+		r.versionCheck = true
+		r.strictVersionCheck = true
+		return nil
+	}
+}
+
+// Parent implements the Parent method of the eventhorizon.ReadRepo interface.
+func (r *Repo) Parent() eh.ReadRepo {
+	return nil
+}
+
+// Find implements the Find method of the eventhorizon.ReadRepo interface.
+func (r *Repo) Find(ctx context.Context, id uuid.UUID) (eh.Entity, error) {
+	if r.factoryFn == nil {
+		return nil, eh.RepoError{
+			Err:       ErrModelNotSet,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	table, err := r.table(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if err := r.db.QueryRowContext(ctx,
+		"SELECT data FROM "+table+" WHERE id = "+r.dialect.Placeholder(1),
+		id.String(),
+	).Scan(&data); err == sql.ErrNoRows {
+		return nil, eh.RepoError{
+			Err:       eh.ErrEntityNotFound,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	} else if err != nil {
+		return nil, eh.RepoError{
+			Err:       eh.ErrCouldNotLoadEntity,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	entity := r.factoryFn()
+	if err := json.Unmarshal(data, &entity); err != nil {
+		return nil, eh.RepoError{
+			Err:       eh.ErrCouldNotLoadEntity,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return entity, nil
+}
+
+// FindAll implements the FindAll method of the eventhorizon.ReadRepo interface.
+func (r *Repo) FindAll(ctx context.Context) ([]eh.Entity, error) {
+	return r.FindWhere(ctx, "")
+}
+
+// FindWhere returns the entities matching a condition, for example on mapped
+// columns. The condition is used as a WHERE clause, with bind parameters for
+// the dialect used; all entities are returned for an empty condition.
+func (r *Repo) FindWhere(ctx context.Context, condition string, args ...interface{}) ([]eh.Entity, error) {
+	i, err := r.findWhereIter(ctx, condition, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []eh.Entity{}
+	for i.Next(ctx) {
+		result = append(result, i.data)
+	}
+
+	if err := i.Close(ctx); err != nil {
+		return nil, eh.RepoError{
+			Err:       eh.ErrCouldNotLoadEntity,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return result, nil
+}
+
+// FindAllIter implements the FindAllIter method of the eventhorizon.Iterable
+// interface, streaming the entities from the database rows.
+func (r *Repo) FindAllIter(ctx context.Context) (eh.Iter, error) {
+	return r.findWhereIter(ctx, "")
+}
+
+func (r *Repo) findWhereIter(ctx context.Context, condition string, args ...interface{}) (*iter, error) {
+	if r.factoryFn == nil {
+		return nil, eh.RepoError{
+			Err:       ErrModelNotSet,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	table, err := r.table(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT data FROM " + table
+	if condition != "" {
+		query += " WHERE " + condition
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, eh.RepoError{
+			Err:       eh.ErrCouldNotLoadEntity,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return &iter{
+		rows:      rows,
+		factoryFn: r.factoryFn,
+	}, nil
+}
+
+// The iterator is not thread safe.
+type iter struct {
+	rows      *sql.Rows
+	data      eh.Entity
+	factoryFn func() eh.Entity
+	decodeErr error
+}
+
+func (i *iter) Next(ctx context.Context) bool {
+	if i.decodeErr != nil || !i.rows.Next() {
+		return false
+	}
+
+	var data []byte
+	if err := i.rows.Scan(&data); err != nil {
+		i.decodeErr = err
+		return false
+	}
+
+	item := i.factoryFn()
+	if err := json.Unmarshal(data, &item); err != nil {
+		i.decodeErr = err
+		return false
+	}
+	i.data = item
+	return true
+}
+
+func (i *iter) Value() interface{} {
+	return i.data
+}
+
+func (i *iter) Close(ctx context.Context) error {
+	if err := i.rows.Close(); err != nil {
+		return err
+	}
+	if err := i.rows.Err(); err != nil {
+		return err
+	}
+	return i.decodeErr
+}
+
+// Save implements the Save method of the eventhorizon.WriteRepo interface.
+func (r *Repo) Save(ctx context.Context, entity eh.Entity) error {
+	return r.SaveAll(ctx, []eh.Entity{entity})
+}
+
+// SaveAll implements the SaveAll method of the eventhorizon.BatchWriteRepo
+// interface. The entities are saved in one transaction, which is rolled back
+// if the version check fails for any of them.
+func (r *Repo) SaveAll(ctx context.Context, entities []eh.Entity) error {
+	if len(entities) == 0 {
+		return nil
+	}
+
+	// Build all rows before touching the database.
+	rows := make([][]interface{}, len(entities))
+	for i, entity := range entities {
+		if entity.EntityID() == uuid.Nil {
+			return eh.RepoError{
+				Err:       eh.ErrCouldNotSaveEntity,
+				BaseErr:   eh.ErrMissingEntityID,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		data, err := json.Marshal(entity)
+		if err != nil {
+			return eh.RepoError{
+				Err:       eh.ErrCouldNotSaveEntity,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		var version int
+		if versionable, ok := entity.(eh.Versionable); ok {
+			version = versionable.AggregateVersion()
+		}
+
+		row := []interface{}{entity.EntityID().String(), string(data), version}
+		for _, c := range r.columns {
+			row = append(row, c.Value(entity))
+		}
+		rows[i] = row
+	}
+
+	table, err := r.table(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return eh.RepoError{
+			Err:       eh.ErrCouldNotSaveEntity,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	defer tx.Rollback()
+
+	for i, row := range rows {
+		query, args, checked := r.saveStatement(table, entities[i], row)
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return eh.RepoError{
+				Err:       eh.ErrCouldNotSaveEntity,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if !checked {
+			continue
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return eh.RepoError{
+				Err:       eh.ErrCouldNotSaveEntity,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		} else if n == 0 {
+			// The stored entity has a version that could not be replaced.
+			return eh.RepoError{
+				Err:       eh.ErrIncorrectEntityVersion,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return eh.RepoError{
+			Err:       eh.ErrCouldNotSaveEntity,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// saveStatement returns the statement and arguments to save a row with the id,
+// data, version and mapped columns of an entity, and if the statement checks
+// the version, in which case no affected rows means an incorrect version.
+func (r *Repo) saveStatement(table string, entity eh.Entity, row []interface{}) (string, []interface{}, bool) {
+	names := []string{"id", "data", "version"}
+	for _, c := range r.columns {
+		names = append(names, quoteIdentifier(c.Name))
+	}
+
+	versionable, ok := entity.(eh.Versionable)
+	if !r.versionCheck || !ok {
+		return r.upsert(table, names, ""), row, false
+	}
+
+	version := versionable.AggregateVersion()
+	if !r.strictVersionCheck {
+		return r.upsert(table, names, " WHERE "+table+".version < excluded.version"), row, true
+	}
+
+	// The first version must be a new entity, later versions must follow the
+	// stored version.
+	if version == 1 {
+		return r.insert(table, names) + " ON CONFLICT (id) DO NOTHING", row, true
+	}
+
+	sets := make([]string, 0, len(names)-1)
+	for i, name := range names[1:] {
+		sets = append(sets, name+" = "+r.dialect.Placeholder(i+1))
+	}
+	args := append(append([]interface{}{}, row[1:]...), row[0], version-1)
+
+	return "UPDATE " + table + " SET " + strings.Join(sets, ", ") +
+		" WHERE id = " + r.dialect.Placeholder(len(names)) +
+		" AND version = " + r.dialect.Placeholder(len(names)+1), args, true
+}
+
+// insert returns the statement to insert a row with the named columns.
+func (r *Repo) insert(table string, names []string) string {
+	placeholders := make([]string, len(names))
+	for i := range names {
+		placeholders[i] = r.dialect.Placeholder(i + 1)
+	}
+
+	return "INSERT INTO " + table +
+		" (" + strings.Join(names, ", ") + ")" +
+		" VALUES (" + strings.Join(placeholders, ", ") + ")"
+}
+
+// upsert returns the statement to insert or update a row with the named
+// columns, updating only if the condition matches the stored row.
+func (r *Repo) upsert(table string, names []string, condition string) string {
+	updates := make([]string, 0, len(names)-1)
+	for _, name := range names[1:] {
+		updates = append(updates, name+" = excluded."+name)
+	}
+
+	return r.insert(table, names) +
+		" ON CONFLICT (id) DO UPDATE SET " + strings.Join(updates, ", ") + condition
+}
+
+// Remove implements the Remove method of the eventhorizon.WriteRepo interface.
+func (r *Repo) Remove(ctx context.Context, id uuid.UUID) error {
+	table, err := r.table(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.ExecContext(ctx,
+		"DELETE FROM "+table+" WHERE id = "+r.dialect.Placeholder(1),
+		id.String(),
+	)
+	if err != nil {
+		return eh.RepoError{
+			Err:       eh.ErrCouldNotRemoveEntity,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return eh.RepoError{
+			Err:       eh.ErrCouldNotRemoveEntity,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	} else if n == 0 {
+		return eh.RepoError{
+			Err:       eh.ErrEntityNotFound,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// DB lets the function do custom actions on the database, with the quoted
+// table name of the namespace.
+func (r *Repo) DB(ctx context.Context, f func(ctx context.Context, db *sql.DB, table string) error) error {
+	table, err := r.table(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := f(ctx, r.db, table); err != nil {
+		return eh.RepoError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// SetEntityFactory sets a factory function that creates concrete entity types.
+func (r *Repo) SetEntityFactory(f func() eh.Entity) {
+	r.factoryFn = f
+}
+
+// Clear clears the read model database.
+func (r *Repo) Clear(ctx context.Context) error {
+	table := r.qualifiedTable(ctx)
+
+	r.tablesMu.Lock()
+	defer r.tablesMu.Unlock()
+
+	if _, err := r.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+		return eh.RepoError{
+			Err:       ErrCouldNotClearDB,
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	delete(r.tables, table)
+
+	return nil
+}
+
+// Close closes the database.
+func (r *Repo) Close() error {
+	return r.db.Close()
+}
+
+// qualifiedTable returns the quoted table name for the namespace, including
+// the schema if used.
+func (r *Repo) qualifiedTable(ctx context.Context) string {
+	table := quoteIdentifier(r.tableName(ctx))
+	if schema := r.schemaName(ctx); schema != "" {
+		table = quoteIdentifier(schema) + "." + table
+	}
+	return table
+}
+
+// table returns the qualified table name for the namespace, creating the
+// schema, table and column indexes on first use.
+func (r *Repo) table(ctx context.Context) (string, error) {
+	table := r.qualifiedTable(ctx)
+
+	r.tablesMu.Lock()
+	defer r.tablesMu.Unlock()
+
+	if r.tables[table] {
+		return table, nil
+	}
+
+	statements := []string{}
+	if schema := r.schemaName(ctx); schema != "" {
+		statements = append(statements, r.dialect.CreateSchema(quoteIdentifier(schema)))
+	}
+	statements = append(statements, r.dialect.CreateTable(table, r.columns))
+	for _, c := range r.columns {
+		// Index names are unqualified, the index is created in the schema
+		// of the table.
+		index := quoteIdentifier(r.tableName(ctx) + "_" + c.Name + "_idx")
+		statements = append(statements,
+			"CREATE INDEX IF NOT EXISTS "+index+" ON "+table+" ("+quoteIdentifier(c.Name)+")")
+	}
+
+	for _, s := range statements {
+		if _, err := r.db.ExecContext(ctx, s); err != nil {
+			return "", eh.RepoError{
+				Err:       ErrCouldNotCreateTable,
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+	r.tables[table] = true
+
+	return table, nil
+}
+
+// quoteIdentifier quotes a table or column name for use in queries.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// Repository returns a parent ReadRepo if there is one.
+func Repository(repo eh.ReadRepo) *Repo {
+	if repo == nil {
+		return nil
+	}
+
+	if r, ok := repo.(*Repo); ok {
+		return r
+	}
+
+	return Repository(repo.Parent())
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/repo"
+)
+
+// NOTE: This test uses an embedded SQLite database and is therefore not an
+// integration test.
+func TestReadRepo(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "repo.db")
+	r, err := NewRepo("sqlite3", dsn, SQLite, "test", WithColumns(contentColumn))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if r == nil {
+		t.Fatal("there should be a repository")
+	}
+	defer r.Close()
+
+	r.SetEntityFactory(func() eh.Entity {
+		return &mocks.Model{}
+	})
+	if r.Parent() != nil {
+		t.Error("the parent repo should be nil")
+	}
+
+	// Repo with default namespace.
+	repo.AcceptanceTest(t, context.Background(), r)
+
+	// Repo with other namespace
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	repo.AcceptanceTest(t, ctx, r)
+
+	extraRepoTests(t, context.Background(), r)
+
+	if err := r.Clear(context.Background()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	result, err := r.FindAll(context.Background())
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 0 {
+		t.Error("there should be no items after clearing:", result)
+	}
+}
+
+func TestReadRepoPostgresIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Use PostgreSQL in Docker with fallback to localhost.
+	addr := os.Getenv("POSTGRES_ADDR")
+	if addr == "" {
+		addr = "localhost:5432"
+	}
+	dsn := "postgres://postgres:postgres@" + addr + "/postgres?sslmode=disable"
+
+	r, err := NewRepo("postgres", dsn, Postgres, "test",
+		WithSchemaAsNamespace(),
+		WithColumns(contentColumn),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if r == nil {
+		t.Fatal("there should be a repository")
+	}
+
+	r.SetEntityFactory(func() eh.Entity {
+		return &mocks.Model{}
+	})
+
+	customNamespaceCtx := eh.NewContextWithNamespace(context.Background(), "ns")
+
+	defer r.Close()
+	defer func() {
+		if err := r.Clear(context.Background()); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		if err := r.Clear(customNamespaceCtx); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	repo.AcceptanceTest(t, context.Background(), r)
+	repo.AcceptanceTest(t, customNamespaceCtx, r)
+	extraRepoTests(t, context.Background(), r)
+}
+
+func TestRepoVersionCheck(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		option := WithVersionCheck()
+		if strict {
+			option = WithStrictVersionCheck()
+		}
+
+		// Wait for the lock with concurrent writers.
+		dsn := filepath.Join(t.TempDir(), "repo.db") + "?_busy_timeout=10000"
+		r, err := NewRepo("sqlite3", dsn, SQLite, "test", option)
+		if err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		r.SetEntityFactory(func() eh.Entity {
+			return &mocks.Model{}
+		})
+
+		repo.VersionAcceptanceTest(t, context.Background(), r, strict)
+
+		r.Close()
+	}
+}
+
+func TestRepoVersionCheckPostgresIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Use PostgreSQL in Docker with fallback to localhost.
+	addr := os.Getenv("POSTGRES_ADDR")
+	if addr == "" {
+		addr = "localhost:5432"
+	}
+	dsn := "postgres://postgres:postgres@" + addr + "/postgres?sslmode=disable"
+
+	for _, strict := range []bool{false, true} {
+		option := WithVersionCheck()
+		if strict {
+			option = WithStrictVersionCheck()
+		}
+		r, err := NewRepo("postgres", dsn, Postgres, "test_version",
+			WithSchemaAsNamespace(), option)
+		if err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		r.SetEntityFactory(func() eh.Entity {
+			return &mocks.Model{}
+		})
+
+		repo.VersionAcceptanceTest(t, context.Background(), r, strict)
+
+		if err := r.Clear(context.Background()); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		r.Close()
+	}
+}
+
+var contentColumn = Column{
+	Name: "content",
+	Type: "TEXT",
+	Value: func(entity eh.Entity) interface{} {
+		if m, ok := entity.(*mocks.Model); ok {
+			return m.Content
+		}
+		return nil
+	},
+}
+
+func extraRepoTests(t *testing.T, ctx context.Context, r *Repo) {
+	// Find by a mapped column.
+	entity1 := &mocks.Model{
+		ID:        uuid.New(),
+		Content:   "extra1",
+		CreatedAt: time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC),
+	}
+	entity2 := &mocks.Model{
+		ID:        uuid.New(),
+		Content:   "extra2",
+		CreatedAt: time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC),
+	}
+	if err := r.SaveAll(ctx, []eh.Entity{entity1, entity2}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	result, err := r.FindWhere(ctx, "content = "+r.dialect.Placeholder(1), "extra2")
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(result, []eh.Entity{entity2}) {
+		t.Error("the item should be correct:", result)
+	}
+
+	// The mapped column is updated on save.
+	entity2.Content = "extra2Alt"
+	if err := r.Save(ctx, entity2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	result, err = r.FindWhere(ctx, "content = "+r.dialect.Placeholder(1), "extra2")
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 0 {
+		t.Error("there should be no items:", result)
+	}
+
+	// Custom queries on the table.
+	var count int
+	if err := r.DB(ctx, func(ctx context.Context, db *sql.DB, table string) error {
+		return db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&count)
+	}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if count != 3 {
+		t.Error("there should be three items:", count)
+	}
+
+	for _, e := range []*mocks.Model{entity1, entity2} {
+		if err := r.Remove(ctx, e.ID); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+}
+
+func TestNewRepoOptions(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "repo.db")
+
+	if _, err := NewRepo("sqlite3", dsn, nil, "test"); err != ErrNoDialect {
+		t.Error("there should be a ErrNoDialect error:", err)
+	}
+	if _, err := NewRepo("sqlite3", dsn, SQLite, "test",
+		WithSchemaAsNamespace(),
+	); !errors.Is(err, ErrSchemaNotSupported) {
+		t.Error("there should be a ErrSchemaNotSupported error:", err)
+	}
+	if _, err := NewRepo("sqlite3", dsn, SQLite, "test",
+		WithColumns(Column{Name: "data", Type: "TEXT", Value: contentColumn.Value}),
+	); !errors.Is(err, ErrInvalidColumn) {
+		t.Error("there should be a ErrInvalidColumn error:", err)
+	}
+}
+
+func TestRepository(t *testing.T) {
+	if r := Repository(nil); r != nil {
+		t.Error("the parent repository should be nil:", r)
+	}
+
+	inner := &mocks.Repo{}
+	if r := Repository(inner); r != nil {
+		t.Error("the parent repository should be nil:", r)
+	}
+
+	dsn := filepath.Join(t.TempDir(), "repo.db")
+	repo, err := NewRepo("sqlite3", dsn, SQLite, "test")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer repo.Close()
+
+	outer := &mocks.Repo{ParentRepo: repo}
+	if r := Repository(outer); r != repo {
+		t.Error("the parent repository should be correct:", r)
+	}
+}
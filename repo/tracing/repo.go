@@ -70,6 +70,60 @@ func (r *Repo) FindAll(ctx context.Context) ([]eh.Entity, error) {
 	return entities, err
 }
 
+// FindAllIter implements the FindAllIter method of the eventhorizon.Iterable
+// interface. The span is finished when the iterator is closed.
+func (r *Repo) FindAllIter(ctx context.Context) (eh.Iter, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "Repo.FindAllIter")
+
+	i, err := eh.FindAllIter(ctx, r.ReadWriteRepo)
+	if err != nil {
+		ext.LogError(sp, err)
+		sp.Finish()
+		return nil, err
+	}
+
+	return &iter{Iter: i, sp: sp}, nil
+}
+
+// iter finishes the span of an iterator when it is closed.
+type iter struct {
+	eh.Iter
+	sp opentracing.Span
+}
+
+func (i *iter) Close(ctx context.Context) error {
+	err := i.Iter.Close(ctx)
+	if err != nil {
+		ext.LogError(i.sp, err)
+	}
+	i.sp.Finish()
+
+	return err
+}
+
+// Query implements the Query method of the eventhorizon.QueryRepo interface.
+func (r *Repo) Query(ctx context.Context, q eh.Query) (*eh.QueryResult, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "Repo.Query")
+
+	var result *eh.QueryResult
+	var err error
+	if repo, ok := r.ReadWriteRepo.(eh.QueryRepo); ok {
+		result, err = repo.Query(ctx, q)
+	} else {
+		err = eh.RepoError{
+			Err:       eh.ErrQueryNotSupported,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	if err != nil {
+		ext.LogError(sp, err)
+	}
+	sp.Finish()
+
+	return result, err
+}
+
 // Save implements the Save method of the eventhorizon.WriteRepo interface.
 func (r *Repo) Save(ctx context.Context, entity eh.Entity) error {
 	sp, ctx := opentracing.StartSpanFromContext(ctx, "Repo.Save")
@@ -85,6 +139,23 @@ func (r *Repo) Save(ctx context.Context, entity eh.Entity) error {
 	return err
 }
 
+// SaveAll implements the SaveAll method of the eventhorizon.BatchWriteRepo
+// interface. The entities are saved in one operation if the underlying repo
+// supports it.
+func (r *Repo) SaveAll(ctx context.Context, entities []eh.Entity) error {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "Repo.SaveAll")
+
+	err := eh.SaveAll(ctx, r.ReadWriteRepo, entities)
+
+	sp.SetTag("eh.entities", len(entities))
+	if err != nil {
+		ext.LogError(sp, err)
+	}
+	sp.Finish()
+
+	return err
+}
+
 // Remove implements the Remove method of the eventhorizon.WriteRepo interface.
 func (r *Repo) Remove(ctx context.Context, id uuid.UUID) error {
 	sp, ctx := opentracing.StartSpanFromContext(ctx, "Repo.Remove")
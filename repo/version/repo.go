@@ -87,6 +87,33 @@ func (r *Repo) Find(ctx context.Context, id uuid.UUID) (eh.Entity, error) {
 	}
 }
 
+// FindAllIter implements the FindAllIter method of the eventhorizon.Iterable
+// interface. The entities are streamed from the underlying repo if possible.
+func (r *Repo) FindAllIter(ctx context.Context) (eh.Iter, error) {
+	return eh.FindAllIter(ctx, r.ReadWriteRepo)
+}
+
+// Query implements the Query method of the eventhorizon.QueryRepo interface.
+// The query is passed to the underlying repo.
+func (r *Repo) Query(ctx context.Context, q eh.Query) (*eh.QueryResult, error) {
+	repo, ok := r.ReadWriteRepo.(eh.QueryRepo)
+	if !ok {
+		return nil, eh.RepoError{
+			Err:       eh.ErrQueryNotSupported,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return repo.Query(ctx, q)
+}
+
+// SaveAll implements the SaveAll method of the eventhorizon.BatchWriteRepo
+// interface. The entities are saved in one operation if the underlying repo
+// supports it.
+func (r *Repo) SaveAll(ctx context.Context, entities []eh.Entity) error {
+	return eh.SaveAll(ctx, r.ReadWriteRepo, entities)
+}
+
 // findMinVersion finds an item if it has a version and it is at least minVersion.
 func (r *Repo) findMinVersion(ctx context.Context, id uuid.UUID, minVersion int) (eh.Entity, error) {
 	entity, err := r.ReadWriteRepo.Find(ctx, id)
@@ -0,0 +1,134 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestFindAllIter(t *testing.T) {
+	ctx := context.Background()
+	entities := []Entity{&testEntity{uuid.New()}, &testEntity{uuid.New()}}
+	repo := &testReadRepo{entities: entities}
+
+	i, err := FindAllIter(ctx, repo)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if i.Value() != nil {
+		t.Error("there should be no value before Next:", i.Value())
+	}
+	var iterated []Entity
+	for i.Next(ctx) {
+		iterated = append(iterated, i.Value().(Entity))
+	}
+	if err := i.Close(ctx); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(iterated) != 2 || iterated[0] != entities[0] || iterated[1] != entities[1] {
+		t.Error("the entities should be iterated:", iterated)
+	}
+
+	repo.err = errors.New("repo error")
+	if _, err := FindAllIter(ctx, repo); !errors.Is(err, repo.err) {
+		t.Error("there should be a repo error:", err)
+	}
+}
+
+func TestSaveAll(t *testing.T) {
+	ctx := context.Background()
+	entities := []Entity{&testEntity{uuid.New()}, &testEntity{uuid.New()}}
+
+	repo := &testWriteRepo{}
+	if err := SaveAll(ctx, repo, entities); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(repo.saved) != 2 || repo.batches != 0 {
+		t.Error("the entities should be saved one at a time:", repo.saved, repo.batches)
+	}
+
+	batchRepo := &testBatchWriteRepo{}
+	if err := SaveAll(ctx, batchRepo, entities); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(batchRepo.saved) != 2 || batchRepo.batches != 1 {
+		t.Error("the entities should be saved in a batch:", batchRepo.saved, batchRepo.batches)
+	}
+
+	repo = &testWriteRepo{err: errors.New("repo error")}
+	if err := SaveAll(ctx, repo, entities); !errors.Is(err, repo.err) {
+		t.Error("there should be a repo error:", err)
+	}
+	if len(repo.saved) != 0 {
+		t.Error("the entities should not be saved:", repo.saved)
+	}
+}
+
+type testEntity struct {
+	id uuid.UUID
+}
+
+func (e *testEntity) EntityID() uuid.UUID {
+	return e.id
+}
+
+type testReadRepo struct {
+	entities []Entity
+	err      error
+}
+
+func (r *testReadRepo) Parent() ReadRepo {
+	return nil
+}
+
+func (r *testReadRepo) Find(ctx context.Context, id uuid.UUID) (Entity, error) {
+	return nil, RepoError{Err: ErrEntityNotFound}
+}
+
+func (r *testReadRepo) FindAll(ctx context.Context) ([]Entity, error) {
+	return r.entities, r.err
+}
+
+type testWriteRepo struct {
+	saved   []Entity
+	batches int
+	err     error
+}
+
+func (r *testWriteRepo) Save(ctx context.Context, entity Entity) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.saved = append(r.saved, entity)
+	return nil
+}
+
+func (r *testWriteRepo) Remove(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+
+type testBatchWriteRepo struct {
+	testWriteRepo
+}
+
+func (r *testBatchWriteRepo) SaveAll(ctx context.Context, entities []Entity) error {
+	r.batches++
+	r.saved = append(r.saved, entities...)
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Snapshot is a recording of the state of an aggregate at a specific version,
+// used to avoid replaying all events when loading the aggregate.
+type Snapshot struct {
+	// Version is the version of the aggregate when the snapshot was taken.
+	Version int
+	// AggregateType is the type of the snapshotted aggregate.
+	AggregateType AggregateType
+	// Timestamp is the time of the last event included in the snapshot.
+	Timestamp time.Time
+	// State is the aggregate specific state, it must be supported by the
+	// marshalers of the snapshot store in use.
+	State SnapshotData
+}
+
+// SnapshotData is the aggregate specific state in a snapshot.
+type SnapshotData interface{}
+
+// Snapshotable is an aggregate that can be snapshotted and restored.
+type Snapshotable interface {
+	// CreateSnapshot creates a snapshot of the current aggregate state. Only the
+	// State of the snapshot has to be set, the rest is set by the aggregate store.
+	CreateSnapshot() *Snapshot
+	// ApplySnapshot restores the aggregate state from a snapshot. Aggregates
+	// that does not embed an aggregate base must also restore their version.
+	ApplySnapshot(*Snapshot) error
+}
+
+// SnapshotStore is an interface for a store of aggregate snapshots.
+type SnapshotStore interface {
+	// LoadSnapshot loads the latest snapshot for an aggregate. Returns nil if
+	// there is no snapshot.
+	LoadSnapshot(context.Context, uuid.UUID) (*Snapshot, error)
+
+	// SaveSnapshot saves a snapshot for an aggregate, replacing any older one.
+	SaveSnapshot(context.Context, uuid.UUID, Snapshot) error
+}
+
+// ErrSnapshotDataNotRegistered is when no snapshot data factory was registered.
+var ErrSnapshotDataNotRegistered = errors.New("snapshot data not registered")
+
+// RegisterSnapshotData registers a snapshot data factory for an aggregate type.
+// The factory is used to create concrete snapshot state when loading from the
+// database.
+//
+// An example would be:
+//     RegisterSnapshotData(MyAggregateType, func() SnapshotData { return &MyState{} })
+func RegisterSnapshotData(aggregateType AggregateType, factory func() SnapshotData) {
+	if aggregateType == AggregateType("") {
+		panic("eventhorizon: attempt to register empty aggregate type")
+	}
+
+	snapshotDataFactoriesMu.Lock()
+	defer snapshotDataFactoriesMu.Unlock()
+	if _, ok := snapshotDataFactories[aggregateType]; ok {
+		panic(fmt.Sprintf("eventhorizon: registering duplicate snapshot data for %q", aggregateType))
+	}
+	snapshotDataFactories[aggregateType] = factory
+}
+
+// CreateSnapshotData creates snapshot data of a type using the factory
+// registered with RegisterSnapshotData.
+func CreateSnapshotData(aggregateType AggregateType) (SnapshotData, error) {
+	snapshotDataFactoriesMu.RLock()
+	defer snapshotDataFactoriesMu.RUnlock()
+	if factory, ok := snapshotDataFactories[aggregateType]; ok {
+		return factory(), nil
+	}
+	return nil, ErrSnapshotDataNotRegistered
+}
+
+var snapshotDataFactories = make(map[AggregateType]func() SnapshotData)
+var snapshotDataFactoriesMu sync.RWMutex
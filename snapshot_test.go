@@ -0,0 +1,71 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCreateSnapshotData(t *testing.T) {
+	data, err := CreateSnapshotData(TestSnapshotRegisterType)
+	if !errors.Is(err, ErrSnapshotDataNotRegistered) {
+		t.Error("there should be a snapshot data not registered error:", err)
+	}
+
+	RegisterSnapshotData(TestSnapshotRegisterType, func() SnapshotData {
+		return &TestSnapshotRegisterData{}
+	})
+
+	data, err = CreateSnapshotData(TestSnapshotRegisterType)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, ok := data.(*TestSnapshotRegisterData); !ok {
+		t.Errorf("the snapshot data type should be correct: %T", data)
+	}
+}
+
+func TestRegisterSnapshotDataEmptyName(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || r != "eventhorizon: attempt to register empty aggregate type" {
+			t.Error("there should have been a panic:", r)
+		}
+	}()
+	RegisterSnapshotData(AggregateType(""), func() SnapshotData {
+		return &TestSnapshotRegisterData{}
+	})
+}
+
+func TestRegisterSnapshotDataTwice(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || r != "eventhorizon: registering duplicate snapshot data for \"TestSnapshotRegisterTwice\"" {
+			t.Error("there should have been a panic:", r)
+		}
+	}()
+	RegisterSnapshotData(TestSnapshotRegisterTwiceType, func() SnapshotData {
+		return &TestSnapshotRegisterData{}
+	})
+	RegisterSnapshotData(TestSnapshotRegisterTwiceType, func() SnapshotData {
+		return &TestSnapshotRegisterData{}
+	})
+}
+
+const (
+	TestSnapshotRegisterType      AggregateType = "TestSnapshotRegister"
+	TestSnapshotRegisterTwiceType AggregateType = "TestSnapshotRegisterTwice"
+)
+
+type TestSnapshotRegisterData struct{}